@@ -16,25 +16,479 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+const (
+	// CheckLabel is set on every SyntheticRun created for a Check.
+	CheckLabel = "perph.io/check"
+	// LocationLabel is set on every SyntheticRun to the location it runs from.
+	LocationLabel = "perph.io/location"
+
+	// DefaultCheckInterval is used when a Check does not set an interval.
+	DefaultCheckInterval = time.Minute
+	// DefaultHistoryLimit is used when a Check does not set a history limit.
+	DefaultHistoryLimit = 10
+	// DefaultTracingServiceName is the service.name of client spans when
+	// Tracing does not set one.
+	DefaultTracingServiceName = "perph"
+)
+
 // CheckSpec defines the desired state of Check
 type CheckSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Interval is the time between scheduled runs. Defaults to one minute.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Locations restricts the probe locations the check runs from. The check
+	// runs from every location when empty.
+	// +optional
+	Locations []string `json:"locations,omitempty"`
+
+	// HistoryLimit is the number of finished SyntheticRuns kept per location.
+	// Defaults to 10.
+	// +optional
+	HistoryLimit *int32 `json:"historyLimit,omitempty"`
+
+	// HTTP probes an HTTP endpoint.
+	// +optional
+	HTTP *HTTPProbe `json:"http,omitempty"`
+
+	// Steps probes a sequence of HTTP requests, such as a login followed by
+	// a search, instead of a single one. The run stops at the first step
+	// that fails.
+	// +optional
+	Steps []HTTPStep `json:"steps,omitempty"`
+
+	// Queue probes the end to end latency of an asynchronous path through
+	// a message queue, instead of HTTP requests.
+	// +optional
+	Queue *QueueProbe `json:"queue,omitempty"`
+
+	// HTML fetches a page and asserts on its content, links and assets,
+	// instead of probing a single HTTP response.
+	// +optional
+	HTML *HTMLProbe `json:"html,omitempty"`
+
+	// TemplateRef names a CheckTemplate in the namespace of the check that
+	// the check is rendered from. Fields set on the check itself override
+	// those of the template.
+	// +optional
+	TemplateRef string `json:"templateRef,omitempty"`
+
+	// Parameters are the values of the parameters declared by the template.
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// DependsOn names Checks, in the same namespace, this check depends on,
+	// such as the database behind an API. A failed run is reported as
+	// DependencyFailed instead of Failed while a dependency is failing from
+	// the same location. Dependencies must not form a cycle, and are read
+	// from the check itself rather than its template.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// Tracing propagates W3C trace context with the HTTP requests of the
+	// check, so that a failed run leads straight to the trace of the
+	// request in the target services.
+	// +optional
+	Tracing *Tracing `json:"tracing,omitempty"`
+}
+
+// Tracing sends a traceparent header with every request, starting a new
+// trace whose root is the synthetic request.
+type Tracing struct {
+	// SamplingPercent is the percentage of requests sent with the sampled
+	// flag set. Defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	SamplingPercent *int32 `json:"samplingPercent,omitempty"`
+
+	// ServiceName is the service.name client spans are exported with.
+	// Defaults to perph.
+	// +optional
+	ServiceName string `json:"serviceName,omitempty"`
+
+	// OTLP exports a client span for every sampled request to an
+	// OpenTelemetry collector, so that the trace starts at the synthetic
+	// request rather than at the first service.
+	// +optional
+	OTLP *OTLPEndpoint `json:"otlp,omitempty"`
+}
+
+// SamplingOrDefault returns the fraction of requests that are sampled.
+func (t *Tracing) SamplingOrDefault() float64 {
+	if t.SamplingPercent == nil {
+		return 1
+	}
+	return float64(*t.SamplingPercent) / 100
+}
+
+// ServiceNameOrDefault returns the service.name of client spans.
+func (t *Tracing) ServiceNameOrDefault() string {
+	if t.ServiceName == "" {
+		return DefaultTracingServiceName
+	}
+	return t.ServiceName
+}
+
+// HTTPProbe describes a single HTTP request and the response it expects.
+type HTTPProbe struct {
+	// URL is the address to request.
+	URL string `json:"url"`
+
+	// Method is the HTTP method. Defaults to GET.
+	// +optional
+	Method string `json:"method,omitempty"`
+
+	// Headers are added to the request.
+	// +optional
+	Headers []HTTPHeader `json:"headers,omitempty"`
+
+	// Variables are substituted into the URL, header values and body
+	// wherever $(NAME) appears. $$(NAME) produces a literal $(NAME).
+	// +optional
+	Variables []Variable `json:"variables,omitempty"`
+
+	// Body is sent as the request body.
+	// +optional
+	Body string `json:"body,omitempty"`
+
+	// Timeout bounds the whole request. Defaults to ten seconds.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// ExpectedStatus lists the acceptable response status codes. Any 2xx
+	// status is accepted when empty.
+	// +optional
+	ExpectedStatus []int32 `json:"expectedStatus,omitempty"`
+
+	// TLS configures the client side of the TLS handshake.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// Auth obtains credentials that are sent with the request.
+	// +optional
+	Auth *HTTPAuth `json:"auth,omitempty"`
+}
+
+// HTTPAuth configures how a request authenticates.
+type HTTPAuth struct {
+	// OAuth2 obtains an access token from an OAuth2 token endpoint and sends
+	// it in the Authorization header.
+	// +optional
+	OAuth2 *OAuth2Auth `json:"oauth2,omitempty"`
+}
+
+// OAuth2GrantType is an OAuth2 grant used to obtain an access token.
+type OAuth2GrantType string
+
+const (
+	// GrantClientCredentials is the client credentials grant (RFC 6749 4.4).
+	GrantClientCredentials OAuth2GrantType = "client_credentials"
+	// GrantPassword is the resource owner password grant (RFC 6749 4.3).
+	GrantPassword OAuth2GrantType = "password"
+	// GrantJWTBearer is the JWT bearer assertion grant (RFC 7523).
+	GrantJWTBearer OAuth2GrantType = "jwt_bearer"
+	// GrantTokenExchange is the token exchange grant (RFC 8693).
+	GrantTokenExchange OAuth2GrantType = "token_exchange"
+)
+
+// OAuth2Auth configures an OAuth2 grant. Tokens are cached and shared by
+// every request using the same configuration, and refreshed shortly before
+// they expire.
+type OAuth2Auth struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string `json:"tokenURL"`
+
+	// GrantType selects the grant. Defaults to client_credentials.
+	// +kubebuilder:validation:Enum=client_credentials;password;jwt_bearer;token_exchange
+	// +optional
+	GrantType OAuth2GrantType `json:"grantType,omitempty"`
+
+	// ClientID identifies the client to the authorization server.
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
+
+	// ClientSecret authenticates the client.
+	// +optional
+	ClientSecret *ValueSource `json:"clientSecret,omitempty"`
+
+	// ClientAuthInBody sends the client credentials as form parameters
+	// instead of HTTP basic authentication.
+	// +optional
+	ClientAuthInBody bool `json:"clientAuthInBody,omitempty"`
+
+	// Scopes are requested for the token.
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Audience is sent as the audience parameter when set.
+	// +optional
+	Audience string `json:"audience,omitempty"`
+
+	// Username is the resource owner for the password grant.
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// Password is the resource owner password for the password grant.
+	// +optional
+	Password *ValueSource `json:"password,omitempty"`
+
+	// JWT configures the assertion signed for the jwt-bearer grant.
+	// +optional
+	JWT *JWTAssertion `json:"jwt,omitempty"`
+
+	// SubjectToken is the token exchanged by the token-exchange grant.
+	// +optional
+	SubjectToken *ValueSource `json:"subjectToken,omitempty"`
+
+	// SubjectTokenType is the type of SubjectToken. Defaults to
+	// urn:ietf:params:oauth:token-type:access_token.
+	// +optional
+	SubjectTokenType string `json:"subjectTokenType,omitempty"`
+
+	// Params are extra form parameters sent to the token endpoint.
+	// +optional
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// JWTAssertion describes the JWT signed for the jwt-bearer grant.
+type JWTAssertion struct {
+	// Issuer is the iss claim. Defaults to the client ID.
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+
+	// Subject is the sub claim. Defaults to the issuer.
+	// +optional
+	Subject string `json:"subject,omitempty"`
+
+	// Audience is the aud claim. Defaults to the token URL.
+	// +optional
+	Audience string `json:"audience,omitempty"`
+
+	// PrivateKey is the PEM encoded RSA or ECDSA key the assertion is signed with.
+	PrivateKey ValueSource `json:"privateKey"`
+
+	// KeyID is sent as the kid header when set.
+	// +optional
+	KeyID string `json:"keyID,omitempty"`
+
+	// Lifetime is how long the assertion is valid. Defaults to five minutes.
+	// +optional
+	Lifetime *metav1.Duration `json:"lifetime,omitempty"`
+}
+
+// HTTPStep is one request of a sequence.
+type HTTPStep struct {
+	// Name identifies the step in results.
+	Name string `json:"name"`
+
+	HTTPProbe `json:",inline"`
+
+	// ThinkTime is the pause before the request, such as the time a user
+	// spends reading the previous page.
+	// +optional
+	ThinkTime *metav1.Duration `json:"thinkTime,omitempty"`
+
+	// ThinkTimeDistribution is how load tests vary the think time from one
+	// iteration to the next. The think time is the mean of the distribution.
+	// Defaults to Constant.
+	// +kubebuilder:validation:Enum=Constant;Uniform;Exponential
+	// +optional
+	ThinkTimeDistribution ThinkTimeDistribution `json:"thinkTimeDistribution,omitempty"`
+}
+
+// ThinkTimeDistribution is a distribution of think times.
+type ThinkTimeDistribution string
+
+const (
+	// ThinkTimeConstant pauses for exactly the think time.
+	ThinkTimeConstant ThinkTimeDistribution = "Constant"
+	// ThinkTimeUniform pauses for a time drawn uniformly between zero and
+	// twice the think time.
+	ThinkTimeUniform ThinkTimeDistribution = "Uniform"
+	// ThinkTimeExponential pauses for an exponentially distributed time, as
+	// between the arrivals of independent users.
+	ThinkTimeExponential ThinkTimeDistribution = "Exponential"
+)
+
+// requestSteps returns the requests of a spec that sets either a single
+// request or a sequence of steps. A single request is an unnamed step.
+func requestSteps(http *HTTPProbe, steps []HTTPStep) []HTTPStep {
+	if http != nil {
+		return []HTTPStep{{HTTPProbe: *http}}
+	}
+	return steps
+}
+
+// RequestSteps returns the requests the check issues, in order.
+func (s *CheckSpec) RequestSteps() []HTTPStep {
+	return requestSteps(s.HTTP, s.Steps)
+}
+
+// ValueSources returns every ValueSource the requests of the check, and the
+// export of their spans, read values from.
+func (s *CheckSpec) ValueSources() []*ValueSource {
+	var out []*ValueSource
+	steps := s.RequestSteps()
+	for i := range steps {
+		out = append(out, steps[i].ValueSources()...)
+	}
+	if s.Queue != nil {
+		out = append(out, s.Queue.ValueSources()...)
+	}
+	if s.HTML != nil {
+		out = append(out, s.HTML.ValueSources()...)
+	}
+	if s.Tracing != nil && s.Tracing.OTLP != nil {
+		out = append(out, s.Tracing.OTLP.ValueSources()...)
+	}
+	return out
+}
+
+// HTTPHeader is a single request header.
+type HTTPHeader struct {
+	Name string `json:"name"`
+
+	// Value is the header value.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// ValueFrom reads the header value from a Secret or ConfigMap.
+	// +optional
+	ValueFrom *ValueSource `json:"valueFrom,omitempty"`
+}
+
+// Variable is a named value substituted into a probe request.
+type Variable struct {
+	Name string `json:"name"`
+
+	// Value is the variable value.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// ValueFrom reads the variable value from a Secret or ConfigMap.
+	// +optional
+	ValueFrom *ValueSource `json:"valueFrom,omitempty"`
+}
+
+// ValueSource selects a value stored outside of the spec. Exactly one of its
+// fields must be set. Values read from Secrets are redacted from statuses,
+// events and logs.
+type ValueSource struct {
+	// SecretKeyRef selects a key of a Secret in the namespace of the object.
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+
+	// ConfigMapKeyRef selects a key of a ConfigMap in the namespace of the object.
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// TLSConfig configures the client side of a TLS connection.
+type TLSConfig struct {
+	// ServerName overrides the name used for SNI and certificate verification.
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+
+	// InsecureSkipVerify disables certificate verification.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// CA is a PEM bundle of certificate authorities trusted instead of the
+	// system roots.
+	// +optional
+	CA *ValueSource `json:"ca,omitempty"`
+
+	// Cert is a PEM client certificate presented to the server. Key must be
+	// set with it.
+	// +optional
+	Cert *ValueSource `json:"cert,omitempty"`
+
+	// Key is the PEM private key of Cert.
+	// +optional
+	Key *ValueSource `json:"key,omitempty"`
+}
+
+// ValueSources returns every ValueSource the probe reads values from.
+func (p *HTTPProbe) ValueSources() []*ValueSource {
+	var out []*ValueSource
+	for i := range p.Headers {
+		if p.Headers[i].ValueFrom != nil {
+			out = append(out, p.Headers[i].ValueFrom)
+		}
+	}
+	for i := range p.Variables {
+		if p.Variables[i].ValueFrom != nil {
+			out = append(out, p.Variables[i].ValueFrom)
+		}
+	}
+	if p.TLS != nil {
+		for _, v := range []*ValueSource{p.TLS.CA, p.TLS.Cert, p.TLS.Key} {
+			if v != nil {
+				out = append(out, v)
+			}
+		}
+	}
+	if p.Auth != nil && p.Auth.OAuth2 != nil {
+		o := p.Auth.OAuth2
+		for _, v := range []*ValueSource{o.ClientSecret, o.Password, o.SubjectToken} {
+			if v != nil {
+				out = append(out, v)
+			}
+		}
+		if o.JWT != nil {
+			out = append(out, &o.JWT.PrivateKey)
+		}
+	}
+	return out
 }
 
 // CheckStatus defines the observed state of Check
 type CheckStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// LastRun is the name of the most recent SyntheticRun created for the check.
+	// +optional
+	LastRun string `json:"lastRun,omitempty"`
+
+	// RenderedSpec is the spec the check runs with, rendered from its
+	// template. It is only set for checks with a templateRef.
+	// +optional
+	RenderedSpec *CheckSpec `json:"renderedSpec,omitempty"`
+
+	// RenderError explains why the template could not be rendered. The
+	// check keeps running with the last rendered spec meanwhile.
+	// +optional
+	RenderError string `json:"renderError,omitempty"`
+
+	// Maintenance is the maintenance window the check is in. Its runs are
+	// silenced until the window ends.
+	// +optional
+	Maintenance *ActiveMaintenance `json:"maintenance,omitempty"`
+}
+
+// ActiveMaintenance describes a maintenance window in progress.
+type ActiveMaintenance struct {
+	// Window is the name of the MaintenanceWindow.
+	Window string `json:"window"`
+
+	// Reason explains the maintenance.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// End is when the window ends.
+	End metav1.Time `json:"end"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 
 // Check is the Schema for the checks API
 type Check struct {
@@ -54,6 +508,48 @@ type CheckList struct {
 	Items           []Check `json:"items"`
 }
 
+// EffectiveSpec returns the spec the check runs with: the rendered spec for
+// checks with a template, and the check's own spec otherwise or until the
+// template has been rendered.
+func (c *Check) EffectiveSpec() *CheckSpec {
+	if c.Spec.TemplateRef != "" && c.Status.RenderedSpec != nil {
+		return c.Status.RenderedSpec
+	}
+	return &c.Spec
+}
+
+// IntervalOrDefault returns the time between scheduled runs of the check.
+func (c *Check) IntervalOrDefault() time.Duration {
+	spec := c.EffectiveSpec()
+	if spec.Interval == nil || spec.Interval.Duration <= 0 {
+		return DefaultCheckInterval
+	}
+	return spec.Interval.Duration
+}
+
+// HistoryLimitOrDefault returns the number of finished runs kept per location.
+func (c *Check) HistoryLimitOrDefault() int {
+	spec := c.EffectiveSpec()
+	if spec.HistoryLimit == nil || *spec.HistoryLimit < 0 {
+		return DefaultHistoryLimit
+	}
+	return int(*spec.HistoryLimit)
+}
+
+// RunsIn returns true if the check is scheduled in the given location.
+func (c *Check) RunsIn(location string) bool {
+	spec := c.EffectiveSpec()
+	if len(spec.Locations) == 0 {
+		return true
+	}
+	for _, l := range spec.Locations {
+		if l == location {
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
 	SchemeBuilder.Register(&Check{}, &CheckList{})
 }
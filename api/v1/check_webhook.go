@@ -0,0 +1,83 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/perph/perph/pkg/dependency"
+)
+
+// +kubebuilder:webhook:path=/validate-synthetic-perph-io-v1-check,mutating=false,failurePolicy=fail,groups=synthetic.perph.io,resources=checks,verbs=create;update,versions=v1,name=vcheck.kb.io
+
+// SetupWebhookWithManager registers the webhook that rejects Checks whose
+// dependencies would form a cycle with the webhook server of mgr.
+func (r *Check) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register("/validate-synthetic-perph-io-v1-check",
+		&webhook.Admission{Handler: &checkValidator{}})
+	return nil
+}
+
+// checkValidator rejects Checks whose dependencies would form a cycle with
+// those of the other Checks in their namespace.
+type checkValidator struct {
+	client  client.Client
+	decoder *admission.Decoder
+}
+
+func (v *checkValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var check Check
+	if err := v.decoder.Decode(req, &check); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if len(check.Spec.DependsOn) == 0 {
+		return admission.Allowed("")
+	}
+
+	var list CheckList
+	if err := v.client.List(ctx, &list, client.InNamespace(req.Namespace)); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	graph := map[string][]string{}
+	for _, c := range list.Items {
+		graph[c.Name] = c.Spec.DependsOn
+	}
+	graph[check.Name] = check.Spec.DependsOn
+	if cycle := dependency.Cycle(graph, check.Name); cycle != nil {
+		return admission.Denied(fmt.Sprintf("dependsOn forms a cycle: %s", strings.Join(cycle, " -> ")))
+	}
+	return admission.Allowed("")
+}
+
+// InjectClient injects the client.
+func (v *checkValidator) InjectClient(c client.Client) error {
+	v.client = c
+	return nil
+}
+
+// InjectDecoder injects the decoder.
+func (v *checkValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestCheckValidator(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	g.Expect(AddToScheme(scheme)).To(Succeed())
+	decoder, err := admission.NewDecoder(scheme)
+	g.Expect(err).NotTo(HaveOccurred())
+	check := func(name string, dependsOn ...string) *Check {
+		return &Check{
+			TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "Check"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       CheckSpec{DependsOn: dependsOn},
+		}
+	}
+	// The frontend depends on the api, which depends on the database.
+	v := &checkValidator{
+		client:  fake.NewFakeClientWithScheme(scheme, check("frontend", "api"), check("api", "db"), check("db")),
+		decoder: decoder,
+	}
+	handle := func(c *Check) admission.Response {
+		raw, err := json.Marshal(c)
+		g.Expect(err).NotTo(HaveOccurred())
+		return v.Handle(ctx, admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Namespace: c.Namespace,
+			Name:      c.Name,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+	}
+
+	g.Expect(handle(check("cache")).Allowed).To(BeTrue())
+	g.Expect(handle(check("db", "cache")).Allowed).To(BeTrue())
+	g.Expect(handle(check("checkout", "frontend", "db")).Allowed).To(BeTrue())
+
+	// Making the database depend on the frontend closes a cycle.
+	res := handle(check("db", "frontend"))
+	g.Expect(res.Allowed).To(BeFalse())
+	g.Expect(string(res.Result.Reason)).To(Equal("dependsOn forms a cycle: db -> frontend -> api -> db"))
+
+	res = handle(check("self", "self"))
+	g.Expect(res.Allowed).To(BeFalse())
+	g.Expect(string(res.Result.Reason)).To(ContainSubstring("self -> self"))
+
+	res = v.Handle(ctx, admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+		Object: runtime.RawExtension{Raw: []byte("{")},
+	}})
+	g.Expect(res.Allowed).To(BeFalse())
+	g.Expect(res.Result.Code).To(Equal(int32(http.StatusBadRequest)))
+}
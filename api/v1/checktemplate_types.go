@@ -0,0 +1,97 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// Annotations on Services and Ingresses that opt them into discovery.
+const (
+	// ProbeAnnotation set to "true" generates a Check for the object.
+	ProbeAnnotation = "perph.io/probe"
+	// PathAnnotation is the path requested by the generated Check.
+	// Defaults to "/".
+	PathAnnotation = "perph.io/path"
+	// IntervalAnnotation overrides the interval of the generated Check.
+	IntervalAnnotation = "perph.io/interval"
+	// PortAnnotation selects the Service port probed, by name or number.
+	// Defaults to the first port.
+	PortAnnotation = "perph.io/port"
+	// TemplateAnnotation names the CheckTemplate the generated Check is
+	// rendered from.
+	TemplateAnnotation = "perph.io/template"
+)
+
+// CheckTemplateSpec defines the desired state of CheckTemplate
+type CheckTemplateSpec struct {
+	// Parameters declares the parameters of the template.
+	// +optional
+	Parameters []TemplateParameter `json:"parameters,omitempty"`
+
+	// Template is the spec of the Checks rendered from the template. Every
+	// $(NAME) in its strings is replaced by the parameter NAME, and names
+	// that are not parameters are left for the probe to resolve as
+	// variables. Discovery also provides the parameters URL, SCHEME, HOST,
+	// PORT, PATH, NAME and NAMESPACE, and fills in the URL when the template
+	// leaves it empty.
+	Template CheckSpec `json:"template"`
+}
+
+// TemplateParameter declares a parameter of a CheckTemplate.
+type TemplateParameter struct {
+	Name string `json:"name"`
+
+	// Description documents the parameter.
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Default is used when a Check does not set the parameter. Parameters
+	// without a default are required.
+	// +optional
+	Default *string `json:"default,omitempty"`
+}
+
+// CheckTemplateStatus defines the observed state of CheckTemplate
+type CheckTemplateStatus struct {
+}
+
+// +kubebuilder:object:root=true
+
+// CheckTemplate is the Schema for the checktemplates API
+type CheckTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CheckTemplateSpec   `json:"spec,omitempty"`
+	Status CheckTemplateStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CheckTemplateList contains a list of CheckTemplate
+type CheckTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CheckTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CheckTemplate{}, &CheckTemplateList{})
+}
@@ -16,25 +16,231 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+const (
+	// DefaultExportKeyTemplate partitions exported files by kind, day and
+	// the name of the Check or LoadTest.
+	DefaultExportKeyTemplate = "$(kind)/date=$(date)/name=$(name)"
+	// DefaultExportMaxFileSize is the size a file is written at.
+	DefaultExportMaxFileSize = 64 << 20
+	// DefaultExportMaxFileAge is how long runs wait to be written.
+	DefaultExportMaxFileAge = 5 * time.Minute
+	// DefaultExportBatchSize is the number of runs sent per request.
+	DefaultExportBatchSize = 500
+	// DefaultExportBatchAge is how long runs wait to be sent.
+	DefaultExportBatchAge = 10 * time.Second
+	// DefaultExportRetries is the number of times a failed request is
+	// retried.
+	DefaultExportRetries = 3
+	// DefaultExportRetryBackoff is the delay before the first retry, which
+	// doubles with every further one.
+	DefaultExportRetryBackoff = time.Second
+	// DefaultInfluxMeasurement is the measurement runs are written to.
+	DefaultInfluxMeasurement = "perph_run"
+	// DefaultExportBackoffLimit is the number of failed attempts after
+	// which a one-shot task fails, as for Jobs.
+	DefaultExportBackoffLimit = 6
+)
+
+// ExportMode decides when a task exports runs.
+// +kubebuilder:validation:Enum=Continuous;Scheduled;OneShot
+type ExportMode string
+
+const (
+	// ExportContinuous exports runs as they finish, in batches the sink
+	// decides on.
+	ExportContinuous ExportMode = "Continuous"
+	// ExportScheduled exports the runs that finished since the last export
+	// on a cron schedule.
+	ExportScheduled ExportMode = "Scheduled"
+	// ExportOneShot exports the runs of the time range once, then
+	// completes like a Job.
+	ExportOneShot ExportMode = "OneShot"
+)
+
 // ExportTaskSpec defines the desired state of ExportTask
 type ExportTaskSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Sink is where the finished SyntheticRuns in the namespace of the task
+	// are exported to.
+	Sink ExportSink `json:"sink"`
+
+	// Mode defaults to Continuous.
+	// +optional
+	Mode ExportMode `json:"mode,omitempty"`
+
+	// Schedule is the cron schedule, in UTC, of a Scheduled task.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// TimeRange limits the runs exported to those that finished within it.
+	// Runs that finished before the task was created are read from the
+	// result store when the manager keeps one, so a new sink can be
+	// backfilled with more history than the cluster holds.
+	// +optional
+	TimeRange *ExportTimeRange `json:"timeRange,omitempty"`
+
+	// BackoffLimit is the number of failed attempts after which a OneShot
+	// task fails. Defaults to 6.
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+}
+
+// ExportTimeRange is a range of completion times of runs.
+type ExportTimeRange struct {
+	// From is the start of the range. Unbounded when not set.
+	// +optional
+	From *metav1.Time `json:"from,omitempty"`
+
+	// To is the end of the range, excluded. A OneShot task exports up to
+	// the time it started when not set, other tasks are unbounded.
+	// +optional
+	To *metav1.Time `json:"to,omitempty"`
+}
+
+// ExportSink is a destination of exported runs. Exactly one member must be
+// set.
+type ExportSink struct {
+	// S3 writes runs as files to an S3-compatible object store.
+	// +optional
+	S3 *S3Sink `json:"s3,omitempty"`
+
+	// Influx writes runs to InfluxDB in line protocol.
+	// +optional
+	Influx *InfluxSink `json:"influx,omitempty"`
+
+	// OTLP sends runs as OpenTelemetry metrics.
+	// +optional
+	OTLP *OTLPSink `json:"otlp,omitempty"`
+}
+
+// ExportFormat is the file format runs are exported in.
+// +kubebuilder:validation:Enum=CSV;JSONL;Parquet
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "CSV"
+	ExportJSONL   ExportFormat = "JSONL"
+	ExportParquet ExportFormat = "Parquet"
+)
+
+// S3Sink writes runs to a bucket of an S3-compatible object store, one row
+// per run.
+type S3Sink struct {
+	// Endpoint is the URL of the object store, e.g.
+	// https://s3.eu-west-1.amazonaws.com or http://minio.minio:9000.
+	Endpoint string `json:"endpoint"`
+
+	// Region the bucket is in. Defaults to us-east-1.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	Bucket string `json:"bucket"`
+
+	// PathStyle addresses the bucket in the path of requests instead of
+	// the host name, as MinIO and most other S3-compatible stores expect.
+	// +optional
+	PathStyle bool `json:"pathStyle,omitempty"`
+
+	// CredentialsSecret names a Secret in the namespace of the task with
+	// the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to sign requests with,
+	// and an optional AWS_SESSION_TOKEN.
+	CredentialsSecret string `json:"credentialsSecret"`
+
+	// Format is the format of the files. Defaults to JSONL.
+	// +optional
+	Format ExportFormat `json:"format,omitempty"`
+
+	// Prefix is prepended to the key of every file.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// KeyTemplate is the partition of the key of a file, after Prefix and
+	// before the file name. $(date), $(hour), $(namespace), $(kind),
+	// $(name) and $(location) are replaced with those of the runs in the
+	// file, kind being "check" or "loadtest". Defaults to
+	// "$(kind)/date=$(date)/name=$(name)".
+	// +optional
+	KeyTemplate string `json:"keyTemplate,omitempty"`
+
+	// MaxFileSize is the size pending runs are written at, as files of at
+	// most this size. Defaults to 64Mi.
+	// +optional
+	MaxFileSize *resource.Quantity `json:"maxFileSize,omitempty"`
+
+	// MaxFileAge is how long a finished run waits for more to be written
+	// with. Defaults to five minutes.
+	// +optional
+	MaxFileAge *metav1.Duration `json:"maxFileAge,omitempty"`
 }
 
+// ExportPhase is the state of an ExportTask.
+type ExportPhase string
+
+const (
+	ExportRunning   ExportPhase = "Running"
+	ExportSucceeded ExportPhase = "Succeeded"
+	ExportFailed    ExportPhase = "Failed"
+)
+
 // ExportTaskStatus defines the observed state of ExportTask
 type ExportTaskStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Phase is Running until a OneShot task completes.
+	// +optional
+	Phase ExportPhase `json:"phase,omitempty"`
+
+	// StartTime is when the task first ran.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when a OneShot task completed.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Exported is the number of runs exported so far.
+	// +optional
+	Exported int64 `json:"exported,omitempty"`
+
+	// Total is the number of runs exported so far and waiting to be.
+	// +optional
+	Total int64 `json:"total,omitempty"`
+
+	// LastError explains why the last attempt to export failed. It is
+	// cleared by the next one that succeeds.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// Failures is the number of failed attempts of a OneShot task.
+	// +optional
+	Failures int32 `json:"failures,omitempty"`
+
+	// LastScheduleTime is when a Scheduled task last exported.
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastExportTime is when the task last shipped a batch to its sink.
+	// +optional
+	LastExportTime *metav1.Time `json:"lastExportTime,omitempty"`
+
+	// Watermark is the completion time of the newest SyntheticRun exported so far.
+	// +optional
+	Watermark *metav1.Time `json:"watermark,omitempty"`
+
+	// WatermarkRun is the name of the newest SyntheticRun exported so far.
+	// It orders runs that finished at the Watermark.
+	// +optional
+	WatermarkRun string `json:"watermarkRun,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 
 // ExportTask is the Schema for the exporttasks API
 type ExportTask struct {
@@ -54,6 +260,220 @@ type ExportTaskList struct {
 	Items           []ExportTask `json:"items"`
 }
 
+// ExportCompression is how request bodies are compressed.
+// +kubebuilder:validation:Enum=None;Gzip
+type ExportCompression string
+
+const (
+	CompressionNone ExportCompression = "None"
+	CompressionGzip ExportCompression = "Gzip"
+)
+
+// ExportDelivery configures how a sink sends runs.
+type ExportDelivery struct {
+	// BatchSize is the number of runs sent per request. Defaults to 500.
+	// +optional
+	BatchSize *int32 `json:"batchSize,omitempty"`
+
+	// BatchAge is how long a finished run waits for more to be sent with.
+	// Defaults to ten seconds.
+	// +optional
+	BatchAge *metav1.Duration `json:"batchAge,omitempty"`
+
+	// Retries is the number of times a request that failed on a network
+	// error, a 429 or a 5xx response is retried before the task gives up
+	// until its next attempt. Defaults to 3.
+	// +optional
+	Retries *int32 `json:"retries,omitempty"`
+
+	// RetryBackoff is the delay before the first retry, which doubles with
+	// every further one. Defaults to one second.
+	// +optional
+	RetryBackoff *metav1.Duration `json:"retryBackoff,omitempty"`
+
+	// Compression of request bodies. Defaults to Gzip.
+	// +optional
+	Compression ExportCompression `json:"compression,omitempty"`
+
+	// ResourceLabels maps labels of the Check or LoadTest of a run to the
+	// tags or resource attributes it is sent with, by label key.
+	// +optional
+	ResourceLabels map[string]string `json:"resourceLabels,omitempty"`
+}
+
+// InfluxSink writes runs to InfluxDB, one point per run in Measurement,
+// tagged with the namespace, kind, name, location and phase of the run.
+type InfluxSink struct {
+	// URL is the base URL of InfluxDB, e.g. http://influxdb:8086.
+	URL string `json:"url"`
+
+	// Org and Bucket select where runs are written in InfluxDB 2.
+	// +optional
+	Org string `json:"org,omitempty"`
+	// +optional
+	Bucket string `json:"bucket,omitempty"`
+
+	// Database selects where runs are written in InfluxDB 1, when Bucket
+	// is not set.
+	// +optional
+	Database string `json:"database,omitempty"`
+
+	// Measurement defaults to perph_run.
+	// +optional
+	Measurement string `json:"measurement,omitempty"`
+
+	// TokenFrom reads the token requests are authorized with, or the
+	// username:password of InfluxDB 1.
+	// +optional
+	TokenFrom *ValueSource `json:"tokenFrom,omitempty"`
+
+	ExportDelivery `json:",inline"`
+}
+
+// OTLPProtocol is the transport of OTLP requests.
+// +kubebuilder:validation:Enum=HTTP;GRPC
+type OTLPProtocol string
+
+const (
+	// OTLPHTTP posts protobuf to <endpoint>/v1/metrics or /v1/traces.
+	OTLPHTTP OTLPProtocol = "HTTP"
+	// OTLPGRPC calls the services of the collector over HTTP/2.
+	OTLPGRPC OTLPProtocol = "GRPC"
+)
+
+// OTLPEndpoint is an OpenTelemetry collector.
+type OTLPEndpoint struct {
+	// Endpoint is the URL of the collector, e.g. http://collector:4318 for
+	// HTTP or http://collector:4317 for GRPC. GRPC over http URLs uses
+	// HTTP/2 without TLS.
+	Endpoint string `json:"endpoint"`
+
+	// Protocol defaults to HTTP.
+	// +optional
+	Protocol OTLPProtocol `json:"protocol,omitempty"`
+
+	// Headers are sent with every request, e.g. for authentication.
+	// +optional
+	Headers []HTTPHeader `json:"headers,omitempty"`
+}
+
+// ValueSources returns every reference to a Secret or ConfigMap in e.
+func (e *OTLPEndpoint) ValueSources() []*ValueSource {
+	var out []*ValueSource
+	for i := range e.Headers {
+		if e.Headers[i].ValueFrom != nil {
+			out = append(out, e.Headers[i].ValueFrom)
+		}
+	}
+	return out
+}
+
+// OTLPSink sends runs to an OpenTelemetry collector as gauges of their
+// durations and results.
+type OTLPSink struct {
+	OTLPEndpoint   `json:",inline"`
+	ExportDelivery `json:",inline"`
+}
+
+// BatchSizeOrDefault returns the number of runs sent per request.
+func (d *ExportDelivery) BatchSizeOrDefault() int {
+	if d.BatchSize == nil || *d.BatchSize <= 0 {
+		return DefaultExportBatchSize
+	}
+	return int(*d.BatchSize)
+}
+
+// BatchAgeOrDefault returns how long finished runs wait to be sent.
+func (d *ExportDelivery) BatchAgeOrDefault() time.Duration {
+	if d.BatchAge == nil || d.BatchAge.Duration <= 0 {
+		return DefaultExportBatchAge
+	}
+	return d.BatchAge.Duration
+}
+
+// RetriesOrDefault returns the number of times a failed request is retried.
+func (d *ExportDelivery) RetriesOrDefault() int {
+	if d.Retries == nil || *d.Retries < 0 {
+		return DefaultExportRetries
+	}
+	return int(*d.Retries)
+}
+
+// RetryBackoffOrDefault returns the delay before the first retry.
+func (d *ExportDelivery) RetryBackoffOrDefault() time.Duration {
+	if d.RetryBackoff == nil || d.RetryBackoff.Duration <= 0 {
+		return DefaultExportRetryBackoff
+	}
+	return d.RetryBackoff.Duration
+}
+
+// ModeOrDefault returns when the task exports runs.
+func (t *ExportTask) ModeOrDefault() ExportMode {
+	if t.Spec.Mode == "" {
+		return ExportContinuous
+	}
+	return t.Spec.Mode
+}
+
+// BackoffLimitOrDefault returns the number of failed attempts after which
+// a OneShot task fails.
+func (t *ExportTask) BackoffLimitOrDefault() int32 {
+	if t.Spec.BackoffLimit == nil || *t.Spec.BackoffLimit < 0 {
+		return DefaultExportBackoffLimit
+	}
+	return *t.Spec.BackoffLimit
+}
+
+// FormatOrDefault returns the format files are written in.
+func (s *S3Sink) FormatOrDefault() ExportFormat {
+	if s.Format == "" {
+		return ExportJSONL
+	}
+	return s.Format
+}
+
+// RegionOrDefault returns the region of the bucket.
+func (s *S3Sink) RegionOrDefault() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+// KeyTemplateOrDefault returns the partition of the keys of files.
+func (s *S3Sink) KeyTemplateOrDefault() string {
+	if s.KeyTemplate == "" {
+		return DefaultExportKeyTemplate
+	}
+	return s.KeyTemplate
+}
+
+// MaxFileSizeOrDefault returns the size pending runs are written at.
+func (s *S3Sink) MaxFileSizeOrDefault() int64 {
+	if s.MaxFileSize == nil || s.MaxFileSize.Value() <= 0 {
+		return DefaultExportMaxFileSize
+	}
+	return s.MaxFileSize.Value()
+}
+
+// MaxFileAgeOrDefault returns how long finished runs wait to be written.
+func (s *S3Sink) MaxFileAgeOrDefault() time.Duration {
+	if s.MaxFileAge == nil || s.MaxFileAge.Duration <= 0 {
+		return DefaultExportMaxFileAge
+	}
+	return s.MaxFileAge.Duration
+}
+
+// After returns true if run finished after the watermark of the task.
+func (t *ExportTask) After(run *SyntheticRun) bool {
+	if run.Status.CompletionTime == nil {
+		return false
+	}
+	w := t.Status.Watermark
+	done := run.Status.CompletionTime
+	return w == nil || w.Before(done) || (w.Equal(done) && run.Name > t.Status.WatermarkRun)
+}
+
 func init() {
 	SchemeBuilder.Register(&ExportTask{}, &ExportTaskList{})
 }
@@ -0,0 +1,232 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+const (
+	// FaultProxyLabel is set on the pods of a standalone FaultProxy to its
+	// name.
+	FaultProxyLabel = "perph.io/fault-proxy"
+	// FaultProxyContainer is the name of the container that runs the proxy,
+	// in its own pods or as a sidecar.
+	FaultProxyContainer = "perph-fault-proxy"
+	// FaultProxyFinalizer removes the sidecar of a FaultProxy from its
+	// target before the FaultProxy is deleted.
+	FaultProxyFinalizer = "perph.io/fault-proxy"
+
+	// DefaultFaultProxyPort is the port the proxy listens on by default.
+	DefaultFaultProxyPort = 8080
+	// DefaultFaultErrorStatus answers the requests an HTTP fault fails by
+	// default.
+	DefaultFaultErrorStatus = 503
+)
+
+// FaultProxyMode is how a FaultProxy is deployed.
+// +kubebuilder:validation:Enum=Standalone;Sidecar
+type FaultProxyMode string
+
+const (
+	// FaultProxyStandalone runs the proxy in a Deployment of its own,
+	// behind a Service named after the FaultProxy.
+	FaultProxyStandalone FaultProxyMode = "Standalone"
+	// FaultProxySidecar adds the proxy to the pods of a Deployment, which
+	// reach their dependency through localhost.
+	FaultProxySidecar FaultProxyMode = "Sidecar"
+)
+
+// FaultProtocol is the protocol a FaultProxy forwards.
+// +kubebuilder:validation:Enum=TCP;HTTP
+type FaultProtocol string
+
+const (
+	// FaultTCP forwards connections, and injects faults per connection.
+	FaultTCP FaultProtocol = "TCP"
+	// FaultHTTP forwards HTTP requests, and injects faults per request.
+	FaultHTTP FaultProtocol = "HTTP"
+)
+
+// FaultProxySpec defines the desired state of FaultProxy
+type FaultProxySpec struct {
+	// Mode defaults to Standalone.
+	// +optional
+	Mode FaultProxyMode `json:"mode,omitempty"`
+
+	// Target names the Deployment, in the same namespace, the proxy is
+	// added to as a sidecar. Required in Sidecar mode.
+	// +optional
+	Target string `json:"target,omitempty"`
+
+	// Upstream is the host:port of the dependency traffic is forwarded to.
+	Upstream string `json:"upstream"`
+
+	// Port is the port the proxy listens on. Defaults to 8080.
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// Protocol defaults to TCP.
+	// +optional
+	Protocol FaultProtocol `json:"protocol,omitempty"`
+
+	// Image is the image of the proxy. Defaults to the image of the
+	// operator.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Faults are injected during their windows. When several are active,
+	// the largest latency and bandwidth limit apply, and their error and
+	// reset percentages add up.
+	// +optional
+	Faults []Fault `json:"faults,omitempty"`
+}
+
+// Fault is a degradation of the dependency. A fault without a window is
+// always injected.
+type Fault struct {
+	// Name identifies the fault in the status and in Validations.
+	Name string `json:"name"`
+
+	// Schedule is a cron schedule of the starts of a recurring window that
+	// lasts Duration.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Duration is how long a recurring window lasts.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// TimeZone is the IANA time zone the schedule is interpreted in.
+	// Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Start is the start of a one-off window.
+	// +optional
+	Start *metav1.Time `json:"start,omitempty"`
+
+	// End is the end of a one-off window.
+	// +optional
+	End *metav1.Time `json:"end,omitempty"`
+
+	// Latency delays every connection, or every request with HTTP.
+	// +optional
+	Latency *metav1.Duration `json:"latency,omitempty"`
+
+	// Jitter adds a random delay of up to this long to Latency.
+	// +optional
+	Jitter *metav1.Duration `json:"jitter,omitempty"`
+
+	// ErrorPercent is the percentage of requests answered with ErrorStatus
+	// with HTTP, or of connections closed without being forwarded with TCP.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ErrorPercent *int32 `json:"errorPercent,omitempty"`
+
+	// ErrorStatus is the status of failed HTTP requests. Defaults to 503.
+	// +optional
+	ErrorStatus *int32 `json:"errorStatus,omitempty"`
+
+	// ResetPercent is the percentage of connections, or of requests with
+	// HTTP, reset by the proxy.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ResetPercent *int32 `json:"resetPercent,omitempty"`
+
+	// Bandwidth limits the bytes per second forwarded in each direction of
+	// a connection, or of a request with HTTP.
+	// +optional
+	Bandwidth *resource.Quantity `json:"bandwidth,omitempty"`
+}
+
+// FaultProxyStatus defines the observed state of FaultProxy
+type FaultProxyStatus struct {
+	// ObservedGeneration is the generation of the spec the proxy runs.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Address is where clients reach the dependency through the proxy.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// ActiveFaults lists the faults being injected.
+	// +optional
+	ActiveFaults []string `json:"activeFaults,omitempty"`
+
+	// NextChange is when a fault next starts or ends.
+	// +optional
+	NextChange *metav1.Time `json:"nextChange,omitempty"`
+
+	// Message explains why the proxy cannot be deployed.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// FaultProxy is the Schema for the faultproxies API
+type FaultProxy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FaultProxySpec   `json:"spec,omitempty"`
+	Status FaultProxyStatus `json:"status,omitempty"`
+}
+
+// PortOrDefault returns the port the proxy listens on.
+func (s *FaultProxySpec) PortOrDefault() int32 {
+	if s.Port == nil || *s.Port <= 0 {
+		return DefaultFaultProxyPort
+	}
+	return *s.Port
+}
+
+// ModeOrDefault returns how the proxy is deployed.
+func (p *FaultProxy) ModeOrDefault() FaultProxyMode {
+	if p.Spec.Mode == "" {
+		return FaultProxyStandalone
+	}
+	return p.Spec.Mode
+}
+
+// ErrorStatusOrDefault returns the status of the HTTP requests f fails.
+func (f *Fault) ErrorStatusOrDefault() int {
+	if f.ErrorStatus == nil || *f.ErrorStatus < 100 {
+		return DefaultFaultErrorStatus
+	}
+	return int(*f.ErrorStatus)
+}
+
+// +kubebuilder:object:root=true
+
+// FaultProxyList contains a list of FaultProxy
+type FaultProxyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FaultProxy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FaultProxy{}, &FaultProxyList{})
+}
@@ -0,0 +1,112 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// PausedByAnnotation is set on a Deployment paused by a Gate to the name of
+// the Gate.
+const PausedByAnnotation = "perph.io/paused-by"
+
+// GateSpec defines the desired state of Gate
+type GateSpec struct {
+	// DeploymentRef names the Deployment, in the same namespace, whose
+	// rollouts are gated.
+	DeploymentRef string `json:"deploymentRef"`
+
+	// Checks name the Checks whose latest runs must pass.
+	// +optional
+	Checks []string `json:"checks,omitempty"`
+
+	// Validations name the Validations whose latest assertions must pass.
+	// +optional
+	Validations []string `json:"validations,omitempty"`
+
+	// LoadTests name the LoadTests whose most recent runs must succeed
+	// without regressing.
+	// +optional
+	LoadTests []string `json:"loadTests,omitempty"`
+
+	// Resume resumes a rollout the gate paused once everything passes
+	// again. Otherwise the rollout stays paused until resumed by hand.
+	// +optional
+	Resume bool `json:"resume,omitempty"`
+}
+
+// GatePhase describes whether a Gate lets rollouts proceed.
+type GatePhase string
+
+const (
+	// GateOpen means everything the gate watches passes, or has yet to
+	// finish a run.
+	GateOpen GatePhase = "Open"
+	// GateClosed means something the gate watches failed, and rollouts of
+	// the Deployment are paused.
+	GateClosed GatePhase = "Closed"
+)
+
+// GateStatus defines the observed state of Gate
+type GateStatus struct {
+	// Phase is whether the gate lets rollouts proceed.
+	// +optional
+	Phase GatePhase `json:"phase,omitempty"`
+
+	// Failing lists what failed, as kind/name.
+	// +optional
+	Failing []string `json:"failing,omitempty"`
+
+	// Paused is true while the gate holds the Deployment paused.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// Message is a human readable explanation of the phase.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastTransitionTime is when the phase last changed.
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Gate is the Schema for the gates API
+type Gate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GateSpec   `json:"spec,omitempty"`
+	Status GateStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// GateList contains a list of Gate
+type GateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Gate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Gate{}, &GateList{})
+}
@@ -0,0 +1,194 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// DefaultHTMLCrawlDepth is used when a crawl does not set a depth.
+	DefaultHTMLCrawlDepth = 1
+	// DefaultHTMLCrawlMaxRequests is used when a crawl does not bound its
+	// requests.
+	DefaultHTMLCrawlMaxRequests = 100
+)
+
+// HTMLProbe fetches a page, parses it and asserts on its content. It can
+// also verify the links and assets the page references. Scripts do not run,
+// so content rendered in the browser is not seen.
+type HTMLProbe struct {
+	// The request of the page. The status of the response is checked like
+	// that of any HTTP probe.
+	HTTPProbe `json:",inline"`
+
+	// Assertions are evaluated against the parsed page.
+	// +optional
+	Assertions []HTMLAssertion `json:"assertions,omitempty"`
+
+	// Crawl verifies the links and assets of the page, and of the pages it
+	// links to on the same origin.
+	// +optional
+	Crawl *HTMLCrawl `json:"crawl,omitempty"`
+}
+
+// HTMLAssertion asserts on the elements of a page a CSS selector matches.
+// Without a count or text constraint, at least one element must match.
+type HTMLAssertion struct {
+	// Name identifies the assertion in the results of a run. Defaults to the
+	// selector.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Selector is a CSS selector. Type, universal, id, class and attribute
+	// selectors can be combined with the descendant and child combinators,
+	// and groups are separated by commas.
+	Selector string `json:"selector"`
+
+	// MinCount is the fewest elements that must match.
+	// +optional
+	MinCount *int32 `json:"minCount,omitempty"`
+
+	// MaxCount is the most elements that may match. Set it to 0 to assert
+	// that nothing matches.
+	// +optional
+	MaxCount *int32 `json:"maxCount,omitempty"`
+
+	// Attribute asserts on the value of an attribute of the matched
+	// elements instead of their text.
+	// +optional
+	Attribute string `json:"attribute,omitempty"`
+
+	// Contains must be part of the text, or attribute, of a matched element.
+	// +optional
+	Contains string `json:"contains,omitempty"`
+
+	// Matches is a regular expression the text, or attribute, of a matched
+	// element must match.
+	// +optional
+	Matches string `json:"matches,omitempty"`
+}
+
+// HTMLCrawl configures how the links and assets of a page are verified.
+type HTMLCrawl struct {
+	// Depth is the number of links followed from the page. Links of pages
+	// at that depth are checked but not parsed. Defaults to 1, which checks
+	// the links and assets of the page itself.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Depth *int32 `json:"depth,omitempty"`
+
+	// MaxRequests bounds the number of links and assets requested.
+	// Defaults to 100.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxRequests *int32 `json:"maxRequests,omitempty"`
+
+	// ExternalLinks also checks links to other origins. They are never
+	// followed. Assets are checked wherever they are served from.
+	// +optional
+	ExternalLinks bool `json:"externalLinks,omitempty"`
+
+	// MaxAssetSize reports assets larger than this.
+	// +optional
+	MaxAssetSize *resource.Quantity `json:"maxAssetSize,omitempty"`
+
+	// FailOn lists the findings that fail the run. The others are only
+	// reported. Defaults to every type of finding.
+	// +optional
+	FailOn []FindingType `json:"failOn,omitempty"`
+}
+
+// DepthOrDefault returns the number of links followed from the page.
+func (c *HTMLCrawl) DepthOrDefault() int {
+	if c.Depth == nil || *c.Depth < 1 {
+		return DefaultHTMLCrawlDepth
+	}
+	return int(*c.Depth)
+}
+
+// MaxRequestsOrDefault returns the number of links and assets requested at
+// most.
+func (c *HTMLCrawl) MaxRequestsOrDefault() int {
+	if c.MaxRequests == nil || *c.MaxRequests < 1 {
+		return DefaultHTMLCrawlMaxRequests
+	}
+	return int(*c.MaxRequests)
+}
+
+// Fails returns true if findings of type t fail the run.
+func (c *HTMLCrawl) Fails(t FindingType) bool {
+	if len(c.FailOn) == 0 {
+		return true
+	}
+	for _, f := range c.FailOn {
+		if f == t {
+			return true
+		}
+	}
+	return false
+}
+
+// FindingType is the kind of problem a finding reports.
+// +kubebuilder:validation:Enum=BrokenLink;MixedContent;OversizedAsset
+type FindingType string
+
+const (
+	// FindingBrokenLink is a link or asset that cannot be fetched, or whose
+	// response has a 4xx or 5xx status.
+	FindingBrokenLink FindingType = "BrokenLink"
+	// FindingMixedContent is an asset of an https page loaded over http.
+	FindingMixedContent FindingType = "MixedContent"
+	// FindingOversizedAsset is an asset larger than the crawl allows.
+	FindingOversizedAsset FindingType = "OversizedAsset"
+)
+
+// Finding is a problem a run found besides its assertions, such as a broken
+// link of a page.
+type Finding struct {
+	Type FindingType `json:"type"`
+
+	// URL is the link or asset the finding is about.
+	URL string `json:"url"`
+
+	// Page is the page that references URL.
+	// +optional
+	Page string `json:"page,omitempty"`
+
+	// Message describes the problem.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// HTMLResult summarises the crawl of an HTML probe.
+type HTMLResult struct {
+	// Title is the title of the page.
+	// +optional
+	Title string `json:"title,omitempty"`
+
+	// Pages is the number of pages parsed, including the probed page.
+	Pages int32 `json:"pages"`
+
+	// Links is the number of links checked.
+	Links int32 `json:"links"`
+
+	// Assets is the number of assets checked.
+	Assets int32 `json:"assets"`
+
+	// Truncated is set when the crawl stopped at its request limit.
+	// +optional
+	Truncated bool `json:"truncated,omitempty"`
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -24,17 +25,619 @@ import (
 
 // LoadTestSpec defines the desired state of LoadTest
 type LoadTestSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// HTTP is the request every virtual user issues in a loop.
+	// +optional
+	HTTP *HTTPProbe `json:"http,omitempty"`
+
+	// Steps is the sequence of requests every virtual user issues in a
+	// loop, instead of a single request.
+	// +optional
+	Steps []HTTPStep `json:"steps,omitempty"`
+
+	// Scenarios are the workloads the load test mixes. The requests of HTTP
+	// or Steps, when set, form an implicit Shared scenario named "default".
+	// +optional
+	Scenarios []Scenario `json:"scenarios,omitempty"`
+
+	// VUs is the number of concurrent virtual users shared by the Shared
+	// scenarios. Defaults to 1.
+	// +optional
+	VUs int32 `json:"vus,omitempty"`
+
+	// Duration is how long the virtual users issue requests.
+	Duration metav1.Duration `json:"duration"`
+
+	// Location is the probe location the load test runs from. Any location
+	// may run it when empty.
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// Workers is the number of SyntheticRuns the virtual users are split
+	// across. Each worker is handed its own partition of the feeder data.
+	// Defaults to 1.
+	// +optional
+	Workers int32 `json:"workers,omitempty"`
+
+	// Feeders supply the virtual users with records of test data.
+	// +optional
+	Feeders []Feeder `json:"feeders,omitempty"`
+
+	// Connections tune the HTTP connections of the virtual users.
+	// +optional
+	Connections *ConnectionSettings `json:"connections,omitempty"`
+
+	// Baseline is the run the results of every run are compared with to
+	// detect regressions.
+	// +optional
+	Baseline *Baseline `json:"baseline,omitempty"`
+
+	// Snapshot saves the summary of every successful run in the status
+	// under this name, replacing the previous snapshot of that name, after
+	// it has been compared with the baseline.
+	// +optional
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Tracing propagates W3C trace context with the HTTP requests of the
+	// virtual users.
+	// +optional
+	Tracing *Tracing `json:"tracing,omitempty"`
+}
+
+// Baseline selects the results a load test run is compared with. Exactly one
+// of RunRef and Snapshot must be set.
+type Baseline struct {
+	// RunRef names a previous run of the load test, as reported by
+	// status.lastRun.
+	// +optional
+	RunRef string `json:"runRef,omitempty"`
+
+	// Snapshot names a snapshot in the status of the load test.
+	// +optional
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Tolerance is the largest change, in percent, of throughput or of a
+	// latency percentile that is not a regression. Defaults to 10.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Tolerance *int32 `json:"tolerance,omitempty"`
+
+	// Confidence is the confidence level, in percent, at which a Mann-Whitney
+	// U test must find the latencies of the run higher than those of the
+	// baseline for a latency regression. Defaults to 95.
+	// +kubebuilder:validation:Minimum=50
+	// +kubebuilder:validation:Maximum=99
+	// +optional
+	Confidence int32 `json:"confidence,omitempty"`
+}
+
+// HTTPProtocol selects the HTTP version of load test requests.
+type HTTPProtocol string
+
+const (
+	// ProtocolAuto negotiates HTTP/2 over TLS and uses HTTP/1.1 otherwise.
+	ProtocolAuto HTTPProtocol = "Auto"
+	// ProtocolHTTP1 always uses HTTP/1.1.
+	ProtocolHTTP1 HTTPProtocol = "HTTP1"
+	// ProtocolHTTP2 always uses HTTP/2, over cleartext connections with
+	// prior knowledge.
+	ProtocolHTTP2 HTTPProtocol = "HTTP2"
+)
+
+// ConnectionSettings tune how virtual users open and reuse connections.
+type ConnectionSettings struct {
+	// Protocol selects the HTTP version. Defaults to Auto.
+	// +kubebuilder:validation:Enum=Auto;HTTP1;HTTP2
+	// +optional
+	Protocol HTTPProtocol `json:"protocol,omitempty"`
+
+	// PerVU gives every virtual user connections of its own, as separate
+	// clients would have, instead of sharing them among the virtual users of
+	// a worker.
+	// +optional
+	PerVU bool `json:"perVU,omitempty"`
+
+	// MaxRequestsPerConnection makes a virtual user drop its idle
+	// connections after that many requests, so that connections are opened
+	// again as clients come and go. Connections are reused without limit
+	// when zero.
+	// +optional
+	MaxRequestsPerConnection int32 `json:"maxRequestsPerConnection,omitempty"`
+}
+
+// ScenarioExecutor decides how the iterations of a Scenario are scheduled.
+type ScenarioExecutor string
+
+const (
+	// ExecutorShared runs the scenario on the shared virtual users of the
+	// LoadTest, which pick one of the Shared scenarios by weight at the start
+	// of every iteration.
+	ExecutorShared ScenarioExecutor = "Shared"
+	// ExecutorConstantVUs runs the scenario on virtual users of its own that
+	// loop over it.
+	ExecutorConstantVUs ScenarioExecutor = "ConstantVUs"
+	// ExecutorConstantArrivalRate starts iterations of the scenario at a
+	// fixed rate, however long they take, on virtual users of its own.
+	// Iterations that find every virtual user busy are dropped.
+	ExecutorConstantArrivalRate ScenarioExecutor = "ConstantArrivalRate"
+)
+
+// Scenario is a workload of a LoadTest, such as browsing or checking out.
+type Scenario struct {
+	Name string `json:"name"`
+
+	// Executor decides how the iterations of the scenario are scheduled.
+	// Defaults to Shared.
+	// +kubebuilder:validation:Enum=Shared;ConstantVUs;ConstantArrivalRate
+	// +optional
+	Executor ScenarioExecutor `json:"executor,omitempty"`
+
+	// Weight is the share of the iterations of the shared virtual users
+	// spent on a Shared scenario, relative to the other Shared scenarios.
+	// Defaults to 1.
+	// +optional
+	Weight int32 `json:"weight,omitempty"`
+
+	// VUs is the number of virtual users of a ConstantVUs scenario, and the
+	// most iterations of a ConstantArrivalRate scenario in flight at once.
+	// Defaults to 1.
+	// +optional
+	VUs int32 `json:"vus,omitempty"`
+
+	// Rate is the number of iterations a ConstantArrivalRate scenario starts
+	// every second.
+	// +kubebuilder:validation:Maximum=1000000
+	// +optional
+	Rate int32 `json:"rate,omitempty"`
+
+	// HTTP is the request of an iteration of the scenario.
+	// +optional
+	HTTP *HTTPProbe `json:"http,omitempty"`
+
+	// Steps is the sequence of requests of an iteration of the scenario,
+	// instead of a single request.
+	// +optional
+	Steps []HTTPStep `json:"steps,omitempty"`
+
+	// GRPC is the gRPC call of an iteration of the scenario, instead of
+	// HTTP requests.
+	// +optional
+	GRPC *GRPCCall `json:"grpc,omitempty"`
+
+	// WebSocket is the WebSocket session of an iteration of the scenario,
+	// instead of HTTP requests.
+	// +optional
+	WebSocket *WebSocketSession `json:"webSocket,omitempty"`
+
+	// Produce publishes messages to a message queue in every iteration of
+	// the scenario, instead of HTTP requests.
+	// +optional
+	Produce *QueueProducer `json:"produce,omitempty"`
+}
+
+// GRPCCallType is the kind of a gRPC method.
+type GRPCCallType string
+
+const (
+	// GRPCUnary sends a single message and receives a single reply.
+	GRPCUnary GRPCCallType = "Unary"
+	// GRPCServerStream sends a single message and receives a stream of
+	// replies.
+	GRPCServerStream GRPCCallType = "ServerStream"
+	// GRPCBidiStream sends and receives streams of messages.
+	GRPCBidiStream GRPCCallType = "BidiStream"
+)
+
+// GRPCCall is a call of a gRPC method. Messages are opaque to the load test,
+// so they are given in their protobuf encoding.
+type GRPCCall struct {
+	// URL is the address of the server, with the scheme https, or http for
+	// cleartext HTTP/2.
+	URL string `json:"url"`
+
+	// Method is the full name of the method, such as
+	// /helloworld.Greeter/SayHello.
+	Method string `json:"method"`
+
+	// Type is the kind of the method. Defaults to Unary.
+	// +kubebuilder:validation:Enum=Unary;ServerStream;BidiStream
+	// +optional
+	Type GRPCCallType `json:"type,omitempty"`
+
+	// Messages are the base64 encoded protobuf request messages. Unary and
+	// server streaming calls send the first one, and bidirectional streams
+	// send them in turn.
+	// +optional
+	Messages []string `json:"messages,omitempty"`
+
+	// Metadata is sent with the call.
+	// +optional
+	Metadata []HTTPHeader `json:"metadata,omitempty"`
+
+	// MessageRate is the number of messages a bidirectional stream sends
+	// every second. Defaults to 1.
+	// +kubebuilder:validation:Maximum=1000000
+	// +optional
+	MessageRate int32 `json:"messageRate,omitempty"`
+
+	// StreamLifetime is how long a streaming call is kept open before the
+	// client ends it. Streams last until the server ends them or the load
+	// test ends when unset.
+	// +optional
+	StreamLifetime *metav1.Duration `json:"streamLifetime,omitempty"`
+
+	// Timeout bounds a unary call. Defaults to ten seconds.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// TLS configures the client side of the connection.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// WebSocketSession is a WebSocket connection that exchanges text messages.
+type WebSocketSession struct {
+	// URL is the address of the endpoint, with the scheme ws or wss.
+	URL string `json:"url"`
+
+	// Headers are sent with the opening handshake.
+	// +optional
+	Headers []HTTPHeader `json:"headers,omitempty"`
+
+	// Steps are performed in order once connected, after which the
+	// connection is closed.
+	// +optional
+	Steps []WebSocketStep `json:"steps,omitempty"`
+
+	// TLS configures the client side of the connection.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// WebSocketStep sends messages or waits for one. Exactly one of Send and
+// Expect must be set.
+type WebSocketStep struct {
+	// Name identifies the step in results.
+	Name string `json:"name"`
+
+	// Send is a text message to send.
+	// +optional
+	Send string `json:"send,omitempty"`
+
+	// Count is the number of times Send is sent. Defaults to 1.
+	// +optional
+	Count int32 `json:"count,omitempty"`
+
+	// Interval is the pause between the repetitions of Send.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Expect is a regular expression that a received message must match.
+	// Messages that do not match are skipped.
+	// +optional
+	Expect string `json:"expect,omitempty"`
+
+	// Timeout bounds the wait for a message matching Expect. Defaults to ten
+	// seconds.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// DefaultScenario is the name of the scenario formed by the requests set
+// directly in a LoadTestSpec.
+const DefaultScenario = "default"
+
+// FeederFormat is the encoding of the data of a Feeder.
+type FeederFormat string
+
+const (
+	// FeederCSV is comma separated values whose first row names the columns.
+	FeederCSV FeederFormat = "CSV"
+	// FeederJSONL is one JSON object per line.
+	FeederJSONL FeederFormat = "JSONL"
+)
+
+// FeederStrategy decides which record a virtual user is handed.
+type FeederStrategy string
+
+const (
+	// FeedSequential hands out the records in order, starting over once
+	// every record has been used.
+	FeedSequential FeederStrategy = "Sequential"
+	// FeedRandom hands out a random record every iteration.
+	FeedRandom FeederStrategy = "Random"
+	// FeedUniquePerVU hands every virtual user, across all workers, a record
+	// of its own that it uses for every iteration. The load test fails when
+	// there are fewer records than virtual users.
+	FeedUniquePerVU FeederStrategy = "UniquePerVU"
+)
+
+// Feeder reads records of test data for the virtual users of a LoadTest. A
+// virtual user draws a record from every feeder at the start of each
+// iteration, and every field of the record becomes a variable, named after
+// its column or key, of the requests in that iteration. Variables the steps
+// define themselves take precedence. Exactly one of ConfigMapKeyRef and Path
+// must be set.
+type Feeder struct {
+	Name string `json:"name"`
+
+	// Format is the encoding of the data. Defaults to CSV.
+	// +kubebuilder:validation:Enum=CSV;JSONL
+	// +optional
+	Format FeederFormat `json:"format,omitempty"`
+
+	// Strategy decides which record a virtual user is handed. Defaults to
+	// Sequential.
+	// +kubebuilder:validation:Enum=Sequential;Random;UniquePerVU
+	// +optional
+	Strategy FeederStrategy `json:"strategy,omitempty"`
+
+	// ConfigMapKeyRef selects a key of a ConfigMap holding the data.
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+
+	// Path is the file holding the data, relative to the feeder directory
+	// mounted into the manager.
+	// +optional
+	Path string `json:"path,omitempty"`
 }
 
 // LoadTestStatus defines the observed state of LoadTest
 type LoadTestStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// LastRun is the name of the most recent SyntheticRun of this LoadTest.
+	// When the load test is split across several workers, the runs are
+	// named after it followed by the index of the worker.
+	// +optional
+	LastRun string `json:"lastRun,omitempty"`
+
+	// Phase is the phase of the most recent run, combined across workers.
+	// +optional
+	Phase SyntheticRunPhase `json:"phase,omitempty"`
+
+	// Summary is the aggregate result of the most recent run.
+	// +optional
+	Summary *LoadSummary `json:"summary,omitempty"`
+
+	// Comparison compares the most recent run with the baseline.
+	// +optional
+	Comparison *Comparison `json:"comparison,omitempty"`
+
+	// Snapshots are the saved summaries of earlier runs.
+	// +optional
+	Snapshots []LoadSnapshot `json:"snapshots,omitempty"`
+}
+
+// LoadSnapshot is the saved summary of a run.
+type LoadSnapshot struct {
+	Name string `json:"name"`
+
+	// Run is the name of the run, as reported by status.lastRun.
+	Run string `json:"run"`
+
+	// Time is when the snapshot was taken.
+	Time metav1.Time `json:"time"`
+
+	Summary LoadSummary `json:"summary"`
+}
+
+// Comparison is the outcome of comparing a run with its baseline.
+type Comparison struct {
+	// Baseline describes the baseline, such as "snapshot release-1.4" or
+	// "run orders-3".
+	Baseline string `json:"baseline"`
+
+	// Deltas compare throughput and every latency percentile with the
+	// baseline.
+	// +optional
+	Deltas []Delta `json:"deltas,omitempty"`
+
+	// PValue is the one-sided p-value of a Mann-Whitney U test for the
+	// latencies of the run being higher than those of the baseline.
+	// +optional
+	PValue string `json:"pValue,omitempty"`
+
+	// Significant is true when the latencies of the run are higher than those
+	// of the baseline at the configured confidence level.
+	// +optional
+	Significant bool `json:"significant,omitempty"`
+
+	// Regression is true when throughput dropped by more than the tolerance,
+	// or when the latencies are significantly higher and a percentile rose
+	// by more than the tolerance.
+	// +optional
+	Regression bool `json:"regression,omitempty"`
+
+	// Message explains the regression, or why the run could not be
+	// compared.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// Delta compares a measure of a run with the baseline.
+type Delta struct {
+	// Name is the measure: throughput, p50, p90, p95 or p99.
+	Name string `json:"name"`
+
+	// Baseline is the value of the baseline, such as 120.5/s or 35ms.
+	Baseline string `json:"baseline"`
+
+	// Current is the value of the run.
+	Current string `json:"current"`
+
+	// Change is the relative change, such as +12.5%.
+	Change string `json:"change"`
+
+	// Exceeded is true when the change is worse than the tolerance.
+	// +optional
+	Exceeded bool `json:"exceeded,omitempty"`
+}
+
+// LoadSummary aggregates the requests issued by a load test run.
+type LoadSummary struct {
+	// Requests is the number of requests issued.
+	Requests int64 `json:"requests"`
+
+	// Failures is the number of requests that errored or failed an assertion.
+	Failures int64 `json:"failures"`
+
+	// Duration is the wall clock time the requests were issued over.
+	Duration metav1.Duration `json:"duration"`
+
+	// Latency holds the request latency percentiles.
+	// +optional
+	Latency LatencyPercentiles `json:"latency,omitempty"`
+
+	// Scenarios breaks the summary down per scenario.
+	// +optional
+	Scenarios []ScenarioSummary `json:"scenarios,omitempty"`
+
+	// Histogram counts the request latencies in logarithmic buckets, eight
+	// to a doubling starting at 100µs, so that the latency distributions of
+	// runs can be compared. Trailing empty buckets are omitted.
+	// +optional
+	Histogram []int64 `json:"histogram,omitempty"`
+}
+
+// ScenarioSummary aggregates the iterations of a scenario of a load test run.
+type ScenarioSummary struct {
+	Name string `json:"name"`
+
+	// Iterations is the number of iterations started.
+	Iterations int64 `json:"iterations"`
+
+	// DroppedIterations is the number of iterations of a ConstantArrivalRate
+	// scenario that were not started because every virtual user was busy.
+	// +optional
+	DroppedIterations int64 `json:"droppedIterations,omitempty"`
+
+	// Requests is the number of requests issued.
+	Requests int64 `json:"requests"`
+
+	// Failures is the number of requests that errored or failed an assertion.
+	Failures int64 `json:"failures"`
+
+	// Latency holds the request latency percentiles.
+	// +optional
+	Latency LatencyPercentiles `json:"latency,omitempty"`
+
+	// Connections is the number of connections opened, which grows with
+	// connection churn.
+	// +optional
+	Connections int64 `json:"connections,omitempty"`
+
+	// MessagesSent is the number of gRPC, WebSocket or queue messages sent.
+	// +optional
+	MessagesSent int64 `json:"messagesSent,omitempty"`
+
+	// MessagesReceived is the number of gRPC or WebSocket messages received.
+	// +optional
+	MessagesReceived int64 `json:"messagesReceived,omitempty"`
+
+	// StreamErrors is the number of gRPC streams and WebSocket sessions that
+	// broke off with an error.
+	// +optional
+	StreamErrors int64 `json:"streamErrors,omitempty"`
+
+	// ByRequest breaks the scenario down per request name. Requests are named
+	// after their step, or after their method and URL when unnamed.
+	// +optional
+	ByRequest []RequestSummary `json:"byRequest,omitempty"`
+}
+
+// RequestSummary aggregates the requests with the same name.
+type RequestSummary struct {
+	Name string `json:"name"`
+
+	// Requests is the number of requests issued.
+	Requests int64 `json:"requests"`
+
+	// Failures is the number of requests that errored or failed an assertion.
+	Failures int64 `json:"failures"`
+
+	// Latency holds the request latency percentiles.
+	// +optional
+	Latency LatencyPercentiles `json:"latency,omitempty"`
+}
+
+// LatencyPercentiles is a summary of a latency distribution.
+type LatencyPercentiles struct {
+	P50 metav1.Duration `json:"p50,omitempty"`
+	P90 metav1.Duration `json:"p90,omitempty"`
+	P95 metav1.Duration `json:"p95,omitempty"`
+	P99 metav1.Duration `json:"p99,omitempty"`
+	Max metav1.Duration `json:"max,omitempty"`
+}
+
+// LoadTestLabel is set on every SyntheticRun created for a LoadTest.
+const LoadTestLabel = "perph.io/loadtest"
+
+// AllScenarios returns the scenarios of the load test, preceded by the
+// default scenario when the spec sets requests directly.
+func (s *LoadTestSpec) AllScenarios() []Scenario {
+	var out []Scenario
+	if s.HTTP != nil || len(s.Steps) > 0 {
+		out = append(out, Scenario{Name: DefaultScenario, HTTP: s.HTTP, Steps: s.Steps})
+	}
+	return append(out, s.Scenarios...)
+}
+
+// RequestSteps returns the requests of an iteration of the scenario, in
+// order.
+func (s *Scenario) RequestSteps() []HTTPStep {
+	return requestSteps(s.HTTP, s.Steps)
+}
+
+// WorkersOrDefault returns the number of workers the load test is split
+// across.
+func (s *LoadTestSpec) WorkersOrDefault() int32 {
+	if s.Workers <= 0 {
+		return 1
+	}
+	return s.Workers
+}
+
+// DefaultTolerance is the tolerance of a Baseline that does not set one.
+const DefaultTolerance = 10
+
+// DefaultConfidence is the confidence level of a Baseline that does not set
+// one.
+const DefaultConfidence = 95
+
+// ToleranceOrDefault returns the tolerance of the baseline in percent.
+func (b *Baseline) ToleranceOrDefault() int32 {
+	if b.Tolerance == nil || *b.Tolerance < 0 {
+		return DefaultTolerance
+	}
+	return *b.Tolerance
+}
+
+// ConfidenceOrDefault returns the confidence level of the baseline in
+// percent.
+func (b *Baseline) ConfidenceOrDefault() int32 {
+	if b.Confidence <= 0 || b.Confidence >= 100 {
+		return DefaultConfidence
+	}
+	return b.Confidence
+}
+
+// MessagesPerSecond returns the average rate of messages sent and received
+// by the scenario over a run that lasted elapsed.
+func (s *ScenarioSummary) MessagesPerSecond(elapsed metav1.Duration) float64 {
+	if elapsed.Duration <= 0 {
+		return 0
+	}
+	return float64(s.MessagesSent+s.MessagesReceived) / elapsed.Seconds()
+}
+
+// RequestsPerSecond returns the average throughput of the summarised run.
+func (s *LoadSummary) RequestsPerSecond() float64 {
+	if s.Duration.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Requests) / s.Duration.Seconds()
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 
 // LoadTest is the Schema for the loadtests API
 type LoadTest struct {
@@ -0,0 +1,182 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CanaryVariable is replaced with the identifier of the canary message in
+// the payload of a QueueProbe.
+const CanaryVariable = "CANARY"
+
+// QueueProbe publishes a canary message to a Kafka topic or NATS subject and
+// measures the time until it is seen on the output topic or subject, such as
+// the topic a pipeline writes its results to. Exactly one of Kafka and NATS
+// must be set.
+type QueueProbe struct {
+	// Kafka reaches Kafka through a REST proxy.
+	// +optional
+	Kafka *KafkaEndpoint `json:"kafka,omitempty"`
+
+	// NATS is the NATS server.
+	// +optional
+	NATS *NATSEndpoint `json:"nats,omitempty"`
+
+	// Topic is the Kafka topic or NATS subject the canary is published to.
+	Topic string `json:"topic"`
+
+	// OutputTopic is the Kafka topic or NATS subject the canary is expected
+	// on. Defaults to Topic.
+	// +optional
+	OutputTopic string `json:"outputTopic,omitempty"`
+
+	// Payload is the canary message. $(CANARY) is replaced with an
+	// identifier unique to the run, and the canary is recognised on the
+	// output topic as the first message that contains it. Defaults to
+	// {"canary":"$(CANARY)"}.
+	// +optional
+	Payload string `json:"payload,omitempty"`
+
+	// Timeout bounds the wait for the canary. Defaults to ten seconds.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// MaxLatency fails the run when the canary takes longer to arrive.
+	// +optional
+	MaxLatency *metav1.Duration `json:"maxLatency,omitempty"`
+}
+
+// QueueProducer publishes messages to a Kafka topic or NATS subject as fast
+// as the executor of its scenario asks. Exactly one of Kafka and NATS must be
+// set.
+type QueueProducer struct {
+	// Kafka reaches Kafka through a REST proxy.
+	// +optional
+	Kafka *KafkaEndpoint `json:"kafka,omitempty"`
+
+	// NATS is the NATS server.
+	// +optional
+	NATS *NATSEndpoint `json:"nats,omitempty"`
+
+	// Topic is the Kafka topic or NATS subject messages are published to.
+	Topic string `json:"topic"`
+
+	// Payload is the message. Feeder variables are substituted into it.
+	Payload string `json:"payload"`
+
+	// BatchSize is the number of messages published by an iteration.
+	// Defaults to 1.
+	// +optional
+	BatchSize int32 `json:"batchSize,omitempty"`
+}
+
+// KafkaEndpoint is a REST proxy that speaks the v2 API of the Confluent
+// Kafka REST Proxy.
+type KafkaEndpoint struct {
+	// URL is the address of the REST proxy.
+	URL string `json:"url"`
+
+	// Headers are added to every request to the proxy, such as credentials.
+	// +optional
+	Headers []HTTPHeader `json:"headers,omitempty"`
+
+	// ConsumerGroup is the consumer group probes read the output topic in.
+	// Defaults to a group of its own for every check and location.
+	// +optional
+	ConsumerGroup string `json:"consumerGroup,omitempty"`
+
+	// TLS configures the client side of the connection.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// NATSEndpoint is a NATS server.
+type NATSEndpoint struct {
+	// URL is the address of the server, with the scheme nats, or tls to
+	// require TLS.
+	URL string `json:"url"`
+
+	// Token authenticates with a token.
+	// +optional
+	Token *ValueSource `json:"token,omitempty"`
+
+	// Username authenticates together with Password.
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// Password authenticates together with Username.
+	// +optional
+	Password *ValueSource `json:"password,omitempty"`
+
+	// TLS configures the client side of the connection.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// QueueResult is the outcome of a QueueProbe.
+type QueueResult struct {
+	// Canary is the identifier of the canary message.
+	Canary string `json:"canary"`
+
+	// Publish is the time the broker took to accept the canary.
+	// +optional
+	Publish metav1.Duration `json:"publish,omitempty"`
+
+	// EndToEnd is the time from publishing the canary until it was seen on
+	// the output topic.
+	// +optional
+	EndToEnd metav1.Duration `json:"endToEnd,omitempty"`
+}
+
+// ValueSources returns every reference to a Secret or ConfigMap in p.
+func (p *QueueProbe) ValueSources() []*ValueSource {
+	return queueValueSources(p.Kafka, p.NATS)
+}
+
+// ValueSources returns every reference to a Secret or ConfigMap in p.
+func (p *QueueProducer) ValueSources() []*ValueSource {
+	return queueValueSources(p.Kafka, p.NATS)
+}
+
+func queueValueSources(kafka *KafkaEndpoint, nats *NATSEndpoint) []*ValueSource {
+	var out []*ValueSource
+	var tls *TLSConfig
+	switch {
+	case kafka != nil:
+		for i := range kafka.Headers {
+			if kafka.Headers[i].ValueFrom != nil {
+				out = append(out, kafka.Headers[i].ValueFrom)
+			}
+		}
+		tls = kafka.TLS
+	case nats != nil:
+		for _, v := range []*ValueSource{nats.Token, nats.Password} {
+			if v != nil {
+				out = append(out, v)
+			}
+		}
+		tls = nats.TLS
+	}
+	if tls != nil {
+		for _, v := range []*ValueSource{tls.CA, tls.Cert, tls.Key} {
+			if v != nil {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+const (
+	// DefaultStatusPageDays is the number of days of uptime a status page
+	// shows by default.
+	DefaultStatusPageDays = 90
+	// DefaultStatusPageInterval is the time between refreshes of a status
+	// page by default.
+	DefaultStatusPageInterval = time.Minute
+)
+
+// StatusPageSpec defines the desired state of StatusPage
+type StatusPageSpec struct {
+	// Title is the heading of the page.
+	Title string `json:"title"`
+
+	// Components are the parts of the service the page reports on, in the
+	// order they are shown.
+	Components []StatusComponent `json:"components"`
+
+	// ConfigMap, when set, names a ConfigMap in the namespace of the page
+	// that the rendered page is written to, as index.html and status.json.
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+
+	// Days is the number of days of uptime shown. Defaults to 90.
+	// +optional
+	Days *int32 `json:"days,omitempty"`
+
+	// Interval is the time between refreshes of the page. Defaults to one
+	// minute.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// StatusComponent is a part of the service, backed by Checks.
+type StatusComponent struct {
+	// Name is shown on the page.
+	Name string `json:"name"`
+
+	// Description is shown on the page.
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Checks names Checks, in the namespace of the page, the component is
+	// made of.
+	// +optional
+	Checks []string `json:"checks,omitempty"`
+
+	// Selector selects more Checks the component is made of.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// ComponentState describes how a component of a status page is doing.
+type ComponentState string
+
+const (
+	// ComponentOperational means every check of the component passes.
+	ComponentOperational ComponentState = "Operational"
+	// ComponentMaintenance means checks of the component are in a
+	// maintenance window, and none outside of one fails.
+	ComponentMaintenance ComponentState = "UnderMaintenance"
+	// ComponentDegraded means some checks of the component fail.
+	ComponentDegraded ComponentState = "Degraded"
+	// ComponentOutage means every check of the component fails.
+	ComponentOutage ComponentState = "Outage"
+)
+
+// StatusPageStatus defines the observed state of StatusPage
+type StatusPageStatus struct {
+	// Components holds the state of each component.
+	// +optional
+	Components []ComponentStatus `json:"components,omitempty"`
+
+	// Incidents holds the open incidents and those resolved within the days
+	// the page shows, newest first.
+	// +optional
+	Incidents []Incident `json:"incidents,omitempty"`
+
+	// LastUpdate is when the page was last refreshed.
+	// +optional
+	LastUpdate *metav1.Time `json:"lastUpdate,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the page was last
+	// refreshed with.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Message explains why the page cannot be written to its ConfigMap.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ComponentStatus is the state of a component of a status page.
+type ComponentStatus struct {
+	Name  string         `json:"name"`
+	State ComponentState `json:"state"`
+
+	// Failing names the checks of the component that fail.
+	// +optional
+	Failing []string `json:"failing,omitempty"`
+}
+
+// Incident is a period during which a component was degraded or down.
+type Incident struct {
+	// Component is the name of the component.
+	Component string `json:"component"`
+
+	// State is the worst state of the component during the incident.
+	State ComponentState `json:"state"`
+
+	// Checks names the checks that failed during the incident.
+	// +optional
+	Checks []string `json:"checks,omitempty"`
+
+	// Start is when the incident was opened.
+	Start metav1.Time `json:"start"`
+
+	// End is when the incident was resolved, or nil while it is open.
+	// +optional
+	End *metav1.Time `json:"end,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// StatusPage is the Schema for the statuspages API
+type StatusPage struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   StatusPageSpec   `json:"spec,omitempty"`
+	Status StatusPageStatus `json:"status,omitempty"`
+}
+
+// DaysOrDefault returns the number of days of uptime the page shows.
+func (p *StatusPage) DaysOrDefault() int {
+	if p.Spec.Days == nil || *p.Spec.Days <= 0 {
+		return DefaultStatusPageDays
+	}
+	return int(*p.Spec.Days)
+}
+
+// IntervalOrDefault returns the time between refreshes of the page.
+func (p *StatusPage) IntervalOrDefault() time.Duration {
+	if p.Spec.Interval == nil || p.Spec.Interval.Duration <= 0 {
+		return DefaultStatusPageInterval
+	}
+	return p.Spec.Interval.Duration
+}
+
+// +kubebuilder:object:root=true
+
+// StatusPageList contains a list of StatusPage
+type StatusPageList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []StatusPage `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&StatusPage{}, &StatusPageList{})
+}
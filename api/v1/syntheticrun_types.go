@@ -16,25 +16,228 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// SyntheticRunPhase describes where a SyntheticRun is in its lifecycle.
+type SyntheticRunPhase string
+
+const (
+	// RunPending means the run has been created but not started.
+	RunPending SyntheticRunPhase = "Pending"
+	// RunRunning means the run is currently executing.
+	RunRunning SyntheticRunPhase = "Running"
+	// RunSucceeded means the run completed and every assertion passed.
+	RunSucceeded SyntheticRunPhase = "Succeeded"
+	// RunFailed means the run completed with an error or a failed assertion.
+	RunFailed SyntheticRunPhase = "Failed"
+	// RunDependencyFailed means the run failed while a Check it depends on
+	// was failing too, so the failure is put down to that check.
+	RunDependencyFailed SyntheticRunPhase = "DependencyFailed"
+)
+
 // SyntheticRunSpec defines the desired state of SyntheticRun
 type SyntheticRunSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// CheckRef is the name of the Check, in the same namespace, that this run executes.
+	// +optional
+	CheckRef string `json:"checkRef,omitempty"`
+
+	// LoadTestRef is the name of the LoadTest, in the same namespace, that this run executes.
+	// +optional
+	LoadTestRef string `json:"loadTestRef,omitempty"`
+
+	// Location is the probe location the run executes from.
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// Partition is the share of a load test split across several workers
+	// that this run executes.
+	// +optional
+	Partition *Partition `json:"partition,omitempty"`
+}
+
+// Partition identifies one of the workers a load test is split across.
+type Partition struct {
+	// Index is the zero based index of the worker.
+	Index int32 `json:"index"`
+
+	// Count is the number of workers.
+	Count int32 `json:"count"`
 }
 
 // SyntheticRunStatus defines the observed state of SyntheticRun
 type SyntheticRunStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Phase is the current lifecycle phase of the run.
+	// +optional
+	Phase SyntheticRunPhase `json:"phase,omitempty"`
+
+	// StartTime is when the run started executing.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the run finished executing.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message is a human readable explanation of the result.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// SilencedBy names the MaintenanceWindow the run started in. Silenced
+	// runs are left out of alerting metrics and SLO calculations.
+	// +optional
+	SilencedBy string `json:"silencedBy,omitempty"`
+
+	// RootCause names the Check a DependencyFailed run is put down to: the
+	// first failing check up the chain of dependencies that did not itself
+	// fail on a dependency.
+	// +optional
+	RootCause string `json:"rootCause,omitempty"`
+
+	// StatusCode is the HTTP status code of the probe response. For checks
+	// with steps, it is that of the last step that ran.
+	// +optional
+	StatusCode int32 `json:"statusCode,omitempty"`
+
+	// Timings is the duration of each phase of the probe request. For
+	// checks with steps, it is that of the last step that ran.
+	// +optional
+	Timings *PhaseTimings `json:"timings,omitempty"`
+
+	// Connection describes the connection the probe request was sent over.
+	// +optional
+	Connection *ConnectionInfo `json:"connection,omitempty"`
+
+	// TraceID is the W3C trace ID the probe request was sent with, when the
+	// check propagates trace context. For checks with steps, it is that of
+	// the last step that ran.
+	// +optional
+	TraceID string `json:"traceID,omitempty"`
+
+	// Queue is the outcome of the probe of a check that probes a message
+	// queue.
+	// +optional
+	Queue *QueueResult `json:"queue,omitempty"`
+
+	// HTML summarises the crawl of a check that probes an HTML page.
+	// +optional
+	HTML *HTMLResult `json:"html,omitempty"`
+
+	// Findings lists the problems the run found besides its assertions,
+	// such as the broken links of an HTML page.
+	// +optional
+	Findings []Finding `json:"findings,omitempty"`
+
+	// Steps holds the outcome of every step that ran, for checks with steps.
+	// +optional
+	Steps []StepResult `json:"steps,omitempty"`
+
+	// Assertions holds the outcome of every assertion evaluated by the run.
+	// +optional
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+
+	// LoadTest summarises the run when it executes a LoadTest.
+	// +optional
+	LoadTest *LoadSummary `json:"loadTest,omitempty"`
+}
+
+// PhaseTimings is the time spent in each phase of a probe request. FirstByte
+// runs from the request being written to the first response byte, and
+// Transfer from the first to the last response byte.
+type PhaseTimings struct {
+	DNS       metav1.Duration `json:"dns,omitempty"`
+	Connect   metav1.Duration `json:"connect,omitempty"`
+	TLS       metav1.Duration `json:"tls,omitempty"`
+	FirstByte metav1.Duration `json:"firstByte,omitempty"`
+	Transfer  metav1.Duration `json:"transfer,omitempty"`
+	Total     metav1.Duration `json:"total,omitempty"`
+}
+
+// ConnectionInfo describes the connection a probe request was sent over.
+type ConnectionInfo struct {
+	// RemoteAddr is the resolved IP address and port of the server.
+	// +optional
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+
+	// Protocol is the negotiated application protocol, "http/1.1" or "h2".
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+
+	// TLSVersion is the negotiated TLS version, e.g. "TLS 1.3".
+	// +optional
+	TLSVersion string `json:"tlsVersion,omitempty"`
+
+	// CipherSuite is the negotiated TLS cipher suite.
+	// +optional
+	CipherSuite string `json:"cipherSuite,omitempty"`
+}
+
+// StepResult is the outcome of one step of a check.
+type StepResult struct {
+	Name string `json:"name"`
+
+	// StatusCode is the HTTP status code of the response.
+	// +optional
+	StatusCode int32 `json:"statusCode,omitempty"`
+
+	// Duration is the total time the request took.
+	// +optional
+	Duration metav1.Duration `json:"duration,omitempty"`
+
+	// TraceID is the W3C trace ID the request was sent with, when the
+	// check propagates trace context.
+	// +optional
+	TraceID string `json:"traceID,omitempty"`
+}
+
+// AssertionResult is the outcome of a single assertion.
+type AssertionResult struct {
+	// Name identifies the assertion within the check.
+	Name string `json:"name"`
+
+	// Passed is true when the assertion held.
+	Passed bool `json:"passed"`
+
+	// Message explains why the assertion failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// Finished returns true once the run has either succeeded or failed.
+func (r *SyntheticRun) Finished() bool {
+	return r.Status.Phase == RunSucceeded || r.Failed()
+}
+
+// WorkerRunName returns the name of the run of worker index out of workers
+// of the load test run name.
+func WorkerRunName(name string, index, workers int32) string {
+	if workers == 1 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, index)
+}
+
+// IsWorkerOf returns true if r is the load test run name, or the run of one
+// of its workers.
+func (r *SyntheticRun) IsWorkerOf(name string) bool {
+	if p := r.Spec.Partition; p != nil {
+		return r.Name == WorkerRunName(name, p.Index, p.Count)
+	}
+	return r.Name == name
+}
+
+// Failed returns true if the run failed, on its own or on a dependency.
+func (r *SyntheticRun) Failed() bool {
+	return r.Status.Phase == RunFailed || r.Status.Phase == RunDependencyFailed
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 
 // SyntheticRun is the Schema for the syntheticruns API
 type SyntheticRun struct {
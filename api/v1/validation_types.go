@@ -24,14 +24,54 @@ import (
 
 // ValidationSpec defines the desired state of Validation
 type ValidationSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// CheckRef names the Check in the same namespace whose responses are
+	// validated. Every run of the check reports the outcome as assertions.
+	CheckRef string `json:"checkRef"`
+
+	// ExpectedStatus lists the response status codes the contract allows.
+	// Any status is allowed when empty.
+	// +optional
+	ExpectedStatus []int32 `json:"expectedStatus,omitempty"`
+
+	// Schemas are the JSON Schemas response bodies must conform to.
+	// +optional
+	Schemas []ResponseSchema `json:"schemas,omitempty"`
+
+	// MaxLatency is the longest the check may take to get a response.
+	// +optional
+	MaxLatency *metav1.Duration `json:"maxLatency,omitempty"`
+
+	// During restricts the validation to runs that start while a
+	// FaultProxy injects faults, to assert that the check still meets its
+	// thresholds while a dependency is degraded.
+	// +optional
+	During *FaultWindow `json:"during,omitempty"`
+}
+
+// FaultWindow selects the windows of the faults of a FaultProxy.
+type FaultWindow struct {
+	// FaultProxy names a FaultProxy in the same namespace.
+	FaultProxy string `json:"faultProxy"`
+
+	// Fault names one of the faults of the FaultProxy. Any of them counts
+	// when empty.
+	// +optional
+	Fault string `json:"fault,omitempty"`
+}
+
+// ResponseSchema is the JSON Schema of the responses with a status code.
+type ResponseSchema struct {
+	// Status is the response status code the schema applies to. A schema
+	// without a status applies to every status without a schema of its own.
+	// +optional
+	Status int32 `json:"status,omitempty"`
+
+	// Schema is a JSON Schema document, in the dialect used by OpenAPI 3.
+	Schema string `json:"schema"`
 }
 
 // ValidationStatus defines the observed state of Validation
 type ValidationStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,187 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/perph/perph/pkg/importer"
+	"github.com/perph/perph/pkg/openapi"
+)
+
+func runImport(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("import requires a format: openapi, har or postman")
+	}
+	switch args[0] {
+	case "openapi":
+		return importOpenAPI(args[1:])
+	case "har", "postman":
+		return importRecording(args[0], args[1:])
+	}
+	return fmt.Errorf("unknown import format %q", args[0])
+}
+
+func importOpenAPI(args []string) error {
+	fs := flag.NewFlagSet("import openapi", flag.ExitOnError)
+	namespace := fs.String("namespace", "", "Namespace of the generated objects.")
+	baseURL := fs.String("base-url", "", "Base URL of the API. Defaults to the first server of the document.")
+	prefix := fs.String("prefix", "", "Prefix of the names of the generated objects.")
+	interval := fs.Duration("interval", 0, "Interval of the generated Checks.")
+	output := fs.String("o", "-", "File the objects are written to.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: perph import openapi [flags] <document>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	doc, err := openapi.Parse(data)
+	if err != nil {
+		return err
+	}
+	opts := openapi.Options{Namespace: *namespace, BaseURL: *baseURL, Prefix: *prefix}
+	if *interval > 0 {
+		opts.Interval = &metav1.Duration{Duration: *interval}
+	}
+	res, err := openapi.Generate(doc, opts)
+	if err != nil {
+		return err
+	}
+	for _, msg := range res.Skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s\n", msg)
+	}
+
+	var objs []interface{}
+	for i := range res.Checks {
+		objs = append(objs, &res.Checks[i], &res.Validations[i])
+	}
+	return writeObjects(*output, objs)
+}
+
+func importRecording(format string, args []string) error {
+	fs := flag.NewFlagSet("import "+format, flag.ExitOnError)
+	as := fs.String("as", "check", "Kind of the generated object: check or loadtest.")
+	name := fs.String("name", "", "Name of the generated object. Defaults to the file name.")
+	namespace := fs.String("namespace", "", "Namespace of the generated object.")
+	secret := fs.String("secret", "", "Secret that credentials and variables are read from. Defaults to <name>-credentials.")
+	vus := fs.Int("vus", 1, "Virtual users of the generated LoadTest.")
+	duration := fs.Duration("duration", time.Minute, "Duration of the generated LoadTest.")
+	output := fs.String("o", "-", "File the object is written to.")
+	var include *string
+	if format == "har" {
+		include = fs.String("include", "", "Only import requests whose URL matches this regular expression. Static page resources are skipped otherwise.")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: perph import %s [flags] <file>\n", format)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *as != "check" && *as != "loadtest" {
+		return fmt.Errorf("-as must be check or loadtest, got %q", *as)
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		base := filepath.Base(fs.Arg(0))
+		*name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if *secret == "" {
+		*secret = *name + "-credentials"
+	}
+	opts := importer.Options{Secret: *secret}
+
+	var rec *importer.Recording
+	switch format {
+	case "har":
+		var re *regexp.Regexp
+		if *include != "" {
+			if re, err = regexp.Compile(*include); err != nil {
+				return fmt.Errorf("invalid -include: %v", err)
+			}
+		}
+		rec, err = importer.HAR(data, re, opts)
+	case "postman":
+		rec, err = importer.Postman(data, opts)
+	}
+	if err != nil {
+		return err
+	}
+	if len(rec.SecretKeys) > 0 {
+		fmt.Fprintf(os.Stderr, "the generated %s reads the keys %s of Secret %q\n", *as, strings.Join(rec.SecretKeys, ", "), *secret)
+	}
+
+	meta := metav1.ObjectMeta{Name: *name, Namespace: *namespace}
+	var obj interface{} = rec.Check(meta)
+	if *as == "loadtest" {
+		obj = rec.LoadTest(meta, int32(*vus), metav1.Duration{Duration: *duration})
+	}
+	return writeObjects(*output, []interface{}{obj})
+}
+
+// writeObjects writes objs as a stream of YAML documents to the named file,
+// or to standard output for "-".
+func writeObjects(name string, objs []interface{}) error {
+	var w io.Writer = os.Stdout
+	if name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	for i, obj := range objs {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
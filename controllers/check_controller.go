@@ -0,0 +1,413 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/maintenance"
+	"github.com/perph/perph/pkg/template"
+)
+
+// referencesIndex indexes Checks by the Secrets and ConfigMaps they read
+// values from and the CheckTemplate they are rendered from, as
+// "secret/<name>", "configmap/<name>" and "checktemplate/<name>".
+const referencesIndex = ".spec.references"
+
+// CheckReconciler schedules SyntheticRuns for a Check
+type CheckReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// Location is the probe location this manager schedules runs for.
+	Location string
+
+	// references holds the resource versions of the Secrets, ConfigMaps and
+	// CheckTemplate each Check read when it was last reconciled, so that a
+	// change to them can trigger an immediate run.
+	mu         sync.Mutex
+	references map[types.NamespacedName]string
+}
+
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=checks,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=checks/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets;configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=checktemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=maintenancewindows,verbs=get;list;watch
+
+func (r *CheckReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("check", req.NamespacedName)
+
+	var check syntheticv1.Check
+	if err := r.Get(ctx, req.NamespacedName, &check); err != nil {
+		if apierrors.IsNotFound(err) {
+			runs.forgetObject("check", req.NamespacedName)
+			r.forgetReferences(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch Check")
+		return ctrl.Result{}, err
+	}
+	if check.Spec.TemplateRef != "" {
+		if err := r.render(ctx, &check); err != nil {
+			log.Error(err, "unable to render Check")
+			return ctrl.Result{}, err
+		}
+		if check.Status.RenderedSpec == nil {
+			return ctrl.Result{}, nil
+		}
+	}
+	if !check.RunsIn(r.Location) {
+		return ctrl.Result{}, nil
+	}
+
+	var list syntheticv1.SyntheticRunList
+	if err := r.List(ctx, &list, client.InNamespace(check.Namespace), client.MatchingLabels(map[string]string{
+		syntheticv1.CheckLabel:    check.Name,
+		syntheticv1.LocationLabel: r.Location,
+	})); err != nil {
+		log.Error(err, "unable to list SyntheticRuns")
+		return ctrl.Result{}, err
+	}
+	history := list.Items
+	sort.Slice(history, func(i, j int) bool {
+		return history[j].CreationTimestamp.Before(&history[i].CreationTimestamp)
+	})
+
+	changed, err := r.referencesChanged(ctx, &check)
+	if err != nil {
+		log.Error(err, "unable to read referenced Secrets and ConfigMaps")
+		return ctrl.Result{}, err
+	}
+	if changed {
+		run, err := r.newRun(&check, time.Now())
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, run); err != nil && !apierrors.IsAlreadyExists(err) {
+			log.Error(err, "unable to create SyntheticRun")
+			return ctrl.Result{}, err
+		}
+		log.Info("referenced values changed, scheduled run", "syntheticrun", run.Name)
+	}
+
+	// Runs are named after the schedule slot they belong to, so a stale cache
+	// or a concurrent reconcile cannot schedule the same slot twice.
+	interval := check.IntervalOrDefault()
+	slot := time.Now().Truncate(interval)
+	next := ctrl.Result{RequeueAfter: time.Until(slot.Add(interval))}
+
+	change, err := r.maintain(ctx, log, &check)
+	if err != nil {
+		log.Error(err, "unable to update Check status")
+		return ctrl.Result{}, err
+	}
+	if !change.IsZero() && time.Until(change) < next.RequeueAfter {
+		next.RequeueAfter = time.Until(change)
+	}
+
+	run, err := r.newRun(&check, slot)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Create(ctx, run); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return next, r.prune(ctx, &check, history)
+		}
+		log.Error(err, "unable to create SyntheticRun")
+		return ctrl.Result{}, err
+	}
+	log.V(1).Info("scheduled run", "syntheticrun", run.Name)
+
+	check.Status.LastRun = run.Name
+	if err := r.Status().Update(ctx, &check); err != nil {
+		log.Error(err, "unable to update Check status")
+		return ctrl.Result{}, err
+	}
+
+	return next, r.prune(ctx, &check, history)
+}
+
+// newRun returns the SyntheticRun of check for this manager's location and
+// the given schedule slot.
+func (r *CheckReconciler) newRun(check *syntheticv1.Check, slot time.Time) (*syntheticv1.SyntheticRun, error) {
+	run := &syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s-%d", check.Name, r.Location, slot.Unix()),
+			Namespace: check.Namespace,
+			Labels: map[string]string{
+				syntheticv1.CheckLabel:    check.Name,
+				syntheticv1.LocationLabel: r.Location,
+			},
+		},
+		Spec: syntheticv1.SyntheticRunSpec{
+			CheckRef: check.Name,
+			Location: r.Location,
+		},
+	}
+	if err := ctrl.SetControllerReference(check, run, r.Scheme); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// prune deletes finished runs beyond the check's history limit. history is
+// sorted newest first.
+func (r *CheckReconciler) prune(ctx context.Context, check *syntheticv1.Check, history []syntheticv1.SyntheticRun) error {
+	kept := 0
+	for i := range history {
+		run := &history[i]
+		if !run.Finished() {
+			continue
+		}
+		if kept < check.HistoryLimitOrDefault() {
+			kept++
+			continue
+		}
+		if err := r.Delete(ctx, run); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// maintain records the maintenance window check is in, if any, in its status
+// and returns when that next changes. Invalid windows are logged and
+// otherwise ignored.
+func (r *CheckReconciler) maintain(ctx context.Context, log logr.Logger, check *syntheticv1.Check) (time.Time, error) {
+	var windows syntheticv1.MaintenanceWindowList
+	if err := r.List(ctx, &windows, client.InNamespace(check.Namespace)); err != nil {
+		log.Error(err, "unable to list MaintenanceWindows")
+		return time.Time{}, nil
+	}
+	now := time.Now()
+	s, err := maintenance.Active(windows.Items, check.Labels, now)
+	if err != nil {
+		log.Error(err, "invalid maintenance window")
+	}
+	change := maintenance.NextChange(windows.Items, check.Labels, now)
+
+	var current *syntheticv1.ActiveMaintenance
+	if s != nil {
+		current = &syntheticv1.ActiveMaintenance{
+			Window: s.Window.Name,
+			Reason: s.Window.Spec.Reason,
+			End:    metav1.NewTime(s.End),
+		}
+	}
+	if equality.Semantic.DeepEqual(current, check.Status.Maintenance) {
+		return change, nil
+	}
+	check.Status.Maintenance = current
+	return change, r.Status().Update(ctx, check)
+}
+
+// checksInWindow maps a MaintenanceWindow to the Checks it selects.
+func (r *CheckReconciler) checksInWindow(obj handler.MapObject) []reconcile.Request {
+	w, ok := obj.Object.(*syntheticv1.MaintenanceWindow)
+	if !ok {
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&w.Spec.Selector)
+	if err != nil {
+		r.Log.Error(err, "invalid maintenance window selector", "name", w.Name)
+		return nil
+	}
+	var list syntheticv1.CheckList
+	if err := r.List(context.Background(), &list, client.InNamespace(w.Namespace)); err != nil {
+		r.Log.Error(err, "unable to list Checks in maintenance window", "name", w.Name)
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, check := range list.Items {
+		if !selector.Matches(labels.Set(check.Labels)) {
+			continue
+		}
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: check.Namespace,
+			Name:      check.Name,
+		}})
+	}
+	return reqs
+}
+
+// render records the spec of check rendered from its template in its status.
+// Rendering errors are reported in the status too, and leave the previously
+// rendered spec in place.
+func (r *CheckReconciler) render(ctx context.Context, check *syntheticv1.Check) error {
+	status := check.Status.DeepCopy()
+
+	var tmpl syntheticv1.CheckTemplate
+	err := r.Get(ctx, types.NamespacedName{Namespace: check.Namespace, Name: check.Spec.TemplateRef}, &tmpl)
+	switch {
+	case apierrors.IsNotFound(err):
+		status.RenderError = fmt.Sprintf("check template %q not found", check.Spec.TemplateRef)
+	case err != nil:
+		return err
+	default:
+		spec, err := template.Effective(check, &tmpl)
+		if err != nil {
+			status.RenderError = err.Error()
+			break
+		}
+		status.RenderedSpec = spec
+		status.RenderError = ""
+	}
+
+	if equality.Semantic.DeepEqual(*status, check.Status) {
+		return nil
+	}
+	check.Status = *status
+	return r.Status().Update(ctx, check)
+}
+
+// referencesChanged reports whether any Secret or ConfigMap the check reads
+// values from, or its template, changed since the check was last reconciled.
+// The first reconcile of a check only records what it references.
+func (r *CheckReconciler) referencesChanged(ctx context.Context, check *syntheticv1.Check) (bool, error) {
+	refs := checkReferences(check)
+	versions := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		parts := strings.SplitN(ref, "/", 2)
+		var obj runtime.Object
+		switch parts[0] {
+		case "secret":
+			obj = &corev1.Secret{}
+		case "configmap":
+			obj = &corev1.ConfigMap{}
+		case "checktemplate":
+			obj = &syntheticv1.CheckTemplate{}
+		}
+		version := "missing"
+		err := r.Get(ctx, types.NamespacedName{Namespace: check.Namespace, Name: parts[1]}, obj)
+		switch {
+		case err == nil:
+			m, _ := obj.(metav1.Object)
+			version = m.GetResourceVersion()
+		case !apierrors.IsNotFound(err):
+			return false, err
+		}
+		versions = append(versions, ref+"@"+version)
+	}
+	current := strings.Join(versions, ",")
+
+	key := types.NamespacedName{Namespace: check.Namespace, Name: check.Name}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.references == nil {
+		r.references = map[types.NamespacedName]string{}
+	}
+	previous, seen := r.references[key]
+	r.references[key] = current
+	return seen && previous != current, nil
+}
+
+// forgetReferences drops what the deleted check key referenced.
+func (r *CheckReconciler) forgetReferences(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.references, key)
+}
+
+// checkReferences returns the sorted index keys of the Secrets and ConfigMaps
+// a check reads values from and of the template it is rendered from.
+func checkReferences(check *syntheticv1.Check) []string {
+	set := map[string]bool{}
+	if check.Spec.TemplateRef != "" {
+		set["checktemplate/"+check.Spec.TemplateRef] = true
+	}
+	for _, v := range check.EffectiveSpec().ValueSources() {
+		switch {
+		case v.SecretKeyRef != nil:
+			set["secret/"+v.SecretKeyRef.Name] = true
+		case v.ConfigMapKeyRef != nil:
+			set["configmap/"+v.ConfigMapKeyRef.Name] = true
+		}
+	}
+	refs := make([]string, 0, len(set))
+	for ref := range set {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// checksReferencing maps a Secret or ConfigMap to the Checks that read
+// values from it, and a CheckTemplate to the Checks rendered from it.
+func (r *CheckReconciler) checksReferencing(kind string) handler.ToRequestsFunc {
+	return func(obj handler.MapObject) []reconcile.Request {
+		var list syntheticv1.CheckList
+		err := r.List(context.Background(), &list,
+			client.InNamespace(obj.Meta.GetNamespace()),
+			client.MatchingField(referencesIndex, kind+"/"+obj.Meta.GetName()))
+		if err != nil {
+			r.Log.Error(err, "unable to list Checks referencing "+kind, "name", obj.Meta.GetName())
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(list.Items))
+		for _, check := range list.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: check.Namespace,
+				Name:      check.Name,
+			}})
+		}
+		return reqs
+	}
+}
+
+func (r *CheckReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(&syntheticv1.Check{}, referencesIndex, func(obj runtime.Object) []string {
+		return checkReferences(obj.(*syntheticv1.Check))
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&syntheticv1.Check{}).
+		Owns(&syntheticv1.SyntheticRun{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: r.checksReferencing("secret")}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: r.checksReferencing("configmap")}).
+		Watches(&source.Kind{Type: &syntheticv1.CheckTemplate{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: r.checksReferencing("checktemplate")}).
+		Watches(&source.Kind{Type: &syntheticv1.MaintenanceWindow{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.checksInWindow)}).
+		Complete(r)
+}
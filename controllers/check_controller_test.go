@@ -0,0 +1,94 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// tracedCheck returns a check that reads a header of its request from the
+// Secret api, and one of its span export from the Secret collector.
+func tracedCheck() *syntheticv1.Check {
+	secret := func(name string) *syntheticv1.ValueSource {
+		return &syntheticv1.ValueSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  "token",
+		}}
+	}
+	return &syntheticv1.Check{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Spec: syntheticv1.CheckSpec{
+			HTTP: &syntheticv1.HTTPProbe{
+				URL:     "http://api",
+				Headers: []syntheticv1.HTTPHeader{{Name: "Authorization", ValueFrom: secret("api")}},
+			},
+			Tracing: &syntheticv1.Tracing{OTLP: &syntheticv1.OTLPEndpoint{
+				Endpoint: "http://collector:4318",
+				Headers:  []syntheticv1.HTTPHeader{{Name: "Api-Key", ValueFrom: secret("collector")}},
+			}},
+		},
+	}
+}
+
+func TestCheckReferences(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	check := tracedCheck()
+	g.Expect(checkReferences(check)).To(Equal([]string{"secret/api", "secret/collector"}))
+
+	collector := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "collector", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string][]byte{"token": []byte("old")},
+	}
+	scheme := newScheme()
+	syntheticv1.AddToScheme(scheme)
+	r := &CheckReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, check, collector),
+		Log:    zap.Logger(true),
+		Scheme: scheme,
+	}
+	changed, err := r.referencesChanged(ctx, check)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(changed).To(BeFalse())
+
+	// Rotating the key of the collector triggers a run. The fake client
+	// leaves resource versions to its callers.
+	collector.Data["token"] = []byte("new")
+	collector.ResourceVersion = "2"
+	g.Expect(r.Update(ctx, collector)).To(Succeed())
+	changed, err = r.referencesChanged(ctx, check)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(changed).To(BeTrue())
+
+	// Deleting the check forgets what it referenced.
+	key := types.NamespacedName{Namespace: "default", Name: "api"}
+	g.Expect(r.Delete(ctx, check)).To(Succeed())
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.references).NotTo(HaveKey(key))
+}
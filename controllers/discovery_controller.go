@@ -0,0 +1,368 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/template"
+)
+
+// ServiceDiscoveryReconciler generates Checks for Services annotated with
+// perph.io/probe.
+type ServiceDiscoveryReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Scheme   *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=checktemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=checks,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *ServiceDiscoveryReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("service", req.NamespacedName)
+
+	var svc corev1.Service
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			// The generated Check is garbage collected with its owner.
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch Service")
+		return ctrl.Result{}, err
+	}
+
+	var target *url.URL
+	if discoveryEnabled(&svc) {
+		target = serviceURL(&svc)
+		if target == nil {
+			log.Info("service has no port to probe")
+		}
+	}
+	return ctrl.Result{}, syncDiscoveredCheck(ctx, r.Client, r.Scheme, r.Recorder, log, &svc, "service", target)
+}
+
+// serviceURL returns the in-cluster address of the probed port of svc.
+func serviceURL(svc *corev1.Service) *url.URL {
+	host := svc.Name + "." + svc.Namespace + ".svc"
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		host = svc.Spec.ExternalName
+	}
+
+	want := svc.Annotations[syntheticv1.PortAnnotation]
+	var port *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		p := &svc.Spec.Ports[i]
+		if want == "" || want == p.Name || want == strconv.Itoa(int(p.Port)) {
+			port = p
+			break
+		}
+	}
+	switch {
+	case port != nil:
+		scheme := "http"
+		if port.Name == "https" || port.Port == 443 {
+			scheme = "https"
+		}
+		return &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(int(port.Port)))}
+	case svc.Spec.Type == corev1.ServiceTypeExternalName && want == "":
+		return &url.URL{Scheme: "http", Host: host}
+	case svc.Spec.Type == corev1.ServiceTypeExternalName:
+		if _, err := strconv.Atoi(want); err == nil {
+			return &url.URL{Scheme: "http", Host: net.JoinHostPort(host, want)}
+		}
+	}
+	return nil
+}
+
+// servicesUsingTemplate maps a CheckTemplate to the discovered Services that
+// render their Check from it.
+func (r *ServiceDiscoveryReconciler) servicesUsingTemplate(obj handler.MapObject) []reconcile.Request {
+	var list corev1.ServiceList
+	if err := r.List(context.Background(), &list, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list Services", "checktemplate", obj.Meta.GetName())
+		return nil
+	}
+	var reqs []reconcile.Request
+	for i := range list.Items {
+		svc := &list.Items[i]
+		if discoveryEnabled(svc) && svc.Annotations[syntheticv1.TemplateAnnotation] == obj.Meta.GetName() {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}})
+		}
+	}
+	return reqs
+}
+
+func (r *ServiceDiscoveryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}).
+		Owns(&syntheticv1.Check{}).
+		Watches(&source.Kind{Type: &syntheticv1.CheckTemplate{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.servicesUsingTemplate)}).
+		Complete(r)
+}
+
+// IngressDiscoveryReconciler generates Checks for Ingresses annotated with
+// perph.io/probe.
+type IngressDiscoveryReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Scheme   *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch
+
+func (r *IngressDiscoveryReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("ingress", req.NamespacedName)
+
+	var ing networkingv1beta1.Ingress
+	if err := r.Get(ctx, req.NamespacedName, &ing); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch Ingress")
+		return ctrl.Result{}, err
+	}
+
+	var target *url.URL
+	if discoveryEnabled(&ing) {
+		target = ingressURL(&ing)
+		if target == nil {
+			log.Info("ingress has no host to probe")
+		}
+	}
+	return ctrl.Result{}, syncDiscoveredCheck(ctx, r.Client, r.Scheme, r.Recorder, log, &ing, "ingress", target)
+}
+
+// ingressURL returns the address of the first host served by ing, using
+// HTTPS when the host is covered by a TLS entry.
+func ingressURL(ing *networkingv1beta1.Ingress) *url.URL {
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host == "" {
+			continue
+		}
+		scheme := "http"
+		for _, t := range ing.Spec.TLS {
+			for _, h := range t.Hosts {
+				if h == rule.Host {
+					scheme = "https"
+				}
+			}
+		}
+		return &url.URL{Scheme: scheme, Host: rule.Host}
+	}
+	return nil
+}
+
+// ingressesUsingTemplate maps a CheckTemplate to the discovered Ingresses
+// that render their Check from it.
+func (r *IngressDiscoveryReconciler) ingressesUsingTemplate(obj handler.MapObject) []reconcile.Request {
+	var list networkingv1beta1.IngressList
+	if err := r.List(context.Background(), &list, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list Ingresses", "checktemplate", obj.Meta.GetName())
+		return nil
+	}
+	var reqs []reconcile.Request
+	for i := range list.Items {
+		ing := &list.Items[i]
+		if discoveryEnabled(ing) && ing.Annotations[syntheticv1.TemplateAnnotation] == obj.Meta.GetName() {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}})
+		}
+	}
+	return reqs
+}
+
+func (r *IngressDiscoveryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&networkingv1beta1.Ingress{}).
+		Owns(&syntheticv1.Check{}).
+		Watches(&source.Kind{Type: &syntheticv1.CheckTemplate{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.ingressesUsingTemplate)}).
+		Complete(r)
+}
+
+// discoveryObject is a Service or Ingress that may be discovered.
+type discoveryObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+func discoveryEnabled(obj metav1.Object) bool {
+	return obj.GetAnnotations()[syntheticv1.ProbeAnnotation] == "true"
+}
+
+// syncDiscoveredCheck brings the Check generated for owner in line with its
+// annotations, or deletes it when target is nil. Checks that exist under the
+// same name but were not generated for owner are left alone, and so is the
+// Check of an owner with invalid annotations.
+func syncDiscoveredCheck(ctx context.Context, c client.Client, scheme *runtime.Scheme, recorder record.EventRecorder, log logr.Logger, owner discoveryObject, kind string, target *url.URL) error {
+	key := types.NamespacedName{Namespace: owner.GetNamespace(), Name: kind + "-" + owner.GetName()}
+	var existing syntheticv1.Check
+	err := c.Get(ctx, key, &existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		if target == nil {
+			return nil
+		}
+	case err != nil:
+		log.Error(err, "unable to fetch Check")
+		return err
+	case !metav1.IsControlledBy(&existing, owner):
+		log.Info("a Check that was not discovered already uses the generated name", "check", key.Name)
+		return nil
+	case target == nil:
+		if err := c.Delete(ctx, &existing); err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to delete discovered Check")
+			return err
+		}
+		log.Info("deleted discovered Check", "check", key.Name)
+		return nil
+	}
+
+	spec, err := discoveredSpec(ctx, c, owner, target)
+	if err, ok := err.(invalidAnnotation); ok {
+		// Retrying does not help until the annotation is fixed, which
+		// triggers a reconcile of its own.
+		recorder.Event(owner, corev1.EventTypeWarning, "InvalidAnnotation", err.Error())
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "unable to render discovered Check")
+		return err
+	}
+	if spec == nil {
+		log.Info("waiting for CheckTemplate", "checktemplate", owner.GetAnnotations()[syntheticv1.TemplateAnnotation])
+		return nil
+	}
+
+	if existing.Name != "" {
+		if equality.Semantic.DeepEqual(existing.Spec, *spec) {
+			return nil
+		}
+		existing.Spec = *spec
+		if err := c.Update(ctx, &existing); err != nil {
+			log.Error(err, "unable to update discovered Check")
+			return err
+		}
+		log.Info("updated discovered Check", "check", key.Name)
+		return nil
+	}
+
+	check := &syntheticv1.Check{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Spec:       *spec,
+	}
+	if err := ctrl.SetControllerReference(owner, check, scheme); err != nil {
+		return err
+	}
+	if err := c.Create(ctx, check); err != nil {
+		log.Error(err, "unable to create discovered Check")
+		return err
+	}
+	log.Info("created discovered Check", "check", key.Name)
+	return nil
+}
+
+// invalidAnnotation is an error in the annotations of a discovered object.
+type invalidAnnotation string
+
+func (e invalidAnnotation) Error() string { return string(e) }
+
+// discoveredSpec renders the Check spec for owner from its CheckTemplate, or
+// probes target with a GET when it names none. It returns nil while the
+// named template does not exist.
+func discoveredSpec(ctx context.Context, c client.Client, owner metav1.Object, target *url.URL) (*syntheticv1.CheckSpec, error) {
+	annotations := owner.GetAnnotations()
+	path := annotations[syntheticv1.PathAnnotation]
+	if path == "" {
+		path = "/"
+	}
+	u := *target
+	u.Path = path
+	params := map[string]string{
+		"URL":       u.String(),
+		"SCHEME":    u.Scheme,
+		"HOST":      u.Hostname(),
+		"PORT":      u.Port(),
+		"PATH":      path,
+		"NAME":      owner.GetName(),
+		"NAMESPACE": owner.GetNamespace(),
+	}
+
+	base := &syntheticv1.CheckSpec{}
+	if name := annotations[syntheticv1.TemplateAnnotation]; name != "" {
+		var tmpl syntheticv1.CheckTemplate
+		err := c.Get(ctx, types.NamespacedName{Namespace: owner.GetNamespace(), Name: name}, &tmpl)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		base = &tmpl.Spec.Template
+		resolved, err := template.Resolve(tmpl.Spec.Parameters, params)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %v", name, err)
+		}
+		params = resolved
+	}
+
+	spec, err := template.Render(base, params)
+	if err != nil {
+		return nil, err
+	}
+	if spec.HTTP == nil && len(spec.Steps) == 0 && spec.Queue == nil && spec.HTML == nil {
+		spec.HTTP = &syntheticv1.HTTPProbe{}
+	}
+	if spec.HTTP != nil && spec.HTTP.URL == "" {
+		spec.HTTP.URL = params["URL"]
+	}
+	if v, ok := annotations[syntheticv1.IntervalAnnotation]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, invalidAnnotation(fmt.Sprintf("invalid %s annotation %q", syntheticv1.IntervalAnnotation, v))
+		}
+		spec.Interval = &metav1.Duration{Duration: d}
+	}
+	return spec, nil
+}
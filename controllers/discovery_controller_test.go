@@ -0,0 +1,148 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func discoveredService(annotations map[string]string, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: annotations},
+		Spec:       corev1.ServiceSpec{Ports: ports},
+	}
+}
+
+func TestServiceURL(t *testing.T) {
+	g := NewGomegaWithT(t)
+	metrics := corev1.ServicePort{Name: "metrics", Port: 9090}
+	https := corev1.ServicePort{Name: "https", Port: 8443}
+
+	for _, tc := range []struct {
+		port string
+		svc  *corev1.Service
+		want string
+	}{
+		{"", discoveredService(nil, metrics, https), "http://web.default.svc:9090"},
+		{"https", discoveredService(nil, metrics, https), "https://web.default.svc:8443"},
+		{"9090", discoveredService(nil, metrics, https), "http://web.default.svc:9090"},
+		{"", discoveredService(nil, corev1.ServicePort{Port: 443}), "https://web.default.svc:443"},
+		{"grpc", discoveredService(nil, metrics, https), ""},
+		{"", discoveredService(nil), ""},
+	} {
+		tc.svc.Annotations = map[string]string{syntheticv1.PortAnnotation: tc.port}
+		u := serviceURL(tc.svc)
+		if tc.want == "" {
+			g.Expect(u).To(BeNil(), "port %q", tc.port)
+			continue
+		}
+		g.Expect(u).NotTo(BeNil(), "port %q", tc.port)
+		g.Expect(u.String()).To(Equal(tc.want), "port %q", tc.port)
+	}
+
+	external := discoveredService(nil)
+	external.Spec.Type = corev1.ServiceTypeExternalName
+	external.Spec.ExternalName = "api.example.com"
+	g.Expect(serviceURL(external).String()).To(Equal("http://api.example.com"))
+	external.Annotations = map[string]string{syntheticv1.PortAnnotation: "8080"}
+	g.Expect(serviceURL(external).String()).To(Equal("http://api.example.com:8080"))
+}
+
+func TestDiscoveredSpec(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	target := &url.URL{Scheme: "http", Host: "web.default.svc:8080"}
+	region := "eu"
+	c := fake.NewFakeClientWithScheme(newScheme(), &syntheticv1.CheckTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "health", Namespace: "default"},
+		Spec: syntheticv1.CheckTemplateSpec{
+			Parameters: []syntheticv1.TemplateParameter{{Name: "REGION", Default: &region}},
+			Template: syntheticv1.CheckSpec{
+				HTTP: &syntheticv1.HTTPProbe{
+					URL:     "$(SCHEME)://$(HOST):$(PORT)/healthz",
+					Headers: []syntheticv1.HTTPHeader{{Name: "X-Region", Value: "$(REGION)"}},
+				},
+			},
+		},
+	})
+
+	// Without a template the target is probed with a GET.
+	spec, err := discoveredSpec(ctx, c, discoveredService(map[string]string{
+		syntheticv1.PathAnnotation:     "/ready",
+		syntheticv1.IntervalAnnotation: "30s",
+	}), target)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.HTTP.URL).To(Equal("http://web.default.svc:8080/ready"))
+	g.Expect(spec.Interval.Duration).To(Equal(30 * time.Second))
+
+	spec, err = discoveredSpec(ctx, c, discoveredService(map[string]string{
+		syntheticv1.TemplateAnnotation: "health",
+	}), target)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.HTTP.URL).To(Equal("http://web.default.svc:8080/healthz"))
+	g.Expect(spec.HTTP.Headers[0].Value).To(Equal("eu"))
+	g.Expect(spec.Interval).To(BeNil())
+
+	// A template that does not exist yet is waited for.
+	spec, err = discoveredSpec(ctx, c, discoveredService(map[string]string{
+		syntheticv1.TemplateAnnotation: "missing",
+	}), target)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec).To(BeNil())
+
+	for _, interval := range []string{"soon", "-1m", "0s"} {
+		_, err = discoveredSpec(ctx, c, discoveredService(map[string]string{
+			syntheticv1.IntervalAnnotation: interval,
+		}), target)
+		g.Expect(err).To(BeAssignableToTypeOf(invalidAnnotation("")), "interval %q", interval)
+	}
+}
+
+func TestDiscoveryInvalidAnnotation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recorder := record.NewFakeRecorder(10)
+	r := &ServiceDiscoveryReconciler{
+		Client: fake.NewFakeClientWithScheme(newScheme(), discoveredService(map[string]string{
+			syntheticv1.ProbeAnnotation:    "true",
+			syntheticv1.IntervalAnnotation: "soon",
+		}, corev1.ServicePort{Name: "http", Port: 80})),
+		Log:      zap.Logger(true),
+		Recorder: recorder,
+		Scheme:   newScheme(),
+	}
+
+	// The annotation is reported rather than retried.
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(recorder.Events).To(Receive(ContainSubstring(`InvalidAnnotation invalid perph.io/interval annotation "soon"`)))
+	var checks syntheticv1.CheckList
+	g.Expect(r.List(context.Background(), &checks)).To(Succeed())
+	g.Expect(checks.Items).To(BeEmpty())
+}
@@ -17,34 +17,424 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	metricsv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/export"
+	"github.com/perph/perph/pkg/maintenance"
+	"github.com/perph/perph/pkg/secrets"
+	"github.com/perph/perph/pkg/store"
 )
 
 // ExportTaskReconciler reconciles a ExportTask object
 type ExportTaskReconciler struct {
 	client.Client
 	Log logr.Logger
+
+	// Store, when set, is read for runs that are no longer kept in the
+	// cluster, so that tasks can backfill them.
+	Store store.Store
 }
 
 // +kubebuilder:rbac:groups=metrics.perph.io,resources=exporttasks,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=metrics.perph.io,resources=exporttasks/status,verbs=get;update;patch
 
 func (r *ExportTaskReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
-	_ = r.Log.WithValues("exporttask", req.NamespacedName)
+	ctx := context.Background()
+	log := r.Log.WithValues("exporttask", req.NamespacedName)
+
+	var task metricsv1.ExportTask
+	if err := r.Get(ctx, req.NamespacedName, &task); err != nil {
+		if apierrors.IsNotFound(err) {
+			exportLag.forget(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch ExportTask")
+		return ctrl.Result{}, err
+	}
+
+	if task.Status.Watermark != nil {
+		exportLag.set(req.NamespacedName, task.Status.Watermark.Time)
+	}
+	if phase := task.Status.Phase; phase == metricsv1.ExportSucceeded || phase == metricsv1.ExportFailed {
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	status := task.Status.DeepCopy()
+	if status.StartTime == nil {
+		start := metav1.NewTime(now)
+		status.StartTime = &start
+	}
+	status.Phase = metricsv1.ExportRunning
+	result, exportErr := r.export(ctx, log, &task, status, now)
+	if exportErr != nil {
+		log.Error(exportErr, "unable to export runs")
+		status.LastError = exportErr.Error()
+		// A one-shot task gives up like a Job does, once it has failed more
+		// often than its backoff limit allows.
+		if task.ModeOrDefault() == metricsv1.ExportOneShot {
+			status.Failures++
+			if status.Failures > task.BackoffLimitOrDefault() {
+				completed := metav1.NewTime(now)
+				status.Phase = metricsv1.ExportFailed
+				status.CompletionTime = &completed
+				result, exportErr = ctrl.Result{}, nil
+			}
+		}
+	} else {
+		status.LastError = ""
+	}
+
+	if !equality.Semantic.DeepEqual(&task.Status, status) {
+		task.Status = *status
+		if err := r.Status().Update(ctx, &task); err != nil {
+			log.Error(err, "unable to update ExportTask status")
+			return ctrl.Result{}, err
+		}
+	}
+	if task.Status.Watermark != nil {
+		exportLag.set(req.NamespacedName, task.Status.Watermark.Time)
+	}
+	return result, exportErr
+}
+
+// export sends the pending runs of the task to its sink when its mode says
+// they are due, and records the progress in status.
+func (r *ExportTaskReconciler) export(ctx context.Context, log logr.Logger, task *metricsv1.ExportTask, status *metricsv1.ExportTaskStatus, now time.Time) (ctrl.Result, error) {
+	sink, labels, err := r.sink(ctx, task)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to configure sink: %v", err)
+	}
+	if sink == nil {
+		return ctrl.Result{}, fmt.Errorf("sink must set one of s3, influx and otlp")
+	}
 
-	// your logic here
+	mode := task.ModeOrDefault()
+	var from, to time.Time
+	if tr := task.Spec.TimeRange; tr != nil {
+		if tr.From != nil {
+			from = tr.From.Time
+		}
+		if tr.To != nil {
+			to = tr.To.Time
+		}
+	}
+	if to.IsZero() && mode == metricsv1.ExportOneShot {
+		to = status.StartTime.Time
+	}
 
+	runs, err := r.pending(ctx, task, from, to, now)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to list runs: %v", err)
+	}
+	status.Total = status.Exported + int64(len(runs))
+	var objects map[string]map[string]string
+	if labels {
+		if objects, err = r.labels(ctx, task.Namespace); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to list labels of Checks and LoadTests: %v", err)
+		}
+	}
+	rows := make([]export.Row, len(runs))
+	for i := range runs {
+		rows[i] = export.RowOf(&runs[i])
+		rows[i].Labels = objects[rows[i].Kind+"/"+rows[i].Name]
+	}
+
+	var next time.Time
+	switch mode {
+	case metricsv1.ExportContinuous:
+		due, at, err := sink.Due(rows, now)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to encode runs: %v", err)
+		}
+		if !due {
+			if at.IsZero() {
+				return ctrl.Result{}, nil
+			}
+			return ctrl.Result{RequeueAfter: at.Sub(now)}, nil
+		}
+	case metricsv1.ExportScheduled:
+		schedule, err := maintenance.ParseSchedule(task.Spec.Schedule)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("invalid schedule: %v", err)
+		}
+		last := status.StartTime.Time
+		if status.LastScheduleTime != nil {
+			last = status.LastScheduleTime.Time
+		}
+		tick := schedule.Next(last.UTC())
+		if tick.IsZero() {
+			return ctrl.Result{}, nil
+		}
+		if now.Before(tick) {
+			return ctrl.Result{RequeueAfter: tick.Sub(now)}, nil
+		}
+		next = schedule.Next(now.UTC())
+	}
+
+	// Runs are read from the cluster and the store, so the watermark is all
+	// there is to resume from after a restart. It moves past the runs sent
+	// even when later ones fail.
+	if len(rows) > 0 {
+		n, err := sink.Write(ctx, rows)
+		if n > 0 {
+			log.V(1).Info("exported runs", "runs", n)
+			last := &runs[n-1]
+			exported := metav1.NewTime(now)
+			status.LastExportTime = &exported
+			status.Watermark = last.Status.CompletionTime
+			status.WatermarkRun = last.Name
+			status.Exported += int64(n)
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	switch mode {
+	case metricsv1.ExportScheduled:
+		scheduled := metav1.NewTime(now)
+		status.LastScheduleTime = &scheduled
+		if next.IsZero() {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+	case metricsv1.ExportOneShot:
+		// Runs may still finish within a range that ends in the future.
+		if now.Before(to) {
+			return ctrl.Result{RequeueAfter: to.Sub(now)}, nil
+		}
+		completed := metav1.NewTime(now)
+		status.Phase = metricsv1.ExportSucceeded
+		status.CompletionTime = &completed
+	}
 	return ctrl.Result{}, nil
 }
 
+// sink returns the sink of the task, or nil if it has none, and whether it
+// needs the labels of the Checks and LoadTests of runs.
+func (r *ExportTaskReconciler) sink(ctx context.Context, task *metricsv1.ExportTask) (export.Sink, bool, error) {
+	resolver := secrets.NewResolver(r.Client, task.Namespace)
+	switch sink := task.Spec.Sink; {
+	case sink.S3 != nil:
+		files, err := r.files(ctx, task)
+		return files, false, err
+	case sink.Influx != nil:
+		token, err := resolver.Get(ctx, "", sink.Influx.TokenFrom)
+		if err != nil {
+			return nil, false, err
+		}
+		measurement := sink.Influx.Measurement
+		if measurement == "" {
+			measurement = metricsv1.DefaultInfluxMeasurement
+		}
+		return &export.Influx{
+			Delivery:    delivery(&sink.Influx.ExportDelivery),
+			URL:         sink.Influx.URL,
+			Org:         sink.Influx.Org,
+			Bucket:      sink.Influx.Bucket,
+			Database:    sink.Influx.Database,
+			Measurement: measurement,
+			Token:       token,
+		}, len(sink.Influx.ResourceLabels) > 0, nil
+	case sink.OTLP != nil:
+		header := http.Header{}
+		for _, h := range sink.OTLP.Headers {
+			value, err := resolver.Get(ctx, h.Value, h.ValueFrom)
+			if err != nil {
+				return nil, false, fmt.Errorf("header %s: %v", h.Name, err)
+			}
+			header.Add(h.Name, value)
+		}
+		return &export.OTLP{
+			Delivery: delivery(&sink.OTLP.ExportDelivery),
+			Endpoint: sink.OTLP.Endpoint,
+			GRPC:     sink.OTLP.Protocol == metricsv1.OTLPGRPC,
+			Header:   header,
+		}, len(sink.OTLP.ResourceLabels) > 0, nil
+	}
+	return nil, false, nil
+}
+
+// delivery returns how a sink sends runs as the spec d configures it.
+func delivery(d *metricsv1.ExportDelivery) export.Delivery {
+	return export.Delivery{
+		BatchSize:      d.BatchSizeOrDefault(),
+		BatchAge:       d.BatchAgeOrDefault(),
+		Retries:        d.RetriesOrDefault(),
+		Backoff:        d.RetryBackoffOrDefault(),
+		Gzip:           d.Compression != metricsv1.CompressionNone,
+		ResourceLabels: d.ResourceLabels,
+	}
+}
+
+// labels returns the labels of the Checks and LoadTests in namespace, by
+// row kind and name.
+func (r *ExportTaskReconciler) labels(ctx context.Context, namespace string) (map[string]map[string]string, error) {
+	out := map[string]map[string]string{}
+	var checks metricsv1.CheckList
+	if err := r.List(ctx, &checks, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, c := range checks.Items {
+		out[export.KindCheck+"/"+c.Name] = c.Labels
+	}
+	var loadTests metricsv1.LoadTestList
+	if err := r.List(ctx, &loadTests, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, lt := range loadTests.Items {
+		out[export.KindLoadTest+"/"+lt.Name] = lt.Labels
+	}
+	return out, nil
+}
+
+// files returns the sink the task writes files to.
+func (r *ExportTaskReconciler) files(ctx context.Context, task *metricsv1.ExportTask) (*export.Files, error) {
+	sink := task.Spec.Sink.S3
+	format, err := export.FormatOf(sink.FormatOrDefault())
+	if err != nil {
+		return nil, err
+	}
+	var secret corev1.Secret
+	key := types.NamespacedName{Namespace: task.Namespace, Name: sink.CredentialsSecret}
+	if err := r.Get(ctx, key, &secret); err != nil {
+		return nil, err
+	}
+	s3 := &export.S3{
+		Endpoint:        sink.Endpoint,
+		Region:          sink.RegionOrDefault(),
+		Bucket:          sink.Bucket,
+		PathStyle:       sink.PathStyle,
+		AccessKeyID:     string(secret.Data["AWS_ACCESS_KEY_ID"]),
+		SecretAccessKey: string(secret.Data["AWS_SECRET_ACCESS_KEY"]),
+		SessionToken:    string(secret.Data["AWS_SESSION_TOKEN"]),
+	}
+	if s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+		return nil, fmt.Errorf("secret %q must set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", sink.CredentialsSecret)
+	}
+	return &export.Files{
+		Writer:      s3,
+		Format:      format,
+		Prefix:      sink.Prefix,
+		KeyTemplate: sink.KeyTemplateOrDefault(),
+		MaxSize:     sink.MaxFileSizeOrDefault(),
+		MaxAge:      sink.MaxFileAgeOrDefault(),
+	}, nil
+}
+
+// pending returns the finished runs in the namespace of the task past its
+// watermark that finished in [from, to), oldest first. A zero from or to
+// leaves the range open on that side. Runs the cluster no longer keeps are
+// read from the store.
+func (r *ExportTaskReconciler) pending(ctx context.Context, task *metricsv1.ExportTask, from, to, now time.Time) ([]metricsv1.SyntheticRun, error) {
+	within := func(run *metricsv1.SyntheticRun) bool {
+		t := run.Status.CompletionTime
+		return run.Finished() && task.After(run) && t != nil &&
+			!t.Time.Before(from) && (to.IsZero() || t.Time.Before(to))
+	}
+
+	var list metricsv1.SyntheticRunList
+	if err := r.List(ctx, &list, client.InNamespace(task.Namespace)); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var runs []metricsv1.SyntheticRun
+	for i := range list.Items {
+		run := &list.Items[i]
+		if !within(run) {
+			continue
+		}
+		// Runs kept in the store only keep a summary in their status.
+		if r.Store != nil && run.Spec.CheckRef != "" {
+			status, err := store.Details(r.Store, run)
+			if err != nil {
+				return nil, err
+			}
+			run.Status = *status
+		}
+		runs = append(runs, *run)
+		seen[run.Name] = true
+	}
+
+	if r.Store != nil {
+		series, err := r.Store.Series()
+		if err != nil {
+			return nil, err
+		}
+		start := from
+		if w := task.Status.Watermark; w != nil && w.Time.After(start) {
+			start = w.Time
+		}
+		end := to
+		if end.IsZero() {
+			end = now.Add(time.Hour)
+		}
+		for _, s := range series {
+			if s.Namespace != task.Namespace {
+				continue
+			}
+			records, err := r.Store.Records(s, start, end)
+			if err != nil {
+				return nil, err
+			}
+			for i := range records {
+				if run := records[i].SyntheticRun(s); !seen[run.Name] && within(run) {
+					runs = append(runs, *run)
+					seen[run.Name] = true
+				}
+			}
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		a, b := runs[i].Status.CompletionTime, runs[j].Status.CompletionTime
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return runs[i].Name < runs[j].Name
+	})
+	return runs, nil
+}
+
+// tasksForRun maps a finished SyntheticRun to the ExportTasks in its
+// namespace.
+func (r *ExportTaskReconciler) tasksForRun(obj handler.MapObject) []reconcile.Request {
+	if run, ok := obj.Object.(*metricsv1.SyntheticRun); !ok || !run.Finished() {
+		return nil
+	}
+	var list metricsv1.ExportTaskList
+	if err := r.List(context.Background(), &list, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list ExportTasks")
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, task := range list.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: task.Namespace, Name: task.Name}})
+	}
+	return reqs
+}
+
 func (r *ExportTaskReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&metricsv1.ExportTask{}).
+		Watches(&source.Kind{Type: &metricsv1.SyntheticRun{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.tasksForRun)}).
 		Complete(r)
 }
@@ -0,0 +1,311 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	metricsv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/store"
+)
+
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	metricsv1.AddToScheme(scheme)
+	return scheme
+}
+
+// influxServer accepts writes and counts them.
+func influxServer(writes *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(writes, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func newExportTask(name string, mode metricsv1.ExportMode, url string) *metricsv1.ExportTask {
+	task := &metricsv1.ExportTask{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       metricsv1.ExportTaskSpec{Mode: mode},
+	}
+	if url != "" {
+		task.Spec.Sink.Influx = &metricsv1.InfluxSink{URL: url, Database: "perph"}
+	}
+	return task
+}
+
+// exportedRun returns a run of the api check that finished at t, which is
+// kept to the second like the API server does.
+func exportedRun(name string, t time.Time) *metricsv1.SyntheticRun {
+	finished := metav1.NewTime(t.Truncate(time.Second))
+	return &metricsv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       metricsv1.SyntheticRunSpec{CheckRef: "api", Location: "eu"},
+		Status: metricsv1.SyntheticRunStatus{
+			Phase:          metricsv1.RunSucceeded,
+			StartTime:      &finished,
+			CompletionTime: &finished,
+		},
+	}
+}
+
+// reconcileTask reconciles the task called name and returns the result and
+// the task as it was left.
+func reconcileTask(g *GomegaWithT, r *ExportTaskReconciler, name string) (ctrl.Result, *metricsv1.ExportTask, error) {
+	key := types.NamespacedName{Namespace: "default", Name: name}
+	res, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	var task metricsv1.ExportTask
+	g.Expect(r.Get(context.Background(), key, &task)).To(Succeed())
+	return res, &task, err
+}
+
+func TestExportTaskContinuous(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var writes int32
+	influx := influxServer(&writes)
+	defer influx.Close()
+
+	now := time.Now()
+	r := &ExportTaskReconciler{
+		Client: fake.NewFakeClientWithScheme(newScheme(),
+			newExportTask("continuous", "", influx.URL),
+			exportedRun("api-1", now.Add(-time.Minute))),
+		Log: zap.Logger(true),
+	}
+
+	// A batch older than its age is sent at once.
+	res, task, err := reconcileTask(g, r, "continuous")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res).To(Equal(ctrl.Result{}))
+	g.Expect(atomic.LoadInt32(&writes)).To(Equal(int32(1)))
+	g.Expect(task.Status.Phase).To(Equal(metricsv1.ExportRunning))
+	g.Expect(task.Status.Exported).To(Equal(int64(1)))
+	g.Expect(task.Status.WatermarkRun).To(Equal("api-1"))
+
+	// A younger one waits until it is due.
+	g.Expect(r.Create(context.Background(), exportedRun("api-2", time.Now()))).To(Succeed())
+	res, task, err = reconcileTask(g, r, "continuous")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.RequeueAfter).To(BeNumerically(">", 0))
+	g.Expect(res.RequeueAfter).To(BeNumerically("<=", metricsv1.DefaultExportBatchAge))
+	g.Expect(atomic.LoadInt32(&writes)).To(Equal(int32(1)))
+	g.Expect(task.Status.Exported).To(Equal(int64(1)))
+	g.Expect(task.Status.Total).To(Equal(int64(2)))
+}
+
+func TestExportTaskScheduled(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var writes int32
+	influx := influxServer(&writes)
+	defer influx.Close()
+
+	task := newExportTask("hourly", metricsv1.ExportScheduled, influx.URL)
+	task.Spec.Schedule = "0 * * * *"
+	r := &ExportTaskReconciler{
+		Client: fake.NewFakeClientWithScheme(newScheme(), task,
+			exportedRun("api-1", time.Now().Add(-time.Minute))),
+		Log: zap.Logger(true),
+	}
+
+	// Nothing is sent before the first tick after the task started.
+	res, task, err := reconcileTask(g, r, "hourly")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.RequeueAfter).To(BeNumerically(">", 0))
+	g.Expect(res.RequeueAfter).To(BeNumerically("<=", time.Hour))
+	g.Expect(atomic.LoadInt32(&writes)).To(BeZero())
+	g.Expect(task.Status.StartTime).NotTo(BeNil())
+	g.Expect(task.Status.LastScheduleTime).To(BeNil())
+
+	// Once a tick has passed, the runs are sent and the next tick awaited.
+	last := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	task.Status.LastScheduleTime = &last
+	g.Expect(r.Status().Update(context.Background(), task)).To(Succeed())
+	res, task, err = reconcileTask(g, r, "hourly")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.RequeueAfter).To(BeNumerically(">", 0))
+	g.Expect(res.RequeueAfter).To(BeNumerically("<=", time.Hour))
+	g.Expect(atomic.LoadInt32(&writes)).To(Equal(int32(1)))
+	g.Expect(task.Status.Exported).To(Equal(int64(1)))
+	g.Expect(task.Status.LastScheduleTime.Time).To(BeTemporally(">", last.Time))
+}
+
+func TestExportTaskOneShot(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var writes int32
+	influx := influxServer(&writes)
+	defer influx.Close()
+
+	now := time.Now()
+	later := newExportTask("later", metricsv1.ExportOneShot, influx.URL)
+	to := metav1.NewTime(now.Add(time.Hour))
+	later.Spec.TimeRange = &metricsv1.ExportTimeRange{To: &to}
+	r := &ExportTaskReconciler{
+		Client: fake.NewFakeClientWithScheme(newScheme(),
+			newExportTask("once", metricsv1.ExportOneShot, influx.URL), later,
+			exportedRun("api-1", now.Add(-time.Minute))),
+		Log: zap.Logger(true),
+	}
+
+	// Without a range, the runs up to the start of the task are exported.
+	res, task, err := reconcileTask(g, r, "once")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res).To(Equal(ctrl.Result{}))
+	g.Expect(task.Status.Phase).To(Equal(metricsv1.ExportSucceeded))
+	g.Expect(task.Status.CompletionTime).NotTo(BeNil())
+	g.Expect(task.Status.Exported).To(Equal(int64(1)))
+
+	// A range ending in the future is waited for.
+	res, task, err = reconcileTask(g, r, "later")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.RequeueAfter).To(BeNumerically(">", 59*time.Minute))
+	g.Expect(res.RequeueAfter).To(BeNumerically("<=", time.Hour))
+	g.Expect(task.Status.Phase).To(Equal(metricsv1.ExportRunning))
+	g.Expect(task.Status.Exported).To(Equal(int64(1)))
+}
+
+func TestExportTaskBackoffLimit(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	limit := int32(1)
+	broken := newExportTask("broken", metricsv1.ExportOneShot, "")
+	broken.Spec.BackoffLimit = &limit
+	r := &ExportTaskReconciler{
+		Client: fake.NewFakeClientWithScheme(newScheme(), broken,
+			newExportTask("retried", metricsv1.ExportContinuous, "")),
+		Log: zap.Logger(true),
+	}
+
+	_, task, err := reconcileTask(g, r, "broken")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(task.Status.Phase).To(Equal(metricsv1.ExportRunning))
+	g.Expect(task.Status.Failures).To(Equal(int32(1)))
+	g.Expect(task.Status.LastError).To(ContainSubstring("sink must set"))
+
+	// Failing more often than the backoff limit allows fails the task for
+	// good.
+	res, task, err := reconcileTask(g, r, "broken")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res).To(Equal(ctrl.Result{}))
+	g.Expect(task.Status.Phase).To(Equal(metricsv1.ExportFailed))
+	g.Expect(task.Status.Failures).To(Equal(int32(2)))
+	g.Expect(task.Status.CompletionTime).NotTo(BeNil())
+
+	_, task, err = reconcileTask(g, r, "broken")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(task.Status.Failures).To(Equal(int32(2)))
+
+	// Other tasks keep retrying.
+	for i := 0; i < 3; i++ {
+		_, task, err = reconcileTask(g, r, "retried")
+		g.Expect(err).To(HaveOccurred())
+	}
+	g.Expect(task.Status.Phase).To(Equal(metricsv1.ExportRunning))
+	g.Expect(task.Status.Failures).To(BeZero())
+}
+
+func TestExportTaskBackfill(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var writes int32
+	influx := influxServer(&writes)
+	defer influx.Close()
+
+	dir, err := ioutil.TempDir("", "store")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	results, err := store.Open(dir, store.Policy{Retention: 24 * time.Hour}, zap.Logger(true))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer results.Close()
+
+	// The store holds runs the cluster no longer keeps, and the latest run
+	// too.
+	now := time.Now()
+	old1 := exportedRun("api-old-1", now.Add(-3*time.Hour))
+	old2 := exportedRun("api-old-2", now.Add(-2*time.Hour))
+	latest := exportedRun("api-latest", now.Add(-time.Hour))
+	for _, run := range []*metricsv1.SyntheticRun{old1, old2, latest} {
+		g.Expect(results.Append(store.SeriesOf(run), store.RecordOf(run))).To(Succeed())
+	}
+
+	// The task resumes past the first run it exported before.
+	task := newExportTask("backfill", metricsv1.ExportOneShot, influx.URL)
+	from := metav1.NewTime(now.Add(-4 * time.Hour))
+	task.Spec.TimeRange = &metricsv1.ExportTimeRange{From: &from}
+	task.Status.Watermark = old1.Status.CompletionTime
+	task.Status.WatermarkRun = old1.Name
+	task.Status.Exported = 1
+	r := &ExportTaskReconciler{
+		Client: fake.NewFakeClientWithScheme(newScheme(), task, latest),
+		Log:    zap.Logger(true),
+		Store:  results,
+	}
+
+	res, task, err := reconcileTask(g, r, "backfill")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res).To(Equal(ctrl.Result{}))
+	g.Expect(task.Status.Phase).To(Equal(metricsv1.ExportSucceeded))
+	g.Expect(task.Status.Exported).To(Equal(int64(3)))
+	g.Expect(task.Status.Total).To(Equal(int64(3)))
+	g.Expect(task.Status.Watermark.Time).To(BeTemporally("==", latest.Status.CompletionTime.Time))
+	g.Expect(task.Status.WatermarkRun).To(Equal("api-latest"))
+}
+
+func TestExportTaskBackfillOpenRange(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var writes int32
+	influx := influxServer(&writes)
+	defer influx.Close()
+
+	dir, err := ioutil.TempDir("", "store")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	results, err := store.Open(dir, store.Policy{Retention: 24 * time.Hour}, zap.Logger(true))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer results.Close()
+
+	// The cluster no longer keeps the run, and the task has no range and no
+	// watermark to start from.
+	old := exportedRun("api-old", time.Now().Add(-2*time.Hour))
+	g.Expect(results.Append(store.SeriesOf(old), store.RecordOf(old))).To(Succeed())
+	r := &ExportTaskReconciler{
+		Client: fake.NewFakeClientWithScheme(newScheme(), newExportTask("backfill", metricsv1.ExportOneShot, influx.URL)),
+		Log:    zap.Logger(true),
+		Store:  results,
+	}
+
+	_, task, err := reconcileTask(g, r, "backfill")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(task.Status.Phase).To(Equal(metricsv1.ExportSucceeded))
+	g.Expect(task.Status.Total).To(Equal(int64(1)))
+	g.Expect(task.Status.Exported).To(Equal(int64(1)))
+	g.Expect(task.Status.WatermarkRun).To(Equal("api-old"))
+}
@@ -0,0 +1,404 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/faultproxy"
+)
+
+// FaultProxyReconciler deploys the proxies of FaultProxies, in Deployments of
+// their own or as sidecars of their targets, and reports the faults they
+// inject.
+type FaultProxyReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// Image is the image of proxies that do not set one, which runs the
+	// manager's binary.
+	Image string
+}
+
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=faultproxies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=faultproxies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+
+func (r *FaultProxyReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("faultproxy", req.NamespacedName)
+
+	var fp syntheticv1.FaultProxy
+	if err := r.Get(ctx, req.NamespacedName, &fp); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch FaultProxy")
+		return ctrl.Result{}, err
+	}
+	deleting := !fp.DeletionTimestamp.IsZero()
+	sidecar := fp.ModeOrDefault() == syntheticv1.FaultProxySidecar && !deleting
+
+	// Take the sidecar out of the Deployments the proxy no longer targets
+	// before letting go of them.
+	keep := ""
+	if sidecar {
+		keep = fp.Spec.Target
+	}
+	if err := r.detach(ctx, &fp, keep); err != nil {
+		log.Error(err, "unable to remove sidecar from Deployments")
+		return ctrl.Result{}, err
+	}
+	if deleting {
+		if hasString(fp.Finalizers, syntheticv1.FaultProxyFinalizer) {
+			fp.Finalizers = removeString(fp.Finalizers, syntheticv1.FaultProxyFinalizer)
+			if err := r.Update(ctx, &fp); err != nil {
+				log.Error(err, "unable to remove finalizer")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+	if sidecar != hasString(fp.Finalizers, syntheticv1.FaultProxyFinalizer) {
+		if sidecar {
+			fp.Finalizers = append(fp.Finalizers, syntheticv1.FaultProxyFinalizer)
+		} else {
+			fp.Finalizers = removeString(fp.Finalizers, syntheticv1.FaultProxyFinalizer)
+		}
+		if err := r.Update(ctx, &fp); err != nil {
+			log.Error(err, "unable to update finalizers")
+			return ctrl.Result{}, err
+		}
+	}
+
+	status := fp.Status.DeepCopy()
+	status.Message = ""
+	now := time.Now()
+	active, next, err := faultproxy.Active(fp.Spec.Faults, now)
+	if err != nil {
+		// The proxy skips invalid faults and injects the others.
+		status.Message = err.Error()
+	}
+	status.ActiveFaults = faultproxy.Names(active)
+	status.NextChange = nil
+	if !next.IsZero() {
+		t := metav1.NewTime(next)
+		status.NextChange = &t
+	}
+
+	port := strconv.Itoa(int(fp.Spec.PortOrDefault()))
+	if sidecar {
+		if err := r.removeStandalone(ctx, &fp); err != nil {
+			log.Error(err, "unable to remove standalone proxy")
+			return ctrl.Result{}, err
+		}
+		msg, err := r.attach(ctx, &fp)
+		if err != nil {
+			log.Error(err, "unable to add sidecar to Deployment", "deployment", fp.Spec.Target)
+			return ctrl.Result{}, err
+		}
+		status.Address = ""
+		if msg != "" {
+			status.Message = msg
+		} else {
+			status.Address = "localhost:" + port
+		}
+	} else {
+		if err := r.deploy(ctx, &fp); err != nil {
+			log.Error(err, "unable to deploy standalone proxy")
+			return ctrl.Result{}, err
+		}
+		status.Address = fmt.Sprintf("%s.%s.svc:%s", fp.Name, fp.Namespace, port)
+	}
+	status.ObservedGeneration = fp.Generation
+
+	var result ctrl.Result
+	if !next.IsZero() {
+		result.RequeueAfter = next.Sub(now)
+	}
+	if equality.Semantic.DeepEqual(*status, fp.Status) {
+		return result, nil
+	}
+	fp.Status = *status
+	if err := r.Status().Update(ctx, &fp); err != nil {
+		log.Error(err, "unable to update FaultProxy status")
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// container returns the container that runs the proxy of fp.
+func (r *FaultProxyReconciler) container(fp *syntheticv1.FaultProxy) (corev1.Container, error) {
+	spec, err := json.Marshal(fp.Spec)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	image := fp.Spec.Image
+	if image == "" {
+		image = r.Image
+	}
+	return corev1.Container{
+		Name:    syntheticv1.FaultProxyContainer,
+		Image:   image,
+		Command: []string{"/manager"},
+		Args:    []string{"--fault-proxy=" + string(spec)},
+		Ports: []corev1.ContainerPort{{
+			Name:          "fault-proxy",
+			ContainerPort: fp.Spec.PortOrDefault(),
+			Protocol:      corev1.ProtocolTCP,
+		}},
+	}, nil
+}
+
+// attach adds the proxy of fp as a sidecar of its target, and returns why it
+// cannot when the target is missing or taken by another proxy.
+func (r *FaultProxyReconciler) attach(ctx context.Context, fp *syntheticv1.FaultProxy) (string, error) {
+	if fp.Spec.Target == "" {
+		return "target must be set in Sidecar mode", nil
+	}
+	var dep appsv1.Deployment
+	err := r.Get(ctx, types.NamespacedName{Namespace: fp.Namespace, Name: fp.Spec.Target}, &dep)
+	switch {
+	case apierrors.IsNotFound(err):
+		return fmt.Sprintf("deployment %q not found", fp.Spec.Target), nil
+	case err != nil:
+		return "", err
+	}
+	if owner := dep.Labels[syntheticv1.FaultProxyLabel]; owner != "" && owner != fp.Name {
+		return fmt.Sprintf("deployment %q already has the sidecar of fault proxy %q", dep.Name, owner), nil
+	}
+	c, err := r.container(fp)
+	if err != nil {
+		return "", err
+	}
+	changed := setContainer(&dep.Spec.Template.Spec, c)
+	if dep.Labels[syntheticv1.FaultProxyLabel] != fp.Name {
+		if dep.Labels == nil {
+			dep.Labels = map[string]string{}
+		}
+		dep.Labels[syntheticv1.FaultProxyLabel] = fp.Name
+		changed = true
+	}
+	if !changed {
+		return "", nil
+	}
+	return "", r.Update(ctx, &dep)
+}
+
+// detach removes the sidecar of fp from the Deployments it was added to,
+// except keep.
+func (r *FaultProxyReconciler) detach(ctx context.Context, fp *syntheticv1.FaultProxy, keep string) error {
+	var deps appsv1.DeploymentList
+	err := r.List(ctx, &deps, client.InNamespace(fp.Namespace), client.MatchingLabels(map[string]string{syntheticv1.FaultProxyLabel: fp.Name}))
+	if err != nil {
+		return err
+	}
+	for i := range deps.Items {
+		dep := &deps.Items[i]
+		if dep.Name == keep || metav1.IsControlledBy(dep, fp) {
+			continue
+		}
+		removeContainer(&dep.Spec.Template.Spec, syntheticv1.FaultProxyContainer)
+		delete(dep.Labels, syntheticv1.FaultProxyLabel)
+		if err := r.Update(ctx, dep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deploy creates or updates the Deployment and Service of the standalone
+// proxy of fp, both named after it and owned by it.
+func (r *FaultProxyReconciler) deploy(ctx context.Context, fp *syntheticv1.FaultProxy) error {
+	labels := map[string]string{syntheticv1.FaultProxyLabel: fp.Name}
+	c, err := r.container(fp)
+	if err != nil {
+		return err
+	}
+
+	var dep appsv1.Deployment
+	err = r.Get(ctx, types.NamespacedName{Namespace: fp.Namespace, Name: fp.Name}, &dep)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err != nil {
+		dep = appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: fp.Namespace, Name: fp.Name, Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{c}},
+				},
+			},
+		}
+		if err := ctrl.SetControllerReference(fp, &dep, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, &dep); err != nil {
+			return err
+		}
+	} else if setContainer(&dep.Spec.Template.Spec, c) {
+		if err := r.Update(ctx, &dep); err != nil {
+			return err
+		}
+	}
+
+	port := corev1.ServicePort{
+		Name:       "fault-proxy",
+		Port:       fp.Spec.PortOrDefault(),
+		TargetPort: intstr.FromInt(int(fp.Spec.PortOrDefault())),
+		Protocol:   corev1.ProtocolTCP,
+	}
+	var svc corev1.Service
+	err = r.Get(ctx, types.NamespacedName{Namespace: fp.Namespace, Name: fp.Name}, &svc)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err != nil {
+		svc = corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: fp.Namespace, Name: fp.Name, Labels: labels},
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports:    []corev1.ServicePort{port},
+			},
+		}
+		if err := ctrl.SetControllerReference(fp, &svc, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, &svc)
+	}
+	if len(svc.Spec.Ports) == 1 && svc.Spec.Ports[0].Port == port.Port && svc.Spec.Ports[0].TargetPort == port.TargetPort {
+		return nil
+	}
+	svc.Spec.Ports = []corev1.ServicePort{port}
+	return r.Update(ctx, &svc)
+}
+
+// removeStandalone deletes the Deployment and Service of the standalone proxy
+// of fp, once it moves to Sidecar mode.
+func (r *FaultProxyReconciler) removeStandalone(ctx context.Context, fp *syntheticv1.FaultProxy) error {
+	key := types.NamespacedName{Namespace: fp.Namespace, Name: fp.Name}
+	for _, obj := range []interface {
+		runtime.Object
+		metav1.Object
+	}{&appsv1.Deployment{}, &corev1.Service{}} {
+		err := r.Get(ctx, key, obj)
+		switch {
+		case apierrors.IsNotFound(err):
+			continue
+		case err != nil:
+			return err
+		}
+		if !metav1.IsControlledBy(obj, fp) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// setContainer adds c to spec, or replaces the container of the same name,
+// and returns true if spec changed.
+func setContainer(spec *corev1.PodSpec, c corev1.Container) bool {
+	for i := range spec.Containers {
+		old := &spec.Containers[i]
+		if old.Name != c.Name {
+			continue
+		}
+		if old.Image == c.Image && equality.Semantic.DeepEqual(old.Command, c.Command) &&
+			equality.Semantic.DeepEqual(old.Args, c.Args) && equality.Semantic.DeepEqual(old.Ports, c.Ports) {
+			return false
+		}
+		old.Image, old.Command, old.Args, old.Ports = c.Image, c.Command, c.Args, c.Ports
+		return true
+	}
+	spec.Containers = append(spec.Containers, c)
+	return true
+}
+
+// removeContainer removes the container called name from spec.
+func removeContainer(spec *corev1.PodSpec, name string) {
+	var containers []corev1.Container
+	for _, c := range spec.Containers {
+		if c.Name != name {
+			containers = append(containers, c)
+		}
+	}
+	spec.Containers = containers
+}
+
+func hasString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	var out []string
+	for _, item := range list {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// proxiesOf maps a Deployment to the FaultProxy whose sidecar it runs.
+func (r *FaultProxyReconciler) proxiesOf(obj handler.MapObject) []reconcile.Request {
+	name := obj.Meta.GetLabels()[syntheticv1.FaultProxyLabel]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: name}}}
+}
+
+func (r *FaultProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&syntheticv1.FaultProxy{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.proxiesOf)}).
+		Complete(r)
+}
@@ -0,0 +1,268 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/analysis"
+)
+
+// gateReferencesIndex indexes Gates by the Deployment they gate and the
+// objects they watch, as "deployment/<name>", "checks/<name>",
+// "validations/<name>" and "loadtests/<name>".
+const gateReferencesIndex = ".spec.gateReferences"
+
+// GateReconciler pauses the rollouts of Deployments while the checks their
+// Gates watch fail.
+type GateReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+
+	Evaluator *analysis.Evaluator
+}
+
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=gates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=gates/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update;patch
+
+func (r *GateReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("gate", req.NamespacedName)
+
+	var gate syntheticv1.Gate
+	if err := r.Get(ctx, req.NamespacedName, &gate); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch Gate")
+		return ctrl.Result{}, err
+	}
+	status := gate.Status.DeepCopy()
+
+	failing, messages, err := r.failing(ctx, &gate)
+	if err != nil {
+		log.Error(err, "unable to evaluate gate")
+		return ctrl.Result{}, err
+	}
+	phase := syntheticv1.GateOpen
+	if len(failing) > 0 {
+		phase = syntheticv1.GateClosed
+	}
+	status.Failing = failing
+	status.Message = strings.Join(messages, "; ")
+	if phase != status.Phase {
+		now := metav1.Now()
+		status.LastTransitionTime = &now
+		status.Phase = phase
+	}
+
+	var dep appsv1.Deployment
+	err = r.Get(ctx, types.NamespacedName{Namespace: gate.Namespace, Name: gate.Spec.DeploymentRef}, &dep)
+	switch {
+	case apierrors.IsNotFound(err):
+		status.Paused = false
+		status.Message = fmt.Sprintf("deployment %q not found", gate.Spec.DeploymentRef)
+	case err != nil:
+		log.Error(err, "unable to fetch Deployment")
+		return ctrl.Result{}, err
+	default:
+		if err := r.hold(ctx, &gate, &dep, status); err != nil {
+			log.Error(err, "unable to update Deployment")
+			return ctrl.Result{}, err
+		}
+		status.Paused = dep.Spec.Paused && dep.Annotations[syntheticv1.PausedByAnnotation] == gate.Name
+	}
+
+	if equality.Semantic.DeepEqual(*status, gate.Status) {
+		return ctrl.Result{}, nil
+	}
+	gate.Status = *status
+	if err := r.Status().Update(ctx, &gate); err != nil {
+		log.Error(err, "unable to update Gate status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// failing returns what the gate watches that failed, as kind/name, and why.
+// Objects without finished runs do not fail.
+func (r *GateReconciler) failing(ctx context.Context, gate *syntheticv1.Gate) ([]string, []string, error) {
+	var failing, messages []string
+	for _, ref := range gateObjects(gate) {
+		parts := strings.SplitN(ref, "/", 2)
+		res, err := r.Evaluator.Evaluate(ctx, parts[0], gate.Namespace, parts[1])
+		switch {
+		case err == analysis.ErrNotFound:
+			failing = append(failing, ref)
+			messages = append(messages, ref+": not found")
+		case err != nil:
+			return nil, nil, err
+		case res.Finished && !res.Passed:
+			failing = append(failing, ref)
+			messages = append(messages, ref+": "+res.Message)
+		}
+	}
+	return failing, messages, nil
+}
+
+// hold pauses a rollout of dep in progress while the gate is closed, as
+// status reports, and resumes it once the gate opens again if the gate paused
+// it and may resume it.
+func (r *GateReconciler) hold(ctx context.Context, gate *syntheticv1.Gate, dep *appsv1.Deployment, status *syntheticv1.GateStatus) error {
+	pausedByGate := dep.Spec.Paused && dep.Annotations[syntheticv1.PausedByAnnotation] == gate.Name
+	switch {
+	case status.Phase == syntheticv1.GateClosed && !dep.Spec.Paused && rollingOut(dep):
+		if dep.Annotations == nil {
+			dep.Annotations = map[string]string{}
+		}
+		dep.Annotations[syntheticv1.PausedByAnnotation] = gate.Name
+		dep.Spec.Paused = true
+		if err := r.Update(ctx, dep); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(gate, corev1.EventTypeWarning, "RolloutPaused",
+			"paused rollout of deployment %s: %s", dep.Name, strings.Join(status.Failing, ", "))
+	case status.Phase == syntheticv1.GateOpen && pausedByGate && gate.Spec.Resume:
+		delete(dep.Annotations, syntheticv1.PausedByAnnotation)
+		dep.Spec.Paused = false
+		if err := r.Update(ctx, dep); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(gate, corev1.EventTypeNormal, "RolloutResumed", "resumed rollout of deployment %s", dep.Name)
+	}
+	return nil
+}
+
+// rollingOut returns true while dep has pods that do not run its latest
+// template, or its controller has yet to observe the latest spec.
+func rollingOut(dep *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	return dep.Status.ObservedGeneration < dep.Generation ||
+		dep.Status.UpdatedReplicas < replicas ||
+		dep.Status.Replicas > dep.Status.UpdatedReplicas
+}
+
+// gateObjects returns the objects the gate watches, as kind/name.
+func gateObjects(gate *syntheticv1.Gate) []string {
+	var out []string
+	for _, name := range gate.Spec.Checks {
+		out = append(out, analysis.KindCheck+"/"+name)
+	}
+	for _, name := range gate.Spec.Validations {
+		out = append(out, analysis.KindValidation+"/"+name)
+	}
+	for _, name := range gate.Spec.LoadTests {
+		out = append(out, analysis.KindLoadTest+"/"+name)
+	}
+	return out
+}
+
+// gatesReferencing returns requests for the Gates in namespace with any of
+// the given index keys.
+func (r *GateReconciler) gatesReferencing(namespace string, keys ...string) []reconcile.Request {
+	seen := map[types.NamespacedName]bool{}
+	var reqs []reconcile.Request
+	for _, key := range keys {
+		var list syntheticv1.GateList
+		err := r.List(context.Background(), &list,
+			client.InNamespace(namespace),
+			client.MatchingField(gateReferencesIndex, key))
+		if err != nil {
+			r.Log.Error(err, "unable to list Gates", "reference", key)
+			return nil
+		}
+		for _, gate := range list.Items {
+			name := types.NamespacedName{Namespace: gate.Namespace, Name: gate.Name}
+			if !seen[name] {
+				seen[name] = true
+				reqs = append(reqs, reconcile.Request{NamespacedName: name})
+			}
+		}
+	}
+	return reqs
+}
+
+// gatesForRun maps a finished run of a Check to the Gates watching the check
+// or its Validations.
+func (r *GateReconciler) gatesForRun(obj handler.MapObject) []reconcile.Request {
+	check := obj.Meta.GetLabels()[syntheticv1.CheckLabel]
+	if check == "" {
+		return nil
+	}
+	keys := []string{analysis.KindCheck + "/" + check}
+	var validations syntheticv1.ValidationList
+	err := r.List(context.Background(), &validations,
+		client.InNamespace(obj.Meta.GetNamespace()),
+		client.MatchingField(validationCheckIndex, check))
+	if err != nil {
+		r.Log.Error(err, "unable to list Validations", "check", check)
+		return nil
+	}
+	for _, v := range validations.Items {
+		keys = append(keys, analysis.KindValidation+"/"+v.Name)
+	}
+	return r.gatesReferencing(obj.Meta.GetNamespace(), keys...)
+}
+
+func (r *GateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(&syntheticv1.Gate{}, gateReferencesIndex, func(obj runtime.Object) []string {
+		gate := obj.(*syntheticv1.Gate)
+		keys := append(gateObjects(gate), "deployment/"+gate.Spec.DeploymentRef)
+		sort.Strings(keys)
+		return keys
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&syntheticv1.Gate{}).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+				return r.gatesReferencing(obj.Meta.GetNamespace(), "deployment/"+obj.Meta.GetName())
+			})}).
+		Watches(&source.Kind{Type: &syntheticv1.SyntheticRun{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.gatesForRun)}).
+		Watches(&source.Kind{Type: &syntheticv1.LoadTest{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+				return r.gatesReferencing(obj.Meta.GetNamespace(), analysis.KindLoadTest+"/"+obj.Meta.GetName())
+			})}).
+		Complete(r)
+}
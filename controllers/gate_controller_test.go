@@ -0,0 +1,176 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/analysis"
+)
+
+func newGateReconciler(objs ...runtime.Object) *GateReconciler {
+	scheme := newScheme()
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	return &GateReconciler{
+		Client:    c,
+		Log:       zap.Logger(true),
+		Recorder:  record.NewFakeRecorder(10),
+		Evaluator: &analysis.Evaluator{Client: c, Scheme: scheme},
+	}
+}
+
+func newGate(resume bool) *syntheticv1.Gate {
+	return &syntheticv1.Gate{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: syntheticv1.GateSpec{
+			DeploymentRef: "web",
+			LoadTests:     []string{"checkout"},
+			Resume:        resume,
+		},
+	}
+}
+
+// gatedLoadTest returns the load test the gate watches, which failed or not.
+func gatedLoadTest(failed bool) *syntheticv1.LoadTest {
+	lt := &syntheticv1.LoadTest{
+		ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "default"},
+		Status:     syntheticv1.LoadTestStatus{LastRun: "checkout-1", Phase: syntheticv1.RunSucceeded},
+	}
+	if failed {
+		lt.Status.Phase = syntheticv1.RunFailed
+	}
+	return lt
+}
+
+// gatedDeployment returns the deployment the gate holds, in the middle of a
+// rollout or not.
+func gatedDeployment(rolling bool) *appsv1.Deployment {
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Generation: 2},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1},
+	}
+	if rolling {
+		dep.Status.UpdatedReplicas = 0
+	}
+	return dep
+}
+
+// reconcileGate reconciles the gate and returns it and its deployment as
+// they were left.
+func reconcileGate(g *GomegaWithT, r *GateReconciler) (*syntheticv1.Gate, *appsv1.Deployment) {
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+	var gate syntheticv1.Gate
+	g.Expect(r.Get(ctx, key, &gate)).To(Succeed())
+	var dep appsv1.Deployment
+	g.Expect(r.Get(ctx, key, &dep)).To(Succeed())
+	return &gate, &dep
+}
+
+func TestGatePausesAndResumes(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	r := newGateReconciler(newGate(true), gatedLoadTest(true), gatedDeployment(true))
+
+	gate, dep := reconcileGate(g, r)
+	g.Expect(gate.Status.Phase).To(Equal(syntheticv1.GateClosed))
+	g.Expect(gate.Status.Failing).To(Equal([]string{"loadtests/checkout"}))
+	g.Expect(gate.Status.Paused).To(BeTrue())
+	g.Expect(dep.Spec.Paused).To(BeTrue())
+	g.Expect(dep.Annotations).To(HaveKeyWithValue(syntheticv1.PausedByAnnotation, "web"))
+
+	lt := gatedLoadTest(false)
+	var current syntheticv1.LoadTest
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "checkout"}, &current)).To(Succeed())
+	current.Status = lt.Status
+	g.Expect(r.Status().Update(ctx, &current)).To(Succeed())
+
+	gate, dep = reconcileGate(g, r)
+	g.Expect(gate.Status.Phase).To(Equal(syntheticv1.GateOpen))
+	g.Expect(gate.Status.Failing).To(BeEmpty())
+	g.Expect(gate.Status.Paused).To(BeFalse())
+	g.Expect(dep.Spec.Paused).To(BeFalse())
+	g.Expect(dep.Annotations).NotTo(HaveKey(syntheticv1.PausedByAnnotation))
+}
+
+func TestGateKeepsPausedWithoutResume(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	r := newGateReconciler(newGate(false), gatedLoadTest(true), gatedDeployment(true))
+
+	_, dep := reconcileGate(g, r)
+	g.Expect(dep.Spec.Paused).To(BeTrue())
+
+	var current syntheticv1.LoadTest
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "checkout"}, &current)).To(Succeed())
+	current.Status = gatedLoadTest(false).Status
+	g.Expect(r.Status().Update(ctx, &current)).To(Succeed())
+
+	// Without Resume, the rollout stays paused for someone to resume it.
+	gate, dep := reconcileGate(g, r)
+	g.Expect(gate.Status.Phase).To(Equal(syntheticv1.GateOpen))
+	g.Expect(gate.Status.Paused).To(BeTrue())
+	g.Expect(dep.Spec.Paused).To(BeTrue())
+}
+
+func TestGateLeavesDeploymentPausedByHand(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	dep := gatedDeployment(true)
+	dep.Spec.Paused = true
+	r := newGateReconciler(newGate(true), gatedLoadTest(true), dep)
+
+	gate, dep := reconcileGate(g, r)
+	g.Expect(gate.Status.Phase).To(Equal(syntheticv1.GateClosed))
+	g.Expect(gate.Status.Paused).To(BeFalse())
+	g.Expect(dep.Annotations).NotTo(HaveKey(syntheticv1.PausedByAnnotation))
+
+	// The gate only resumes rollouts it paused itself.
+	var current syntheticv1.LoadTest
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "checkout"}, &current)).To(Succeed())
+	current.Status = gatedLoadTest(false).Status
+	g.Expect(r.Status().Update(ctx, &current)).To(Succeed())
+
+	gate, dep = reconcileGate(g, r)
+	g.Expect(gate.Status.Phase).To(Equal(syntheticv1.GateOpen))
+	g.Expect(gate.Status.Paused).To(BeFalse())
+	g.Expect(dep.Spec.Paused).To(BeTrue())
+}
+
+func TestGateIgnoresDeploymentNotRollingOut(t *testing.T) {
+	g := NewGomegaWithT(t)
+	r := newGateReconciler(newGate(true), gatedLoadTest(true), gatedDeployment(false))
+
+	gate, dep := reconcileGate(g, r)
+	g.Expect(gate.Status.Phase).To(Equal(syntheticv1.GateClosed))
+	g.Expect(gate.Status.Paused).To(BeFalse())
+	g.Expect(dep.Spec.Paused).To(BeFalse())
+	g.Expect(r.Recorder.(*record.FakeRecorder).Events).To(BeEmpty())
+}
@@ -0,0 +1,252 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/load"
+)
+
+// LoadTestReconciler starts the SyntheticRuns of the workers of every
+// generation of a LoadTest and reports their combined summary.
+type LoadTestReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// Location is the probe location this manager runs load tests from.
+	Location string
+}
+
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=loadtests,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=loadtests/status,verbs=get;update;patch
+
+func (r *LoadTestReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("loadtest", req.NamespacedName)
+
+	var lt syntheticv1.LoadTest
+	if err := r.Get(ctx, req.NamespacedName, &lt); err != nil {
+		if apierrors.IsNotFound(err) {
+			runs.forgetObject("loadtest", req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch LoadTest")
+		return ctrl.Result{}, err
+	}
+	if lt.Spec.Location != "" && lt.Spec.Location != r.Location {
+		return ctrl.Result{}, nil
+	}
+
+	// Each generation of the spec is run once. Editing the load test starts
+	// a new run.
+	name := fmt.Sprintf("%s-%d", lt.Name, lt.Generation)
+	workers := lt.Spec.WorkersOrDefault()
+	if lt.Status.LastRun != name {
+		for i := int32(0); i < workers; i++ {
+			run, err := r.newRun(&lt, name, i, workers)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.Create(ctx, run); err != nil && !apierrors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create SyntheticRun")
+				return ctrl.Result{}, err
+			}
+		}
+		log.Info("started load test", "syntheticrun", name, "workers", workers)
+
+		lt.Status.LastRun = name
+		lt.Status.Phase = syntheticv1.RunPending
+		lt.Status.Summary = nil
+		lt.Status.Comparison = nil
+		if err := r.Status().Update(ctx, &lt); err != nil {
+			log.Error(err, "unable to update LoadTest status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	runs := make([]syntheticv1.SyntheticRun, workers)
+	for i := range runs {
+		key := types.NamespacedName{Namespace: lt.Namespace, Name: syntheticv1.WorkerRunName(name, int32(i), workers)}
+		if err := r.Get(ctx, key, &runs[i]); err != nil {
+			if apierrors.IsNotFound(err) {
+				return ctrl.Result{}, nil
+			}
+			log.Error(err, "unable to fetch SyntheticRun")
+			return ctrl.Result{}, err
+		}
+	}
+	phase, summary := aggregateRuns(runs)
+	if phase == "" || phase == lt.Status.Phase {
+		return ctrl.Result{}, nil
+	}
+	lt.Status.Phase = phase
+	lt.Status.Summary = summary
+	if summary != nil {
+		if b := lt.Spec.Baseline; b != nil {
+			comparison, err := r.compare(ctx, &lt, summary, b)
+			if err != nil {
+				log.Error(err, "unable to fetch baseline")
+				return ctrl.Result{}, err
+			}
+			lt.Status.Comparison = comparison
+			if comparison.Regression {
+				log.Info("load test regressed", "baseline", comparison.Baseline, "message", comparison.Message)
+			}
+		}
+		if lt.Spec.Snapshot != "" && phase == syntheticv1.RunSucceeded {
+			saveSnapshot(&lt.Status, syntheticv1.LoadSnapshot{
+				Name:    lt.Spec.Snapshot,
+				Run:     name,
+				Time:    metav1.Now(),
+				Summary: *summary,
+			})
+		}
+	}
+	if err := r.Status().Update(ctx, &lt); err != nil {
+		log.Error(err, "unable to update LoadTest status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// compare compares summary with the baseline b of lt. A baseline that cannot
+// be found is reported in the comparison rather than as an error.
+func (r *LoadTestReconciler) compare(ctx context.Context, lt *syntheticv1.LoadTest, summary *syntheticv1.LoadSummary, b *syntheticv1.Baseline) (*syntheticv1.Comparison, error) {
+	switch {
+	case b.RunRef != "" && b.Snapshot != "":
+		return &syntheticv1.Comparison{Message: "only one of runRef and snapshot may be set"}, nil
+	case b.Snapshot != "":
+		desc := "snapshot " + b.Snapshot
+		for i := range lt.Status.Snapshots {
+			if s := &lt.Status.Snapshots[i]; s.Name == b.Snapshot {
+				return load.Compare(summary, &s.Summary, desc, b), nil
+			}
+		}
+		return &syntheticv1.Comparison{Baseline: desc, Message: fmt.Sprintf("snapshot %q not found", b.Snapshot)}, nil
+	case b.RunRef != "":
+		desc := "run " + b.RunRef
+		var runs syntheticv1.SyntheticRunList
+		if err := r.List(ctx, &runs, client.InNamespace(lt.Namespace),
+			client.MatchingLabels(map[string]string{syntheticv1.LoadTestLabel: lt.Name})); err != nil {
+			return nil, err
+		}
+		var workers []syntheticv1.SyntheticRun
+		for _, run := range runs.Items {
+			if run.IsWorkerOf(b.RunRef) {
+				workers = append(workers, run)
+			}
+		}
+		phase, base := aggregateRuns(workers)
+		if len(workers) == 0 || base == nil {
+			return &syntheticv1.Comparison{Baseline: desc, Message: fmt.Sprintf("run %q has no results", b.RunRef)}, nil
+		}
+		if phase != syntheticv1.RunSucceeded {
+			return &syntheticv1.Comparison{Baseline: desc, Message: fmt.Sprintf("run %q did not succeed", b.RunRef)}, nil
+		}
+		return load.Compare(summary, base, desc, b), nil
+	}
+	return &syntheticv1.Comparison{Message: "one of runRef and snapshot must be set"}, nil
+}
+
+// saveSnapshot adds snapshot to status, replacing the snapshot of the same
+// name.
+func saveSnapshot(status *syntheticv1.LoadTestStatus, snapshot syntheticv1.LoadSnapshot) {
+	for i := range status.Snapshots {
+		if status.Snapshots[i].Name == snapshot.Name {
+			status.Snapshots[i] = snapshot
+			return
+		}
+	}
+	status.Snapshots = append(status.Snapshots, snapshot)
+}
+
+// aggregateRuns returns the phase of a load test from the phases of the runs
+// of its workers, and its summary once they have all finished.
+func aggregateRuns(runs []syntheticv1.SyntheticRun) (syntheticv1.SyntheticRunPhase, *syntheticv1.LoadSummary) {
+	var phase syntheticv1.SyntheticRunPhase
+	finished := 0
+	failed := false
+	var summaries []*syntheticv1.LoadSummary
+	for i := range runs {
+		switch runs[i].Status.Phase {
+		case syntheticv1.RunRunning:
+			phase = syntheticv1.RunRunning
+		case syntheticv1.RunSucceeded, syntheticv1.RunFailed:
+			finished++
+			failed = failed || runs[i].Status.Phase == syntheticv1.RunFailed
+			if runs[i].Status.LoadTest != nil {
+				summaries = append(summaries, runs[i].Status.LoadTest)
+			}
+		}
+	}
+	if finished < len(runs) {
+		if phase == "" && finished > 0 {
+			phase = syntheticv1.RunRunning
+		}
+		return phase, nil
+	}
+	phase = syntheticv1.RunSucceeded
+	if failed {
+		phase = syntheticv1.RunFailed
+	}
+	return phase, load.Merge(summaries)
+}
+
+// newRun returns the SyntheticRun that executes the share of lt of worker
+// index out of workers.
+func (r *LoadTestReconciler) newRun(lt *syntheticv1.LoadTest, name string, index, workers int32) (*syntheticv1.SyntheticRun, error) {
+	run := &syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      syntheticv1.WorkerRunName(name, index, workers),
+			Namespace: lt.Namespace,
+			Labels: map[string]string{
+				syntheticv1.LoadTestLabel: lt.Name,
+				syntheticv1.LocationLabel: r.Location,
+			},
+		},
+		Spec: syntheticv1.SyntheticRunSpec{
+			LoadTestRef: lt.Name,
+			Location:    r.Location,
+		},
+	}
+	if workers > 1 {
+		run.Spec.Partition = &syntheticv1.Partition{Index: index, Count: workers}
+	}
+	if err := ctrl.SetControllerReference(lt, run, r.Scheme); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *LoadTestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&syntheticv1.LoadTest{}).
+		Owns(&syntheticv1.SyntheticRun{}).
+		Complete(r)
+}
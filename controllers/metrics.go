@@ -0,0 +1,427 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+var (
+	checkLabels    = []string{"check", "namespace", "location"}
+	loadTestLabels = []string{"loadtest", "namespace", "location"}
+
+	checkUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_check_up",
+		Help: "Whether the most recent run of a check succeeded (1) or failed (0). Absent while the most recent run was silenced or failed on a dependency.",
+	}, checkLabels)
+
+	checkRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "perph_check_runs_total",
+		Help: "Number of finished check runs by result. Runs inside a maintenance window count as silenced, and failed runs of a check whose dependency was failing as dependency_failed.",
+	}, append(checkLabels, "result"))
+
+	checkSilenced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_check_silenced",
+		Help: "Whether the most recent run of a check fell inside a maintenance window (1) or not (0).",
+	}, checkLabels)
+
+	checkDependencyFailed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_check_dependency_failed",
+		Help: "Whether the most recent run of a check failed while a check it depends on was failing (1) or not (0).",
+	}, checkLabels)
+
+	probePhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "perph_probe_phase_duration_seconds",
+		Help:    "Time spent in each phase of a probe request.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, append(checkLabels, "phase"))
+
+	probeInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_probe_connection_info",
+		Help: "Connection details of the most recent run of a check. Always 1.",
+	}, append(checkLabels, "remote_addr", "protocol", "tls_version", "cipher_suite"))
+
+	assertionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "perph_check_assertion_failures_total",
+		Help: "Number of failed assertions by assertion name.",
+	}, append(checkLabels, "assertion"))
+
+	queueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "perph_queue_latency_seconds",
+		Help:    "Time taken by the canary of a queue probe to be accepted by the broker (publish) and to reach the output topic (end_to_end).",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 18),
+	}, append(checkLabels, "stage"))
+
+	loadTestRPS = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_loadtest_requests_per_second",
+		Help: "Average throughput of the most recent load test run.",
+	}, loadTestLabels)
+
+	loadTestRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_loadtest_requests",
+		Help: "Requests issued by the most recent load test run by result.",
+	}, append(loadTestLabels, "result"))
+
+	loadTestLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_loadtest_latency_seconds",
+		Help: "Request latency percentiles of the most recent load test run.",
+	}, append(loadTestLabels, "quantile"))
+
+	loadTestConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_loadtest_connections",
+		Help: "Connections opened by each scenario of the most recent load test run.",
+	}, append(loadTestLabels, "scenario"))
+
+	loadTestMessageRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_loadtest_messages_per_second",
+		Help: "Average rate of gRPC, WebSocket and queue messages exchanged by each scenario of the most recent load test run.",
+	}, append(loadTestLabels, "scenario"))
+
+	loadTestStreamErrors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "perph_loadtest_stream_errors",
+		Help: "gRPC streams and WebSocket sessions that broke off in each scenario of the most recent load test run.",
+	}, append(loadTestLabels, "scenario"))
+
+	exportLag = &exportLagCollector{
+		desc: prometheus.NewDesc(
+			"perph_export_lag_seconds",
+			"Age of the newest SyntheticRun an export task has shipped.",
+			[]string{"exporttask", "namespace"}, nil),
+		watermarks: map[types.NamespacedName]time.Time{},
+	}
+
+	runs = &runRecorder{
+		observed: map[types.NamespacedName]types.UID{},
+		latest:   map[string]time.Time{},
+		info:     map[string]prometheus.Labels{},
+		series:   map[string]map[string]series{},
+	}
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		checkUp,
+		checkRuns,
+		checkSilenced,
+		checkDependencyFailed,
+		probePhaseDuration,
+		probeInfo,
+		assertionFailures,
+		queueLatency,
+		loadTestRPS,
+		loadTestRequests,
+		loadTestLatency,
+		loadTestConnections,
+		loadTestMessageRate,
+		loadTestStreamErrors,
+		exportLag,
+	)
+}
+
+// runRecorder turns finished SyntheticRuns into metrics. Each run is
+// recorded once per process, which matches the lifetime of the counters it
+// feeds, and gauges only move forward in time so that runs reconciled out of
+// order after a restart do not overwrite newer results. The series of a
+// Check or LoadTest are removed when it is deleted.
+type runRecorder struct {
+	mu       sync.Mutex
+	observed map[types.NamespacedName]types.UID
+	latest   map[string]time.Time
+	info     map[string]prometheus.Labels
+
+	// series holds the series recorded for each Check and LoadTest, by
+	// object key, so that they can be deleted along with it.
+	series map[string]map[string]series
+}
+
+// vector is a metric vector whose series can be deleted.
+type vector interface {
+	Delete(prometheus.Labels) bool
+}
+
+// series is a series of a vector.
+type series struct {
+	vec    vector
+	labels prometheus.Labels
+}
+
+// objectKey returns the key of the Check or LoadTest of the given kind.
+func objectKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// track records that vec has a series for labels, which must hold the kind
+// label of the object, and returns labels. The caller must hold r.mu.
+func (r *runRecorder) track(kind string, vec vector, labels prometheus.Labels) prometheus.Labels {
+	object := objectKey(kind, labels["namespace"], labels[kind])
+	if r.series[object] == nil {
+		r.series[object] = map[string]series{}
+	}
+	// Maps are printed sorted by key.
+	r.series[object][fmt.Sprintf("%p %v", vec, labels)] = series{vec: vec, labels: labels}
+	return labels
+}
+
+// observe records the metrics of a finished run.
+func (r *runRecorder) observe(run *syntheticv1.SyntheticRun) {
+	key := types.NamespacedName{Namespace: run.Namespace, Name: run.Name}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.observed[key] == run.UID {
+		return
+	}
+	r.observed[key] = run.UID
+
+	var finished time.Time
+	if run.Status.CompletionTime != nil {
+		finished = run.Status.CompletionTime.Time
+	}
+
+	switch {
+	case run.Spec.CheckRef != "":
+		r.observeCheck(run, finished)
+	case run.Spec.LoadTestRef != "":
+		r.observeLoadTest(run, finished)
+	}
+}
+
+func (r *runRecorder) observeCheck(run *syntheticv1.SyntheticRun, finished time.Time) {
+	labels := prometheus.Labels{
+		"check":     run.Spec.CheckRef,
+		"namespace": run.Namespace,
+		"location":  run.Spec.Location,
+	}
+	succeeded := run.Status.Phase == syntheticv1.RunSucceeded
+
+	// Silenced runs and runs that failed on a dependency are counted apart
+	// and kept out of the series that alerts and SLOs are built on, so that
+	// only the root failing check alerts.
+	suppressed := ""
+	switch {
+	case run.Status.SilencedBy != "":
+		suppressed = "silenced"
+	case run.Status.Phase == syntheticv1.RunDependencyFailed:
+		suppressed = "dependency_failed"
+	}
+	if suppressed != "" {
+		checkRuns.With(r.track("check", checkRuns, withLabel(labels, "result", suppressed))).Inc()
+		if r.advance("check", labels, finished) {
+			// The check is neither up nor down while its runs are suppressed.
+			checkUp.Delete(labels)
+			checkSilenced.With(r.track("check", checkSilenced, labels)).Set(boolValue(suppressed == "silenced"))
+			checkDependencyFailed.With(r.track("check", checkDependencyFailed, labels)).Set(boolValue(suppressed == "dependency_failed"))
+			r.setInfo(labels, run.Status.Connection)
+		}
+		return
+	}
+
+	result := "failure"
+	if succeeded {
+		result = "success"
+	}
+	checkRuns.With(r.track("check", checkRuns, withLabel(labels, "result", result))).Inc()
+
+	if t := run.Status.Timings; t != nil {
+		for phase, d := range map[string]time.Duration{
+			"dns":        t.DNS.Duration,
+			"connect":    t.Connect.Duration,
+			"tls":        t.TLS.Duration,
+			"first_byte": t.FirstByte.Duration,
+			"transfer":   t.Transfer.Duration,
+			"total":      t.Total.Duration,
+		} {
+			probePhaseDuration.With(r.track("check", probePhaseDuration, withLabel(labels, "phase", phase))).Observe(d.Seconds())
+		}
+	}
+
+	if q := run.Status.Queue; q != nil {
+		queueLatency.With(r.track("check", queueLatency, withLabel(labels, "stage", "publish"))).Observe(q.Publish.Seconds())
+		queueLatency.With(r.track("check", queueLatency, withLabel(labels, "stage", "end_to_end"))).Observe(q.EndToEnd.Seconds())
+	}
+
+	for _, a := range run.Status.Assertions {
+		if !a.Passed {
+			assertionFailures.With(r.track("check", assertionFailures, withLabel(labels, "assertion", a.Name))).Inc()
+		}
+	}
+
+	if r.advance("check", labels, finished) {
+		checkUp.With(r.track("check", checkUp, labels)).Set(boolValue(succeeded))
+		checkSilenced.With(r.track("check", checkSilenced, labels)).Set(0)
+		checkDependencyFailed.With(r.track("check", checkDependencyFailed, labels)).Set(0)
+		r.setInfo(labels, run.Status.Connection)
+	}
+}
+
+// setInfo replaces the connection info series of a check with the details of
+// its most recent run. The caller must hold r.mu.
+func (r *runRecorder) setInfo(labels prometheus.Labels, conn *syntheticv1.ConnectionInfo) {
+	key := objectKey("check", labels["namespace"], labels["check"]) + "/" + labels["location"]
+	if old, ok := r.info[key]; ok {
+		probeInfo.Delete(old)
+		delete(r.info, key)
+	}
+	if conn == nil {
+		return
+	}
+	info := withLabel(labels, "remote_addr", conn.RemoteAddr)
+	info["protocol"] = conn.Protocol
+	info["tls_version"] = conn.TLSVersion
+	info["cipher_suite"] = conn.CipherSuite
+	probeInfo.With(info).Set(1)
+	r.info[key] = info
+}
+
+func (r *runRecorder) observeLoadTest(run *syntheticv1.SyntheticRun, finished time.Time) {
+	s := run.Status.LoadTest
+	if s == nil {
+		return
+	}
+	labels := prometheus.Labels{
+		"loadtest":  run.Spec.LoadTestRef,
+		"namespace": run.Namespace,
+		"location":  run.Spec.Location,
+	}
+	if !r.advance("loadtest", labels, finished) {
+		return
+	}
+
+	loadTestRPS.With(r.track("loadtest", loadTestRPS, labels)).Set(s.RequestsPerSecond())
+	loadTestRequests.With(r.track("loadtest", loadTestRequests, withLabel(labels, "result", "success"))).Set(float64(s.Requests - s.Failures))
+	loadTestRequests.With(r.track("loadtest", loadTestRequests, withLabel(labels, "result", "failure"))).Set(float64(s.Failures))
+	for quantile, d := range map[string]time.Duration{
+		"0.5":  s.Latency.P50.Duration,
+		"0.9":  s.Latency.P90.Duration,
+		"0.95": s.Latency.P95.Duration,
+		"0.99": s.Latency.P99.Duration,
+		"1":    s.Latency.Max.Duration,
+	} {
+		loadTestLatency.With(r.track("loadtest", loadTestLatency, withLabel(labels, "quantile", quantile))).Set(d.Seconds())
+	}
+	for i := range s.Scenarios {
+		sc := &s.Scenarios[i]
+		scenario := withLabel(labels, "scenario", sc.Name)
+		loadTestConnections.With(r.track("loadtest", loadTestConnections, scenario)).Set(float64(sc.Connections))
+		loadTestMessageRate.With(r.track("loadtest", loadTestMessageRate, scenario)).Set(sc.MessagesPerSecond(s.Duration))
+		loadTestStreamErrors.With(r.track("loadtest", loadTestStreamErrors, scenario)).Set(float64(sc.StreamErrors))
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// advance reports whether finished is at least as new as the last run
+// reflected in the gauges of the series, and records it if so. The caller
+// must hold r.mu.
+func (r *runRecorder) advance(kind string, labels prometheus.Labels, finished time.Time) bool {
+	key := objectKey(kind, labels["namespace"], labels[kind]) + "/" + labels["location"]
+	if finished.Before(r.latest[key]) {
+		return false
+	}
+	r.latest[key] = finished
+	return true
+}
+
+// forget drops the bookkeeping for a deleted run.
+func (r *runRecorder) forget(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.observed, key)
+}
+
+// forgetObject deletes the series of a deleted Check or LoadTest, in every
+// location, and the bookkeeping that goes with them.
+func (r *runRecorder) forgetObject(kind string, key types.NamespacedName) {
+	object := objectKey(kind, key.Namespace, key.Name)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.series[object] {
+		s.vec.Delete(s.labels)
+	}
+	delete(r.series, object)
+	for k := range r.latest {
+		if strings.HasPrefix(k, object+"/") {
+			delete(r.latest, k)
+		}
+	}
+	for k, info := range r.info {
+		if strings.HasPrefix(k, object+"/") {
+			probeInfo.Delete(info)
+			delete(r.info, k)
+		}
+	}
+}
+
+// exportLagCollector reports export lag computed at scrape time, so the
+// value keeps growing while an export task is stalled.
+type exportLagCollector struct {
+	desc *prometheus.Desc
+
+	mu         sync.Mutex
+	watermarks map[types.NamespacedName]time.Time
+}
+
+func (c *exportLagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *exportLagCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, watermark := range c.watermarks {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue,
+			now.Sub(watermark).Seconds(), key.Name, key.Namespace)
+	}
+}
+
+// set records the newest exported run of an export task.
+func (c *exportLagCollector) set(key types.NamespacedName, watermark time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watermarks[key] = watermark
+}
+
+// forget stops reporting lag for a deleted export task.
+func (c *exportLagCollector) forget(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.watermarks, key)
+}
+
+func withLabel(labels prometheus.Labels, name, value string) prometheus.Labels {
+	out := make(prometheus.Labels, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[name] = value
+	return out
+}
@@ -0,0 +1,201 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// countSeries returns how many series of c have the given label value.
+func countSeries(c prometheus.Collector, name, value string) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	n := 0
+	for m := range ch {
+		var out dto.Metric
+		if err := m.Write(&out); err != nil {
+			panic(err)
+		}
+		for _, l := range out.Label {
+			if l.GetName() == name && l.GetValue() == value {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func newRecorder() *runRecorder {
+	return &runRecorder{
+		observed: map[types.NamespacedName]types.UID{},
+		latest:   map[string]time.Time{},
+		info:     map[string]prometheus.Labels{},
+		series:   map[string]map[string]series{},
+	}
+}
+
+func checkRun(name, check string, phase syntheticv1.SyntheticRunPhase, finished time.Time) *syntheticv1.SyntheticRun {
+	return &syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "metrics", UID: types.UID(name)},
+		Spec:       syntheticv1.SyntheticRunSpec{CheckRef: check, Location: "eu"},
+		Status: syntheticv1.SyntheticRunStatus{
+			Phase:          phase,
+			CompletionTime: &metav1.Time{Time: finished},
+			Timings:        &syntheticv1.PhaseTimings{Total: metav1.Duration{Duration: time.Second}},
+			Connection:     &syntheticv1.ConnectionInfo{RemoteAddr: "10.0.0.1:443", Protocol: "HTTP/2.0"},
+			Assertions:     []syntheticv1.AssertionResult{{Name: "status", Passed: phase == syntheticv1.RunSucceeded}},
+		},
+	}
+}
+
+func TestRunRecorderCheck(t *testing.T) {
+	g := NewGomegaWithT(t)
+	r := newRecorder()
+	now := time.Now()
+	labels := prometheus.Labels{"check": "observed", "namespace": "metrics", "location": "eu"}
+
+	r.observe(checkRun("observed-1", "observed", syntheticv1.RunFailed, now))
+	g.Expect(testutil.ToFloat64(checkUp.With(labels))).To(Equal(0.0))
+	g.Expect(testutil.ToFloat64(checkRuns.With(withLabel(labels, "result", "failure")))).To(Equal(1.0))
+	g.Expect(testutil.ToFloat64(assertionFailures.With(withLabel(labels, "assertion", "status")))).To(Equal(1.0))
+	g.Expect(countSeries(probeInfo, "check", "observed")).To(Equal(1))
+
+	// A run is recorded once.
+	r.observe(checkRun("observed-1", "observed", syntheticv1.RunFailed, now))
+	g.Expect(testutil.ToFloat64(checkRuns.With(withLabel(labels, "result", "failure")))).To(Equal(1.0))
+
+	r.observe(checkRun("observed-2", "observed", syntheticv1.RunSucceeded, now.Add(time.Minute)))
+	g.Expect(testutil.ToFloat64(checkUp.With(labels))).To(Equal(1.0))
+
+	// Older runs do not overwrite the gauges.
+	r.observe(checkRun("observed-0", "observed", syntheticv1.RunFailed, now.Add(-time.Minute)))
+	g.Expect(testutil.ToFloat64(checkUp.With(labels))).To(Equal(1.0))
+	g.Expect(testutil.ToFloat64(checkRuns.With(withLabel(labels, "result", "failure")))).To(Equal(2.0))
+
+	// Suppressed runs clear the up gauge rather than leave it stale.
+	silenced := checkRun("observed-3", "observed", syntheticv1.RunFailed, now.Add(2*time.Minute))
+	silenced.Status.SilencedBy = "upgrade"
+	r.observe(silenced)
+	g.Expect(countSeries(checkUp, "check", "observed")).To(Equal(0))
+	g.Expect(testutil.ToFloat64(checkSilenced.With(labels))).To(Equal(1.0))
+
+	r.observe(checkRun("observed-4", "observed", syntheticv1.RunDependencyFailed, now.Add(3*time.Minute)))
+	g.Expect(countSeries(checkUp, "check", "observed")).To(Equal(0))
+	g.Expect(testutil.ToFloat64(checkSilenced.With(labels))).To(Equal(0.0))
+	g.Expect(testutil.ToFloat64(checkDependencyFailed.With(labels))).To(Equal(1.0))
+
+	r.observe(checkRun("observed-5", "observed", syntheticv1.RunSucceeded, now.Add(4*time.Minute)))
+	g.Expect(testutil.ToFloat64(checkUp.With(labels))).To(Equal(1.0))
+	g.Expect(testutil.ToFloat64(checkDependencyFailed.With(labels))).To(Equal(0.0))
+
+	r.forget(types.NamespacedName{Namespace: "metrics", Name: "observed-5"})
+	g.Expect(r.observed).NotTo(HaveKey(types.NamespacedName{Namespace: "metrics", Name: "observed-5"}))
+}
+
+func TestRunRecorderForgetObject(t *testing.T) {
+	g := NewGomegaWithT(t)
+	r := newRecorder()
+	now := time.Now()
+
+	r.observe(checkRun("deleted-1", "deleted", syntheticv1.RunFailed, now))
+	r.observe(checkRun("kept-1", "kept", syntheticv1.RunSucceeded, now))
+	run := checkRun("deleted-2", "deleted", syntheticv1.RunSucceeded, now.Add(time.Minute))
+	run.Spec.Location = "us"
+	run.Status.Queue = &syntheticv1.QueueResult{Publish: metav1.Duration{Duration: time.Millisecond}, EndToEnd: metav1.Duration{Duration: time.Second}}
+	r.observe(run)
+
+	r.forgetObject("check", types.NamespacedName{Namespace: "metrics", Name: "deleted"})
+	for _, c := range []prometheus.Collector{
+		checkUp, checkRuns, checkSilenced, checkDependencyFailed, probePhaseDuration,
+		probeInfo, assertionFailures, queueLatency,
+	} {
+		g.Expect(countSeries(c, "check", "deleted")).To(Equal(0))
+	}
+	g.Expect(countSeries(checkUp, "check", "kept")).To(Equal(1))
+	g.Expect(countSeries(probeInfo, "check", "kept")).To(Equal(1))
+	g.Expect(r.latest).To(HaveLen(1))
+	g.Expect(r.info).To(HaveLen(1))
+
+	// A check recreated under the same name starts afresh.
+	r.observe(checkRun("deleted-3", "deleted", syntheticv1.RunFailed, now))
+	g.Expect(testutil.ToFloat64(checkUp.With(prometheus.Labels{"check": "deleted", "namespace": "metrics", "location": "eu"}))).To(Equal(0.0))
+}
+
+func TestRunRecorderLoadTest(t *testing.T) {
+	g := NewGomegaWithT(t)
+	r := newRecorder()
+	labels := prometheus.Labels{"loadtest": "checkout", "namespace": "metrics", "location": "eu"}
+
+	r.observe(&syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "checkout-1", Namespace: "metrics", UID: "checkout-1"},
+		Spec:       syntheticv1.SyntheticRunSpec{LoadTestRef: "checkout", Location: "eu"},
+		Status: syntheticv1.SyntheticRunStatus{
+			Phase:          syntheticv1.RunSucceeded,
+			CompletionTime: &metav1.Time{Time: time.Now()},
+			LoadTest: &syntheticv1.LoadSummary{
+				Requests:  100,
+				Failures:  10,
+				Duration:  metav1.Duration{Duration: 10 * time.Second},
+				Scenarios: []syntheticv1.ScenarioSummary{{Name: "browse", Connections: 4}},
+			},
+		},
+	})
+	g.Expect(testutil.ToFloat64(loadTestRPS.With(labels))).To(Equal(10.0))
+	g.Expect(testutil.ToFloat64(loadTestRequests.With(withLabel(labels, "result", "failure")))).To(Equal(10.0))
+	g.Expect(testutil.ToFloat64(loadTestConnections.With(withLabel(labels, "scenario", "browse")))).To(Equal(4.0))
+
+	r.forgetObject("loadtest", types.NamespacedName{Namespace: "metrics", Name: "checkout"})
+	for _, c := range []prometheus.Collector{
+		loadTestRPS, loadTestRequests, loadTestLatency, loadTestConnections,
+		loadTestMessageRate, loadTestStreamErrors,
+	} {
+		g.Expect(countSeries(c, "loadtest", "checkout")).To(Equal(0))
+	}
+	g.Expect(r.latest).To(BeEmpty())
+}
+
+func TestExportLagCollector(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := &exportLagCollector{
+		desc:       prometheus.NewDesc("perph_export_lag_seconds", "", []string{"exporttask", "namespace"}, nil),
+		watermarks: map[types.NamespacedName]time.Time{},
+	}
+	key := types.NamespacedName{Namespace: "metrics", Name: "warehouse"}
+
+	c.set(key, time.Now().Add(-time.Minute))
+	lag := testutil.ToFloat64(c)
+	g.Expect(lag).To(BeNumerically(">=", 60))
+
+	// The lag keeps growing while nothing is exported.
+	time.Sleep(10 * time.Millisecond)
+	g.Expect(testutil.ToFloat64(c)).To(BeNumerically(">", lag))
+
+	c.forget(key)
+	g.Expect(countSeries(c, "exporttask", "warehouse")).To(Equal(0))
+}
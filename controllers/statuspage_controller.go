@@ -0,0 +1,199 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/query"
+	"github.com/perph/perph/pkg/statuspage"
+)
+
+// StatusPageReconciler tracks the state and incidents of the components of
+// StatusPages, and writes the rendered pages to ConfigMaps.
+type StatusPageReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	History *query.History
+}
+
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=statuspages,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=statuspages/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update
+
+func (r *StatusPageReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("statuspage", req.NamespacedName)
+
+	var page syntheticv1.StatusPage
+	if err := r.Get(ctx, req.NamespacedName, &page); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch StatusPage")
+		return ctrl.Result{}, err
+	}
+
+	var checks syntheticv1.CheckList
+	if err := r.List(ctx, &checks, client.InNamespace(page.Namespace)); err != nil {
+		log.Error(err, "unable to list Checks")
+		return ctrl.Result{}, err
+	}
+	var runs syntheticv1.SyntheticRunList
+	if err := r.List(ctx, &runs, client.InNamespace(page.Namespace)); err != nil {
+		log.Error(err, "unable to list SyntheticRuns")
+		return ctrl.Result{}, err
+	}
+	components, err := statuspage.Evaluate(&page, checks.Items, runs.Items)
+	if err != nil {
+		log.Error(err, "unable to evaluate components")
+		return ctrl.Result{}, err
+	}
+
+	// Refresh the page when a component changes state, the spec changes, the
+	// ConfigMap is missing, or the interval is up. Refreshing at any other
+	// time would loop on the updates of the page and its ConfigMap.
+	now := time.Now()
+	interval := page.IntervalOrDefault()
+	due := !equality.Semantic.DeepEqual(components, page.Status.Components) ||
+		page.Status.ObservedGeneration != page.Generation ||
+		page.Status.LastUpdate == nil ||
+		!now.Before(page.Status.LastUpdate.Add(interval))
+	if page.Spec.ConfigMap != "" && !due {
+		var cm corev1.ConfigMap
+		err := r.Get(ctx, types.NamespacedName{Namespace: page.Namespace, Name: page.Spec.ConfigMap}, &cm)
+		switch {
+		case apierrors.IsNotFound(err):
+			due = true
+		case err != nil:
+			log.Error(err, "unable to fetch ConfigMap")
+			return ctrl.Result{}, err
+		}
+	}
+	if !due {
+		return ctrl.Result{RequeueAfter: page.Status.LastUpdate.Add(interval).Sub(now)}, nil
+	}
+
+	page.Status.Components = components
+	statuspage.UpdateIncidents(&page.Status, now, page.DaysOrDefault())
+	at := metav1.NewTime(now)
+	page.Status.LastUpdate = &at
+	page.Status.ObservedGeneration = page.Generation
+
+	page.Status.Message = ""
+	if page.Spec.ConfigMap != "" {
+		msg, err := r.publish(ctx, &page, checks.Items, now)
+		if err != nil {
+			log.Error(err, "unable to write status page to ConfigMap", "configMap", page.Spec.ConfigMap)
+			return ctrl.Result{}, err
+		}
+		page.Status.Message = msg
+	}
+	if err := r.Status().Update(ctx, &page); err != nil {
+		log.Error(err, "unable to update StatusPage status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// publish renders page at now into its ConfigMap, creating the ConfigMap
+// owned by the page when it does not exist. A ConfigMap the page does not
+// own is left alone, and the returned message says why.
+func (r *StatusPageReconciler) publish(ctx context.Context, page *syntheticv1.StatusPage, checks []syntheticv1.Check, now time.Time) (string, error) {
+	var cm corev1.ConfigMap
+	err := r.Get(ctx, types.NamespacedName{Namespace: page.Namespace, Name: page.Spec.ConfigMap}, &cm)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	create := err != nil
+	if create {
+		cm = corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: page.Namespace, Name: page.Spec.ConfigMap}}
+		if err := ctrl.SetControllerReference(page, &cm, r.Scheme); err != nil {
+			return "", err
+		}
+	} else if !metav1.IsControlledBy(&cm, page) {
+		return fmt.Sprintf("ConfigMap %q exists and is not owned by the page", cm.Name), nil
+	}
+
+	p, err := statuspage.Build(ctx, r.History, page, checks, now)
+	if err != nil {
+		return "", err
+	}
+	html, err := statuspage.HTML(p)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	cm.Data = map[string]string{
+		"index.html":  string(html),
+		"status.json": string(data),
+	}
+	if create {
+		return "", r.Create(ctx, &cm)
+	}
+	return "", r.Update(ctx, &cm)
+}
+
+// pagesIn maps a Check, or a run of one, to the StatusPages in its
+// namespace.
+func (r *StatusPageReconciler) pagesIn(obj handler.MapObject) []reconcile.Request {
+	if _, ok := obj.Object.(*syntheticv1.SyntheticRun); ok && obj.Meta.GetLabels()[syntheticv1.CheckLabel] == "" {
+		return nil
+	}
+	var list syntheticv1.StatusPageList
+	if err := r.List(context.Background(), &list, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list StatusPages")
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, page := range list.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: page.Namespace, Name: page.Name}})
+	}
+	return reqs
+}
+
+func (r *StatusPageReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&syntheticv1.StatusPage{}).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&source.Kind{Type: &syntheticv1.SyntheticRun{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.pagesIn)}).
+		Watches(&source.Kind{Type: &syntheticv1.Check{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.pagesIn)}).
+		Complete(r)
+}
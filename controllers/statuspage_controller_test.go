@@ -0,0 +1,82 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/query"
+)
+
+func TestStatusPageConfigMapOwnership(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	page := &syntheticv1.StatusPage{
+		ObjectMeta: metav1.ObjectMeta{Name: "status", Namespace: "default", UID: "page"},
+		Spec: syntheticv1.StatusPageSpec{
+			Title:      "Status",
+			ConfigMap:  "site",
+			Components: []syntheticv1.StatusComponent{{Name: "API", Checks: []string{"api"}}},
+		},
+	}
+	site := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "site", Namespace: "default"},
+		Data:       map[string]string{"index.html": "<h1>Welcome</h1>"},
+	}
+	scheme := newScheme()
+	syntheticv1.AddToScheme(scheme)
+	c := fake.NewFakeClientWithScheme(scheme, page, site)
+	r := &StatusPageReconciler{
+		Client:  c,
+		Log:     zap.Logger(true),
+		Scheme:  scheme,
+		History: &query.History{Client: c},
+	}
+	key := types.NamespacedName{Namespace: "default", Name: "status"}
+
+	// A ConfigMap the page does not own is left alone.
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+	var cm corev1.ConfigMap
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "site"}, &cm)).To(Succeed())
+	g.Expect(cm.Data).To(Equal(site.Data))
+	var got syntheticv1.StatusPage
+	g.Expect(c.Get(ctx, key, &got)).To(Succeed())
+	g.Expect(got.Status.Message).To(Equal(`ConfigMap "site" exists and is not owned by the page`))
+
+	// The page creates its own ConfigMap once the other one is gone.
+	g.Expect(c.Delete(ctx, &cm)).To(Succeed())
+	cm = corev1.ConfigMap{}
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "site"}, &cm)).To(Succeed())
+	g.Expect(metav1.IsControlledBy(&cm, page)).To(BeTrue())
+	g.Expect(cm.Data).To(HaveKey("status.json"))
+	got = syntheticv1.StatusPage{}
+	g.Expect(c.Get(ctx, key, &got)).To(Succeed())
+	g.Expect(got.Status.Message).To(BeEmpty())
+}
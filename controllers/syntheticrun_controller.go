@@ -17,34 +17,512 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/faultproxy"
+	"github.com/perph/perph/pkg/load"
+	"github.com/perph/perph/pkg/maintenance"
+	"github.com/perph/perph/pkg/probe"
+	"github.com/perph/perph/pkg/queue"
+	"github.com/perph/perph/pkg/secrets"
+	"github.com/perph/perph/pkg/store"
 )
 
+// validationCheckIndex indexes Validations by the Check they apply to.
+const validationCheckIndex = ".spec.checkRef"
+
 // SyntheticRunReconciler reconciles a SyntheticRun object
 type SyntheticRunReconciler struct {
 	client.Client
-	Log logr.Logger
+	Log      logr.Logger
+	Recorder record.EventRecorder
+
+	// Location is the probe location this manager executes runs for.
+	Location string
+	// MaxConcurrentRuns is the number of runs executed in parallel.
+	MaxConcurrentRuns int
+	// FeederDir is the directory load test feeders read files from. Feeders
+	// cannot read files when it is empty.
+	FeederDir string
+	// Store, when set, receives the detailed results of finished runs, and
+	// the status of check runs only keeps a summary.
+	Store store.Store
 }
 
 // +kubebuilder:rbac:groups=synthetic.perph.io,resources=syntheticruns,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=synthetic.perph.io,resources=syntheticruns/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=validations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=faultproxies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=synthetic.perph.io,resources=maintenancewindows,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets;configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *SyntheticRunReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
-	_ = r.Log.WithValues("syntheticrun", req.NamespacedName)
+	ctx := context.Background()
+	log := r.Log.WithValues("syntheticrun", req.NamespacedName)
+
+	var run syntheticv1.SyntheticRun
+	if err := r.Get(ctx, req.NamespacedName, &run); err != nil {
+		if apierrors.IsNotFound(err) {
+			runs.forget(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch SyntheticRun")
+		return ctrl.Result{}, err
+	}
+
+	if run.Finished() {
+		runs.observe(&run)
+		return ctrl.Result{}, nil
+	}
+	if run.Spec.Location != "" && run.Spec.Location != r.Location {
+		return ctrl.Result{}, nil
+	}
+
+	values := secrets.NewResolver(r.Client, run.Namespace)
+	switch {
+	case run.Spec.CheckRef != "":
+		var check syntheticv1.Check
+		err := r.Get(ctx, types.NamespacedName{Namespace: run.Namespace, Name: run.Spec.CheckRef}, &check)
+		switch {
+		case apierrors.IsNotFound(err):
+			r.fail(&run, fmt.Sprintf("check %q not found", run.Spec.CheckRef))
+		case err != nil:
+			log.Error(err, "unable to fetch Check")
+			return ctrl.Result{}, err
+		default:
+			r.executeCheck(ctx, &check, &run, values)
+			r.silence(ctx, log, &check, &run)
+			if run.Status.Phase == syntheticv1.RunFailed {
+				r.attribute(ctx, log, &check, &run)
+			}
+		}
+	case run.Spec.LoadTestRef != "":
+		var lt syntheticv1.LoadTest
+		err := r.Get(ctx, types.NamespacedName{Namespace: run.Namespace, Name: run.Spec.LoadTestRef}, &lt)
+		switch {
+		case apierrors.IsNotFound(err):
+			r.fail(&run, fmt.Sprintf("load test %q not found", run.Spec.LoadTestRef))
+		case err != nil:
+			log.Error(err, "unable to fetch LoadTest")
+			return ctrl.Result{}, err
+		default:
+			if err := r.executeLoadTest(ctx, &lt, &run, values); err != nil {
+				log.Error(err, "unable to update SyntheticRun status")
+				return ctrl.Result{}, err
+			}
+		}
+	default:
+		return ctrl.Result{}, nil
+	}
+	redactStatus(&run.Status, values)
 
-	// your logic here
+	// Metrics are recorded from the detailed results even when the status
+	// only keeps a summary.
+	observed := run.DeepCopy()
+	if r.Store != nil && run.Finished() {
+		if err := r.Store.Append(store.SeriesOf(&run), store.RecordOf(&run)); err != nil {
+			log.Error(err, "unable to store run results")
+		} else if run.Spec.CheckRef != "" {
+			store.Summarize(&run.Status)
+		}
+	}
+
+	if err := r.Status().Update(ctx, &run); err != nil {
+		log.Error(err, "unable to update SyntheticRun status")
+		return ctrl.Result{}, err
+	}
+	log.V(1).Info("run finished", "phase", run.Status.Phase)
+	switch {
+	case run.Status.SilencedBy != "":
+	case run.Status.Phase == syntheticv1.RunFailed:
+		r.Recorder.Event(&run, corev1.EventTypeWarning, "RunFailed", run.Status.Message)
+	case run.Status.Phase == syntheticv1.RunDependencyFailed:
+		r.Recorder.Event(&run, corev1.EventTypeNormal, "DependencyFailed", run.Status.Message)
+	}
+	runs.observe(observed)
 
 	return ctrl.Result{}, nil
 }
 
+// executeCheck runs the requests of check and records the outcome in run.
+// Validations of the check are evaluated against the last response.
+func (r *SyntheticRunReconciler) executeCheck(ctx context.Context, check *syntheticv1.Check, run *syntheticv1.SyntheticRun, values probe.Values) {
+	start := metav1.Now()
+	run.Status.StartTime = &start
+
+	spec := check.EffectiveSpec()
+	if spec.Queue != nil {
+		r.executeQueue(ctx, spec.Queue, run, values)
+		return
+	}
+	tracer, err := r.tracer(ctx, spec.Tracing, run, values)
+	if err != nil {
+		r.fail(run, fmt.Sprintf("tracing: %v", err))
+		return
+	}
+	defer r.flush(ctx, tracer, run)
+	if spec.HTML != nil {
+		r.executeHTML(ctx, spec.HTML, run, values, tracer)
+		return
+	}
+	steps := spec.RequestSteps()
+	stepped := spec.HTTP == nil
+	if len(steps) == 0 {
+		r.fail(run, "check does not define a probe")
+		return
+	}
+
+	var res *probe.Result
+	for i := range steps {
+		step := &steps[i]
+		if stepped && step.ThinkTime != nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(step.ThinkTime.Duration):
+			}
+		}
+
+		var err error
+		res, err = probe.HTTP(ctx, &step.HTTPProbe, values, tracer)
+		if err != nil {
+			if res != nil {
+				run.Status.TraceID = res.TraceID
+			}
+			if step.Name != "" {
+				err = fmt.Errorf("step %q: %v", step.Name, err)
+			}
+			r.fail(run, err.Error())
+			return
+		}
+
+		run.Status.StatusCode = int32(res.StatusCode)
+		run.Status.Timings = &res.Timings
+		run.Status.Connection = &res.Connection
+		run.Status.TraceID = res.TraceID
+		a := probe.CheckStatus(&step.HTTPProbe, res)
+		if stepped {
+			run.Status.Steps = append(run.Status.Steps, syntheticv1.StepResult{
+				Name:       step.Name,
+				StatusCode: int32(res.StatusCode),
+				Duration:   res.Timings.Total,
+				TraceID:    res.TraceID,
+			})
+			a.Name = step.Name + "/" + a.Name
+		}
+		run.Status.Assertions = append(run.Status.Assertions, a)
+		if !a.Passed {
+			r.complete(run)
+			return
+		}
+	}
+
+	var validations syntheticv1.ValidationList
+	if err := r.List(ctx, &validations, client.InNamespace(check.Namespace),
+		client.MatchingField(validationCheckIndex, check.Name)); err != nil {
+		r.fail(run, fmt.Sprintf("unable to list Validations: %v", err))
+		return
+	}
+	for i := range validations.Items {
+		v := &validations.Items[i]
+		if v.Spec.During != nil {
+			during, err := r.duringFaults(ctx, v, start.Time)
+			if err != nil {
+				// A Validation that cannot tell whether it applies fails
+				// rather than go unnoticed.
+				run.Status.Assertions = append(run.Status.Assertions, syntheticv1.AssertionResult{
+					Name:    v.Name + "/during",
+					Message: err.Error(),
+				})
+				continue
+			}
+			if !during {
+				continue
+			}
+		}
+		run.Status.Assertions = append(run.Status.Assertions, probe.Validate(v, res)...)
+	}
+	r.complete(run)
+}
+
+// duringFaults returns true if the FaultProxy of v injects the faults v
+// selects at t. A FaultProxy that does not exist injects nothing.
+func (r *SyntheticRunReconciler) duringFaults(ctx context.Context, v *syntheticv1.Validation, t time.Time) (bool, error) {
+	var fp syntheticv1.FaultProxy
+	if err := r.Get(ctx, types.NamespacedName{Namespace: v.Namespace, Name: v.Spec.During.FaultProxy}, &fp); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to fetch FaultProxy %q: %v", v.Spec.During.FaultProxy, err)
+	}
+	return faultproxy.Injects(&fp, v.Spec.During.Fault, t)
+}
+
+// executeHTML fetches the page of spec, inspects it and records the outcome
+// in run.
+func (r *SyntheticRunReconciler) executeHTML(ctx context.Context, spec *syntheticv1.HTMLProbe, run *syntheticv1.SyntheticRun, values probe.Values, tracer *probe.Tracer) {
+	res, err := probe.HTTP(ctx, &spec.HTTPProbe, values, tracer)
+	if err != nil {
+		if res != nil {
+			run.Status.TraceID = res.TraceID
+		}
+		r.fail(run, err.Error())
+		return
+	}
+	run.Status.StatusCode = int32(res.StatusCode)
+	run.Status.Timings = &res.Timings
+	run.Status.Connection = &res.Connection
+	run.Status.TraceID = res.TraceID
+	a := probe.CheckStatus(&spec.HTTPProbe, res)
+	run.Status.Assertions = append(run.Status.Assertions, a)
+	if !a.Passed {
+		r.complete(run)
+		return
+	}
+
+	inspection, err := probe.InspectHTML(ctx, spec, res, values)
+	if err != nil {
+		r.fail(run, err.Error())
+		return
+	}
+	run.Status.HTML = &inspection.Result
+	run.Status.Findings = inspection.Findings
+	run.Status.Assertions = append(run.Status.Assertions, inspection.Assertions...)
+	r.complete(run)
+}
+
+// tracer returns the tracer of the requests of run as spec configures it,
+// or nil if spec is.
+func (r *SyntheticRunReconciler) tracer(ctx context.Context, spec *syntheticv1.Tracing, run *syntheticv1.SyntheticRun, values probe.Values) (*probe.Tracer, error) {
+	tracer, err := probe.NewTracer(ctx, spec, values)
+	if tracer == nil {
+		return nil, err
+	}
+	tracer.Attributes = [][2]string{
+		{"perph.run", run.Name},
+		{"perph.location", r.Location},
+	}
+	switch {
+	case run.Spec.CheckRef != "":
+		tracer.Attributes = append(tracer.Attributes, [2]string{"perph.check", run.Spec.CheckRef})
+	case run.Spec.LoadTestRef != "":
+		tracer.Attributes = append(tracer.Attributes, [2]string{"perph.loadtest", run.Spec.LoadTestRef})
+	}
+	return tracer, nil
+}
+
+// flush exports the spans of tracer. Spans are a diagnostic aid, so failing
+// to export them does not fail run.
+func (r *SyntheticRunReconciler) flush(ctx context.Context, tracer *probe.Tracer, run *syntheticv1.SyntheticRun) {
+	if err := tracer.Flush(ctx); err != nil {
+		r.Log.Error(err, "unable to export spans", "syntheticrun", run.Name)
+	}
+}
+
+// silence marks run as silenced if it started inside a maintenance window
+// that selects check. Invalid windows are logged and otherwise ignored, since
+// they must not keep the check from running.
+func (r *SyntheticRunReconciler) silence(ctx context.Context, log logr.Logger, check *syntheticv1.Check, run *syntheticv1.SyntheticRun) {
+	var windows syntheticv1.MaintenanceWindowList
+	if err := r.List(ctx, &windows, client.InNamespace(check.Namespace)); err != nil {
+		log.Error(err, "unable to list MaintenanceWindows")
+		return
+	}
+	s, err := maintenance.Active(windows.Items, check.Labels, run.Status.StartTime.Time)
+	if err != nil {
+		log.Error(err, "invalid maintenance window")
+	}
+	if s != nil {
+		run.Status.SilencedBy = s.Window.Name
+	}
+}
+
+// attribute marks run, which failed, as DependencyFailed if the latest
+// finished run of a check that check depends on failed too, from this
+// location. Dependencies are only looked up one level deep, since a failing
+// dependency records its own root cause.
+func (r *SyntheticRunReconciler) attribute(ctx context.Context, log logr.Logger, check *syntheticv1.Check, run *syntheticv1.SyntheticRun) {
+	for _, dep := range check.Spec.DependsOn {
+		var list syntheticv1.SyntheticRunList
+		if err := r.List(ctx, &list, client.InNamespace(check.Namespace), client.MatchingLabels(map[string]string{
+			syntheticv1.CheckLabel:    dep,
+			syntheticv1.LocationLabel: r.Location,
+		})); err != nil {
+			log.Error(err, "unable to list SyntheticRuns of dependency", "dependency", dep)
+			continue
+		}
+		latest := latestFinished(list.Items)
+		if latest == nil || !latest.Failed() {
+			continue
+		}
+		root := dep
+		if latest.Status.RootCause != "" {
+			root = latest.Status.RootCause
+		}
+		run.Status.Phase = syntheticv1.RunDependencyFailed
+		run.Status.RootCause = root
+		run.Status.Message = fmt.Sprintf("dependency %q is failing: %s", dep, run.Status.Message)
+		return
+	}
+}
+
+// latestFinished returns the finished run among runs that completed last, or
+// nil if none has finished.
+func latestFinished(runs []syntheticv1.SyntheticRun) *syntheticv1.SyntheticRun {
+	var latest *syntheticv1.SyntheticRun
+	for i := range runs {
+		run := &runs[i]
+		if !run.Finished() || run.Status.CompletionTime == nil {
+			continue
+		}
+		if latest == nil || latest.Status.CompletionTime.Before(run.Status.CompletionTime) {
+			latest = run
+		}
+	}
+	return latest
+}
+
+// executeQueue publishes the canary of spec and records how long it took to
+// reach the output topic in run.
+func (r *SyntheticRunReconciler) executeQueue(ctx context.Context, spec *syntheticv1.QueueProbe, run *syntheticv1.SyntheticRun, values probe.Values) {
+	id := string(run.UID)
+	if id == "" {
+		id = run.Name
+	}
+	// Runs of a check share a consumer group per location, rather than
+	// leave a group behind on the broker for every run.
+	group := fmt.Sprintf("perph-canary-%s-%s-%s", run.Namespace, run.Spec.CheckRef, run.Spec.Location)
+	res, err := queue.Canary(ctx, spec, id, group, values)
+	if err != nil {
+		r.fail(run, err.Error())
+		return
+	}
+	run.Status.Queue = res
+	run.Status.Assertions = append(run.Status.Assertions, syntheticv1.AssertionResult{Name: "canary", Passed: true})
+	if spec.MaxLatency != nil {
+		a := syntheticv1.AssertionResult{Name: "latency", Passed: res.EndToEnd.Duration <= spec.MaxLatency.Duration}
+		if !a.Passed {
+			a.Message = fmt.Sprintf("canary took %v, expected at most %v", res.EndToEnd.Duration, spec.MaxLatency.Duration)
+		}
+		run.Status.Assertions = append(run.Status.Assertions, a)
+	}
+	r.complete(run)
+}
+
+// executeLoadTest drives the virtual users of lt and records the summary in
+// run. Load tests run for a long time, so the run is marked as running first.
+func (r *SyntheticRunReconciler) executeLoadTest(ctx context.Context, lt *syntheticv1.LoadTest, run *syntheticv1.SyntheticRun, values probe.Values) error {
+	if run.Status.Phase == syntheticv1.RunRunning {
+		// Either the manager restarted mid test, or the cache has not caught
+		// up with the final status yet, in which case the update conflicts.
+		r.fail(run, "load test was interrupted")
+		return nil
+	}
+	start := metav1.Now()
+	run.Status.StartTime = &start
+	run.Status.Phase = syntheticv1.RunRunning
+	if err := r.Status().Update(ctx, run); err != nil {
+		return err
+	}
+
+	// The summary so far is published in the status of the run while it is
+	// running, for clients following the progress of the load test. A
+	// failed update only skips that report.
+	tracer, err := r.tracer(ctx, lt.Spec.Tracing, run, values)
+	if err != nil {
+		r.fail(run, fmt.Sprintf("tracing: %v", err))
+		return nil
+	}
+	defer r.flush(ctx, tracer, run)
+	summary, err := load.Run(ctx, &lt.Spec, values, load.Options{
+		Partition: run.Spec.Partition,
+		FeederDir: r.FeederDir,
+		Tracer:    tracer,
+		Progress: func(s *syntheticv1.LoadSummary) {
+			run.Status.LoadTest = s
+			if err := r.Status().Update(ctx, run); err != nil {
+				r.Log.Error(err, "unable to report load test progress", "syntheticrun", run.Name)
+			}
+		},
+	})
+	if err != nil {
+		r.fail(run, err.Error())
+		return nil
+	}
+	run.Status.LoadTest = summary
+	r.complete(run)
+	return nil
+}
+
+// complete finishes run, failing it if any assertion failed.
+func (r *SyntheticRunReconciler) complete(run *syntheticv1.SyntheticRun) {
+	now := metav1.Now()
+	run.Status.CompletionTime = &now
+	run.Status.Phase = syntheticv1.RunSucceeded
+	for _, a := range run.Status.Assertions {
+		if !a.Passed {
+			run.Status.Phase = syntheticv1.RunFailed
+			run.Status.Message = fmt.Sprintf("assertion %q failed: %s", a.Name, a.Message)
+			return
+		}
+	}
+}
+
+// fail finishes run with an error that prevented the probe from completing.
+func (r *SyntheticRunReconciler) fail(run *syntheticv1.SyntheticRun, message string) {
+	now := metav1.Now()
+	if run.Status.StartTime == nil {
+		run.Status.StartTime = &now
+	}
+	run.Status.CompletionTime = &now
+	run.Status.Phase = syntheticv1.RunFailed
+	run.Status.Message = message
+}
+
+// redactStatus removes the Secret values a run used from the parts of its
+// status that may echo them, such as error messages that quote a URL.
+func redactStatus(status *syntheticv1.SyntheticRunStatus, values *secrets.Resolver) {
+	status.Message = values.Redact(status.Message)
+	for i := range status.Assertions {
+		status.Assertions[i].Message = values.Redact(status.Assertions[i].Message)
+	}
+	for i := range status.Findings {
+		status.Findings[i].Message = values.Redact(status.Findings[i].Message)
+	}
+}
+
 func (r *SyntheticRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&syntheticv1.SyntheticRun{}).
-		Complete(r)
+	err := mgr.GetFieldIndexer().IndexField(&syntheticv1.Validation{}, validationCheckIndex, func(obj runtime.Object) []string {
+		return []string{obj.(*syntheticv1.Validation).Spec.CheckRef}
+	})
+	if err != nil {
+		return err
+	}
+
+	// The builder does not expose controller options, and probes spend most
+	// of their time waiting on the network, so runs are reconciled in
+	// parallel.
+	c, err := controller.New("syntheticrun-controller", mgr, controller.Options{
+		Reconciler:              r,
+		MaxConcurrentReconciles: r.MaxConcurrentRuns,
+	})
+	if err != nil {
+		return err
+	}
+	return c.Watch(&source.Kind{Type: &syntheticv1.SyntheticRun{}}, &handler.EnqueueRequestForObject{})
 }
@@ -0,0 +1,98 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// dependencyRun returns a run of check in location that finished minutes
+// ago with phase.
+func dependencyRun(name, check, location string, minutes int, phase syntheticv1.SyntheticRunPhase) *syntheticv1.SyntheticRun {
+	finished := metav1.NewTime(time.Now().Add(-time.Duration(minutes) * time.Minute))
+	return &syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{
+			syntheticv1.CheckLabel:    check,
+			syntheticv1.LocationLabel: location,
+		}},
+		Spec:   syntheticv1.SyntheticRunSpec{CheckRef: check, Location: location},
+		Status: syntheticv1.SyntheticRunStatus{Phase: phase, CompletionTime: &finished},
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	attribute := func(objs ...runtime.Object) *syntheticv1.SyntheticRun {
+		r := &SyntheticRunReconciler{
+			Client:   fake.NewFakeClientWithScheme(newScheme(), objs...),
+			Log:      zap.Logger(true),
+			Location: "eu",
+		}
+		check := &syntheticv1.Check{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+			Spec:       syntheticv1.CheckSpec{DependsOn: []string{"cache", "api"}},
+		}
+		run := dependencyRun("frontend-1", "frontend", "eu", 0, syntheticv1.RunFailed)
+		run.Status.Message = "connection refused"
+		r.attribute(context.Background(), r.Log, check, run)
+		return run
+	}
+
+	// A dependency whose latest run failed is the root cause.
+	run := attribute(
+		dependencyRun("api-1", "api", "eu", 2, syntheticv1.RunSucceeded),
+		dependencyRun("api-2", "api", "eu", 1, syntheticv1.RunFailed),
+	)
+	g.Expect(run.Status.Phase).To(Equal(syntheticv1.RunDependencyFailed))
+	g.Expect(run.Status.RootCause).To(Equal("api"))
+	g.Expect(run.Status.Message).To(Equal(`dependency "api" is failing: connection refused`))
+
+	// The root cause of a dependency that failed on its own dependency is
+	// carried over.
+	failed := dependencyRun("api-1", "api", "eu", 1, syntheticv1.RunDependencyFailed)
+	failed.Status.RootCause = "db"
+	run = attribute(failed)
+	g.Expect(run.Status.Phase).To(Equal(syntheticv1.RunDependencyFailed))
+	g.Expect(run.Status.RootCause).To(Equal("db"))
+
+	// Dependencies that recovered, failed elsewhere or have not finished a
+	// run yet leave the failure to the check.
+	for _, deps := range [][]runtime.Object{
+		{
+			dependencyRun("api-1", "api", "eu", 2, syntheticv1.RunFailed),
+			dependencyRun("api-2", "api", "eu", 1, syntheticv1.RunSucceeded),
+		},
+		{dependencyRun("api-1", "api", "us", 1, syntheticv1.RunFailed)},
+		{dependencyRun("api-1", "api", "eu", 1, syntheticv1.RunRunning)},
+		nil,
+	} {
+		run = attribute(deps...)
+		g.Expect(run.Status.Phase).To(Equal(syntheticv1.RunFailed))
+		g.Expect(run.Status.RootCause).To(BeEmpty())
+		g.Expect(run.Status.Message).To(Equal("connection refused"))
+	}
+}
@@ -16,13 +16,22 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"os"
+	"time"
 
 	metricsv1 "github.com/perph/perph/api/v1"
 	syntheticv1 "github.com/perph/perph/api/v1"
 	"github.com/perph/perph/controllers"
+	"github.com/perph/perph/pkg/analysis"
+	"github.com/perph/perph/pkg/faultproxy"
+	"github.com/perph/perph/pkg/query"
+	"github.com/perph/perph/pkg/statuspage"
+	"github.com/perph/perph/pkg/store"
 	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -35,6 +44,7 @@ var (
 )
 
 func init() {
+	clientgoscheme.AddToScheme(scheme)
 
 	syntheticv1.AddToScheme(scheme)
 	metricsv1.AddToScheme(scheme)
@@ -43,20 +53,92 @@ func init() {
 
 func main() {
 	var metricsAddr string
+	var location string
+	var maxConcurrentRuns int
+	var feederDir string
+	var analysisAddr string
+	var analysisCertDir string
+	var enableWebhooks bool
+	var storeDir string
+	var storeRetention time.Duration
+	var storeDownsampling string
+	var queryAddr string
+	var queryCertDir string
+	var statusPageAddr string
+	var faultProxySpec string
+	var faultProxyImage string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&location, "location", "default", "The probe location this manager runs checks from.")
+	flag.IntVar(&maxConcurrentRuns, "max-concurrent-runs", 10, "The number of SyntheticRuns executed in parallel.")
+	flag.StringVar(&feederDir, "feeder-dir", "", "The directory load test feeders may read files from. Feeders cannot read files when empty.")
+	flag.StringVar(&analysisAddr, "analysis-addr", "", "The address the analysis endpoint for rollout tools binds to. Disabled when empty.")
+	flag.StringVar(&analysisCertDir, "analysis-cert-dir", "", "The directory holding the tls.crt and tls.key the analysis endpoint is served with. Served over plain HTTP when empty.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false, "Serve the validating webhooks. Requires a serving certificate in the webhook server's cert dir.")
+	flag.StringVar(&storeDir, "result-store-dir", "", "The directory of the store detailed run results are written to. Runs keep their full results in their status when empty.")
+	flag.DurationVar(&storeRetention, "result-retention", store.DefaultPolicy.Retention, "How long the result store keeps runs in full.")
+	flag.StringVar(&storeDownsampling, "result-downsampling", "5m:720h,1h:2160h", "The resolution:retention levels the result store downsamples runs into once they are past their retention, from the finest to the coarsest.")
+	flag.StringVar(&queryAddr, "query-addr", "", "The address the query API for dashboards binds to. Disabled when empty.")
+	flag.StringVar(&queryCertDir, "query-cert-dir", "", "The directory holding the tls.crt and tls.key the query API is served with, such as the webhook server's cert dir. Required with --query-addr.")
+	flag.StringVar(&statusPageAddr, "status-page-addr", "", "The address public status pages are served on, without authentication. Disabled when empty.")
+	flag.StringVar(&faultProxySpec, "fault-proxy", "", "Run a fault injection proxy with the given FaultProxy spec, as JSON, instead of the manager.")
+	flag.StringVar(&faultProxyImage, "fault-proxy-image", "controller:latest", "The image of FaultProxies that do not set one. Must be the image of the manager.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.Logger(true))
 
+	if queryAddr != "" && queryCertDir == "" {
+		setupLog.Error(errors.New("the query API receives bearer tokens and is only served over TLS"), "--query-cert-dir must be set with --query-addr")
+		os.Exit(1)
+	}
+
+	if faultProxySpec != "" {
+		proxy := &faultproxy.Proxy{Log: ctrl.Log.WithName("faultproxy")}
+		if err := json.Unmarshal([]byte(faultProxySpec), &proxy.Spec); err != nil {
+			setupLog.Error(err, "invalid fault proxy spec")
+			os.Exit(1)
+		}
+		if err := proxy.ListenAndServe(); err != nil {
+			setupLog.Error(err, "problem running fault proxy")
+			os.Exit(1)
+		}
+		return
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{Scheme: scheme, MetricsBindAddress: metricsAddr})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	var results store.Store
+	if storeDir != "" {
+		levels, err := store.ParseLevels(storeDownsampling)
+		if err != nil {
+			setupLog.Error(err, "invalid result downsampling")
+			os.Exit(1)
+		}
+		policy := store.Policy{Retention: storeRetention, Downsampling: levels}
+		bolt, err := store.Open(storeDir, policy, ctrl.Log.WithName("store"))
+		if err != nil {
+			setupLog.Error(err, "unable to open result store")
+			os.Exit(1)
+		}
+		defer bolt.Close()
+		if err := mgr.Add(bolt); err != nil {
+			setupLog.Error(err, "unable to add result store")
+			os.Exit(1)
+		}
+		results = bolt
+	}
+
 	err = (&controllers.SyntheticRunReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("SyntheticRun"),
+		Client:            mgr.GetClient(),
+		Log:               ctrl.Log.WithName("controllers").WithName("SyntheticRun"),
+		Recorder:          mgr.GetEventRecorderFor("syntheticrun-controller"),
+		Location:          location,
+		MaxConcurrentRuns: maxConcurrentRuns,
+		FeederDir:         feederDir,
+		Store:             results,
 	}).SetupWithManager(mgr)
 	if err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "SyntheticRun")
@@ -65,13 +147,140 @@ func main() {
 	err = (&controllers.ExportTaskReconciler{
 		Client: mgr.GetClient(),
 		Log:    ctrl.Log.WithName("controllers").WithName("ExportTask"),
+		Store:  results,
 	}).SetupWithManager(mgr)
 	if err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ExportTask")
 		os.Exit(1)
 	}
+	err = (&controllers.CheckReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Check"),
+		Scheme:   mgr.GetScheme(),
+		Location: location,
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Check")
+		os.Exit(1)
+	}
+	err = (&controllers.LoadTestReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("LoadTest"),
+		Scheme:   mgr.GetScheme(),
+		Location: location,
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LoadTest")
+		os.Exit(1)
+	}
+	err = (&controllers.ServiceDiscoveryReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ServiceDiscovery"),
+		Recorder: mgr.GetEventRecorderFor("servicediscovery-controller"),
+		Scheme:   mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ServiceDiscovery")
+		os.Exit(1)
+	}
+	err = (&controllers.IngressDiscoveryReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("IngressDiscovery"),
+		Recorder: mgr.GetEventRecorderFor("ingressdiscovery-controller"),
+		Scheme:   mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IngressDiscovery")
+		os.Exit(1)
+	}
+	evaluator := &analysis.Evaluator{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Location: location,
+		Store:    results,
+	}
+	err = (&controllers.GateReconciler{
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("Gate"),
+		Recorder:  mgr.GetEventRecorderFor("gate-controller"),
+		Evaluator: evaluator,
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Gate")
+		os.Exit(1)
+	}
+	history := &query.History{Client: mgr.GetClient(), Store: results}
+	err = (&controllers.StatusPageReconciler{
+		Client:  mgr.GetClient(),
+		Log:     ctrl.Log.WithName("controllers").WithName("StatusPage"),
+		Scheme:  mgr.GetScheme(),
+		History: history,
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "StatusPage")
+		os.Exit(1)
+	}
+	err = (&controllers.FaultProxyReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("FaultProxy"),
+		Scheme: mgr.GetScheme(),
+		Image:  faultProxyImage,
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "FaultProxy")
+		os.Exit(1)
+	}
+	if enableWebhooks {
+		if err = (&syntheticv1.Check{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Check")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
+	authorizer := &query.KubeAuthorizer{Client: mgr.GetClient()}
+	if analysisAddr != "" {
+		err = mgr.Add(&analysis.Server{
+			Evaluator:  evaluator,
+			Authorizer: authorizer,
+			Log:        ctrl.Log.WithName("analysis"),
+			Addr:       analysisAddr,
+			CertDir:    analysisCertDir,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to add analysis server")
+			os.Exit(1)
+		}
+	}
+
+	if queryAddr != "" {
+		err = mgr.Add(&query.Server{
+			Client:     mgr.GetClient(),
+			Store:      results,
+			Authorizer: authorizer,
+			Log:        ctrl.Log.WithName("query"),
+			Addr:       queryAddr,
+			CertDir:    queryCertDir,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to add query server")
+			os.Exit(1)
+		}
+	}
+
+	if statusPageAddr != "" {
+		err = mgr.Add(&statuspage.Server{
+			Client:  mgr.GetClient(),
+			History: history,
+			Log:     ctrl.Log.WithName("statuspage"),
+			Addr:    statusPageAddr,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to add status page server")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
@@ -0,0 +1,365 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package analysis reports whether Checks, Validations and LoadTests pass,
+// for deployment tooling to gate rollouts on.
+package analysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/faultproxy"
+	"github.com/perph/perph/pkg/store"
+)
+
+// Kinds of the objects that can be analysed, as they appear in requests.
+const (
+	KindCheck      = "checks"
+	KindValidation = "validations"
+	KindLoadTest   = "loadtests"
+)
+
+// ErrNotFound is returned for objects that do not exist.
+var ErrNotFound = errors.New("not found")
+
+// badRequest is an error in what a caller asked for rather than in
+// evaluating it.
+type badRequest string
+
+func (e badRequest) Error() string { return string(e) }
+
+// Result is the outcome of the most recent runs of an object.
+type Result struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// Passed is true when the object has finished runs and they all passed.
+	Passed bool `json:"passed"`
+
+	// Finished is false while the object has no finished run to report, in
+	// which case Passed is false too.
+	Finished bool `json:"finished"`
+
+	// Runs names the runs the result is made of: the latest finished run of
+	// a check in every location, or the most recent run of a load test.
+	Runs []string `json:"runs,omitempty"`
+
+	// Message explains why the object did not pass.
+	Message string `json:"message,omitempty"`
+}
+
+// Evaluator evaluates the results of objects read through a client.
+type Evaluator struct {
+	Client client.Client
+	Scheme *runtime.Scheme
+
+	// Location is the probe location runs are started in.
+	Location string
+
+	// PollInterval is how often a started run is checked for completion.
+	// Defaults to a second.
+	PollInterval time.Duration
+
+	// Store holds the assertions of runs whose status only keeps a summary.
+	// Optional.
+	Store store.Store
+}
+
+// Evaluate returns the result of the most recent runs of the object of the
+// given kind.
+func (e *Evaluator) Evaluate(ctx context.Context, kind, namespace, name string) (*Result, error) {
+	res := &Result{Kind: kind, Namespace: namespace, Name: name}
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	switch kind {
+	case KindCheck:
+		if err := e.get(ctx, key, &syntheticv1.Check{}); err != nil {
+			return nil, err
+		}
+		return res, e.evaluateCheck(ctx, res, name, "", nil)
+	case KindValidation:
+		var v syntheticv1.Validation
+		if err := e.get(ctx, key, &v); err != nil {
+			return nil, err
+		}
+		during, err := e.during(ctx, &v)
+		if err != nil {
+			return nil, err
+		}
+		return res, e.evaluateCheck(ctx, res, v.Spec.CheckRef, v.Name, during)
+	case KindLoadTest:
+		var lt syntheticv1.LoadTest
+		if err := e.get(ctx, key, &lt); err != nil {
+			return nil, err
+		}
+		evaluateLoadTest(res, &lt)
+		return res, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q", kind)
+}
+
+func (e *Evaluator) get(ctx context.Context, key types.NamespacedName, obj runtime.Object) error {
+	err := e.Client.Get(ctx, key, obj)
+	if apierrors.IsNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// during returns whether a run of the check of v started during the fault
+// window of v, or nil if v applies to every run. It fails if the faults of v
+// are missing or invalid.
+func (e *Evaluator) during(ctx context.Context, v *syntheticv1.Validation) (func(*syntheticv1.SyntheticRun) bool, error) {
+	if v.Spec.During == nil {
+		return nil, nil
+	}
+	var fp syntheticv1.FaultProxy
+	err := e.Client.Get(ctx, types.NamespacedName{Namespace: v.Namespace, Name: v.Spec.During.FaultProxy}, &fp)
+	switch {
+	case apierrors.IsNotFound(err):
+		return func(*syntheticv1.SyntheticRun) bool { return false }, nil
+	case err != nil:
+		return nil, err
+	}
+	// Whether a fault is valid does not depend on the time it is asked about.
+	if _, err := faultproxy.Injects(&fp, v.Spec.During.Fault, time.Now()); err != nil {
+		return nil, fmt.Errorf("validation %q: %v", v.Name, err)
+	}
+	return func(run *syntheticv1.SyntheticRun) bool {
+		if run.Status.StartTime == nil {
+			return false
+		}
+		injects, _ := faultproxy.Injects(&fp, v.Spec.During.Fault, run.Status.StartTime.Time)
+		return injects
+	}, nil
+}
+
+// evaluateCheck fills in res from the latest finished run of check in every
+// location, among the runs in counts if it is not nil. For a validation, only
+// its assertions count.
+func (e *Evaluator) evaluateCheck(ctx context.Context, res *Result, check, validation string, counts func(*syntheticv1.SyntheticRun) bool) error {
+	var list syntheticv1.SyntheticRunList
+	if err := e.Client.List(ctx, &list, client.InNamespace(res.Namespace),
+		client.MatchingLabels(map[string]string{syntheticv1.CheckLabel: check})); err != nil {
+		return err
+	}
+	latest := map[string]*syntheticv1.SyntheticRun{}
+	for i := range list.Items {
+		run := &list.Items[i]
+		if !run.Finished() || run.Status.CompletionTime == nil || (counts != nil && !counts(run)) {
+			continue
+		}
+		prev := latest[run.Spec.Location]
+		if prev == nil || prev.Status.CompletionTime.Before(run.Status.CompletionTime) {
+			latest[run.Spec.Location] = run
+		}
+	}
+	var runs []*syntheticv1.SyntheticRun
+	for _, run := range latest {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Name < runs[j].Name })
+	if err := e.details(runs, validation); err != nil {
+		return err
+	}
+	evaluateRuns(res, runs, validation)
+	return nil
+}
+
+// details restores the assertions of runs from the store when a validation
+// is evaluated, as runs kept in the store only keep a summary.
+func (e *Evaluator) details(runs []*syntheticv1.SyntheticRun, validation string) error {
+	if validation == "" || e.Store == nil {
+		return nil
+	}
+	for i, run := range runs {
+		status, err := store.Details(e.Store, run)
+		if err != nil {
+			return err
+		}
+		out := run.DeepCopy()
+		out.Status = *status.DeepCopy()
+		runs[i] = out
+	}
+	return nil
+}
+
+// evaluateRuns fills in res from finished runs of a check.
+func evaluateRuns(res *Result, runs []*syntheticv1.SyntheticRun, validation string) {
+	if len(runs) == 0 {
+		res.Message = "no finished runs"
+		return
+	}
+	res.Finished = true
+	var failures []string
+	for _, run := range runs {
+		res.Runs = append(res.Runs, run.Name)
+		if msg := runFailure(run, validation); msg != "" {
+			failures = append(failures, fmt.Sprintf("%s: %s", run.Name, msg))
+		}
+	}
+	res.Passed = len(failures) == 0
+	res.Message = strings.Join(failures, "; ")
+}
+
+// runFailure explains why run failed, or returns an empty string if it
+// passed. For a validation, only its assertions count, and a run that ended
+// before they were evaluated fails.
+func runFailure(run *syntheticv1.SyntheticRun, validation string) string {
+	if validation == "" {
+		if run.Status.Phase == syntheticv1.RunSucceeded {
+			return ""
+		}
+		return run.Status.Message
+	}
+	evaluated := false
+	for _, a := range run.Status.Assertions {
+		if !strings.HasPrefix(a.Name, validation+"/") {
+			continue
+		}
+		evaluated = true
+		if !a.Passed {
+			return fmt.Sprintf("assertion %q failed: %s", a.Name, a.Message)
+		}
+	}
+	if !evaluated {
+		if run.Status.Message != "" {
+			return "validation was not evaluated: " + run.Status.Message
+		}
+		return "validation was not evaluated"
+	}
+	return ""
+}
+
+// evaluateLoadTest fills in res from the most recent run of lt, which fails
+// when it regressed against its baseline.
+func evaluateLoadTest(res *Result, lt *syntheticv1.LoadTest) {
+	switch lt.Status.Phase {
+	case syntheticv1.RunSucceeded, syntheticv1.RunFailed:
+	default:
+		res.Message = "load test has not finished"
+		return
+	}
+	res.Finished = true
+	res.Runs = []string{lt.Status.LastRun}
+	switch {
+	case lt.Status.Phase == syntheticv1.RunFailed:
+		res.Message = "load test failed"
+	case lt.Status.Comparison != nil && lt.Status.Comparison.Regression:
+		res.Message = lt.Status.Comparison.Message
+	default:
+		res.Passed = true
+	}
+}
+
+// Run starts a run of the check of the object of the given kind in the
+// location of e, waits until it finishes and returns its result. Load tests
+// run once per generation and cannot be started this way.
+func (e *Evaluator) Run(ctx context.Context, kind, namespace, name string) (*Result, error) {
+	res := &Result{Kind: kind, Namespace: namespace, Name: name}
+	var check syntheticv1.Check
+	validation := ""
+	switch kind {
+	case KindCheck:
+		if err := e.get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &check); err != nil {
+			return nil, err
+		}
+	case KindValidation:
+		var v syntheticv1.Validation
+		if err := e.get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &v); err != nil {
+			return nil, err
+		}
+		// A validation of a missing check answers like a missing validation.
+		err := e.get(ctx, types.NamespacedName{Namespace: namespace, Name: v.Spec.CheckRef}, &check)
+		switch {
+		case err == ErrNotFound:
+			return nil, err
+		case err != nil:
+			return nil, fmt.Errorf("check %q: %v", v.Spec.CheckRef, err)
+		}
+		validation = v.Name
+	case KindLoadTest:
+		return nil, badRequest("load tests run once per generation and cannot be started on demand")
+	default:
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+	if !check.RunsIn(e.Location) {
+		return nil, badRequest(fmt.Sprintf("check %q does not run in location %q", check.Name, e.Location))
+	}
+
+	run := &syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s-%d", check.Name, e.Location, time.Now().UnixNano()),
+			Namespace: namespace,
+			Labels: map[string]string{
+				syntheticv1.CheckLabel:    check.Name,
+				syntheticv1.LocationLabel: e.Location,
+			},
+		},
+		Spec: syntheticv1.SyntheticRunSpec{
+			CheckRef: check.Name,
+			Location: e.Location,
+		},
+	}
+	if err := controllerutil.SetControllerReference(&check, run, e.Scheme); err != nil {
+		return nil, err
+	}
+	if err := e.Client.Create(ctx, run); err != nil {
+		return nil, err
+	}
+
+	interval := e.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	key := types.NamespacedName{Namespace: namespace, Name: run.Name}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("run %s did not finish: %v", run.Name, ctx.Err())
+		case <-ticker.C:
+		}
+		if err := e.Client.Get(ctx, key, run); err != nil {
+			if apierrors.IsNotFound(err) {
+				// The run has not reached the cache yet.
+				continue
+			}
+			return nil, err
+		}
+		if run.Finished() {
+			runs := []*syntheticv1.SyntheticRun{run}
+			if err := e.details(runs, validation); err != nil {
+				return nil, err
+			}
+			evaluateRuns(res, runs, validation)
+			return res, nil
+		}
+	}
+}
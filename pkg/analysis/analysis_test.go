@@ -0,0 +1,371 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/query"
+	"github.com/perph/perph/pkg/store"
+)
+
+func newEvaluator(objs ...runtime.Object) *Evaluator {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	syntheticv1.AddToScheme(scheme)
+	return &Evaluator{
+		Client:       fake.NewFakeClientWithScheme(scheme, objs...),
+		Scheme:       scheme,
+		Location:     "eu",
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+// staticAuthorizer allows the token "reader" to get objects in the default
+// namespace, and the token "runner" to also create SyntheticRuns there.
+type staticAuthorizer struct{}
+
+func (staticAuthorizer) Authorize(_ context.Context, token string, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	if token != "reader" && token != "runner" {
+		return false, query.ErrUnauthenticated
+	}
+	if attrs.Namespace != "default" {
+		return false, nil
+	}
+	return attrs.Verb == "get" || token == "runner" && attrs.Verb == "create" && attrs.Resource == "syntheticruns", nil
+}
+
+// request sends a request to url with the bearer token, unless it is empty.
+func request(g *GomegaWithT, method, url, token string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	return resp
+}
+
+func newCheck(name string) *syntheticv1.Check {
+	return &syntheticv1.Check{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+}
+
+// finishedRun returns a run of check in location that finished at minute.
+func finishedRun(check, location string, minute int, phase syntheticv1.SyntheticRunPhase, assertions ...syntheticv1.AssertionResult) *syntheticv1.SyntheticRun {
+	done := metav1.NewTime(time.Date(2019, 7, 1, 12, minute, 0, 0, time.UTC))
+	return &syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      check + "-" + location + "-" + string(rune('a'+minute)),
+			Namespace: "default",
+			Labels:    map[string]string{syntheticv1.CheckLabel: check, syntheticv1.LocationLabel: location},
+		},
+		Spec: syntheticv1.SyntheticRunSpec{CheckRef: check, Location: location},
+		Status: syntheticv1.SyntheticRunStatus{
+			Phase:          phase,
+			CompletionTime: &done,
+			Message:        "status 500",
+			Assertions:     assertions,
+		},
+	}
+}
+
+func TestEvaluateCheck(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	e := newEvaluator(
+		newCheck("api"),
+		finishedRun("api", "eu", 1, syntheticv1.RunFailed),
+		finishedRun("api", "eu", 2, syntheticv1.RunSucceeded),
+		finishedRun("api", "us", 1, syntheticv1.RunSucceeded),
+		finishedRun("api", "us", 2, syntheticv1.RunFailed),
+		newCheck("idle"),
+	)
+
+	res, err := e.Evaluate(ctx, KindCheck, "default", "api")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Finished).To(BeTrue())
+	g.Expect(res.Passed).To(BeFalse())
+	g.Expect(res.Runs).To(Equal([]string{"api-eu-c", "api-us-c"}))
+	g.Expect(res.Message).To(Equal("api-us-c: status 500"))
+
+	res, err = e.Evaluate(ctx, KindCheck, "default", "idle")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Finished).To(BeFalse())
+	g.Expect(res.Passed).To(BeFalse())
+
+	_, err = e.Evaluate(ctx, KindCheck, "default", "missing")
+	g.Expect(err).To(Equal(ErrNotFound))
+}
+
+func TestEvaluateValidation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	validation := func(name string) *syntheticv1.Validation {
+		return &syntheticv1.Validation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       syntheticv1.ValidationSpec{CheckRef: "api"},
+		}
+	}
+	e := newEvaluator(
+		newCheck("api"),
+		validation("contract"),
+		validation("schema"),
+		validation("unused"),
+		// The run fails on the schema, but the contract holds.
+		finishedRun("api", "eu", 1, syntheticv1.RunFailed,
+			syntheticv1.AssertionResult{Name: "status", Passed: true},
+			syntheticv1.AssertionResult{Name: "contract/status", Passed: true},
+			syntheticv1.AssertionResult{Name: "schema/schema", Message: "missing id"}),
+	)
+
+	res, err := e.Evaluate(ctx, KindValidation, "default", "contract")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Passed).To(BeTrue())
+
+	res, err = e.Evaluate(ctx, KindValidation, "default", "schema")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Passed).To(BeFalse())
+	g.Expect(res.Message).To(Equal(`api-eu-b: assertion "schema/schema" failed: missing id`))
+
+	res, err = e.Evaluate(ctx, KindValidation, "default", "unused")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Passed).To(BeFalse())
+	g.Expect(res.Message).To(ContainSubstring("validation was not evaluated"))
+}
+
+func TestEvaluateValidationFromStore(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "analysis")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	results, err := store.Open(dir, store.DefaultPolicy, zap.Logger(true))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer results.Close()
+
+	// The run only keeps a summary, its assertions are in the store.
+	run := finishedRun("api", "eu", 1, syntheticv1.RunFailed,
+		syntheticv1.AssertionResult{Name: "schema/schema", Message: "missing id"})
+	g.Expect(results.Append(store.SeriesOf(run), store.RecordOf(run))).To(Succeed())
+	store.Summarize(&run.Status)
+	e := newEvaluator(
+		newCheck("api"),
+		&syntheticv1.Validation{
+			ObjectMeta: metav1.ObjectMeta{Name: "schema", Namespace: "default"},
+			Spec:       syntheticv1.ValidationSpec{CheckRef: "api"},
+		},
+		run,
+	)
+	e.Store = results
+
+	res, err := e.Evaluate(ctx, KindValidation, "default", "schema")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Passed).To(BeFalse())
+	g.Expect(res.Message).To(Equal(`api-eu-b: assertion "schema/schema" failed: missing id`))
+}
+
+func TestEvaluateValidationDuringFaults(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	start := metav1.NewTime(time.Date(2019, 7, 1, 12, 1, 0, 0, time.UTC))
+	end := metav1.NewTime(time.Date(2019, 7, 1, 12, 2, 0, 0, time.UTC))
+	started := func(run *syntheticv1.SyntheticRun, minute int) *syntheticv1.SyntheticRun {
+		at := metav1.NewTime(time.Date(2019, 7, 1, 12, minute, 0, 0, time.UTC))
+		run.Status.StartTime = &at
+		return run
+	}
+	e := newEvaluator(
+		newCheck("api"),
+		&syntheticv1.Validation{
+			ObjectMeta: metav1.ObjectMeta{Name: "degraded", Namespace: "default"},
+			Spec: syntheticv1.ValidationSpec{
+				CheckRef: "api",
+				During:   &syntheticv1.FaultWindow{FaultProxy: "db", Fault: "slow"},
+			},
+		},
+		&syntheticv1.FaultProxy{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+			Spec: syntheticv1.FaultProxySpec{
+				Upstream: "postgres:5432",
+				Faults:   []syntheticv1.Fault{{Name: "slow", Start: &start, End: &end}},
+			},
+		},
+		// The run during the fault is too slow, the later one is not
+		// evaluated.
+		started(finishedRun("api", "eu", 1, syntheticv1.RunSucceeded,
+			syntheticv1.AssertionResult{Name: "degraded/latency", Message: "too slow"}), 1),
+		started(finishedRun("api", "eu", 3, syntheticv1.RunSucceeded), 3),
+	)
+
+	res, err := e.Evaluate(ctx, KindValidation, "default", "degraded")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Runs).To(Equal([]string{"api-eu-b"}))
+	g.Expect(res.Passed).To(BeFalse())
+	g.Expect(res.Message).To(ContainSubstring("too slow"))
+
+	// A Validation of a fault the proxy does not have cannot be evaluated.
+	var v syntheticv1.Validation
+	g.Expect(e.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "degraded"}, &v)).To(Succeed())
+	v.Spec.During.Fault = "missing"
+	g.Expect(e.Client.Update(ctx, &v)).To(Succeed())
+	_, err = e.Evaluate(ctx, KindValidation, "default", "degraded")
+	g.Expect(err).To(MatchError(`validation "degraded": FaultProxy "db" has no fault "missing"`))
+}
+
+func TestEvaluateLoadTest(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	loadTest := func(name string, phase syntheticv1.SyntheticRunPhase, regression bool) *syntheticv1.LoadTest {
+		return &syntheticv1.LoadTest{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status: syntheticv1.LoadTestStatus{
+				LastRun:    name + "-1",
+				Phase:      phase,
+				Comparison: &syntheticv1.Comparison{Regression: regression, Message: "p99 +40.0%"},
+			},
+		}
+	}
+	e := newEvaluator(
+		loadTest("steady", syntheticv1.RunSucceeded, false),
+		loadTest("slower", syntheticv1.RunSucceeded, true),
+		loadTest("running", syntheticv1.RunRunning, false),
+	)
+
+	res, err := e.Evaluate(ctx, KindLoadTest, "default", "steady")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Passed).To(BeTrue())
+	g.Expect(res.Runs).To(Equal([]string{"steady-1"}))
+
+	res, err = e.Evaluate(ctx, KindLoadTest, "default", "slower")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Passed).To(BeFalse())
+	g.Expect(res.Message).To(Equal("p99 +40.0%"))
+
+	res, err = e.Evaluate(ctx, KindLoadTest, "default", "running")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Finished).To(BeFalse())
+}
+
+func TestRun(t *testing.T) {
+	g := NewGomegaWithT(t)
+	e := newEvaluator(newCheck("api"))
+
+	// Stand in for the run controller.
+	go func() {
+		ctx := context.Background()
+		for {
+			var list syntheticv1.SyntheticRunList
+			e.Client.List(ctx, &list, client.InNamespace("default"))
+			if len(list.Items) > 0 {
+				run := list.Items[0]
+				run.Status.Phase = syntheticv1.RunSucceeded
+				e.Client.Status().Update(ctx, &run)
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := e.Run(ctx, KindCheck, "default", "api")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Passed).To(BeTrue())
+	g.Expect(res.Runs).To(HaveLen(1))
+	g.Expect(res.Runs[0]).To(HavePrefix("api-eu-"))
+
+	_, err = e.Run(ctx, KindLoadTest, "default", "api")
+	g.Expect(err).To(BeAssignableToTypeOf(badRequest("")))
+
+	orphan := &syntheticv1.Validation{
+		ObjectMeta: metav1.ObjectMeta{Name: "orphan", Namespace: "default"},
+		Spec:       syntheticv1.ValidationSpec{CheckRef: "missing"},
+	}
+	g.Expect(e.Client.Create(ctx, orphan)).To(Succeed())
+	_, err = e.Run(ctx, KindValidation, "default", "orphan")
+	g.Expect(err).To(Equal(ErrNotFound))
+}
+
+func TestServer(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	srv := httptest.NewServer(&Server{
+		Evaluator: newEvaluator(
+			newCheck("api"),
+			finishedRun("api", "eu", 1, syntheticv1.RunFailed),
+		),
+		Authorizer: staticAuthorizer{},
+		Log:        zap.Logger(true),
+	})
+	defer srv.Close()
+
+	// Argo Rollouts reads the result of a failing check.
+	resp := request(g, http.MethodGet, srv.URL+"/analysis/default/checks/api", "reader")
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	var res Result
+	g.Expect(json.NewDecoder(resp.Body).Decode(&res)).To(Succeed())
+	resp.Body.Close()
+	g.Expect(res.Passed).To(BeFalse())
+	g.Expect(res.Finished).To(BeTrue())
+
+	// Flagger only looks at the status code.
+	resp = request(g, http.MethodPost, srv.URL+"/analysis/default/checks/api", "reader")
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusPreconditionFailed))
+
+	for _, c := range []struct {
+		path, token string
+		code        int
+	}{
+		{"/analysis/default/checks/api", "", http.StatusUnauthorized},
+		{"/analysis/default/checks/api", "stranger", http.StatusUnauthorized},
+		{"/analysis/other/checks/api", "reader", http.StatusForbidden},
+		{"/analysis/default/checks/api?run=true", "reader", http.StatusForbidden},
+		{"/analysis/default/checks/missing", "reader", http.StatusNotFound},
+		{"/analysis/default/deployments/api", "reader", http.StatusNotFound},
+		{"/analysis/default/loadtests/api?run=true", "runner", http.StatusBadRequest},
+		{"/analysis/default/checks/api?run=true&timeout=x", "runner", http.StatusBadRequest},
+		{"/metrics", "reader", http.StatusNotFound},
+	} {
+		resp := request(g, http.MethodGet, srv.URL+c.path, c.token)
+		resp.Body.Close()
+		g.Expect(resp.StatusCode).To(Equal(c.code), c.path+" as "+c.token)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	authorizationv1 "k8s.io/api/authorization/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/query"
+)
+
+const (
+	// DefaultRunTimeout bounds the wait for a run started by a request that
+	// does not set a timeout.
+	DefaultRunTimeout = 30 * time.Second
+	// MaxRunTimeout is the longest a request may wait for a run.
+	MaxRunTimeout = 10 * time.Minute
+)
+
+// Server serves the results of objects at
+// /analysis/<namespace>/<kind>/<name>, where kind is checks, validations or
+// loadtests.
+//
+// GET always answers 200 with a Result, as the web metric provider of Argo
+// Rollouts expects, which then evaluates a condition such as
+// "result.passed == true". POST answers 200 if the object passed and 412
+// otherwise, as Flagger webhooks expect. With ?run=true the request starts a
+// run of the check and waits for it, up to ?timeout, instead of reporting the
+// most recent runs.
+//
+// Requests carry a bearer token whose user may get the object, and with
+// ?run=true also create SyntheticRuns in its namespace, as the query API
+// requires.
+type Server struct {
+	Evaluator  *Evaluator
+	Authorizer query.Authorizer
+	Log        logr.Logger
+
+	// Addr is the address the server listens on.
+	Addr string
+
+	// CertDir holds the certificate and key the server is served with over
+	// TLS, as tls.crt and tls.key. The server serves plain HTTP when it is
+	// empty.
+	CertDir string
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "analysis" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	namespace, kind, name := parts[1], parts[2], parts[3]
+	switch kind {
+	case KindCheck, KindValidation, KindLoadTest:
+	default:
+		http.Error(w, "unknown kind "+kind, http.StatusNotFound)
+		return
+	}
+	run := r.URL.Query().Get("run") == "true"
+	attrs := []authorizationv1.ResourceAttributes{{
+		Namespace: namespace,
+		Verb:      "get",
+		Group:     syntheticv1.GroupVersion.Group,
+		Version:   syntheticv1.GroupVersion.Version,
+		Resource:  kind,
+		Name:      name,
+	}}
+	if run {
+		attrs = append(attrs, authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "create",
+			Group:     syntheticv1.GroupVersion.Group,
+			Version:   syntheticv1.GroupVersion.Version,
+			Resource:  "syntheticruns",
+		})
+	}
+	if !query.AuthorizeRequest(w, r, s.Authorizer, s.Log, attrs...) {
+		return
+	}
+
+	ctx := r.Context()
+	var res *Result
+	var err error
+	if run {
+		timeout := DefaultRunTimeout
+		if t := r.URL.Query().Get("timeout"); t != "" {
+			if timeout, err = time.ParseDuration(t); err != nil || timeout <= 0 {
+				http.Error(w, "invalid timeout "+t, http.StatusBadRequest)
+				return
+			}
+			if timeout > MaxRunTimeout {
+				timeout = MaxRunTimeout
+			}
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		res, err = s.Evaluator.Run(ctx, kind, namespace, name)
+	} else {
+		res, err = s.Evaluator.Evaluate(ctx, kind, namespace, name)
+	}
+	switch {
+	case err == ErrNotFound:
+		http.Error(w, kind+" "+namespace+"/"+name+" not found", http.StatusNotFound)
+		return
+	case err != nil:
+		if _, ok := err.(badRequest); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Log.Error(err, "unable to evaluate", "kind", kind, "namespace", namespace, "name", name)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPost && !res.Passed {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// Start serves requests until stop is closed.
+func (s *Server) Start(stop <-chan struct{}) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s}
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	s.Log.Info("serving analysis results", "addr", s.Addr)
+	if s.CertDir != "" {
+		err = srv.ServeTLS(l, filepath.Join(s.CertDir, "tls.crt"), filepath.Join(s.CertDir, "tls.key"))
+	} else {
+		err = srv.Serve(l)
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// cacheIdleTTL is how long an unused TokenSource is kept.
+const cacheIdleTTL = time.Hour
+
+// Shared is the process wide cache of token sources. Every request with the
+// same resolved Config, whether from consecutive check runs or from the
+// virtual users of a load test, shares one token.
+var Shared = NewCache()
+
+// Cache holds one TokenSource per distinct Config.
+type Cache struct {
+	mu      sync.Mutex
+	sources map[string]*cacheEntry
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	source   *TokenSource
+	lastUsed time.Time
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{sources: map[string]*cacheEntry{}, now: time.Now}
+}
+
+// TokenSource returns the TokenSource for cfg, creating it if needed. The
+// cache key covers every field of cfg, secrets included, so a rotated
+// credential gets a fresh token instead of the one issued for the old value.
+func (c *Cache) TokenSource(cfg Config) *TokenSource {
+	key := configKey(cfg)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.sources {
+		if now.Sub(e.lastUsed) > cacheIdleTTL {
+			delete(c.sources, k)
+		}
+	}
+	e, ok := c.sources[key]
+	if !ok {
+		e = &cacheEntry{source: NewTokenSource(cfg, nil)}
+		c.sources[key] = e
+	}
+	e.lastUsed = now
+	return e.source
+}
+
+func configKey(cfg Config) string {
+	data, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
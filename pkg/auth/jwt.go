@@ -0,0 +1,158 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// JWTConfig describes the assertion signed for the jwt-bearer grant.
+type JWTConfig struct {
+	Issuer     string
+	Subject    string
+	Audience   string
+	PrivateKey string
+	KeyID      string
+	Lifetime   time.Duration
+}
+
+// sign returns a compact JWS of the assertion, signed with RS256 or ES256
+// depending on the type of the private key.
+func (j *JWTConfig) sign(cfg Config, now time.Time) (string, error) {
+	key, err := parsePrivateKey([]byte(j.PrivateKey))
+	if err != nil {
+		return "", err
+	}
+
+	iss := j.Issuer
+	if iss == "" {
+		iss = cfg.ClientID
+	}
+	sub := j.Subject
+	if sub == "" {
+		sub = iss
+	}
+	aud := j.Audience
+	if aud == "" {
+		aud = cfg.TokenURL
+	}
+	lifetime := j.Lifetime
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+
+	header := map[string]string{"typ": "JWT"}
+	if j.KeyID != "" {
+		header["kid"] = j.KeyID
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		header["alg"] = "RS256"
+	case *ecdsa.PrivateKey:
+		header["alg"] = "ES256"
+	}
+	claims := map[string]interface{}{
+		"iss": iss,
+		"sub": sub,
+		"aud": aud,
+		"iat": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+		"jti": randomID(),
+	}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			// JWS encodes ECDSA signatures as the fixed size concatenation
+			// of r and s rather than ASN.1.
+			size := (k.Curve.Params().BitSize + 7) / 8
+			sig = make([]byte, 2*size)
+			rb, sb := r.Bytes(), s.Bytes()
+			copy(sig[size-len(rb):size], rb)
+			copy(sig[2*size-len(sb):], sb)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("signing jwt assertion: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt private key is not PEM encoded")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return checkCurve(k)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing jwt private key: %v", err)
+	}
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return checkCurve(k)
+	}
+	return nil, errors.New("jwt private key must be RSA or ECDSA")
+}
+
+// checkCurve rejects ECDSA keys that cannot sign ES256 assertions.
+func checkCurve(k *ecdsa.PrivateKey) (crypto.Signer, error) {
+	if k.Curve.Params().BitSize != 256 {
+		return nil, errors.New("jwt ecdsa keys must use P-256")
+	}
+	return k, nil
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,227 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth obtains and caches credentials for probe requests.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Grant types of Config, as sent to the token endpoint.
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+	GrantJWTBearer         = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	GrantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+const (
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+
+	// refreshMargin is how long before expiry a token is refreshed, so that
+	// requests in flight never carry a token that expires mid request.
+	refreshMargin = 30 * time.Second
+)
+
+// Config is a resolved OAuth2 grant, with every secret already read.
+type Config struct {
+	TokenURL         string
+	GrantType        string
+	ClientID         string
+	ClientSecret     string
+	ClientAuthInBody bool
+	Scopes           []string
+	Audience         string
+	Params           map[string]string
+
+	// Username and Password are used by the password grant.
+	Username string
+	Password string
+
+	// JWT signs the assertion of the jwt-bearer grant.
+	JWT *JWTConfig
+
+	// SubjectToken and SubjectTokenType are used by the token-exchange grant.
+	SubjectToken     string
+	SubjectTokenType string
+}
+
+// Token is an access token issued by an authorization server.
+type Token struct {
+	AccessToken string
+	TokenType   string
+	Expiry      time.Time
+}
+
+// SetAuthHeader sets the Authorization header of req to the token.
+func (t *Token) SetAuthHeader(req *http.Request) {
+	typ := t.TokenType
+	if typ == "" || strings.EqualFold(typ, "bearer") {
+		typ = "Bearer"
+	}
+	req.Header.Set("Authorization", typ+" "+t.AccessToken)
+}
+
+// TokenSource hands out a cached token for a Config and fetches a new one
+// shortly before it expires. It is safe for concurrent use, and concurrent
+// callers share a single fetch.
+type TokenSource struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewTokenSource returns a TokenSource for cfg that calls the token endpoint
+// with client.
+func NewTokenSource(cfg Config, client *http.Client) *TokenSource {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &TokenSource{cfg: cfg, client: client, now: time.Now}
+}
+
+// Token returns a valid token, fetching one if the cached token is missing
+// or about to expire.
+func (s *TokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && (s.token.Expiry.IsZero() || s.now().Add(refreshMargin).Before(s.token.Expiry)) {
+		return s.token, nil
+	}
+	token, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+func (s *TokenSource) fetch(ctx context.Context) (*Token, error) {
+	form, err := s.form()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.cfg.ClientSecret != "" && !s.cfg.ClientAuthInBody {
+		req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+	}
+
+	start := s.now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading token response: %v", err)
+	}
+
+	var tr struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("decoding token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.AccessToken == "" {
+		if tr.Error != "" {
+			return nil, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, tr.Error, tr.ErrorDescription)
+		}
+		return nil, fmt.Errorf("token endpoint returned %d without an access token", resp.StatusCode)
+	}
+
+	token := &Token{AccessToken: tr.AccessToken, TokenType: tr.TokenType}
+	if secs, err := tr.ExpiresIn.Int64(); err == nil && secs > 0 {
+		token.Expiry = start.Add(time.Duration(secs) * time.Second)
+	}
+	return token, nil
+}
+
+// form returns the token request parameters of the configured grant.
+func (s *TokenSource) form() (url.Values, error) {
+	cfg := s.cfg
+	form := url.Values{}
+
+	switch cfg.GrantType {
+	case "", GrantClientCredentials:
+		form.Set("grant_type", GrantClientCredentials)
+	case GrantPassword:
+		form.Set("grant_type", GrantPassword)
+		form.Set("username", cfg.Username)
+		form.Set("password", cfg.Password)
+	case GrantJWTBearer:
+		if cfg.JWT == nil {
+			return nil, fmt.Errorf("jwt-bearer grant requires a jwt assertion")
+		}
+		assertion, err := cfg.JWT.sign(cfg, s.now())
+		if err != nil {
+			return nil, err
+		}
+		form.Set("grant_type", GrantJWTBearer)
+		form.Set("assertion", assertion)
+	case GrantTokenExchange:
+		typ := cfg.SubjectTokenType
+		if typ == "" {
+			typ = accessTokenType
+		}
+		form.Set("grant_type", GrantTokenExchange)
+		form.Set("subject_token", cfg.SubjectToken)
+		form.Set("subject_token_type", typ)
+	default:
+		return nil, fmt.Errorf("unsupported grant type %q", cfg.GrantType)
+	}
+
+	if len(cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+	if cfg.Audience != "" {
+		form.Set("audience", cfg.Audience)
+	}
+	if cfg.ClientID != "" && (cfg.ClientSecret == "" || cfg.ClientAuthInBody) {
+		form.Set("client_id", cfg.ClientID)
+	}
+	if cfg.ClientSecret != "" && cfg.ClientAuthInBody {
+		form.Set("client_secret", cfg.ClientSecret)
+	}
+	for k, v := range cfg.Params {
+		form.Set(k, v)
+	}
+	return form, nil
+}
@@ -0,0 +1,218 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// tokenServer issues numbered tokens and records the last form it received.
+type tokenServer struct {
+	*httptest.Server
+	issued int32
+
+	mu   sync.Mutex
+	form url.Values
+	user string
+	pass string
+}
+
+func newTokenServer(expiresIn int) *tokenServer {
+	s := &tokenServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.mu.Lock()
+		s.form = r.PostForm
+		s.user, s.pass, _ = r.BasicAuth()
+		s.mu.Unlock()
+		if r.PostForm.Get("grant_type") == "password" && r.PostForm.Get("password") != "hunter2" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"bad credentials"}`)
+			return
+		}
+		n := atomic.AddInt32(&s.issued, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	return s
+}
+
+func (s *tokenServer) lastForm() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.form
+}
+
+func TestClientCredentials(t *testing.T) {
+	g := NewGomegaWithT(t)
+	srv := newTokenServer(3600)
+	defer srv.Close()
+
+	ts := NewTokenSource(Config{
+		TokenURL:     srv.URL,
+		ClientID:     "perph",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"read", "write"},
+	}, nil)
+	token, err := ts.Token(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(token.AccessToken).To(Equal("token-1"))
+
+	form := srv.lastForm()
+	g.Expect(form.Get("grant_type")).To(Equal("client_credentials"))
+	g.Expect(form.Get("scope")).To(Equal("read write"))
+	g.Expect(form.Get("client_secret")).To(BeEmpty())
+	g.Expect(srv.user).To(Equal("perph"))
+	g.Expect(srv.pass).To(Equal("s3cret"))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	token.SetAuthHeader(req)
+	g.Expect(req.Header.Get("Authorization")).To(Equal("Bearer token-1"))
+}
+
+func TestPasswordGrantError(t *testing.T) {
+	g := NewGomegaWithT(t)
+	srv := newTokenServer(3600)
+	defer srv.Close()
+
+	ts := NewTokenSource(Config{
+		TokenURL:         srv.URL,
+		GrantType:        GrantPassword,
+		ClientID:         "perph",
+		ClientSecret:     "s3cret",
+		ClientAuthInBody: true,
+		Username:         "alice",
+		Password:         "wrong",
+	}, nil)
+	_, err := ts.Token(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("invalid_grant bad credentials")))
+
+	form := srv.lastForm()
+	g.Expect(form.Get("username")).To(Equal("alice"))
+	g.Expect(form.Get("client_id")).To(Equal("perph"))
+	g.Expect(form.Get("client_secret")).To(Equal("s3cret"))
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+	g := NewGomegaWithT(t)
+	srv := newTokenServer(60)
+	defer srv.Close()
+
+	now := time.Now()
+	ts := NewTokenSource(Config{TokenURL: srv.URL, ClientID: "perph"}, nil)
+	ts.now = func() time.Time { return now }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ts.Token(context.Background())
+		}()
+	}
+	wg.Wait()
+	g.Expect(atomic.LoadInt32(&srv.issued)).To(BeEquivalentTo(1))
+
+	now = now.Add(20 * time.Second)
+	token, err := ts.Token(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(token.AccessToken).To(Equal("token-1"))
+
+	now = now.Add(15 * time.Second)
+	token, err = ts.Token(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(token.AccessToken).To(Equal("token-2"))
+}
+
+func TestJWTBearerAssertion(t *testing.T) {
+	g := NewGomegaWithT(t)
+	srv := newTokenServer(3600)
+	defer srv.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+	der, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	ts := NewTokenSource(Config{
+		TokenURL:  srv.URL,
+		GrantType: GrantJWTBearer,
+		ClientID:  "perph",
+		JWT: &JWTConfig{
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+			KeyID:      "key-1",
+		},
+	}, nil)
+	_, err = ts.Token(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	parts := strings.Split(srv.lastForm().Get("assertion"), ".")
+	g.Expect(parts).To(HaveLen(3))
+
+	var header, claims map[string]interface{}
+	g.Expect(decodeSegment(parts[0], &header)).To(Succeed())
+	g.Expect(decodeSegment(parts[1], &claims)).To(Succeed())
+	g.Expect(header).To(HaveKeyWithValue("alg", "ES256"))
+	g.Expect(header).To(HaveKeyWithValue("kid", "key-1"))
+	g.Expect(claims).To(HaveKeyWithValue("iss", "perph"))
+	g.Expect(claims).To(HaveKeyWithValue("sub", "perph"))
+	g.Expect(claims).To(HaveKeyWithValue("aud", srv.URL))
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(sig).To(HaveLen(64))
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	g.Expect(ecdsa.Verify(&key.PublicKey, digest[:], r, s)).To(BeTrue())
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func TestCacheSharesTokenSources(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := NewCache()
+
+	a := c.TokenSource(Config{TokenURL: "https://auth.example.com/token", ClientID: "perph"})
+	b := c.TokenSource(Config{TokenURL: "https://auth.example.com/token", ClientID: "perph"})
+	other := c.TokenSource(Config{TokenURL: "https://auth.example.com/token", ClientID: "other"})
+	g.Expect(a).To(BeIdenticalTo(b))
+	g.Expect(a).NotTo(BeIdenticalTo(other))
+}
@@ -0,0 +1,49 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dependency finds cycles among the dependencies of Checks.
+package dependency
+
+// Cycle returns a cycle of the graph, which maps each node to the nodes it
+// depends on, that passes through from, as the path from from back to itself.
+// It returns nil when from is not part of a cycle. Nodes the graph does not
+// map are treated as having no dependencies.
+func Cycle(graph map[string][]string, from string) []string {
+	visited := map[string]bool{}
+	var path []string
+	var visit func(node string) bool
+	visit = func(node string) bool {
+		path = append(path, node)
+		for _, dep := range graph[node] {
+			if dep == from {
+				path = append(path, dep)
+				return true
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if visit(dep) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(from) {
+		return path
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dependency
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCycle(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	graph := map[string][]string{
+		"api":      {"database", "cache"},
+		"frontend": {"api"},
+		"cache":    {"database"},
+	}
+	g.Expect(Cycle(graph, "api")).To(BeNil())
+	g.Expect(Cycle(graph, "frontend")).To(BeNil())
+	g.Expect(Cycle(graph, "unknown")).To(BeNil())
+
+	graph["database"] = []string{"frontend"}
+	g.Expect(Cycle(graph, "database")).To(Equal([]string{"database", "frontend", "api", "database"}))
+	g.Expect(Cycle(graph, "cache")).To(Equal([]string{"cache", "database", "frontend", "api", "cache"}))
+
+	// A cycle the node only leads into does not pass through it.
+	graph = map[string][]string{
+		"frontend": {"api"},
+		"api":      {"cache"},
+		"cache":    {"api"},
+	}
+	g.Expect(Cycle(graph, "frontend")).To(BeNil())
+	g.Expect(Cycle(graph, "api")).To(Equal([]string{"api", "cache", "api"}))
+
+	g.Expect(Cycle(map[string][]string{"api": {"api"}}, "api")).To(Equal([]string{"api", "api"}))
+}
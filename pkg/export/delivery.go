@@ -0,0 +1,194 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds requests of deliveries that do not set a timeout.
+const DefaultTimeout = 30 * time.Second
+
+// Delivery batches rows into requests and retries those that fail.
+type Delivery struct {
+	// BatchSize is the number of rows per request.
+	BatchSize int
+	// BatchAge is how long a row waits for more to be sent with.
+	BatchAge time.Duration
+
+	// Retries is the number of times a request is retried after a network
+	// error or a response asking for one, waiting Backoff before the first
+	// retry and doubling the wait for each further one.
+	Retries int
+	Backoff time.Duration
+
+	// Timeout bounds every attempt to send a request. Defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// Gzip compresses request bodies.
+	Gzip bool
+
+	// ResourceLabels maps the labels of rows to the tags or resource
+	// attributes they are sent with.
+	ResourceLabels map[string]string
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Due returns true once there are BatchSize rows, or the oldest row is
+// BatchAge old.
+func (d *Delivery) Due(rows []Row, now time.Time) (bool, time.Time, error) {
+	if len(rows) == 0 {
+		return false, time.Time{}, nil
+	}
+	deadline := rows[0].Time.Add(d.BatchAge)
+	return len(rows) >= d.BatchSize || !now.Before(deadline), deadline, nil
+}
+
+// deliver sends rows in batches of BatchSize with send, and returns how
+// many were sent.
+func (d *Delivery) deliver(ctx context.Context, rows []Row, send func(context.Context, []Row) error) (int, error) {
+	size := d.BatchSize
+	if size <= 0 {
+		size = len(rows)
+	}
+	sent := 0
+	for sent < len(rows) {
+		end := sent + size
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := rows[sent:end]
+		if err := d.retry(ctx, func() error { return send(ctx, batch) }); err != nil {
+			return sent, err
+		}
+		sent = end
+	}
+	return sent, nil
+}
+
+// temporary marks errors that retrying may resolve.
+type temporary struct {
+	error
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// temporary, or Retries are exhausted.
+func (d *Delivery) retry(ctx context.Context, fn func() error) error {
+	backoff := d.Backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		t, ok := err.(temporary)
+		if !ok {
+			return err
+		}
+		if attempt >= d.Retries {
+			return t.error
+		}
+		select {
+		case <-ctx.Done():
+			return t.error
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// compress returns body, gzipped if the delivery compresses bodies.
+func (d *Delivery) compress(body []byte) []byte {
+	if !d.Gzip {
+		return body
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(body)
+	w.Close()
+	return buf.Bytes()
+}
+
+// withTimeout returns ctx bounded by the timeout of a request.
+func (d *Delivery) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (d *Delivery) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return http.DefaultClient
+}
+
+// post sends body to url with content type typ and header. Network errors,
+// 429 and 5xx responses are temporary.
+func (d *Delivery) post(ctx context.Context, url, typ string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(d.compress(body)))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", typ)
+	if d.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return temporary{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("post %s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+		return temporary{err}
+	}
+	return err
+}
+
+// resource returns the tags or attributes r maps to through ResourceLabels,
+// sorted by name.
+func (d *Delivery) resource(r *Row) [][2]string {
+	var out [][2]string
+	for label, name := range d.ResourceLabels {
+		if v, ok := r.Labels[label]; ok {
+			out = append(out, [2]string{name, v})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+	return out
+}
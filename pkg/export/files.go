@@ -0,0 +1,171 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Sink sends rows to an external system.
+type Sink interface {
+	// Due returns true if the pending rows, oldest first, should be sent at
+	// now, and when they will be otherwise.
+	Due(rows []Row, now time.Time) (bool, time.Time, error)
+
+	// Write sends rows, oldest first, and returns how many of the oldest
+	// were sent, which is all of them unless it fails.
+	Write(ctx context.Context, rows []Row) (int, error)
+}
+
+// ObjectWriter writes objects to a bucket.
+type ObjectWriter interface {
+	PutObject(ctx context.Context, key, typ string, body []byte) error
+}
+
+// Files writes rows to an object store as files, in partitions the key
+// template of each row names.
+//
+// Rows are written together once the oldest is MaxAge old or they reach
+// MaxSize, whichever comes first. Names of files are derived from their
+// first row, so writing the same rows again after a failure overwrites the
+// files instead of duplicating them.
+type Files struct {
+	Writer ObjectWriter
+	Format Format
+
+	// Prefix is prepended to every key.
+	Prefix string
+
+	// KeyTemplate is the partition of a row, with $(date), $(hour),
+	// $(namespace), $(kind), $(name) and $(location) replaced with those of
+	// the row.
+	KeyTemplate string
+
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+// Due returns true if the pending rows, oldest first, should be written at
+// now, and when they will be otherwise.
+func (f *Files) Due(rows []Row, now time.Time) (bool, time.Time, error) {
+	if len(rows) == 0 {
+		return false, time.Time{}, nil
+	}
+	deadline := rows[0].Time.Add(f.MaxAge)
+	if !now.Before(deadline) {
+		return true, deadline, nil
+	}
+	data, err := f.Format.Encode(rows)
+	if err != nil {
+		return false, deadline, err
+	}
+	return int64(len(data)) >= f.MaxSize, deadline, nil
+}
+
+// Write writes rows, oldest first, as files of at most MaxSize per
+// partition, unless a single row exceeds it. Files are not written in the
+// order of rows, so no row counts as written unless all are.
+func (f *Files) Write(ctx context.Context, rows []Row) (int, error) {
+	var order []string
+	partitions := map[string][]Row{}
+	for i := range rows {
+		p, err := Partition(f.KeyTemplate, &rows[i])
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := partitions[p]; !ok {
+			order = append(order, p)
+		}
+		partitions[p] = append(partitions[p], rows[i])
+	}
+
+	for _, p := range order {
+		files, err := f.split(partitions[p])
+		if err != nil {
+			return 0, err
+		}
+		for _, file := range files {
+			first := file.rows[0]
+			name := fmt.Sprintf("%s-%s.%s", first.Time.UTC().Format("20060102T150405Z"), first.Run, f.Format.Extension())
+			key := strings.TrimPrefix(path.Join(f.Prefix, p, name), "/")
+			if err := f.Writer.PutObject(ctx, key, f.Format.ContentType(), file.data); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(rows), nil
+}
+
+type file struct {
+	rows []Row
+	data []byte
+}
+
+// split encodes rows into files of at most MaxSize, halving the rows until
+// they fit.
+func (f *Files) split(rows []Row) ([]file, error) {
+	data, err := f.Format.Encode(rows)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) <= f.MaxSize || len(rows) == 1 {
+		return []file{{rows: rows, data: data}}, nil
+	}
+	head, err := f.split(rows[:len(rows)/2])
+	if err != nil {
+		return nil, err
+	}
+	tail, err := f.split(rows[len(rows)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return append(head, tail...), nil
+}
+
+// Partition returns the key template tmpl expanded for r.
+func Partition(tmpl string, r *Row) (string, error) {
+	vars := map[string]string{
+		"date":      r.Time.UTC().Format("2006-01-02"),
+		"hour":      r.Time.UTC().Format("15"),
+		"namespace": r.Namespace,
+		"kind":      r.Kind,
+		"name":      r.Name,
+		"location":  r.Location,
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(tmpl, "$(")
+		if i < 0 {
+			b.WriteString(tmpl)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(tmpl[i:], ')')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in key template at %q", tmpl[i:])
+		}
+		v, ok := vars[tmpl[i+2:i+end]]
+		if !ok {
+			return "", fmt.Errorf("unknown variable %q in key template", tmpl[i:i+end+1])
+		}
+		b.WriteString(tmpl[:i])
+		b.WriteString(v)
+		tmpl = tmpl[i+end+1:]
+	}
+}
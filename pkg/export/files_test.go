@@ -0,0 +1,196 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+var start = time.Date(2019, 7, 1, 23, 58, 0, 0, time.UTC)
+
+// rows returns n rows of the check api in eu, a minute apart.
+func rows(n int) []Row {
+	var out []Row
+	for i := 0; i < n; i++ {
+		out = append(out, Row{
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Namespace: "default",
+			Kind:      KindCheck,
+			Name:      "api",
+			Location:  "eu",
+			Run:       fmt.Sprintf("api-%d", i),
+			Phase:     "Succeeded",
+			Duration:  12.5,
+		})
+	}
+	return out
+}
+
+func TestRowOf(t *testing.T) {
+	g := NewGomegaWithT(t)
+	done := metav1.NewTime(start)
+	run := &syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "default"},
+		Spec:       syntheticv1.SyntheticRunSpec{CheckRef: "api", Location: "eu"},
+		Status: syntheticv1.SyntheticRunStatus{
+			Phase:          syntheticv1.RunFailed,
+			CompletionTime: &done,
+			SilencedBy:     "upgrade",
+			StatusCode:     503,
+			Timings: &syntheticv1.PhaseTimings{
+				DNS:   metav1.Duration{Duration: 1500 * time.Microsecond},
+				Total: metav1.Duration{Duration: 20 * time.Millisecond},
+			},
+		},
+	}
+	g.Expect(RowOf(run)).To(Equal(Row{
+		Time:       start,
+		Namespace:  "default",
+		Kind:       KindCheck,
+		Name:       "api",
+		Location:   "eu",
+		Run:        "api-1",
+		Phase:      "Failed",
+		Silenced:   true,
+		StatusCode: 503,
+		Duration:   20,
+		DNS:        1.5,
+	}))
+}
+
+func TestPartition(t *testing.T) {
+	g := NewGomegaWithT(t)
+	r := rows(1)[0]
+
+	p, err := Partition(syntheticv1.DefaultExportKeyTemplate, &r)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(p).To(Equal("check/date=2019-07-01/name=api"))
+	p, err = Partition("$(namespace)/date=$(date)/hour=$(hour)/location=$(location)", &r)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(p).To(Equal("default/date=2019-07-01/hour=23/location=eu"))
+
+	_, err = Partition("date=$(day)", &r)
+	g.Expect(err).To(MatchError(`unknown variable "$(day)" in key template`))
+	_, err = Partition("date=$(date", &r)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestFormats(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	f, err := FormatOf(syntheticv1.ExportCSV)
+	g.Expect(err).NotTo(HaveOccurred())
+	data, err := f.Encode(rows(2))
+	g.Expect(err).NotTo(HaveOccurred())
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(records).To(HaveLen(3))
+	g.Expect(records[0][:4]).To(Equal([]string{"time", "namespace", "kind", "name"}))
+	g.Expect(records[2][:4]).To(Equal([]string{"2019-07-01T23:59:00Z", "default", "check", "api"}))
+	g.Expect(records[2][10]).To(Equal("12.5"))
+
+	f, err = FormatOf(syntheticv1.ExportJSONL)
+	g.Expect(err).NotTo(HaveOccurred())
+	data, err = f.Encode(rows(2))
+	g.Expect(err).NotTo(HaveOccurred())
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	g.Expect(lines).To(HaveLen(2))
+	var row map[string]interface{}
+	g.Expect(json.Unmarshal([]byte(lines[1]), &row)).To(Succeed())
+	g.Expect(row).To(HaveKeyWithValue("run", "api-1"))
+	g.Expect(row).To(HaveKeyWithValue("duration_ms", 12.5))
+
+	_, err = FormatOf("XML")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestFiles(t *testing.T) {
+	g := NewGomegaWithT(t)
+	fake, srv := newFakeS3("lake")
+	defer srv.Close()
+
+	files := &Files{
+		Writer: &S3{
+			Endpoint:        srv.URL,
+			Region:          "us-east-1",
+			Bucket:          "lake",
+			PathStyle:       true,
+			AccessKeyID:     testAccessKey,
+			SecretAccessKey: testSecretKey,
+		},
+		Format:      jsonlFormat{},
+		Prefix:      "runs",
+		KeyTemplate: "date=$(date)/check=$(name)",
+		MaxSize:     1 << 20,
+		MaxAge:      5 * time.Minute,
+	}
+
+	// Rows wait for MaxAge after the oldest, or until they reach MaxSize.
+	due, at, err := files.Due(nil, start)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(due).To(BeFalse())
+	g.Expect(at.IsZero()).To(BeTrue())
+	due, at, err = files.Due(rows(3), start.Add(4*time.Minute))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(due).To(BeFalse())
+	g.Expect(at).To(Equal(start.Add(5 * time.Minute)))
+	due, _, err = files.Due(rows(3), start.Add(5*time.Minute))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(due).To(BeTrue())
+
+	one, err := jsonlFormat{}.Encode(rows(1))
+	g.Expect(err).NotTo(HaveOccurred())
+	files.MaxSize = int64(2 * len(one))
+	due, _, err = files.Due(rows(2), start)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(due).To(BeTrue())
+
+	// Rows are partitioned by day, then split into files of at most MaxSize.
+	n, err := files.Write(context.Background(), rows(6))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(n).To(Equal(6))
+	var keys []string
+	for key := range fake.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	g.Expect(keys).To(Equal([]string{
+		"runs/date=2019-07-01/check=api/20190701T235800Z-api-0.jsonl",
+		"runs/date=2019-07-02/check=api/20190702T000000Z-api-2.jsonl",
+		"runs/date=2019-07-02/check=api/20190702T000200Z-api-4.jsonl",
+	}))
+	for _, key := range keys {
+		g.Expect(int64(len(fake.objects[key].body))).To(BeNumerically("<=", files.MaxSize))
+		g.Expect(fake.objects[key].contentType).To(Equal("application/x-ndjson"))
+	}
+
+	// Writing again overwrites the same files.
+	_, err = files.Write(context.Background(), rows(6))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(fake.objects).To(HaveLen(3))
+}
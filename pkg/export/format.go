@@ -0,0 +1,88 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// Format encodes rows into a file.
+type Format interface {
+	// Extension is the extension of file names, without the dot.
+	Extension() string
+	ContentType() string
+	Encode(rows []Row) ([]byte, error)
+}
+
+// FormatOf returns the Format f names.
+func FormatOf(f syntheticv1.ExportFormat) (Format, error) {
+	switch f {
+	case syntheticv1.ExportCSV:
+		return csvFormat{}, nil
+	case syntheticv1.ExportJSONL, "":
+		return jsonlFormat{}, nil
+	case syntheticv1.ExportParquet:
+		return parquetFormat{}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", f)
+}
+
+// csvFormat writes a header line with the names of the columns, then a line
+// per row.
+type csvFormat struct{}
+
+func (csvFormat) Extension() string   { return "csv" }
+func (csvFormat) ContentType() string { return "text/csv" }
+
+func (csvFormat) Encode(rows []Row) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		record[i] = c.name
+	}
+	w.Write(record)
+	for i := range rows {
+		for j, c := range columns {
+			record[j] = text(c.get(&rows[i]))
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// jsonlFormat writes a JSON object per row and line.
+type jsonlFormat struct{}
+
+func (jsonlFormat) Extension() string   { return "jsonl" }
+func (jsonlFormat) ContentType() string { return "application/x-ndjson" }
+
+func (jsonlFormat) Encode(rows []Row) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range rows {
+		if err := enc.Encode(&rows[i]); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,151 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Influx writes rows to InfluxDB in line protocol, one point per row.
+type Influx struct {
+	Delivery
+
+	// URL is the base URL of InfluxDB.
+	URL string
+
+	// Org and Bucket select where points are written in InfluxDB 2, and
+	// Database in InfluxDB 1 when Bucket is empty.
+	Org      string
+	Bucket   string
+	Database string
+
+	Measurement string
+
+	// Token authorizes requests when set.
+	Token string
+}
+
+// Write sends rows in batches.
+func (i *Influx) Write(ctx context.Context, rows []Row) (int, error) {
+	u, err := i.writeURL()
+	if err != nil {
+		return 0, err
+	}
+	header := http.Header{}
+	if i.Token != "" {
+		header.Set("Authorization", "Token "+i.Token)
+	}
+	return i.deliver(ctx, rows, func(ctx context.Context, batch []Row) error {
+		return i.post(ctx, u, "text/plain; charset=utf-8", i.Lines(batch), header)
+	})
+}
+
+// writeURL returns the URL of the write endpoint of the InfluxDB version
+// the sink is configured for.
+func (i *Influx) writeURL() (string, error) {
+	u, err := url.Parse(i.URL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid URL %q", i.URL)
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	q := url.Values{"precision": {"ns"}}
+	switch {
+	case i.Bucket != "":
+		u.Path = base + "/api/v2/write"
+		q.Set("org", i.Org)
+		q.Set("bucket", i.Bucket)
+	case i.Database != "":
+		u.Path = base + "/write"
+		q.Set("db", i.Database)
+	default:
+		return "", fmt.Errorf("either bucket or database must be set")
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Lines encodes rows in line protocol.
+func (i *Influx) Lines(rows []Row) []byte {
+	var buf bytes.Buffer
+	for n := range rows {
+		r := &rows[n]
+		buf.WriteString(lineEscaper.Replace(i.Measurement))
+
+		tags := append(i.resource(r), [][2]string{
+			{"namespace", r.Namespace},
+			{"kind", r.Kind},
+			{"name", r.Name},
+			{"location", r.Location},
+			{"phase", r.Phase},
+		}...)
+		sort.SliceStable(tags, func(a, b int) bool { return tags[a][0] < tags[b][0] })
+		for _, tag := range tags {
+			// Line protocol has no empty tag values.
+			if tag[1] != "" {
+				buf.WriteString("," + tagEscaper.Replace(tag[0]) + "=" + tagEscaper.Replace(tag[1]))
+			}
+		}
+
+		fields := []string{
+			"run=" + quote(r.Run),
+			"success=" + strconv.FormatBool(r.Phase == "Succeeded"),
+			"silenced=" + strconv.FormatBool(r.Silenced),
+			"duration_ms=" + strconv.FormatFloat(r.Duration, 'f', -1, 64),
+		}
+		if r.RootCause != "" {
+			fields = append(fields, "root_cause="+quote(r.RootCause))
+		}
+		switch r.Kind {
+		case KindCheck:
+			fields = append(fields,
+				"dns_ms="+strconv.FormatFloat(r.DNS, 'f', -1, 64),
+				"connect_ms="+strconv.FormatFloat(r.Connect, 'f', -1, 64),
+				"tls_ms="+strconv.FormatFloat(r.TLS, 'f', -1, 64),
+				"first_byte_ms="+strconv.FormatFloat(r.FirstByte, 'f', -1, 64),
+				"transfer_ms="+strconv.FormatFloat(r.Transfer, 'f', -1, 64))
+			if r.StatusCode != 0 {
+				fields = append(fields, "status_code="+strconv.Itoa(int(r.StatusCode))+"i")
+			}
+		case KindLoadTest:
+			fields = append(fields,
+				"requests="+strconv.FormatInt(r.Requests, 10)+"i",
+				"failures="+strconv.FormatInt(r.Failures, 10)+"i",
+				"p95_ms="+strconv.FormatFloat(r.P95, 'f', -1, 64))
+		}
+		buf.WriteString(" " + strings.Join(fields, ",") + " " + strconv.FormatInt(r.Time.UnixNano(), 10) + "\n")
+	}
+	return buf.Bytes()
+}
+
+var (
+	lineEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper  = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// quote returns s as a string field value.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,137 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLines(t *testing.T) {
+	g := NewGomegaWithT(t)
+	i := &Influx{
+		Delivery:    Delivery{ResourceLabels: map[string]string{"team": "team", "app.kubernetes.io/name": "app"}},
+		Measurement: "perph run",
+	}
+	in := rows(2)
+	in[0].Labels = map[string]string{"team": "payments, eu", "other": "x"}
+	in[0].StatusCode = 200
+	in[1].Kind, in[1].Name, in[1].Location = KindLoadTest, "checkout", ""
+	in[1].Phase, in[1].RootCause = "Failed", `dependency "db" is failing`
+	in[1].Requests, in[1].Failures, in[1].P95 = 1000, 3, 250.5
+
+	lines := strings.Split(strings.TrimSpace(string(i.Lines(in))), "\n")
+	g.Expect(lines).To(Equal([]string{
+		`perph\ run,kind=check,location=eu,name=api,namespace=default,phase=Succeeded,team=payments\,\ eu ` +
+			`run="api-0",success=true,silenced=false,duration_ms=12.5,dns_ms=0,connect_ms=0,tls_ms=0,first_byte_ms=0,transfer_ms=0,status_code=200i ` +
+			"1562025480000000000",
+		`perph\ run,kind=loadtest,name=checkout,namespace=default,phase=Failed ` +
+			`run="api-1",success=false,silenced=false,duration_ms=12.5,root_cause="dependency \"db\" is failing",requests=1000i,failures=3i,p95_ms=250.5 ` +
+			"1562025540000000000",
+	}))
+}
+
+func TestInfluxURL(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	u, err := (&Influx{URL: "http://influxdb:8086/", Org: "perph", Bucket: "runs"}).writeURL()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(u).To(Equal("http://influxdb:8086/api/v2/write?bucket=runs&org=perph&precision=ns"))
+	u, err = (&Influx{URL: "http://influxdb:8086", Database: "runs"}).writeURL()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(u).To(Equal("http://influxdb:8086/write?db=runs&precision=ns"))
+	_, err = (&Influx{URL: "http://influxdb:8086"}).writeURL()
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestInfluxWrite(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var mu sync.Mutex
+	var bodies []string
+	failures := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failures > 0 {
+			failures--
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		g.Expect(r.Header.Get("Content-Encoding")).To(Equal("gzip"))
+		zr, err := gzip.NewReader(r.Body)
+		g.Expect(err).NotTo(HaveOccurred())
+		body, _ := ioutil.ReadAll(zr)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	i := &Influx{
+		Delivery: Delivery{
+			BatchSize: 2,
+			BatchAge:  time.Minute,
+			Retries:   1,
+			Backoff:   time.Millisecond,
+			Gzip:      true,
+		},
+		URL:         srv.URL,
+		Database:    "runs",
+		Measurement: "perph_run",
+		Token:       "secret",
+	}
+
+	due, _, err := i.Due(rows(1), start)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(due).To(BeFalse())
+	due, _, err = i.Due(rows(2), start)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(due).To(BeTrue())
+
+	// The first request is retried, then each batch is sent once.
+	n, err := i.Write(context.Background(), rows(5))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(n).To(Equal(5))
+	g.Expect(bodies).To(HaveLen(3))
+	g.Expect(strings.Count(bodies[0], "\n")).To(Equal(2))
+	g.Expect(bodies[2]).To(HavePrefix("perph_run,"))
+	g.Expect(bodies[2]).To(ContainSubstring(`run="api-4"`))
+
+	// Batches that fail for good stop the write.
+	failures = 2
+	n, err = i.Write(context.Background(), rows(5))
+	g.Expect(err).To(MatchError(ContainSubstring("503 Service Unavailable: overloaded")))
+	g.Expect(n).To(Equal(0))
+
+	i.Token = "wrong"
+	failures = 0
+	n, err = i.Write(context.Background(), rows(1))
+	g.Expect(err).To(MatchError(ContainSubstring("401")))
+	g.Expect(n).To(Equal(0))
+}
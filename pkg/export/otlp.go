@@ -0,0 +1,307 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// OTLP sends rows to an OpenTelemetry collector as gauges, over OTLP/HTTP
+// with protobuf bodies or over gRPC.
+type OTLP struct {
+	Delivery
+
+	// Endpoint is the URL of the collector.
+	Endpoint string
+
+	// GRPC calls the MetricsService of the collector instead of posting to
+	// /v1/metrics.
+	GRPC bool
+
+	// Header is sent with every request.
+	Header http.Header
+}
+
+// Paths of the Export methods of the MetricsService and TraceService.
+const (
+	grpcMetricsExport = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	grpcTracesExport  = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+)
+
+var (
+	// dialer bounds connecting to collectors, which the HTTP/2 transport
+	// does not do on its own.
+	dialer = &net.Dialer{Timeout: 30 * time.Second}
+
+	// grpcClient calls collectors at https endpoints, and h2cClient those
+	// at http endpoints, over HTTP/2 without TLS.
+	grpcClient = &http.Client{Transport: &http2.Transport{
+		DialTLS: func(network, addr string, config *tls.Config) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, network, addr, config)
+		},
+	}}
+	h2cClient = &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+	}}
+)
+
+// Write sends rows in batches.
+func (o *OTLP) Write(ctx context.Context, rows []Row) (int, error) {
+	return o.deliver(ctx, rows, func(ctx context.Context, batch []Row) error {
+		return o.export(ctx, "/v1/metrics", grpcMetricsExport, o.Request(batch))
+	})
+}
+
+// export sends msg, an Export request, to path on the collector, or to
+// method when calling it over gRPC.
+func (o *OTLP) export(ctx context.Context, path, method string, msg []byte) error {
+	u, err := url.Parse(o.Endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q", o.Endpoint)
+	}
+	base := strings.TrimSuffix(o.Endpoint, "/")
+	if o.GRPC {
+		return o.call(ctx, u.Scheme, base+method, msg)
+	}
+	return o.post(ctx, base+path, "application/x-protobuf", msg, o.Header)
+}
+
+// call sends msg to the Export method at u. Statuses that ask for a retry
+// are temporary.
+func (o *OTLP) call(ctx context.Context, scheme, u string, msg []byte) error {
+	// Messages are framed by a compression flag and their length.
+	frame := []byte{0, 0, 0, 0, 0}
+	if o.Gzip {
+		frame[0] = 1
+		msg = o.compress(msg)
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(append(frame, msg...)))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.withTimeout(ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+	for name, values := range o.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if o.Gzip {
+		req.Header.Set("Grpc-Encoding", "gzip")
+	}
+
+	client := o.Client
+	switch {
+	case client != nil:
+	case scheme == "http":
+		client = h2cClient
+	default:
+		client = grpcClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return temporary{err}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("export: %s", resp.Status)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+			return temporary{err}
+		}
+		return err
+	}
+
+	// Trailers-only responses carry the status in their headers.
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status == "0" {
+		return nil
+	}
+	if m, err := url.PathUnescape(message); err == nil {
+		message = m
+	}
+	err = fmt.Errorf("export: grpc status %s: %s", status, message)
+	switch status {
+	case "4", "8", "10", "14":
+		// DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED, ABORTED and UNAVAILABLE.
+		return temporary{err}
+	}
+	return err
+}
+
+// gauge is a metric sent per row.
+type gauge struct {
+	name string
+	unit string
+	kind string
+	// value returns the value of the gauge for a row as a double, or as an
+	// integer if integer is set, and false if the row has none.
+	value   func(r *Row) (float64, bool)
+	integer bool
+}
+
+func always(f func(r *Row) float64) func(r *Row) (float64, bool) {
+	return func(r *Row) (float64, bool) { return f(r), true }
+}
+
+var gauges = []gauge{
+	{name: "perph.run.duration", unit: "ms", value: always(func(r *Row) float64 { return r.Duration })},
+	{name: "perph.run.success", unit: "1", integer: true, value: always(func(r *Row) float64 {
+		if r.Phase == "Succeeded" {
+			return 1
+		}
+		return 0
+	})},
+	{name: "perph.check.dns", unit: "ms", kind: KindCheck, value: always(func(r *Row) float64 { return r.DNS })},
+	{name: "perph.check.connect", unit: "ms", kind: KindCheck, value: always(func(r *Row) float64 { return r.Connect })},
+	{name: "perph.check.tls", unit: "ms", kind: KindCheck, value: always(func(r *Row) float64 { return r.TLS })},
+	{name: "perph.check.first_byte", unit: "ms", kind: KindCheck, value: always(func(r *Row) float64 { return r.FirstByte })},
+	{name: "perph.check.transfer", unit: "ms", kind: KindCheck, value: always(func(r *Row) float64 { return r.Transfer })},
+	{name: "perph.check.status_code", unit: "1", kind: KindCheck, integer: true, value: func(r *Row) (float64, bool) {
+		return float64(r.StatusCode), r.StatusCode != 0
+	}},
+	{name: "perph.loadtest.requests", unit: "{request}", kind: KindLoadTest, integer: true, value: always(func(r *Row) float64 { return float64(r.Requests) })},
+	{name: "perph.loadtest.failures", unit: "{request}", kind: KindLoadTest, integer: true, value: always(func(r *Row) float64 { return float64(r.Failures) })},
+	{name: "perph.loadtest.latency.p95", unit: "ms", kind: KindLoadTest, value: always(func(r *Row) float64 { return r.P95 })},
+}
+
+// Request encodes rows as an ExportMetricsServiceRequest, with a resource
+// per namespace and set of resource attributes.
+func (o *OTLP) Request(rows []Row) []byte {
+	type resource struct {
+		attrs [][2]string
+		rows  []*Row
+	}
+	var order []string
+	resources := map[string]*resource{}
+	for i := range rows {
+		r := &rows[i]
+		attrs := append([][2]string{
+			{"service.name", "perph"},
+			{"k8s.namespace.name", r.Namespace},
+		}, o.resource(r)...)
+		var key []string
+		for _, kv := range attrs {
+			key = append(key, kv[0]+"="+kv[1])
+		}
+		k := strings.Join(key, ",")
+		if resources[k] == nil {
+			resources[k] = &resource{attrs: attrs}
+			order = append(order, k)
+		}
+		resources[k].rows = append(resources[k].rows, r)
+	}
+	sort.Strings(order)
+
+	var req protobuf
+	for _, k := range order {
+		res := resources[k]
+		req.message(1, func(rm *protobuf) {
+			rm.message(1, func(r *protobuf) {
+				for _, kv := range res.attrs {
+					r.message(1, func(attr *protobuf) { stringAttribute(attr, kv[0], kv[1]) })
+				}
+			})
+			rm.message(2, func(sm *protobuf) {
+				sm.message(1, func(scope *protobuf) { scope.string(1, "perph") })
+				for _, g := range gauges {
+					writeGauge(sm, g, res.rows)
+				}
+			})
+		})
+	}
+	return req.Bytes()
+}
+
+// writeGauge writes a Metric of g to sm with a point per row that has a
+// value of g, unless there are none.
+func writeGauge(sm *protobuf, g gauge, rows []*Row) {
+	var points []*Row
+	for _, r := range rows {
+		if _, ok := g.value(r); ok && (g.kind == "" || g.kind == r.Kind) {
+			points = append(points, r)
+		}
+	}
+	if len(points) == 0 {
+		return
+	}
+	sm.message(2, func(m *protobuf) {
+		m.string(1, g.name)
+		m.string(3, g.unit)
+		m.message(5, func(gauge *protobuf) {
+			for _, r := range points {
+				v, _ := g.value(r)
+				gauge.message(1, func(p *protobuf) {
+					p.fixed64(3, uint64(r.Time.UnixNano()))
+					if g.integer {
+						p.fixed64(6, uint64(int64(v)))
+					} else {
+						p.double(4, v)
+					}
+					for _, kv := range [][2]string{
+						{"perph.kind", r.Kind},
+						{"perph.name", r.Name},
+						{"perph.location", r.Location},
+						{"perph.phase", r.Phase},
+					} {
+						p.message(7, func(attr *protobuf) { stringAttribute(attr, kv[0], kv[1]) })
+					}
+					p.message(7, func(attr *protobuf) {
+						attr.string(1, "perph.silenced")
+						attr.message(2, func(v *protobuf) {
+							var b uint64
+							if r.Silenced {
+								b = 1
+							}
+							v.uint(2, b)
+						})
+					})
+				})
+			}
+		})
+	})
+}
+
+// stringAttribute writes a KeyValue with a string value.
+func stringAttribute(kv *protobuf, key, value string) {
+	kv.string(1, key)
+	kv.message(2, func(v *protobuf) { v.string(1, value) })
+}
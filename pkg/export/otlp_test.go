@@ -0,0 +1,256 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// pmsg is a decoded protobuf message, with the values of each field as
+// uint64 for varint and fixed64 fields, and []byte for the others.
+type pmsg map[int][]interface{}
+
+func decode(b []byte) pmsg {
+	m := pmsg{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case protoVarint:
+			v, n := binary.Uvarint(b)
+			b = b[n:]
+			m[field] = append(m[field], v)
+		case protoFixed64:
+			m[field] = append(m[field], binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case protoBytes:
+			l, n := binary.Uvarint(b)
+			m[field] = append(m[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			panic("unsupported wire type")
+		}
+	}
+	return m
+}
+
+func (m pmsg) msgs(field int) []pmsg {
+	var out []pmsg
+	for _, v := range m[field] {
+		out = append(out, decode(v.([]byte)))
+	}
+	return out
+}
+
+func (m pmsg) msg(field int) pmsg {
+	return m.msgs(field)[0]
+}
+
+func (m pmsg) str(field int) string {
+	return string(m[field][0].([]byte))
+}
+
+// attributes returns the string attributes of a list of KeyValues.
+func attributes(kvs []pmsg) map[string]string {
+	out := map[string]string{}
+	for _, kv := range kvs {
+		if v := kv.msg(2); v[1] != nil {
+			out[kv.str(1)] = v.str(1)
+		}
+	}
+	return out
+}
+
+// collector records the metrics and spans exported to it, and fails the
+// first requests as it is told to.
+type collector struct {
+	mu       sync.Mutex
+	failures int
+	requests []pmsg
+	paths    []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+
+	if r.URL.Path == "/v1/metrics" || r.URL.Path == "/v1/traces" {
+		if r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		if c.failures > 0 {
+			c.failures--
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+		c.requests = append(c.requests, decode(body))
+		c.paths = append(c.paths, r.URL.Path)
+		return
+	}
+
+	if r.URL.Path != grpcMetricsExport && r.URL.Path != grpcTracesExport {
+		http.NotFound(w, r)
+		return
+	}
+	if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	if c.failures > 0 {
+		c.failures--
+		w.Header().Set("Grpc-Status", "14")
+		w.Header().Set("Grpc-Message", "try%20again")
+		return
+	}
+	w.Header().Set("Trailer", "Grpc-Status")
+	msg := body[5:]
+	if body[0] == 1 {
+		zr, _ := gzip.NewReader(bytes.NewReader(msg))
+		msg, _ = ioutil.ReadAll(zr)
+	}
+	c.requests = append(c.requests, decode(msg))
+	c.paths = append(c.paths, r.URL.Path)
+	w.Write([]byte{0, 0, 0, 0, 0})
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func TestOTLPRequest(t *testing.T) {
+	g := NewGomegaWithT(t)
+	o := &OTLP{Delivery: Delivery{ResourceLabels: map[string]string{"team": "team.name"}}}
+	in := rows(3)
+	in[0].Labels = map[string]string{"team": "payments"}
+	in[1].Labels = map[string]string{"team": "payments"}
+	in[1].StatusCode = 503
+	in[1].Phase = "Failed"
+	in[2].Kind, in[2].Name, in[2].Requests = KindLoadTest, "checkout", 1000
+
+	req := decode(o.Request(in))
+	resources := req.msgs(1)
+	g.Expect(resources).To(HaveLen(2))
+	g.Expect(attributes(resources[0].msg(1).msgs(1))).To(Equal(map[string]string{
+		"service.name":       "perph",
+		"k8s.namespace.name": "default",
+	}))
+	g.Expect(attributes(resources[1].msg(1).msgs(1))).To(HaveKeyWithValue("team.name", "payments"))
+
+	scope := resources[1].msg(2)
+	g.Expect(scope.msg(1).str(1)).To(Equal("perph"))
+	metrics := map[string]pmsg{}
+	for _, m := range scope.msgs(2) {
+		metrics[m.str(1)] = m
+	}
+	g.Expect(metrics).To(HaveKey("perph.check.dns"))
+	g.Expect(metrics).NotTo(HaveKey("perph.loadtest.requests"))
+
+	points := metrics["perph.run.duration"].msg(5).msgs(1)
+	g.Expect(points).To(HaveLen(2))
+	g.Expect(points[0][3][0]).To(Equal(uint64(start.UnixNano())))
+	g.Expect(math.Float64frombits(points[0][4][0].(uint64))).To(Equal(12.5))
+	g.Expect(attributes(points[0].msgs(7))).To(Equal(map[string]string{
+		"perph.kind":     "check",
+		"perph.name":     "api",
+		"perph.location": "eu",
+		"perph.phase":    "Succeeded",
+	}))
+
+	success := metrics["perph.run.success"].msg(5).msgs(1)
+	g.Expect(success[0][6][0]).To(Equal(uint64(1)))
+	g.Expect(success[1][6][0]).To(Equal(uint64(0)))
+	status := metrics["perph.check.status_code"].msg(5).msgs(1)
+	g.Expect(status).To(HaveLen(1))
+	g.Expect(status[0][6][0]).To(Equal(uint64(503)))
+
+	requests := resources[0].msg(2).msgs(2)
+	var names []string
+	for _, m := range requests {
+		names = append(names, m.str(1))
+	}
+	g.Expect(names).To(ConsistOf("perph.run.duration", "perph.run.success",
+		"perph.loadtest.requests", "perph.loadtest.failures", "perph.loadtest.latency.p95"))
+}
+
+func TestOTLPWrite(t *testing.T) {
+	for _, grpc := range []bool{false, true} {
+		g := NewGomegaWithT(t)
+		c := &collector{failures: 1}
+		srv := httptest.NewServer(h2c.NewHandler(c, &http2.Server{}))
+
+		o := &OTLP{
+			Delivery: Delivery{
+				BatchSize: 2,
+				BatchAge:  time.Minute,
+				Retries:   1,
+				Backoff:   time.Millisecond,
+				Gzip:      grpc,
+			},
+			Endpoint: srv.URL,
+			GRPC:     grpc,
+		}
+		n, err := o.Write(context.Background(), rows(3))
+		g.Expect(err).NotTo(HaveOccurred(), "grpc %v", grpc)
+		g.Expect(n).To(Equal(3))
+		g.Expect(c.requests).To(HaveLen(2))
+		g.Expect(c.requests[1].msg(1).msg(2).msg(2).msg(5).msgs(1)).To(HaveLen(1))
+
+		c.failures = 2
+		n, err = o.Write(context.Background(), rows(1))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(n).To(Equal(0))
+		if grpc {
+			g.Expect(err).To(MatchError("export: grpc status 14: try again"))
+		}
+		srv.Close()
+	}
+}
+
+func TestOTLPTimeout(t *testing.T) {
+	for _, grpc := range []bool{false, true} {
+		g := NewGomegaWithT(t)
+		hang := make(chan struct{})
+		srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-hang
+		}), &http2.Server{}))
+
+		o := &OTLP{
+			Delivery: Delivery{Timeout: 50 * time.Millisecond},
+			Endpoint: srv.URL,
+			GRPC:     grpc,
+		}
+		n, err := o.Write(context.Background(), rows(1))
+		g.Expect(err).To(MatchError(ContainSubstring("deadline exceeded")), "grpc %v", grpc)
+		g.Expect(n).To(Equal(0))
+		close(hang)
+		srv.Close()
+	}
+}
@@ -0,0 +1,276 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// parquetFormat writes a Parquet file with a single row group of required
+// columns, each in one uncompressed data page of PLAIN encoded values. That
+// is the simplest layout every reader supports, and files are small enough
+// for it.
+type parquetFormat struct{}
+
+func (parquetFormat) Extension() string   { return "parquet" }
+func (parquetFormat) ContentType() string { return "application/vnd.apache.parquet" }
+
+// Parquet physical types, converted types, and other enums of the format.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+
+	parquetRequired     = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetDataPage     = 0
+	parquetUncompressed = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+func (parquetFormat) Encode(rows []Row) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(parquetMagic)
+
+	// Each column chunk is a page header followed by the page.
+	type chunk struct {
+		offset int64
+		size   int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, c := range columns {
+		page := plain(c, rows)
+		var header thrift
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structField(5, func() {
+			header.i32(1, int32(len(rows)))
+			header.i32(2, parquetPlain)
+			header.i32(3, parquetRLE)
+			header.i32(4, parquetRLE)
+		})
+		header.stop()
+
+		chunks[i].offset = int64(buf.Len())
+		buf.Write(header.Bytes())
+		buf.Write(page)
+		chunks[i].size = int64(buf.Len()) - chunks[i].offset
+	}
+
+	var meta thrift
+	meta.i32(1, 1)
+	meta.structList(2, len(columns)+1, func(i int) {
+		if i == 0 {
+			meta.string(4, "schema")
+			meta.i32(5, int32(len(columns)))
+			return
+		}
+		c := columns[i-1]
+		meta.i32(1, physicalType(c.typ))
+		meta.i32(3, parquetRequired)
+		meta.string(4, c.name)
+		switch c.typ {
+		case stringColumn:
+			meta.i32(6, parquetUTF8)
+		case timeColumn:
+			meta.i32(6, parquetTimestampMicros)
+		}
+	})
+	meta.i64(3, int64(len(rows)))
+	meta.structList(4, 1, func(int) {
+		var total int64
+		meta.structList(1, len(columns), func(i int) {
+			c := columns[i]
+			meta.i64(2, chunks[i].offset)
+			meta.structField(3, func() {
+				meta.i32(1, physicalType(c.typ))
+				meta.list(2, thriftI32, 2)
+				meta.varint(parquetPlain)
+				meta.varint(parquetRLE)
+				meta.list(3, thriftBinary, 1)
+				meta.bytes([]byte(c.name))
+				meta.i32(4, parquetUncompressed)
+				meta.i64(5, int64(len(rows)))
+				meta.i64(6, chunks[i].size)
+				meta.i64(7, chunks[i].size)
+				meta.i64(9, chunks[i].offset)
+			})
+			total += chunks[i].size
+		})
+		meta.i64(2, total)
+		meta.i64(3, int64(len(rows)))
+	})
+	meta.string(6, "perph")
+	meta.stop()
+
+	buf.Write(meta.Bytes())
+	binary.Write(&buf, binary.LittleEndian, uint32(meta.Len()))
+	buf.Write(parquetMagic)
+	return buf.Bytes(), nil
+}
+
+func physicalType(t columnType) int32 {
+	switch t {
+	case boolColumn:
+		return parquetBoolean
+	case int32Column:
+		return parquetInt32
+	case int64Column, timeColumn:
+		return parquetInt64
+	case doubleColumn:
+		return parquetDouble
+	}
+	return parquetByteArray
+}
+
+// plain returns the values of column c of rows, PLAIN encoded.
+func plain(c column, rows []Row) []byte {
+	var buf bytes.Buffer
+	var bits byte
+	for i := range rows {
+		switch v := c.get(&rows[i]).(type) {
+		case string:
+			binary.Write(&buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		case bool:
+			// Booleans are bit packed, least significant bit first.
+			if v {
+				bits |= 1 << uint(i%8)
+			}
+			if i%8 == 7 {
+				buf.WriteByte(bits)
+				bits = 0
+			}
+		case int32:
+			binary.Write(&buf, binary.LittleEndian, v)
+		case int64:
+			binary.Write(&buf, binary.LittleEndian, v)
+		case float64:
+			binary.Write(&buf, binary.LittleEndian, math.Float64bits(v))
+		case time.Time:
+			binary.Write(&buf, binary.LittleEndian, v.UnixNano()/int64(time.Microsecond))
+		}
+	}
+	if c.typ == boolColumn && len(rows)%8 != 0 {
+		buf.WriteByte(bits)
+	}
+	return buf.Bytes()
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thrift writes structs in the Thrift compact protocol, which Parquet
+// encodes its metadata with. Fields must be written in increasing order of
+// id, and the outermost struct ended with stop.
+type thrift struct {
+	bytes.Buffer
+	last int16
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thrift) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	t.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (t *thrift) bytes(b []byte) {
+	var l [binary.MaxVarintLen64]byte
+	t.Write(l[:binary.PutUvarint(l[:], uint64(len(b)))])
+	t.Write(b)
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thrift) string(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.bytes([]byte(s))
+}
+
+// list starts a list field of n elements of type typ, which the caller
+// writes next.
+func (t *thrift) list(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | typ)
+	} else {
+		t.WriteByte(0xf0 | typ)
+		var b [binary.MaxVarintLen64]byte
+		t.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+	}
+}
+
+// structField writes a struct field whose fields body writes.
+func (t *thrift) structField(id int16, body func()) {
+	t.field(id, thriftStruct)
+	t.nested(body)
+	t.last = id
+}
+
+// structList writes a list field of n structs whose fields elem writes.
+func (t *thrift) structList(id int16, n int, elem func(i int)) {
+	t.list(id, thriftStruct, n)
+	for i := 0; i < n; i++ {
+		t.nested(func() { elem(i) })
+	}
+	t.last = id
+}
+
+// nested writes a struct, whose field ids start over.
+func (t *thrift) nested(body func()) {
+	t.last = 0
+	body()
+	t.stop()
+}
+
+// stop ends a struct.
+func (t *thrift) stop() {
+	t.WriteByte(0)
+}
@@ -0,0 +1,155 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// tstruct is a decoded Thrift struct, by field id.
+type tstruct map[int16]interface{}
+
+// compact decodes the Thrift compact protocol, as much of it as the
+// metadata of a Parquet file uses.
+type compact struct {
+	b   []byte
+	pos int
+}
+
+func (c *compact) uvarint() uint64 {
+	v, n := binary.Uvarint(c.b[c.pos:])
+	c.pos += n
+	return v
+}
+
+func (c *compact) varint() int64 {
+	v, n := binary.Varint(c.b[c.pos:])
+	c.pos += n
+	return v
+}
+
+func (c *compact) readStruct() tstruct {
+	s := tstruct{}
+	var last int16
+	for {
+		h := c.b[c.pos]
+		c.pos++
+		if h == 0 {
+			return s
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(c.varint())
+		}
+		last = id
+		s[id] = c.value(h & 0x0f)
+	}
+}
+
+func (c *compact) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return c.varint()
+	case thriftBinary:
+		n := int(c.uvarint())
+		c.pos += n
+		return string(c.b[c.pos-n : c.pos])
+	case thriftList:
+		h := c.b[c.pos]
+		c.pos++
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(c.uvarint())
+		}
+		var list []interface{}
+		for i := 0; i < n; i++ {
+			list = append(list, c.value(h&0x0f))
+		}
+		return list
+	case thriftStruct:
+		return c.readStruct()
+	}
+	panic("unsupported type")
+}
+
+func TestParquet(t *testing.T) {
+	g := NewGomegaWithT(t)
+	in := rows(10)
+	in[3].Silenced = true
+	in[9].Silenced = true
+	in[4].Run = "api-é"
+	in[5].StatusCode = 503
+	data, err := parquetFormat{}.Encode(in)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(data[:4]).To(Equal(parquetMagic))
+	g.Expect(data[len(data)-4:]).To(Equal(parquetMagic))
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := &compact{b: data[len(data)-8-size : len(data)-8]}
+	meta := footer.readStruct()
+	g.Expect(footer.pos).To(Equal(size))
+	g.Expect(meta[3]).To(Equal(int64(10)))
+
+	schema := meta[2].([]interface{})
+	g.Expect(schema).To(HaveLen(len(columns) + 1))
+	g.Expect(schema[0].(tstruct)[5]).To(Equal(int64(len(columns))))
+	for i, c := range columns {
+		g.Expect(schema[i+1].(tstruct)[4]).To(Equal(c.name))
+	}
+
+	groups := meta[4].([]interface{})
+	g.Expect(groups).To(HaveLen(1))
+	chunks := groups[0].(tstruct)[1].([]interface{})
+	g.Expect(chunks).To(HaveLen(len(columns)))
+
+	// page returns the values of column i.
+	page := func(i int) []byte {
+		md := chunks[i].(tstruct)[3].(tstruct)
+		g.Expect(md[3]).To(Equal([]interface{}{columns[i].name}))
+		g.Expect(md[5]).To(Equal(int64(10)))
+		r := &compact{b: data, pos: int(md[9].(int64))}
+		header := r.readStruct()
+		g.Expect(header[5].(tstruct)[1]).To(Equal(int64(10)))
+		values := data[r.pos : r.pos+int(header[3].(int64))]
+		g.Expect(int64(r.pos+len(values)) - md[9].(int64)).To(Equal(md[7]))
+		return values
+	}
+
+	values := page(0)
+	g.Expect(int64(binary.LittleEndian.Uint64(values[8:]))).To(Equal(start.Add(time.Minute).UnixNano() / 1000))
+
+	var runs []string
+	for b := bytes.NewReader(page(5)); b.Len() > 0; {
+		var n uint32
+		binary.Read(b, binary.LittleEndian, &n)
+		s := make([]byte, n)
+		b.Read(s)
+		runs = append(runs, string(s))
+	}
+	g.Expect(runs).To(HaveLen(10))
+	g.Expect(runs[4]).To(Equal("api-é"))
+	g.Expect(runs[9]).To(Equal("api-9"))
+
+	g.Expect(page(7)).To(Equal([]byte{1 << 3, 1 << 1}))
+	g.Expect(binary.LittleEndian.Uint32(page(9)[5*4:])).To(Equal(uint32(503)))
+	g.Expect(math.Float64frombits(binary.LittleEndian.Uint64(page(10)))).To(Equal(12.5))
+}
@@ -0,0 +1,76 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// protobuf writes messages in the protobuf wire format.
+type protobuf struct {
+	bytes.Buffer
+}
+
+// Protobuf wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+func (p *protobuf) tag(field, wire int) {
+	p.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (p *protobuf) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	p.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (p *protobuf) uint(field int, v uint64) {
+	p.tag(field, protoVarint)
+	p.varint(v)
+}
+
+func (p *protobuf) fixed64(field int, v uint64) {
+	p.tag(field, protoFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	p.Write(b[:])
+}
+
+func (p *protobuf) double(field int, v float64) {
+	p.fixed64(field, math.Float64bits(v))
+}
+
+func (p *protobuf) bytes(field int, b []byte) {
+	p.tag(field, protoBytes)
+	p.varint(uint64(len(b)))
+	p.Write(b)
+}
+
+func (p *protobuf) string(field int, s string) {
+	p.bytes(field, []byte(s))
+}
+
+// message writes an embedded message whose fields body writes.
+func (p *protobuf) message(field int, body func(m *protobuf)) {
+	var m protobuf
+	body(&m)
+	p.bytes(field, m.Bytes())
+}
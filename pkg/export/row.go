@@ -0,0 +1,159 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package export writes the results of SyntheticRuns to external systems.
+package export
+
+import (
+	"strconv"
+	"time"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// Row is the flat record of a finished run that sinks write. Durations are
+// in milliseconds.
+type Row struct {
+	Time       time.Time `json:"time"`
+	Namespace  string    `json:"namespace"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Location   string    `json:"location"`
+	Run        string    `json:"run"`
+	Phase      string    `json:"phase"`
+	Silenced   bool      `json:"silenced"`
+	RootCause  string    `json:"root_cause"`
+	StatusCode int32     `json:"status_code"`
+	Duration   float64   `json:"duration_ms"`
+	DNS        float64   `json:"dns_ms"`
+	Connect    float64   `json:"connect_ms"`
+	TLS        float64   `json:"tls_ms"`
+	FirstByte  float64   `json:"first_byte_ms"`
+	Transfer   float64   `json:"transfer_ms"`
+	Requests   int64     `json:"requests"`
+	Failures   int64     `json:"failures"`
+	P95        float64   `json:"p95_ms"`
+
+	// Labels are the labels of the Check or LoadTest of the run, which
+	// sinks may map to tags or resource attributes.
+	Labels map[string]string `json:"-"`
+}
+
+// Row kinds.
+const (
+	KindCheck    = "check"
+	KindLoadTest = "loadtest"
+)
+
+// RowOf returns the row of a finished run.
+func RowOf(run *syntheticv1.SyntheticRun) Row {
+	r := Row{
+		Namespace:  run.Namespace,
+		Location:   run.Spec.Location,
+		Run:        run.Name,
+		Phase:      string(run.Status.Phase),
+		Silenced:   run.Status.SilencedBy != "",
+		RootCause:  run.Status.RootCause,
+		StatusCode: run.Status.StatusCode,
+	}
+	if run.Status.CompletionTime != nil {
+		r.Time = run.Status.CompletionTime.Time
+	}
+	switch {
+	case run.Spec.CheckRef != "":
+		r.Kind, r.Name = KindCheck, run.Spec.CheckRef
+	case run.Spec.LoadTestRef != "":
+		r.Kind, r.Name = KindLoadTest, run.Spec.LoadTestRef
+	}
+	if t := run.Status.Timings; t != nil {
+		r.Duration = ms(t.Total.Duration)
+		r.DNS = ms(t.DNS.Duration)
+		r.Connect = ms(t.Connect.Duration)
+		r.TLS = ms(t.TLS.Duration)
+		r.FirstByte = ms(t.FirstByte.Duration)
+		r.Transfer = ms(t.Transfer.Duration)
+	}
+	if lt := run.Status.LoadTest; lt != nil {
+		r.Duration = ms(lt.Duration.Duration)
+		r.Requests = lt.Requests
+		r.Failures = lt.Failures
+		r.P95 = ms(lt.Latency.P95.Duration)
+	}
+	return r
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// columnType is the type of a column of a Row.
+type columnType int
+
+const (
+	stringColumn columnType = iota
+	boolColumn
+	int32Column
+	int64Column
+	doubleColumn
+	timeColumn
+)
+
+// column is a column of a Row, in the order of its fields.
+type column struct {
+	name string
+	typ  columnType
+	get  func(*Row) interface{}
+}
+
+var columns = []column{
+	{"time", timeColumn, func(r *Row) interface{} { return r.Time }},
+	{"namespace", stringColumn, func(r *Row) interface{} { return r.Namespace }},
+	{"kind", stringColumn, func(r *Row) interface{} { return r.Kind }},
+	{"name", stringColumn, func(r *Row) interface{} { return r.Name }},
+	{"location", stringColumn, func(r *Row) interface{} { return r.Location }},
+	{"run", stringColumn, func(r *Row) interface{} { return r.Run }},
+	{"phase", stringColumn, func(r *Row) interface{} { return r.Phase }},
+	{"silenced", boolColumn, func(r *Row) interface{} { return r.Silenced }},
+	{"root_cause", stringColumn, func(r *Row) interface{} { return r.RootCause }},
+	{"status_code", int32Column, func(r *Row) interface{} { return r.StatusCode }},
+	{"duration_ms", doubleColumn, func(r *Row) interface{} { return r.Duration }},
+	{"dns_ms", doubleColumn, func(r *Row) interface{} { return r.DNS }},
+	{"connect_ms", doubleColumn, func(r *Row) interface{} { return r.Connect }},
+	{"tls_ms", doubleColumn, func(r *Row) interface{} { return r.TLS }},
+	{"first_byte_ms", doubleColumn, func(r *Row) interface{} { return r.FirstByte }},
+	{"transfer_ms", doubleColumn, func(r *Row) interface{} { return r.Transfer }},
+	{"requests", int64Column, func(r *Row) interface{} { return r.Requests }},
+	{"failures", int64Column, func(r *Row) interface{} { return r.Failures }},
+	{"p95_ms", doubleColumn, func(r *Row) interface{} { return r.P95 }},
+}
+
+// text formats a value of a column as CSV does.
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return ""
+}
@@ -0,0 +1,244 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultUploadTimeout bounds uploads of S3 writers that do not set a
+// timeout. Files are larger than the batches of deliveries, so they get
+// longer than DefaultTimeout.
+const DefaultUploadTimeout = 5 * time.Minute
+
+// S3 writes objects to a bucket of an S3-compatible object store, signing
+// requests with AWS Signature Version 4.
+type S3 struct {
+	// Endpoint is the URL of the object store.
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	// PathStyle addresses the bucket in the path instead of the host name.
+	PathStyle bool
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+	// Timeout bounds every upload. Defaults to DefaultUploadTimeout.
+	Timeout time.Duration
+
+	// now returns the time requests are signed at.
+	now func() time.Time
+}
+
+// s3Error is the body of an S3 error response.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// PutObject writes body to key with content type typ.
+func (s *S3) PutObject(ctx context.Context, key, typ string, body []byte) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultUploadTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", typ)
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	var e s3Error
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("put %s: %s: %s", key, e.Code, e.Message)
+	}
+	return fmt.Errorf("put %s: %s", key, resp.Status)
+}
+
+// objectURL returns the URL of key in the bucket.
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", s.Endpoint)
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	if s.PathStyle {
+		u.Path = base + "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = escape(u.Path, true)
+	return u, nil
+}
+
+const (
+	amzDate       = "20060102T150405Z"
+	amzAlgorithm  = "AWS4-HMAC-SHA256"
+	amzService    = "s3"
+	amzTerminator = "aws4_request"
+)
+
+// sign adds the headers of Signature Version 4 to req, whose payload has
+// the hex encoded SHA-256 hash payload. Every header already set on req is
+// signed.
+func (s *S3) sign(req *http.Request, payload string) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now().UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDate))
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	signed, canonical := canonicalRequest(req, payload)
+	scope, sig := signature(s.SecretAccessKey, s.Region, t, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		amzAlgorithm, s.AccessKeyID, scope, signed, sig))
+}
+
+// signature returns the credential scope and the signature of a canonical
+// request made at t.
+func signature(secret, region string, t time.Time, canonical string) (string, string) {
+	scope := strings.Join([]string{t.Format("20060102"), region, amzService, amzTerminator}, "/")
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{amzAlgorithm, t.Format(amzDate), scope, hex.EncodeToString(sum[:])}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return scope, hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// canonicalRequest returns the signed headers and the canonical request of
+// req as Signature Version 4 defines them.
+func canonicalRequest(req *http.Request, payload string) (string, string) {
+	headers := map[string]string{"host": req.Host}
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "authorization" {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, escape(k, false)+"="+escape(v, false))
+		}
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return signed, strings.Join([]string{req.Method, path, strings.Join(params, "&"), b.String(), signed, payload}, "\n")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escape percent-encodes every byte of s but unreserved characters, and
+// slashes if path is set.
+func escape(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,110 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+)
+
+// Span is the client span of a synthetic request.
+type Span struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+
+	Name       string
+	Start, End time.Time
+
+	// Attributes are string attributes of the span, in order.
+	Attributes [][2]string
+
+	// StatusCode is the HTTP status code of the response, if any.
+	StatusCode int
+
+	// Error describes why the request failed, if it did.
+	Error string
+}
+
+// TraceIDString returns the trace ID of s as lower case hex.
+func (s *Span) TraceIDString() string {
+	return hex.EncodeToString(s.TraceID[:])
+}
+
+// Span kinds and status codes of the OTLP trace protocol.
+const (
+	spanKindClient  = 3
+	spanStatusError = 2
+)
+
+// WriteSpans sends spans in a single request, retrying it as Delivery
+// configures. Resource lists the attributes of the resource the spans
+// belong to, such as service.name.
+func (o *OTLP) WriteSpans(ctx context.Context, resource [][2]string, spans []Span) error {
+	msg := o.TraceRequest(resource, spans)
+	return o.retry(ctx, func() error {
+		return o.export(ctx, "/v1/traces", grpcTracesExport, msg)
+	})
+}
+
+// TraceRequest encodes spans as an ExportTraceServiceRequest of a single
+// resource.
+func (o *OTLP) TraceRequest(resource [][2]string, spans []Span) []byte {
+	var req protobuf
+	req.message(1, func(rs *protobuf) {
+		rs.message(1, func(r *protobuf) {
+			for _, kv := range resource {
+				r.message(1, func(attr *protobuf) { stringAttribute(attr, kv[0], kv[1]) })
+			}
+		})
+		rs.message(2, func(ss *protobuf) {
+			ss.message(1, func(scope *protobuf) { scope.string(1, "perph") })
+			for i := range spans {
+				s := &spans[i]
+				ss.message(2, func(span *protobuf) { writeSpan(span, s) })
+			}
+		})
+	})
+	return req.Bytes()
+}
+
+// writeSpan writes s as a Span without a parent, since synthetic requests
+// start their traces.
+func writeSpan(span *protobuf, s *Span) {
+	span.bytes(1, s.TraceID[:])
+	span.bytes(2, s.SpanID[:])
+	span.string(5, s.Name)
+	span.uint(6, spanKindClient)
+	span.fixed64(7, uint64(s.Start.UnixNano()))
+	span.fixed64(8, uint64(s.End.UnixNano()))
+	for _, kv := range s.Attributes {
+		span.message(9, func(attr *protobuf) { stringAttribute(attr, kv[0], kv[1]) })
+	}
+	if s.StatusCode != 0 {
+		span.message(9, func(attr *protobuf) {
+			attr.string(1, "http.status_code")
+			attr.message(2, func(v *protobuf) { v.uint(3, uint64(s.StatusCode)) })
+		})
+	}
+	// The status of client spans is only set for errors, which include
+	// responses with a 4xx or 5xx status.
+	if s.Error != "" || s.StatusCode >= 400 {
+		span.message(15, func(status *protobuf) {
+			status.string(2, s.Error)
+			status.uint(3, spanStatusError)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestOTLPWriteSpans(t *testing.T) {
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	spans := []Span{
+		{
+			TraceID:    [16]byte{1, 2, 3},
+			SpanID:     [8]byte{4, 5},
+			Name:       "HTTP GET",
+			Start:      start,
+			End:        start.Add(120 * time.Millisecond),
+			Attributes: [][2]string{{"http.method", "GET"}},
+			StatusCode: 200,
+		},
+		{Name: "HTTP POST", Start: start, End: start, StatusCode: 503},
+		{Name: "HTTP GET", Start: start, End: start, Error: "connection refused"},
+	}
+
+	for _, grpc := range []bool{false, true} {
+		g := NewGomegaWithT(t)
+		c := &collector{failures: 1}
+		srv := httptest.NewServer(h2c.NewHandler(c, &http2.Server{}))
+
+		o := &OTLP{
+			Delivery: Delivery{Retries: 1, Backoff: time.Millisecond},
+			Endpoint: srv.URL,
+			GRPC:     grpc,
+		}
+		err := o.WriteSpans(context.Background(), [][2]string{{"service.name", "probes"}}, spans)
+		g.Expect(err).NotTo(HaveOccurred(), "grpc %v", grpc)
+		g.Expect(c.paths).To(HaveLen(1))
+		if grpc {
+			g.Expect(c.paths[0]).To(Equal(grpcTracesExport))
+		} else {
+			g.Expect(c.paths[0]).To(Equal("/v1/traces"))
+		}
+
+		rs := c.requests[0].msg(1)
+		g.Expect(attributes(rs.msg(1).msgs(1))).To(Equal(map[string]string{"service.name": "probes"}))
+		got := rs.msg(2).msgs(2)
+		g.Expect(got).To(HaveLen(3))
+
+		s := got[0]
+		g.Expect(s[1][0]).To(Equal(spans[0].TraceID[:]))
+		g.Expect(s[2][0]).To(Equal(spans[0].SpanID[:]))
+		g.Expect(s.str(5)).To(Equal("HTTP GET"))
+		g.Expect(s[6][0]).To(Equal(uint64(spanKindClient)))
+		g.Expect(s[8][0].(uint64) - s[7][0].(uint64)).To(Equal(uint64(120 * time.Millisecond)))
+		g.Expect(attributes(s.msgs(9))).To(Equal(map[string]string{"http.method": "GET"}))
+		g.Expect(s.msgs(9)[1].msg(2)[3][0]).To(Equal(uint64(200)))
+		g.Expect(s[15]).To(BeNil())
+
+		g.Expect(got[1].msg(15)[3][0]).To(Equal(uint64(spanStatusError)))
+		g.Expect(got[2].msg(15).str(2)).To(Equal("connection refused"))
+		srv.Close()
+	}
+}
+
+func TestSpanTraceIDString(t *testing.T) {
+	g := NewGomegaWithT(t)
+	s := Span{TraceID: [16]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}}
+	g.Expect(s.TraceIDString()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+}
@@ -0,0 +1,176 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package faultproxy forwards traffic to a dependency while injecting the
+// faults of a FaultProxy, such as latency, errors, connection resets and
+// bandwidth limits, during their windows.
+package faultproxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/maintenance"
+)
+
+// window returns the window of f in progress at t, or else the next one.
+// Both times are zero if f is always injected, and the end alone is zero if
+// f has no window left.
+func window(f *syntheticv1.Fault, t time.Time) (time.Time, time.Time, error) {
+	switch {
+	case f.Schedule != "" && (f.Start != nil || f.End != nil):
+		return time.Time{}, time.Time{}, errors.New("only one of schedule and start and end may be set")
+	case f.Start != nil && f.End != nil:
+		return f.Start.Time, f.End.Time, nil
+	case f.Start != nil || f.End != nil:
+		return time.Time{}, time.Time{}, errors.New("start and end must be set together")
+	case f.Schedule == "":
+		return time.Time{}, time.Time{}, nil
+	case f.Duration == nil || f.Duration.Duration <= 0:
+		return time.Time{}, time.Time{}, errors.New("duration must be positive")
+	}
+	sched, err := maintenance.ParseSchedule(f.Schedule)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	loc := time.UTC
+	if f.TimeZone != "" {
+		if loc, err = time.LoadLocation(f.TimeZone); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	start, end := maintenance.Occurrence(sched, loc, f.Duration.Duration, t)
+	if start.IsZero() {
+		// The schedule does not fire again.
+		return t, time.Time{}, nil
+	}
+	return start, end, nil
+}
+
+// Active returns the faults among faults that are injected at t, and the
+// first time after t at which one starts or ends, or the zero time if none
+// will. Invalid faults are never injected and are reported in the error.
+func Active(faults []syntheticv1.Fault, t time.Time) ([]*syntheticv1.Fault, time.Time, error) {
+	var active []*syntheticv1.Fault
+	var next time.Time
+	var errs []error
+	for i := range faults {
+		f := &faults[i]
+		start, end, err := window(f, t)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("fault %q: %v", f.Name, err))
+			continue
+		}
+		if start.IsZero() && end.IsZero() {
+			active = append(active, f)
+			continue
+		}
+		if end.IsZero() {
+			continue
+		}
+		change := start
+		if !start.After(t) {
+			if end.After(t) {
+				active = append(active, f)
+			}
+			change = end
+		}
+		if change.After(t) && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+	return active, next, utilerrors.NewAggregate(errs)
+}
+
+// Injects returns true if fp injects the fault called name at t, or any of
+// its faults if name is empty. It fails if the fault called name is missing
+// or invalid, or with an empty name if any fault is invalid.
+func Injects(fp *syntheticv1.FaultProxy, name string, t time.Time) (bool, error) {
+	faults := fp.Spec.Faults
+	if name != "" {
+		faults = nil
+		for _, f := range fp.Spec.Faults {
+			if f.Name == name {
+				faults = append(faults, f)
+			}
+		}
+		if len(faults) == 0 {
+			return false, fmt.Errorf("FaultProxy %q has no fault %q", fp.Name, name)
+		}
+	}
+	active, _, err := Active(faults, t)
+	if err != nil {
+		return false, err
+	}
+	return len(active) > 0, nil
+}
+
+// Effect is the combined effect of the active faults.
+type Effect struct {
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// ErrorPercent and ResetPercent are percentages of connections, or of
+	// requests with HTTP.
+	ErrorPercent int
+	ErrorStatus  int
+	ResetPercent int
+
+	// Bandwidth is in bytes per second, or unlimited when zero.
+	Bandwidth int64
+}
+
+// Combine returns the effect of injecting faults together: the largest
+// latency and the narrowest bandwidth apply, and error and reset
+// percentages add up.
+func Combine(faults []*syntheticv1.Fault) Effect {
+	var e Effect
+	for _, f := range faults {
+		if f.Latency != nil && f.Latency.Duration > e.Latency {
+			e.Latency = f.Latency.Duration
+		}
+		if f.Jitter != nil && f.Jitter.Duration > e.Jitter {
+			e.Jitter = f.Jitter.Duration
+		}
+		if f.ErrorPercent != nil && *f.ErrorPercent > 0 {
+			e.ErrorPercent += int(*f.ErrorPercent)
+			if e.ErrorStatus == 0 {
+				e.ErrorStatus = f.ErrorStatusOrDefault()
+			}
+		}
+		if f.ResetPercent != nil {
+			e.ResetPercent += int(*f.ResetPercent)
+		}
+		if f.Bandwidth != nil {
+			if b := f.Bandwidth.Value(); b > 0 && (e.Bandwidth == 0 || b < e.Bandwidth) {
+				e.Bandwidth = b
+			}
+		}
+	}
+	return e
+}
+
+// Names returns the names of faults.
+func Names(faults []*syntheticv1.Fault) []string {
+	var out []string
+	for _, f := range faults {
+		out = append(out, f.Name)
+	}
+	return out
+}
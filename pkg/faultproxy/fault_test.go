@@ -0,0 +1,107 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package faultproxy
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func duration(d time.Duration) *metav1.Duration { return &metav1.Duration{Duration: d} }
+
+func percent(p int32) *int32 { return &p }
+
+func TestActive(t *testing.T) {
+	g := NewGomegaWithT(t)
+	at := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		g.Expect(err).NotTo(HaveOccurred())
+		return tm
+	}
+	start, end := metav1.NewTime(at("2019-06-01T12:00:00Z")), metav1.NewTime(at("2019-06-01T13:00:00Z"))
+	faults := []syntheticv1.Fault{
+		{Name: "slow", Latency: duration(time.Second)},
+		// Every hour at half past, for ten minutes.
+		{Name: "flaky", Schedule: "30 * * * *", Duration: duration(10 * time.Minute), ErrorPercent: percent(20)},
+		{Name: "outage", Start: &start, End: &end, ResetPercent: percent(100)},
+	}
+
+	active, next, err := Active(faults, at("2019-06-01T11:00:00Z"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(Names(active)).To(Equal([]string{"slow"}))
+	g.Expect(next).To(BeTemporally("==", at("2019-06-01T11:30:00Z")))
+
+	active, next, _ = Active(faults, at("2019-06-01T12:35:00Z"))
+	g.Expect(Names(active)).To(Equal([]string{"slow", "flaky", "outage"}))
+	g.Expect(next).To(BeTemporally("==", at("2019-06-01T12:40:00Z")))
+
+	active, next, _ = Active(faults, at("2019-06-01T12:45:00Z"))
+	g.Expect(Names(active)).To(Equal([]string{"slow", "outage"}))
+	g.Expect(next).To(BeTemporally("==", end.Time))
+
+	fp := &syntheticv1.FaultProxy{ObjectMeta: metav1.ObjectMeta{Name: "db"}, Spec: syntheticv1.FaultProxySpec{Faults: faults}}
+	injects, err := Injects(fp, "outage", at("2019-06-01T13:00:00Z"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(injects).To(BeFalse())
+	injects, err = Injects(fp, "", at("2019-06-01T13:00:00Z"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(injects).To(BeTrue())
+	_, err = Injects(fp, "missing", at("2019-06-01T13:00:00Z"))
+	g.Expect(err).To(MatchError(`FaultProxy "db" has no fault "missing"`))
+
+	// Invalid faults are reported without hiding the valid ones.
+	faults = append(faults, syntheticv1.Fault{Name: "broken", Schedule: "@hourly"})
+	active, _, err = Active(faults, at("2019-06-01T11:00:00Z"))
+	g.Expect(err).To(MatchError(ContainSubstring(`fault "broken": duration must be positive`)))
+	g.Expect(Names(active)).To(Equal([]string{"slow"}))
+
+	// Asking about an invalid fault fails, but asking about another does not.
+	fp.Spec.Faults = faults
+	_, err = Injects(fp, "broken", at("2019-06-01T11:00:00Z"))
+	g.Expect(err).To(MatchError(ContainSubstring("duration must be positive")))
+	injects, err = Injects(fp, "slow", at("2019-06-01T11:00:00Z"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(injects).To(BeTrue())
+	_, err = Injects(fp, "", at("2019-06-01T11:00:00Z"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestCombine(t *testing.T) {
+	g := NewGomegaWithT(t)
+	fast, slow := resource.MustParse("1Mi"), resource.MustParse("64Ki")
+	status := int32(500)
+
+	e := Combine([]*syntheticv1.Fault{
+		{Latency: duration(100 * time.Millisecond), ErrorPercent: percent(10), Bandwidth: &fast},
+		{Latency: duration(time.Second), Jitter: duration(50 * time.Millisecond), ErrorPercent: percent(5), ErrorStatus: &status, Bandwidth: &slow},
+		{ResetPercent: percent(1)},
+	})
+	g.Expect(e).To(Equal(Effect{
+		Latency:      time.Second,
+		Jitter:       50 * time.Millisecond,
+		ErrorPercent: 15,
+		ErrorStatus:  syntheticv1.DefaultFaultErrorStatus,
+		ResetPercent: 1,
+		Bandwidth:    64 * 1024,
+	}))
+	g.Expect(Combine(nil)).To(Equal(Effect{}))
+}
@@ -0,0 +1,294 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package faultproxy
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// dialTimeout bounds connecting to the upstream.
+const dialTimeout = 10 * time.Second
+
+// Proxy forwards connections, or HTTP requests, to the upstream of Spec and
+// injects the faults of Spec that are active.
+type Proxy struct {
+	Spec syntheticv1.FaultProxySpec
+	Log  logr.Logger
+
+	// now defaults to time.Now.
+	now func() time.Time
+}
+
+// effect returns the effect of the faults active now, and how long until a
+// fault starts or ends, or zero if none will. Invalid faults were reported by
+// the controller and are ignored.
+func (p *Proxy) effect() (Effect, time.Duration) {
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+	active, next, _ := Active(p.Spec.Faults, now)
+	if next.IsZero() {
+		return Combine(active), 0
+	}
+	return Combine(active), next.Sub(now)
+}
+
+// ListenAndServe listens on the port of the proxy and serves connections
+// until listening fails.
+func (p *Proxy) ListenAndServe() error {
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(int(p.Spec.PortOrDefault()))))
+	if err != nil {
+		return err
+	}
+	p.Log.Info("forwarding", "address", l.Addr().String(), "upstream", p.Spec.Upstream, "protocol", p.Spec.Protocol)
+	return p.Serve(l)
+}
+
+// Serve serves the connections accepted on l until l fails.
+func (p *Proxy) Serve(l net.Listener) error {
+	if p.Spec.Protocol == syntheticv1.FaultHTTP {
+		return (&http.Server{Handler: p.Handler()}).Serve(l)
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.forward(conn)
+	}
+}
+
+// forward forwards conn to the upstream. The effect of the faults is set when
+// conn is accepted, so conn is closed when a fault starts or ends for the
+// client to reconnect under the new effect.
+func (p *Proxy) forward(conn net.Conn) {
+	e, change := p.effect()
+	changed := time.Now().Add(change)
+	switch {
+	case roll(e.ResetPercent):
+		reset(conn)
+		return
+	case roll(e.ErrorPercent):
+		conn.Close()
+		return
+	}
+	time.Sleep(e.delay())
+
+	up, err := net.DialTimeout("tcp", p.Spec.Upstream, dialTimeout)
+	if err != nil {
+		p.Log.Error(err, "unable to reach upstream", "upstream", p.Spec.Upstream)
+		conn.Close()
+		return
+	}
+	if change > 0 {
+		t := time.AfterFunc(time.Until(changed), func() {
+			reset(conn)
+			up.Close()
+		})
+		defer t.Stop()
+	}
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		copyPaced(dst, src, e.Bandwidth)
+		// Half close, so that the other direction can finish.
+		if c, ok := dst.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go pipe(up, conn)
+	go pipe(conn, up)
+	<-done
+	<-done
+	conn.Close()
+	up.Close()
+}
+
+// Handler returns the handler that forwards HTTP requests to the upstream.
+func (p *Proxy) Handler() http.Handler {
+	target := &url.URL{Scheme: "http", Host: p.Spec.Upstream}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = target.Host
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, _ := p.effect()
+		if roll(e.ResetPercent) {
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					reset(conn)
+					return
+				}
+			}
+		}
+		if !sleep(r.Context(), e.delay()) {
+			return
+		}
+		if roll(e.ErrorPercent) {
+			http.Error(w, "fault injected by perph", e.ErrorStatus)
+			return
+		}
+		if e.Bandwidth > 0 {
+			w = &pacedResponse{ResponseWriter: w, pacer: pacer{rate: e.Bandwidth}}
+			r.Body = &pacedBody{ReadCloser: r.Body, pacer: pacer{rate: e.Bandwidth}}
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// delay returns the latency to inject, with a random share of the jitter.
+func (e *Effect) delay() time.Duration {
+	d := e.Latency
+	if e.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(e.Jitter) + 1))
+	}
+	return d
+}
+
+// roll returns true with the given percentage of probability.
+func roll(percent int) bool {
+	return percent > 0 && rand.Intn(100) < percent
+}
+
+// sleep waits for d, and returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// reset closes conn with a TCP reset rather than an orderly shutdown.
+func reset(conn net.Conn) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// pacer spreads data over time to keep to a rate in bytes per second.
+type pacer struct {
+	rate  int64
+	start time.Time
+	sent  int64
+}
+
+// chunk returns the most bytes sent at once, a tenth of a second's worth.
+func (p *pacer) chunk() int {
+	if c := p.rate / 10; c > 1 {
+		return int(c)
+	}
+	return 1
+}
+
+// pace records that n more bytes were sent and waits until they are due.
+func (p *pacer) pace(n int) {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	p.sent += int64(n)
+	due := p.start.Add(time.Duration(float64(p.sent) / float64(p.rate) * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// copyPaced copies src to dst at rate bytes per second, or as fast as
+// possible if rate is zero.
+func copyPaced(dst io.Writer, src io.Reader, rate int64) error {
+	if rate <= 0 {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	p := pacer{rate: rate}
+	buf := make([]byte, p.chunk())
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			p.pace(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pacedResponse writes a response body at the rate of its pacer.
+type pacedResponse struct {
+	http.ResponseWriter
+	pacer pacer
+}
+
+func (w *pacedResponse) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		c := b
+		if len(c) > w.pacer.chunk() {
+			c = c[:w.pacer.chunk()]
+		}
+		n, err := w.ResponseWriter.Write(c)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		w.pacer.pace(n)
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// pacedBody reads a request body at the rate of its pacer.
+type pacedBody struct {
+	io.ReadCloser
+	pacer pacer
+}
+
+func (b *pacedBody) Read(p []byte) (int, error) {
+	if len(p) > b.pacer.chunk() {
+		p = p[:b.pacer.chunk()]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.pacer.pace(n)
+	return n, err
+}
@@ -0,0 +1,167 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package faultproxy
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// serve runs a proxy of spec with faults on a local port and returns its
+// address.
+func serve(g *GomegaWithT, spec syntheticv1.FaultProxySpec, now func() time.Time, faults ...syntheticv1.Fault) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	spec.Faults = faults
+	p := &Proxy{Spec: spec, Log: zap.Logger(true), now: now}
+	go p.Serve(l)
+	return l.Addr().String()
+}
+
+func TestTCP(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// The upstream echoes lines back.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	spec := syntheticv1.FaultProxySpec{Upstream: upstream.Addr().String()}
+	echo := func(addr string) (string, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("ping\n")); err != nil {
+			return "", err
+		}
+		return bufio.NewReader(conn).ReadString('\n')
+	}
+
+	line, err := echo(serve(g, spec, nil))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(line).To(Equal("ping\n"))
+
+	start := time.Now()
+	line, err = echo(serve(g, spec, nil, syntheticv1.Fault{Name: "slow", Latency: duration(200 * time.Millisecond)}))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(line).To(Equal("ping\n"))
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+
+	down := syntheticv1.Fault{Name: "down", ErrorPercent: percent(100)}
+	_, err = echo(serve(g, spec, nil, down))
+	g.Expect(err).To(HaveOccurred())
+
+	// Faults outside their window are not injected.
+	noon := func() time.Time { return time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC) }
+	down.Schedule, down.Duration = "30 * * * *", duration(time.Minute)
+	line, err = echo(serve(g, spec, noon, down))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(line).To(Equal("ping\n"))
+
+	// Connections opened before a window are closed when it starts, and new
+	// ones get its faults.
+	start = time.Now()
+	down.Schedule, down.Duration = "", nil
+	down.Start, down.End = &metav1.Time{Time: start.Add(300 * time.Millisecond)}, &metav1.Time{Time: start.Add(time.Hour)}
+	addr := serve(g, spec, nil, down)
+	conn, err := net.Dial("tcp", addr)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("ping\n"))
+	g.Expect(err).NotTo(HaveOccurred())
+	line, err = r.ReadString('\n')
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(line).To(Equal("ping\n"))
+	_, err = r.ReadString('\n')
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+	_, err = echo(addr)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestHTTP(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	body := strings.Repeat("x", 2048)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+" "+body)
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	spec := syntheticv1.FaultProxySpec{Upstream: host, Protocol: syntheticv1.FaultHTTP}
+	get := func(addr string) (int, string, error) {
+		res, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			return 0, "", err
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(b), err
+	}
+
+	status, got, err := get(serve(g, spec, nil))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(status).To(Equal(http.StatusOK))
+	g.Expect(got).To(Equal(host + " " + body))
+
+	status, _, err = get(serve(g, spec, nil, syntheticv1.Fault{Name: "errors", ErrorPercent: percent(100)}))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(status).To(Equal(http.StatusServiceUnavailable))
+
+	_, _, err = get(serve(g, spec, nil, syntheticv1.Fault{Name: "resets", ResetPercent: percent(100)}))
+	g.Expect(err).To(HaveOccurred())
+
+	// 2KiB at 8KiB/s takes about a quarter of a second.
+	bandwidth := resource.MustParse("8Ki")
+	start := time.Now()
+	status, got, err = get(serve(g, spec, nil, syntheticv1.Fault{Name: "narrow", Bandwidth: &bandwidth}))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(status).To(Equal(http.StatusOK))
+	g.Expect(got).To(HaveLen(len(host) + 1 + len(body)))
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+}
@@ -0,0 +1,128 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// harLog is the part of a HAR 1.2 document the importer reads.
+type harLog struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total duration of the request in milliseconds.
+	Time     float64     `json:"time"`
+	Request  harRequest  `json:"request"`
+	Response harResponse `json:"response"`
+}
+
+type harRequest struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Headers  []harHeader `json:"headers"`
+	PostData *struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+	} `json:"postData,omitempty"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harResponse struct {
+	Status  int `json:"status"`
+	Content struct {
+		MimeType string `json:"mimeType"`
+	} `json:"content"`
+}
+
+// staticContent matches the content types of page resources, which a
+// browser fetches on its own and are not part of the recorded flow.
+var staticContent = regexp.MustCompile(`^(image/|font/|text/css|(application|text)/(x-)?javascript|application/font)`)
+
+// HAR converts the entries of a HAR file into steps. Requests for static
+// page resources are skipped unless include matches them, and only requests
+// whose URL matches include are kept when it is set. The think time of a
+// step is the gap between the end of the previous request and its start.
+func HAR(data []byte, include *regexp.Regexp, opts Options) (*Recording, error) {
+	var har harLog
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("parsing har: %v", err)
+	}
+
+	rec := newRecording(opts)
+	used := map[string]bool{}
+	var prevEnd time.Time
+	for _, e := range har.Log.Entries {
+		// Skipped requests still end the think time, since the user waits
+		// for the page they belong to.
+		thinkTime := e.StartedDateTime.Sub(prevEnd)
+		if end := e.StartedDateTime.Add(time.Duration(e.Time * float64(time.Millisecond))); end.After(prevEnd) {
+			prevEnd = end
+		}
+
+		if include != nil {
+			if !include.MatchString(e.Request.URL) {
+				continue
+			}
+		} else if staticContent.MatchString(e.Response.Content.MimeType) {
+			continue
+		}
+
+		step := syntheticv1.HTTPStep{Name: stepName(e.Request.Method, e.Request.URL, used)}
+		step.Method = e.Request.Method
+		target, err := rec.redactQuery(e.Request.URL, &step)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s %s: %v", e.Request.Method, e.Request.URL, err)
+		}
+		step.URL = target
+		for _, h := range e.Request.Headers {
+			if header, ok := rec.header(h.Name, h.Value); ok {
+				step.Headers = append(step.Headers, header)
+			}
+		}
+		if e.Request.PostData != nil {
+			step.Body = rec.redactBody(e.Request.PostData.Text, e.Request.PostData.MimeType, &step)
+		}
+		if e.Response.Status != 0 {
+			step.ExpectedStatus = []int32{int32(e.Response.Status)}
+		}
+
+		if len(rec.Steps) > 0 && thinkTime > 0 {
+			step.ThinkTime = &metav1.Duration{Duration: thinkTime.Round(time.Millisecond)}
+		}
+
+		rec.Steps = append(rec.Steps, step)
+	}
+	if len(rec.Steps) == 0 {
+		return nil, fmt.Errorf("har contains no requests to import")
+	}
+	return rec, nil
+}
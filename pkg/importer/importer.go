@@ -0,0 +1,283 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package importer converts recorded sessions and request collections into
+// Checks and LoadTests.
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// Options controls the conversion.
+type Options struct {
+	// Secret is the name of the Secret that credentials and variables are
+	// read from.
+	Secret string
+}
+
+// Recording is a sequence of requests read from a HAR file or a Postman
+// collection.
+type Recording struct {
+	Steps []syntheticv1.HTTPStep
+
+	// SecretKeys lists the keys the steps read from the Secret.
+	SecretKeys []string
+
+	secret string
+	keys   map[string]bool
+}
+
+func newRecording(opts Options) *Recording {
+	secret := opts.Secret
+	if secret == "" {
+		secret = "perph-import"
+	}
+	return &Recording{secret: secret, keys: map[string]bool{}}
+}
+
+// secretRef returns a reference to a key of the Secret, and records that
+// the key is needed.
+func (r *Recording) secretRef(key string) *syntheticv1.ValueSource {
+	key = secretKey(key)
+	if !r.keys[key] {
+		r.keys[key] = true
+		r.SecretKeys = append(r.SecretKeys, key)
+		sort.Strings(r.SecretKeys)
+	}
+	return &syntheticv1.ValueSource{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: r.secret},
+		Key:                  key,
+	}}
+}
+
+// Check returns a Check that issues the recorded requests in order. Think
+// times are dropped, since they would only slow the check down.
+func (r *Recording) Check(meta metav1.ObjectMeta) *syntheticv1.Check {
+	steps := make([]syntheticv1.HTTPStep, len(r.Steps))
+	for i := range r.Steps {
+		r.Steps[i].DeepCopyInto(&steps[i])
+		steps[i].ThinkTime = nil
+	}
+	return &syntheticv1.Check{
+		TypeMeta:   metav1.TypeMeta{APIVersion: syntheticv1.GroupVersion.String(), Kind: "Check"},
+		ObjectMeta: meta,
+		Spec:       syntheticv1.CheckSpec{Steps: steps},
+	}
+}
+
+// LoadTest returns a LoadTest whose virtual users replay the recorded
+// requests with their think times.
+func (r *Recording) LoadTest(meta metav1.ObjectMeta, vus int32, duration metav1.Duration) *syntheticv1.LoadTest {
+	steps := make([]syntheticv1.HTTPStep, len(r.Steps))
+	for i := range r.Steps {
+		r.Steps[i].DeepCopyInto(&steps[i])
+	}
+	return &syntheticv1.LoadTest{
+		TypeMeta:   metav1.TypeMeta{APIVersion: syntheticv1.GroupVersion.String(), Kind: "LoadTest"},
+		ObjectMeta: meta,
+		Spec: syntheticv1.LoadTestSpec{
+			Steps:    steps,
+			VUs:      vus,
+			Duration: duration,
+		},
+	}
+}
+
+// sensitiveHeaders are headers whose recorded values are credentials.
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-api-key":           true,
+	"x-auth-token":        true,
+	"x-csrf-token":        true,
+	"x-xsrf-token":        true,
+}
+
+// droppedHeaders are set by the client or describe the recorded connection
+// rather than the request.
+var droppedHeaders = map[string]bool{
+	"host":              true,
+	"connection":        true,
+	"content-length":    true,
+	"accept-encoding":   true,
+	"keep-alive":        true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+	"te":                true,
+}
+
+// sensitiveParam matches query parameters whose values are credentials.
+var sensitiveParam = regexp.MustCompile(`(?i)token|secret|password|passwd|api[_-]?key|session|signature|^sig$|^key$|^code$`)
+
+// header converts a recorded header, moving credentials to the Secret. It
+// returns false for headers that should not be replayed.
+func (r *Recording) header(name, value string) (syntheticv1.HTTPHeader, bool) {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(name, ":") || droppedHeaders[lower] {
+		return syntheticv1.HTTPHeader{}, false
+	}
+	if sensitiveHeaders[lower] {
+		return syntheticv1.HTTPHeader{Name: name, ValueFrom: r.secretRef(lower)}, true
+	}
+	return syntheticv1.HTTPHeader{Name: name, Value: value}, true
+}
+
+// redactQuery replaces the values of sensitive query parameters of rawURL
+// with variables read from the Secret.
+func (r *Recording) redactQuery(rawURL string, step *syntheticv1.HTTPStep) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	if len(query) == 0 {
+		return rawURL, nil
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	redacted := false
+	parts := make([]string, 0, len(query))
+	for _, name := range names {
+		for _, value := range query[name] {
+			if sensitiveParam.MatchString(name) {
+				variable := variableName(name)
+				r.addVariable(step, variable, r.secretRef(name))
+				value = "$(" + variable + ")"
+				redacted = true
+				parts = append(parts, url.QueryEscape(name)+"="+value)
+				continue
+			}
+			parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+	if !redacted {
+		return rawURL, nil
+	}
+	u.RawQuery = ""
+	return u.String() + "?" + strings.Join(parts, "&"), nil
+}
+
+// redactBody replaces the values of sensitive fields of a JSON or form
+// encoded body with variables read from the Secret.
+func (r *Recording) redactBody(body, contentType string, step *syntheticv1.HTTPStep) string {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var doc interface{}
+		if err := json.Unmarshal([]byte(body), &doc); err != nil {
+			return body
+		}
+		if !r.redactJSON(doc, step) {
+			return body
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return body
+		}
+		return string(data)
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(body)
+		if err != nil {
+			return body
+		}
+		redacted, err := r.redactQuery("?"+form.Encode(), step)
+		if err != nil {
+			return body
+		}
+		return strings.TrimPrefix(redacted, "?")
+	}
+	return body
+}
+
+// redactJSON replaces sensitive string fields of doc in place and reports
+// whether any was found.
+func (r *Recording) redactJSON(doc interface{}, step *syntheticv1.HTTPStep) bool {
+	redacted := false
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		for k, v := range d {
+			if _, ok := v.(string); ok && sensitiveParam.MatchString(k) {
+				variable := variableName(k)
+				r.addVariable(step, variable, r.secretRef(k))
+				d[k] = "$(" + variable + ")"
+				redacted = true
+				continue
+			}
+			redacted = r.redactJSON(v, step) || redacted
+		}
+	case []interface{}:
+		for _, v := range d {
+			redacted = r.redactJSON(v, step) || redacted
+		}
+	}
+	return redacted
+}
+
+// addVariable declares a variable of step, unless it already exists.
+func (r *Recording) addVariable(step *syntheticv1.HTTPStep, name string, from *syntheticv1.ValueSource) {
+	for _, v := range step.Variables {
+		if v.Name == name {
+			return
+		}
+	}
+	step.Variables = append(step.Variables, syntheticv1.Variable{Name: name, ValueFrom: from})
+}
+
+var (
+	invalidKey      = regexp.MustCompile(`[^-._a-zA-Z0-9]+`)
+	invalidVariable = regexp.MustCompile(`[^_a-zA-Z0-9]+`)
+	invalidStepName = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// secretKey turns s into a valid Secret key.
+func secretKey(s string) string {
+	return invalidKey.ReplaceAllString(s, "-")
+}
+
+// variableName turns s into a probe variable name.
+func variableName(s string) string {
+	return strings.ToUpper(invalidVariable.ReplaceAllString(s, "_"))
+}
+
+// stepName derives a unique step name from a request.
+func stepName(method, rawURL string, used map[string]bool) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+	}
+	base := strings.Trim(invalidStepName.ReplaceAllString(strings.ToLower(method+" "+path), "-"), "-")
+	name := base
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	used[name] = true
+	return name
+}
@@ -0,0 +1,107 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func secretRef(key string) *syntheticv1.ValueSource {
+	return &syntheticv1.ValueSource{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "shop-credentials"},
+		Key:                  key,
+	}}
+}
+
+func TestHAR(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	data, err := ioutil.ReadFile("testdata/session.har")
+	g.Expect(err).NotTo(HaveOccurred())
+	rec, err := HAR(data, nil, Options{Secret: "shop-credentials"})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(rec.Steps).To(HaveLen(3))
+	g.Expect(rec.SecretKeys).To(Equal([]string{"access_token", "cookie", "password"}))
+
+	home := rec.Steps[0]
+	g.Expect(home.Name).To(Equal("get"))
+	g.Expect(home.Headers).To(Equal([]syntheticv1.HTTPHeader{{Name: "Accept", Value: "text/html"}}))
+	g.Expect(home.ThinkTime).To(BeNil())
+
+	login := rec.Steps[1]
+	g.Expect(login.Name).To(Equal("post-api-login"))
+	g.Expect(login.Method).To(Equal("POST"))
+	g.Expect(login.Body).To(MatchJSON(`{"user":"alice","password":"$(PASSWORD)"}`))
+	g.Expect(login.Variables).To(Equal([]syntheticv1.Variable{{Name: "PASSWORD", ValueFrom: secretRef("password")}}))
+	g.Expect(login.ExpectedStatus).To(Equal([]int32{204}))
+	// The stylesheet is skipped, but still counts as activity.
+	g.Expect(login.ThinkTime.Duration).To(Equal(1940 * time.Millisecond))
+
+	search := rec.Steps[2]
+	g.Expect(search.URL).To(Equal("https://shop.example.com/api/search?access_token=$(ACCESS_TOKEN)&q=shoes"))
+	g.Expect(search.Variables).To(Equal([]syntheticv1.Variable{{Name: "ACCESS_TOKEN", ValueFrom: secretRef("access_token")}}))
+	g.Expect(search.Headers).To(Equal([]syntheticv1.HTTPHeader{{Name: "Cookie", ValueFrom: secretRef("cookie")}}))
+	g.Expect(search.ThinkTime.Duration).To(Equal(3 * time.Second))
+
+	check := rec.Check(metav1.ObjectMeta{Name: "shop"})
+	g.Expect(check.Spec.Steps).To(HaveLen(3))
+	g.Expect(check.Spec.Steps[2].ThinkTime).To(BeNil())
+	g.Expect(rec.Steps[2].ThinkTime).NotTo(BeNil())
+
+	lt := rec.LoadTest(metav1.ObjectMeta{Name: "shop"}, 5, metav1.Duration{Duration: time.Minute})
+	g.Expect(lt.Spec.Steps[2].ThinkTime.Duration).To(Equal(3 * time.Second))
+}
+
+func TestPostman(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	data, err := ioutil.ReadFile("testdata/collection.json")
+	g.Expect(err).NotTo(HaveOccurred())
+	rec, err := Postman(data, Options{Secret: "shop-credentials"})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(rec.Steps).To(HaveLen(3))
+	g.Expect(rec.SecretKeys).To(Equal([]string{"accessToken", "apiKey", "baseUrl", "email"}))
+
+	list := rec.Steps[0]
+	g.Expect(list.Name).To(Equal("list-products"))
+	g.Expect(list.URL).To(Equal("$(BASEURL)/api/products?page=1"))
+	g.Expect(list.Headers).To(Equal([]syntheticv1.HTTPHeader{
+		{Name: "Accept", Value: "application/json"},
+		{Name: "Authorization", Value: "Bearer $(ACCESSTOKEN)"},
+	}))
+	g.Expect(list.Variables).To(ConsistOf(
+		syntheticv1.Variable{Name: "BASEURL", ValueFrom: secretRef("baseUrl")},
+		syntheticv1.Variable{Name: "ACCESSTOKEN", ValueFrom: secretRef("accessToken")},
+	))
+
+	health := rec.Steps[1]
+	g.Expect(health.Headers).To(BeEmpty())
+
+	subscribe := rec.Steps[2]
+	g.Expect(subscribe.Method).To(Equal("POST"))
+	g.Expect(subscribe.URL).To(Equal("$(BASEURL)/api/subscribe?api_key=$(API_KEY)"))
+	g.Expect(subscribe.Body).To(Equal("email=$(EMAIL)&list=news+%26+offers"))
+}
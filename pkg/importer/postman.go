@@ -0,0 +1,277 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// postmanCollection is the part of a Postman v2.1 collection the importer
+// reads.
+type postmanCollection struct {
+	Info struct {
+		Schema string `json:"schema"`
+	} `json:"info"`
+	Item []postmanItem `json:"item"`
+	Auth *postmanAuth  `json:"auth,omitempty"`
+}
+
+// postmanItem is either a request or a folder of items.
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item,omitempty"`
+	Request *postmanRequest `json:"request,omitempty"`
+	Auth    *postmanAuth    `json:"auth,omitempty"`
+}
+
+type postmanRequest struct {
+	Method string       `json:"method"`
+	URL    postmanURL   `json:"url"`
+	Header []postmanKV  `json:"header,omitempty"`
+	Body   *postmanBody `json:"body,omitempty"`
+	Auth   *postmanAuth `json:"auth,omitempty"`
+}
+
+// postmanURL is either a string or an object with the raw URL.
+type postmanURL struct {
+	Raw string `json:"raw"`
+}
+
+func (u *postmanURL) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &u.Raw)
+	}
+	var obj struct {
+		Raw string `json:"raw"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	u.Raw = obj.Raw
+	return nil
+}
+
+type postmanKV struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+type postmanBody struct {
+	Mode       string      `json:"mode"`
+	Raw        string      `json:"raw,omitempty"`
+	URLEncoded []postmanKV `json:"urlencoded,omitempty"`
+}
+
+type postmanAuth struct {
+	Type   string      `json:"type"`
+	Bearer []postmanKV `json:"bearer,omitempty"`
+	APIKey []postmanKV `json:"apikey,omitempty"`
+	OAuth2 []postmanKV `json:"oauth2,omitempty"`
+}
+
+func (a *postmanAuth) param(params []postmanKV, key string) string {
+	for _, p := range params {
+		if p.Key == key {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// postmanVariable matches {{name}} references to collection and
+// environment variables.
+var postmanVariable = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// Postman converts the requests of a Postman v2.1 collection into steps, in
+// the order the collection runner would issue them. Collection variables
+// and credentials are read from the Secret rather than copied from the
+// collection.
+func Postman(data []byte, opts Options) (*Recording, error) {
+	var c postmanCollection
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing postman collection: %v", err)
+	}
+	if c.Info.Schema != "" && !strings.Contains(c.Info.Schema, "v2.1") {
+		return nil, fmt.Errorf("unsupported postman collection schema %q, expected v2.1", c.Info.Schema)
+	}
+
+	rec := newRecording(opts)
+	used := map[string]bool{}
+	if err := rec.postmanItems(c.Item, c.Auth, used); err != nil {
+		return nil, err
+	}
+	if len(rec.Steps) == 0 {
+		return nil, fmt.Errorf("collection contains no requests to import")
+	}
+	return rec, nil
+}
+
+func (r *Recording) postmanItems(items []postmanItem, auth *postmanAuth, used map[string]bool) error {
+	for _, item := range items {
+		itemAuth := auth
+		if item.Auth != nil {
+			itemAuth = item.Auth
+		}
+		if item.Request == nil {
+			if err := r.postmanItems(item.Item, itemAuth, used); err != nil {
+				return err
+			}
+			continue
+		}
+		if item.Request.Auth != nil {
+			itemAuth = item.Request.Auth
+		}
+		step, err := r.postmanStep(item.Name, item.Request, itemAuth, used)
+		if err != nil {
+			return fmt.Errorf("request %q: %v", item.Name, err)
+		}
+		r.Steps = append(r.Steps, *step)
+	}
+	return nil
+}
+
+func (r *Recording) postmanStep(name string, req *postmanRequest, auth *postmanAuth, used map[string]bool) (*syntheticv1.HTTPStep, error) {
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = "GET"
+	}
+	step := &syntheticv1.HTTPStep{}
+	step.Method = method
+	step.URL = r.postmanVariables(req.URL.Raw, step)
+	if name == "" {
+		name = method + " " + req.URL.Raw
+	}
+	step.Name = stepName("", name, used)
+
+	for _, h := range req.Header {
+		if h.Disabled {
+			continue
+		}
+		if header, ok := r.header(h.Key, h.Value); ok {
+			header.Value = r.postmanVariables(header.Value, step)
+			step.Headers = append(step.Headers, header)
+		}
+	}
+
+	if req.Body != nil {
+		switch req.Body.Mode {
+		case "raw":
+			body := req.Body.Raw
+			for _, h := range req.Header {
+				if strings.EqualFold(h.Key, "Content-Type") && !h.Disabled {
+					body = r.redactBody(body, h.Value, step)
+				}
+			}
+			step.Body = r.postmanVariables(body, step)
+		case "urlencoded":
+			form := make([]string, 0, len(req.Body.URLEncoded))
+			for _, kv := range req.Body.URLEncoded {
+				if kv.Disabled {
+					continue
+				}
+				// Values that reference variables are not escaped, so that
+				// the references survive.
+				value := url.QueryEscape(kv.Value)
+				if postmanVariable.MatchString(kv.Value) {
+					value = r.postmanVariables(kv.Value, step)
+				}
+				form = append(form, url.QueryEscape(kv.Key)+"="+value)
+			}
+			step.Body = strings.Join(form, "&")
+		case "", "none":
+		default:
+			return nil, fmt.Errorf("unsupported body mode %q", req.Body.Mode)
+		}
+	}
+
+	if err := r.postmanAuth(auth, step); err != nil {
+		return nil, err
+	}
+	return step, nil
+}
+
+// postmanAuth adds the credentials of auth to step, reading them from the
+// Secret.
+func (r *Recording) postmanAuth(auth *postmanAuth, step *syntheticv1.HTTPStep) error {
+	if auth == nil {
+		return nil
+	}
+	switch auth.Type {
+	case "", "noauth":
+	case "bearer", "oauth2":
+		token := auth.param(auth.Bearer, "token")
+		if auth.Type == "oauth2" {
+			token = auth.param(auth.OAuth2, "accessToken")
+		}
+		key := "token"
+		if m := postmanVariable.FindStringSubmatch(token); m != nil {
+			key = m[1]
+		}
+		variable := variableName(key)
+		r.addVariable(step, variable, r.secretRef(key))
+		step.Headers = append(step.Headers, syntheticv1.HTTPHeader{Name: "Authorization", Value: "Bearer $(" + variable + ")"})
+	case "basic":
+		// The header value cannot be assembled from a username and password
+		// at run time, so the Secret holds the whole header.
+		step.Headers = append(step.Headers, syntheticv1.HTTPHeader{Name: "Authorization", ValueFrom: r.secretRef("authorization")})
+	case "apikey":
+		key := auth.param(auth.APIKey, "key")
+		if key == "" {
+			key = "X-API-Key"
+		}
+		secretKey := key
+		if m := postmanVariable.FindStringSubmatch(auth.param(auth.APIKey, "value")); m != nil {
+			secretKey = m[1]
+		}
+		from := r.secretRef(secretKey)
+		if auth.param(auth.APIKey, "in") == "query" {
+			variable := variableName(key)
+			r.addVariable(step, variable, from)
+			sep := "?"
+			if strings.Contains(step.URL, "?") {
+				sep = "&"
+			}
+			step.URL += sep + url.QueryEscape(key) + "=$(" + variable + ")"
+			break
+		}
+		step.Headers = append(step.Headers, syntheticv1.HTTPHeader{Name: key, ValueFrom: from})
+	default:
+		return fmt.Errorf("unsupported auth type %q", auth.Type)
+	}
+	return nil
+}
+
+// postmanVariables rewrites the {{name}} references of s to probe variables
+// read from the Secret. Postman's dynamic variables, such as {{$guid}}, are
+// left as they are.
+func (r *Recording) postmanVariables(s string, step *syntheticv1.HTTPStep) string {
+	return postmanVariable.ReplaceAllStringFunc(s, func(ref string) string {
+		name := postmanVariable.FindStringSubmatch(ref)[1]
+		if strings.HasPrefix(name, "$") {
+			return ref
+		}
+		variable := variableName(name)
+		r.addVariable(step, variable, r.secretRef(name))
+		return "$(" + variable + ")"
+	})
+}
@@ -0,0 +1,362 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema used by OpenAPI 3 to describe request and response bodies.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Error is a single violation of a schema.
+type Error struct {
+	// Path is the JSON pointer of the offending value.
+	Path    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Schema is a parsed JSON Schema.
+type Schema struct {
+	root interface{}
+}
+
+// Parse parses a JSON Schema document.
+func Parse(data []byte) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parsing schema: %v", err)
+	}
+	if _, ok := root.(map[string]interface{}); !ok {
+		if _, ok := root.(bool); !ok {
+			return nil, fmt.Errorf("schema must be an object or a boolean")
+		}
+	}
+	return &Schema{root: root}, nil
+}
+
+// Validate checks doc, decoded with encoding/json, against the schema and
+// returns every violation found.
+func (s *Schema) Validate(doc interface{}) []*Error {
+	v := &validator{root: s.root}
+	v.validate(s.root, doc, "")
+	return v.errs
+}
+
+type validator struct {
+	root interface{}
+	errs []*Error
+}
+
+// resolve returns the schema a local reference such as
+// "#/definitions/Pet" points to.
+func (v *validator) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference %q, only local references are resolved", ref)
+	}
+	cur := v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolved reference %q", ref)
+		}
+		if cur, ok = obj[token]; !ok {
+			return nil, fmt.Errorf("unresolved reference %q", ref)
+		}
+	}
+	return cur, nil
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &Error{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(schema, doc interface{}, path string) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.fail(path, "no value is allowed")
+		}
+		return
+	case map[string]interface{}:
+		v.validateObject(s, doc, path)
+	}
+}
+
+func (v *validator) validateObject(s map[string]interface{}, doc interface{}, path string) {
+	// Keywords next to a reference are ignored, as in JSON Schema draft 7.
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		v.validate(target, doc, path)
+		return
+	}
+	if doc == nil && s["nullable"] == true {
+		return
+	}
+	if t, ok := s["type"]; ok && !matchesType(t, doc) {
+		v.fail(path, "expected %s, got %s", typeList(t), typeOf(doc))
+		return
+	}
+	if enum, ok := s["enum"].([]interface{}); ok && !contains(enum, doc) {
+		v.fail(path, "value is not one of the allowed values")
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, doc) {
+		v.fail(path, "value does not equal the constant")
+	}
+
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		v.validateProperties(s, d, path)
+	case []interface{}:
+		v.validateItems(s, d, path)
+	case string:
+		v.validateString(s, d, path)
+	case float64:
+		v.validateNumber(s, d, path)
+	}
+
+	if allOf, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			v.validate(sub, doc, path)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok && v.matching(anyOf, doc, path) == 0 {
+		v.fail(path, "value does not match any of the anyOf schemas")
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		if n := v.matching(oneOf, doc, path); n != 1 {
+			v.fail(path, "value matches %d of the oneOf schemas, expected exactly one", n)
+		}
+	}
+	if not, ok := s["not"]; ok && v.matches(not, doc, path) {
+		v.fail(path, "value matches the schema it must not match")
+	}
+}
+
+func (v *validator) validateProperties(s map[string]interface{}, doc map[string]interface{}, path string) {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := doc[name]; !ok {
+				v.fail(path, "missing required property %q", name)
+			}
+		}
+	}
+	props, _ := s["properties"].(map[string]interface{})
+	// Properties are visited in order so that errors are reported
+	// deterministically.
+	names := make([]string, 0, len(doc))
+	for name := range doc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := path + "/" + escape(name)
+		if sub, ok := props[name]; ok {
+			v.validate(sub, doc[name], child)
+			continue
+		}
+		if additional, ok := s["additionalProperties"]; ok {
+			if additional == false {
+				v.fail(child, "property is not allowed")
+				continue
+			}
+			v.validate(additional, doc[name], child)
+		}
+	}
+	if min, ok := number(s["minProperties"]); ok && float64(len(doc)) < min {
+		v.fail(path, "expected at least %v properties", min)
+	}
+	if max, ok := number(s["maxProperties"]); ok && float64(len(doc)) > max {
+		v.fail(path, "expected at most %v properties", max)
+	}
+}
+
+func (v *validator) validateItems(s map[string]interface{}, doc []interface{}, path string) {
+	if items, ok := s["items"]; ok {
+		for i, item := range doc {
+			v.validate(items, item, fmt.Sprintf("%s/%d", path, i))
+		}
+	}
+	if min, ok := number(s["minItems"]); ok && float64(len(doc)) < min {
+		v.fail(path, "expected at least %v items", min)
+	}
+	if max, ok := number(s["maxItems"]); ok && float64(len(doc)) > max {
+		v.fail(path, "expected at most %v items", max)
+	}
+	if s["uniqueItems"] == true {
+		for i := range doc {
+			for j := i + 1; j < len(doc); j++ {
+				if reflect.DeepEqual(doc[i], doc[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) validateString(s map[string]interface{}, doc string, path string) {
+	length := float64(len([]rune(doc)))
+	if min, ok := number(s["minLength"]); ok && length < min {
+		v.fail(path, "expected at least %v characters", min)
+	}
+	if max, ok := number(s["maxLength"]); ok && length > max {
+		v.fail(path, "expected at most %v characters", max)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			v.fail(path, "invalid pattern %q: %v", pattern, err)
+		} else if !re.MatchString(doc) {
+			v.fail(path, "value does not match pattern %q", pattern)
+		}
+	}
+}
+
+func (v *validator) validateNumber(s map[string]interface{}, doc float64, path string) {
+	// OpenAPI 3.0 uses boolean exclusive bounds, JSON Schema 2019 numeric ones.
+	if min, ok := number(s["minimum"]); ok {
+		if (s["exclusiveMinimum"] == true && doc <= min) || doc < min {
+			v.fail(path, "value is below the minimum %v", min)
+		}
+	}
+	if min, ok := number(s["exclusiveMinimum"]); ok && doc <= min {
+		v.fail(path, "value must be greater than %v", min)
+	}
+	if max, ok := number(s["maximum"]); ok {
+		if (s["exclusiveMaximum"] == true && doc >= max) || doc > max {
+			v.fail(path, "value is above the maximum %v", max)
+		}
+	}
+	if max, ok := number(s["exclusiveMaximum"]); ok && doc >= max {
+		v.fail(path, "value must be less than %v", max)
+	}
+	if m, ok := number(s["multipleOf"]); ok && m > 0 {
+		if q := doc / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(path, "value is not a multiple of %v", m)
+		}
+	}
+}
+
+// matching returns how many of schemas doc matches.
+func (v *validator) matching(schemas []interface{}, doc interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if v.matches(sub, doc, path) {
+			n++
+		}
+	}
+	return n
+}
+
+func (v *validator) matches(schema, doc interface{}, path string) bool {
+	sub := &validator{root: v.root}
+	sub.validate(schema, doc, path)
+	return len(sub.errs) == 0
+}
+
+func matchesType(t, doc interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return isType(t, doc)
+	case []interface{}:
+		for _, name := range t {
+			if s, ok := name.(string); ok && isType(s, doc) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isType(name string, doc interface{}) bool {
+	switch name {
+	case "integer":
+		f, ok := doc.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := doc.(float64)
+		return ok
+	}
+	return typeOf(doc) == name
+}
+
+func typeOf(doc interface{}) string {
+	switch doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", doc)
+}
+
+func typeList(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func contains(list []interface{}, doc interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, doc) {
+			return true
+		}
+	}
+	return false
+}
+
+// escape encodes a property name as a JSON pointer token.
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
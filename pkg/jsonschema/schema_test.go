@@ -0,0 +1,112 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonschema
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const petSchema = `{
+	"type": "object",
+	"required": ["id", "name"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"name": {"type": "string", "minLength": 1},
+		"tag": {"type": "string", "nullable": true},
+		"status": {"enum": ["available", "sold"]},
+		"photos": {"type": "array", "maxItems": 2, "items": {"type": "string", "pattern": "^https://"}}
+	}
+}`
+
+func validate(t *testing.T, schema, doc string) []string {
+	s, err := Parse([]byte(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, e := range s.Validate(v) {
+		out = append(out, e.Error())
+	}
+	return out
+}
+
+func TestValidate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(validate(t, petSchema, `{"id": 1, "name": "rex", "tag": null, "status": "sold", "photos": ["https://a"]}`)).To(BeEmpty())
+
+	g.Expect(validate(t, petSchema, `{"id": 1.5, "tag": 3, "status": "lost", "owner": "me", "photos": ["http://a", "https://b", "https://c"]}`)).To(ConsistOf(
+		`missing required property "name"`,
+		`/id: expected integer, got number`,
+		`/owner: property is not allowed`,
+		`/photos: expected at most 2 items`,
+		`/photos/0: value does not match pattern "^https://"`,
+		`/status: value is not one of the allowed values`,
+		`/tag: expected string, got number`,
+	))
+
+	g.Expect(validate(t, petSchema, `[]`)).To(ConsistOf(`expected object, got array`))
+}
+
+func TestCombinators(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	oneOf := `{"oneOf": [{"type": "integer"}, {"type": "number", "minimum": 10}]}`
+	g.Expect(validate(t, oneOf, `3`)).To(BeEmpty())
+	g.Expect(validate(t, oneOf, `10.5`)).To(BeEmpty())
+	g.Expect(validate(t, oneOf, `12`)).To(ConsistOf(`value matches 2 of the oneOf schemas, expected exactly one`))
+
+	anyOf := `{"anyOf": [{"type": "string"}, {"type": "boolean"}]}`
+	g.Expect(validate(t, anyOf, `true`)).To(BeEmpty())
+	g.Expect(validate(t, anyOf, `1`)).To(HaveLen(1))
+
+	allOf := `{"allOf": [{"required": ["a"]}, {"required": ["b"]}], "not": {"required": ["c"]}}`
+	g.Expect(validate(t, allOf, `{"a": 1, "b": 2}`)).To(BeEmpty())
+	g.Expect(validate(t, allOf, `{"a": 1, "c": 3}`)).To(ConsistOf(
+		`missing required property "b"`,
+		`value matches the schema it must not match`,
+	))
+}
+
+func TestReferences(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tree := `{
+		"$ref": "#/definitions/Node",
+		"definitions": {
+			"Node": {
+				"type": "object",
+				"required": ["name"],
+				"properties": {"children": {"type": "array", "items": {"$ref": "#/definitions/Node"}}}
+			}
+		}
+	}`
+	g.Expect(validate(t, tree, `{"name": "a", "children": [{"name": "b", "children": []}]}`)).To(BeEmpty())
+	g.Expect(validate(t, tree, `{"name": "a", "children": [{"children": []}]}`)).To(ConsistOf(
+		`/children/0: missing required property "name"`,
+	))
+	g.Expect(validate(t, `{"$ref": "#/definitions/Missing"}`, `{}`)).To(ConsistOf(
+		`unresolved reference "#/definitions/Missing"`,
+	))
+}
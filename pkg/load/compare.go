@@ -0,0 +1,184 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+const (
+	// histogramBase is the upper bound of the first histogram bucket.
+	histogramBase = 100 * time.Microsecond
+	// bucketsPerDoubling is the number of histogram buckets between a
+	// latency and its double.
+	bucketsPerDoubling = 8
+)
+
+// bucket returns the histogram bucket latency falls in.
+func bucket(latency time.Duration) int {
+	if latency <= histogramBase {
+		return 0
+	}
+	return int(math.Ceil(math.Log2(float64(latency)/float64(histogramBase)) * bucketsPerDoubling))
+}
+
+// Histogram counts latencies in the buckets of LoadSummary.Histogram.
+func Histogram(latencies []time.Duration) []int64 {
+	var out []int64
+	for _, l := range latencies {
+		b := bucket(l)
+		for len(out) <= b {
+			out = append(out, 0)
+		}
+		out[b]++
+	}
+	return out
+}
+
+// mergeHistogram adds the counts of other to h.
+func mergeHistogram(h *[]int64, other []int64) {
+	for len(*h) < len(other) {
+		*h = append(*h, 0)
+	}
+	for i, n := range other {
+		(*h)[i] += n
+	}
+}
+
+// MannWhitney returns the one-sided p-value of a Mann-Whitney U test for the
+// values counted by the histogram a being stochastically greater than those
+// counted by b. Values in the same bucket are ties. The normal approximation
+// is used, with corrections for ties and continuity, which is accurate for
+// the sample sizes of load tests. It returns 1 when either sample is empty.
+func MannWhitney(a, b []int64) float64 {
+	var n1, n2 float64
+	for _, n := range a {
+		n1 += float64(n)
+	}
+	for _, n := range b {
+		n2 += float64(n)
+	}
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	total := n1 + n2
+
+	// Values in the same bucket share the average of the ranks they span.
+	var rank, rankSum, ties float64
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var ca, cb float64
+		if i < len(a) {
+			ca = float64(a[i])
+		}
+		if i < len(b) {
+			cb = float64(b[i])
+		}
+		t := ca + cb
+		rankSum += ca * (rank + (t+1)/2)
+		rank += t
+		ties += t*t*t - t
+	}
+	u := rankSum - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((total + 1) - ties/(total*(total-1)))
+	if variance <= 0 {
+		// Every value is in the same bucket.
+		return 1
+	}
+	z := (u - mean - 0.5) / math.Sqrt(variance)
+	return math.Erfc(z/math.Sqrt2) / 2
+}
+
+// Compare compares the summary of a run with that of its baseline, which is
+// described by name, with the tolerance and confidence level of baseline.
+func Compare(run, base *syntheticv1.LoadSummary, name string, baseline *syntheticv1.Baseline) *syntheticv1.Comparison {
+	out := &syntheticv1.Comparison{Baseline: name}
+	tolerance := float64(baseline.ToleranceOrDefault())
+	var exceeded []string
+
+	// Throughput regresses when it drops.
+	before, after := base.RequestsPerSecond(), run.RequestsPerSecond()
+	d := syntheticv1.Delta{
+		Name:     "throughput",
+		Baseline: fmt.Sprintf("%.1f/s", before),
+		Current:  fmt.Sprintf("%.1f/s", after),
+		Change:   change(before, after),
+		Exceeded: before > 0 && (before-after)/before*100 > tolerance,
+	}
+	out.Deltas = append(out.Deltas, d)
+	throughput := d.Exceeded
+	if d.Exceeded {
+		exceeded = append(exceeded, "throughput "+d.Change)
+	}
+
+	// Latency regresses when it rises.
+	var latency []string
+	for _, p := range []struct {
+		name        string
+		before, now time.Duration
+	}{
+		{"p50", base.Latency.P50.Duration, run.Latency.P50.Duration},
+		{"p90", base.Latency.P90.Duration, run.Latency.P90.Duration},
+		{"p95", base.Latency.P95.Duration, run.Latency.P95.Duration},
+		{"p99", base.Latency.P99.Duration, run.Latency.P99.Duration},
+	} {
+		before, after := float64(p.before), float64(p.now)
+		d := syntheticv1.Delta{
+			Name:     p.name,
+			Baseline: p.before.String(),
+			Current:  p.now.String(),
+			Change:   change(before, after),
+			Exceeded: before > 0 && (after-before)/before*100 > tolerance,
+		}
+		out.Deltas = append(out.Deltas, d)
+		if d.Exceeded {
+			latency = append(latency, p.name+" "+d.Change)
+		}
+	}
+
+	if len(run.Histogram) > 0 && len(base.Histogram) > 0 {
+		p := MannWhitney(run.Histogram, base.Histogram)
+		out.PValue = fmt.Sprintf("%.4g", p)
+		out.Significant = p < 1-float64(baseline.ConfidenceOrDefault())/100
+	}
+	if out.Significant {
+		exceeded = append(exceeded, latency...)
+	}
+
+	out.Regression = throughput || out.Significant && len(latency) > 0
+	switch {
+	case out.Regression:
+		out.Message = fmt.Sprintf("regressed beyond %v%% tolerance: %s", tolerance, strings.Join(exceeded, ", "))
+	case len(latency) > 0 && out.PValue == "":
+		out.Message = "latency histograms are missing, so latency changes cannot be tested for significance"
+	case len(latency) > 0:
+		out.Message = fmt.Sprintf("latency changes are not significant at the %d%% confidence level", baseline.ConfidenceOrDefault())
+	}
+	return out
+}
+
+// change formats the relative change from before to after.
+func change(before, after float64) string {
+	if before == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", (after-before)/before*100)
+}
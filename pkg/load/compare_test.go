@@ -0,0 +1,117 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestHistogram(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(bucket(0)).To(Equal(0))
+	g.Expect(bucket(100 * time.Microsecond)).To(Equal(0))
+	g.Expect(bucket(101 * time.Microsecond)).To(Equal(1))
+	g.Expect(bucket(200 * time.Microsecond)).To(Equal(bucketsPerDoubling))
+	g.Expect(bucket(201 * time.Microsecond)).To(Equal(bucketsPerDoubling + 1))
+
+	h := Histogram([]time.Duration{50 * time.Microsecond, 200 * time.Microsecond, 190 * time.Microsecond})
+	g.Expect(h).To(HaveLen(bucketsPerDoubling + 1))
+	g.Expect(h[0]).To(BeEquivalentTo(1))
+	g.Expect(h[bucketsPerDoubling]).To(BeEquivalentTo(2))
+
+	mergeHistogram(&h, []int64{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4})
+	g.Expect(h).To(Equal([]int64{2, 0, 0, 0, 0, 0, 0, 0, 2, 0, 4}))
+}
+
+// sample returns n latencies drawn from a log-normal distribution with the
+// given median.
+func sample(r *rand.Rand, n int, median time.Duration) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = time.Duration(float64(median) * (1 + 0.3*r.NormFloat64()))
+	}
+	return out
+}
+
+func TestMannWhitney(t *testing.T) {
+	g := NewGomegaWithT(t)
+	r := rand.New(rand.NewSource(1))
+
+	base := Histogram(sample(r, 2000, 50*time.Millisecond))
+	same := Histogram(sample(r, 2000, 50*time.Millisecond))
+	slower := Histogram(sample(r, 2000, 55*time.Millisecond))
+
+	g.Expect(MannWhitney(same, base)).To(BeNumerically(">", 0.05))
+	g.Expect(MannWhitney(slower, base)).To(BeNumerically("<", 0.001))
+	g.Expect(MannWhitney(base, slower)).To(BeNumerically(">", 0.999))
+
+	g.Expect(MannWhitney(nil, base)).To(BeEquivalentTo(1))
+	g.Expect(MannWhitney([]int64{5}, []int64{7})).To(BeEquivalentTo(1))
+}
+
+func summaryOf(latencies []time.Duration, rps float64) *syntheticv1.LoadSummary {
+	sortDurations(latencies)
+	return &syntheticv1.LoadSummary{
+		Requests:  int64(len(latencies)),
+		Duration:  metav1.Duration{Duration: time.Duration(float64(len(latencies)) / rps * float64(time.Second))},
+		Latency:   Percentiles(latencies),
+		Histogram: Histogram(latencies),
+	}
+}
+
+func TestCompare(t *testing.T) {
+	g := NewGomegaWithT(t)
+	r := rand.New(rand.NewSource(1))
+	baseline := &syntheticv1.Baseline{Snapshot: "release"}
+	base := summaryOf(sample(r, 2000, 50*time.Millisecond), 100)
+
+	// Latency rose by a fifth.
+	c := Compare(summaryOf(sample(r, 2000, 60*time.Millisecond), 100), base, "snapshot release", baseline)
+	g.Expect(c.Baseline).To(Equal("snapshot release"))
+	g.Expect(c.Deltas).To(HaveLen(5))
+	g.Expect(c.Deltas[0]).To(Equal(syntheticv1.Delta{Name: "throughput", Baseline: "100.0/s", Current: "100.0/s", Change: "+0.0%"}))
+	g.Expect(c.Deltas[1].Name).To(Equal("p50"))
+	g.Expect(c.Deltas[1].Exceeded).To(BeTrue())
+	g.Expect(c.Significant).To(BeTrue())
+	g.Expect(c.Regression).To(BeTrue())
+	g.Expect(c.Message).To(HavePrefix("regressed beyond 10% tolerance: p50 +"))
+
+	// The same change is tolerated with a larger tolerance.
+	tolerance := int32(30)
+	c = Compare(summaryOf(sample(r, 2000, 60*time.Millisecond), 100), base, "snapshot release", &syntheticv1.Baseline{Tolerance: &tolerance})
+	g.Expect(c.Significant).To(BeTrue())
+	g.Expect(c.Regression).To(BeFalse())
+
+	// A small sample is not significant.
+	c = Compare(summaryOf(sample(r, 5, 60*time.Millisecond), 100), base, "snapshot release", baseline)
+	g.Expect(c.Significant).To(BeFalse())
+	g.Expect(c.Regression).To(BeFalse())
+
+	// Throughput dropped by a third.
+	c = Compare(summaryOf(sample(r, 2000, 50*time.Millisecond), 66), base, "snapshot release", baseline)
+	g.Expect(c.Deltas[0].Change).To(Equal("-34.0%"))
+	g.Expect(c.Deltas[0].Exceeded).To(BeTrue())
+	g.Expect(c.Regression).To(BeTrue())
+	g.Expect(c.Message).To(Equal("regressed beyond 10% tolerance: throughput -34.0%"))
+}
@@ -0,0 +1,149 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// transport is an HTTP transport whose idle connections can be dropped.
+type transport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// newTransport returns a transport for HTTP requests using protocol, which
+// keeps up to conns idle connections per host.
+func newTransport(tlsConfig *tls.Config, protocol syntheticv1.HTTPProtocol, conns int) (transport, error) {
+	switch protocol {
+	case "", syntheticv1.ProtocolAuto:
+		t := newHTTP1Transport(tlsConfig, conns)
+		if err := http2.ConfigureTransport(t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	case syntheticv1.ProtocolHTTP1:
+		t := newHTTP1Transport(tlsConfig, conns)
+		// A non-nil map turns off the built in HTTP/2 support.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		return t, nil
+	case syntheticv1.ProtocolHTTP2:
+		return newHTTP2Transport(tlsConfig), nil
+	}
+	return nil, fmt.Errorf("unknown protocol %q", protocol)
+}
+
+func newHTTP1Transport(tlsConfig *tls.Config, conns int) *http.Transport {
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: conns,
+	}
+}
+
+// http2Transport speaks HTTP/2 over TLS to https URLs and over cleartext
+// connections, with prior knowledge, to http URLs.
+type http2Transport struct {
+	tls, cleartext *http2.Transport
+}
+
+func newHTTP2Transport(tlsConfig *tls.Config) *http2Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	return &http2Transport{
+		tls: &http2.Transport{
+			TLSClientConfig: tlsConfig,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := tls.DialWithDialer(dialer, network, addr, cfg)
+				if err != nil {
+					return nil, err
+				}
+				if p := conn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+					conn.Close()
+					return nil, fmt.Errorf("unexpected ALPN protocol %q, want %q", p, http2.NextProtoTLS)
+				}
+				return &countedTLSConn{Conn: conn}, nil
+			},
+		},
+		cleartext: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				conn, err := dialer.Dial(network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return &countedConn{Conn: conn}, nil
+			},
+		},
+	}
+}
+
+// counted is a connection that counts as opened by the first request it
+// carries. The HTTP/2 transport dials without the context of the request,
+// and does not report whether it reuses a connection reliably.
+type counted interface {
+	// first returns true the first time it is called.
+	first() bool
+}
+
+type countedConn struct {
+	net.Conn
+	used int32
+}
+
+func (c *countedConn) first() bool { return atomic.CompareAndSwapInt32(&c.used, 0, 1) }
+
+// countedTLSConn keeps the connection state of the TLS connection visible to
+// the transport.
+type countedTLSConn struct {
+	*tls.Conn
+	used int32
+}
+
+func (c *countedTLSConn) first() bool { return atomic.CompareAndSwapInt32(&c.used, 0, 1) }
+
+func (t *http2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.cleartext.RoundTrip(req)
+	}
+	return t.tls.RoundTrip(req)
+}
+
+func (t *http2Transport) CloseIdleConnections() {
+	t.tls.CloseIdleConnections()
+	t.cleartext.CloseIdleConnections()
+}
+
+// newHTTPClient returns a client over t that does not follow redirects, as
+// the probe does not, so that the expected status of a step can be a
+// redirect.
+func newHTTPClient(t transport) *http.Client {
+	return &http.Client{
+		Transport: t,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
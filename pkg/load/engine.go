@@ -0,0 +1,484 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package load drives the virtual users of a LoadTest.
+package load
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// Options tune how a worker runs its share of a load test.
+type Options struct {
+	// Partition is the share of the load test the worker runs. The worker
+	// runs the whole load test when nil.
+	Partition *syntheticv1.Partition
+
+	// FeederDir is the directory feeder paths are relative to. Feeders
+	// cannot read files when it is empty.
+	FeederDir string
+
+	// Progress, when set, is called with the summary of the requests made so
+	// far every ProgressInterval while the load test runs. Calls do not
+	// overlap and none is made after Run returns.
+	Progress func(*syntheticv1.LoadSummary)
+	// ProgressInterval defaults to DefaultProgressInterval.
+	ProgressInterval time.Duration
+
+	// Tracer, when set, propagates trace context with HTTP requests. The
+	// spans it collects are flushed every DefaultProgressInterval while
+	// the load test runs, and the caller flushes the last ones.
+	Tracer *probe.Tracer
+}
+
+// DefaultProgressInterval is how often Run reports progress by default.
+const DefaultProgressInterval = 10 * time.Second
+
+// Run drives the virtual users of spec that belong to the worker described
+// by opts, each issuing the requests of the scenarios of spec over
+// keep-alive connections until spec.Duration has elapsed, and summarises the
+// requests they made.
+func Run(ctx context.Context, spec *syntheticv1.LoadTestSpec, values probe.Values, opts Options) (*syntheticv1.LoadSummary, error) {
+	scenarios := spec.AllScenarios()
+	if len(scenarios) == 0 {
+		return nil, errors.New("load test does not define a request")
+	}
+	if spec.Duration.Duration <= 0 {
+		return nil, errors.New("load test duration must be positive")
+	}
+	groups, rec, err := plan(ctx, spec, scenarios, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// Virtual users are numbered across all groups and workers, and each
+	// worker runs its partition of every group.
+	total, local := 0, 0
+	for _, g := range groups {
+		first, n := partition(g.vus, opts.Partition)
+		g.first, g.n = total+first, n
+		total += g.vus
+		local += n
+	}
+
+	feeders, err := newFeeders(ctx, spec.Feeders, values, opts.FeederDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range feeders {
+		if f.strategy == syntheticv1.FeedUniquePerVU && len(f.records) < total {
+			return nil, fmt.Errorf("feeder %q has %d records for %d virtual users", f.name, len(f.records), total)
+		}
+	}
+
+	r := &runner{feeders: feeders, values: values, tracer: opts.Tracer}
+	if spec.Connections != nil {
+		r.conns = *spec.Connections
+	}
+	// Connections are shared by every HTTP step, so the TLS settings of the
+	// first step apply to all of them.
+	for _, g := range groups {
+		for _, sc := range g.scenarios {
+			if sc.grpc != nil {
+				defer sc.grpc.transport.CloseIdleConnections()
+			}
+			if sc.produce != nil {
+				defer sc.produce.client.Close()
+			}
+			if len(sc.steps) > 0 && r.tlsConfig == nil {
+				if r.tlsConfig, err = probe.TLSClientConfig(ctx, sc.steps[0].TLS, values); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if r.tlsConfig != nil && !r.conns.PerVU {
+		if r.shared, err = newTransport(r.tlsConfig, r.conns.Protocol, local); err != nil {
+			return nil, err
+		}
+		defer r.shared.CloseIdleConnections()
+	}
+
+	// Spans are still flushed while the requests of the test are cut short.
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, spec.Duration.Duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	if opts.Progress != nil {
+		done := make(chan struct{})
+		reported := make(chan struct{})
+		defer func() {
+			close(done)
+			<-reported
+		}()
+		go func() {
+			defer close(reported)
+			report(opts, rec, start, done)
+		}()
+	}
+	if opts.Tracer != nil {
+		done := make(chan struct{})
+		flushed := make(chan struct{})
+		defer func() {
+			close(done)
+			<-flushed
+		}()
+		go func() {
+			defer close(flushed)
+			flush(parent, opts.Tracer, done)
+		}()
+	}
+	for _, g := range groups {
+		if err := r.start(ctx, g, &wg); err != nil {
+			cancel()
+			wg.Wait()
+			return nil, err
+		}
+	}
+	wg.Wait()
+
+	return rec.summary(time.Since(start)), nil
+}
+
+// report calls opts.Progress with the summary of rec every
+// opts.ProgressInterval until done is closed.
+func report(opts Options, rec *recorder, start time.Time, done <-chan struct{}) {
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			opts.Progress(rec.summary(time.Since(start)))
+		}
+	}
+}
+
+// flush exports the spans of tracer every DefaultProgressInterval until done
+// is closed. Errors are left for the final flush of the caller to report.
+func flush(ctx context.Context, tracer *probe.Tracer, done <-chan struct{}) {
+	ticker := time.NewTicker(DefaultProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			tracer.Flush(ctx)
+		}
+	}
+}
+
+// runner issues the iterations of the virtual users of a worker.
+type runner struct {
+	feeders []*feeder
+	values  probe.Values
+	tracer  *probe.Tracer
+
+	// conns and tlsConfig configure the HTTP transports, and shared is the
+	// transport of every virtual user unless they have their own.
+	conns     syntheticv1.ConnectionSettings
+	tlsConfig *tls.Config
+	shared    transport
+}
+
+// user is the state a virtual user keeps across iterations.
+type user struct {
+	index     int
+	client    *http.Client
+	transport transport
+	requests  int
+}
+
+// newUser returns virtual user index, with an HTTP transport of its own if
+// virtual users do not share one.
+func (r *runner) newUser(index int) (*user, error) {
+	u := &user{index: index, transport: r.shared}
+	if u.transport == nil && r.tlsConfig != nil {
+		t, err := newTransport(r.tlsConfig, r.conns.Protocol, 1)
+		if err != nil {
+			return nil, err
+		}
+		u.transport = t
+	}
+	if u.transport != nil {
+		u.client = newHTTPClient(u.transport)
+	}
+	return u, nil
+}
+
+// close drops the connections of u unless they are shared.
+func (r *runner) close(u *user) {
+	if u.transport != nil && u.transport != r.shared {
+		u.transport.CloseIdleConnections()
+	}
+}
+
+// start starts the virtual users of the worker in g.
+func (r *runner) start(ctx context.Context, g *group, wg *sync.WaitGroup) error {
+	users := make([]*user, g.n)
+	for i := range users {
+		u, err := r.newUser(g.first + i)
+		if err != nil {
+			return err
+		}
+		users[i] = u
+	}
+	if g.executor == syntheticv1.ExecutorConstantArrivalRate {
+		r.arrivals(ctx, g, users, wg)
+		return nil
+	}
+	for _, u := range users {
+		wg.Add(1)
+		go func(u *user) {
+			defer wg.Done()
+			defer r.close(u)
+			for !ended(ctx) {
+				r.iteration(ctx, g.pick(), u)
+			}
+		}(u)
+	}
+	return nil
+}
+
+// arrivals starts iterations of the scenario of g at its rate, dropping
+// those that find every virtual user busy.
+func (r *runner) arrivals(ctx context.Context, g *group, users []*user, wg *sync.WaitGroup) {
+	if len(users) == 0 {
+		return
+	}
+	sc := g.scenarios[0]
+	starts := make(chan struct{})
+	for _, u := range users {
+		wg.Add(1)
+		go func(u *user) {
+			defer wg.Done()
+			defer r.close(u)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-starts:
+					r.iteration(ctx, sc, u)
+				}
+			}
+		}(u)
+	}
+
+	// The rate is split across the workers in proportion to their share of
+	// the virtual users.
+	rate := g.rate * float64(g.n) / float64(g.vus)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(tick(rate))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case starts <- struct{}{}:
+				default:
+					sc.stats.drop()
+				}
+			}
+		}
+	}()
+}
+
+// tick returns the interval between events at rate per second. It is at
+// least a nanosecond, as tickers panic on shorter ones, which caps the rate
+// of a ticker at one billion per second.
+func tick(rate float64) time.Duration {
+	if d := time.Duration(float64(time.Second) / rate); d > 0 {
+		return d
+	}
+	return time.Nanosecond
+}
+
+// iteration issues the requests of sc once, as virtual user u, drawing a
+// record from every feeder first.
+func (r *runner) iteration(ctx context.Context, sc *scenario, u *user) {
+	var data []syntheticv1.Variable
+	for _, f := range r.feeders {
+		data = append(data, f.draw(u.index)...)
+	}
+	sc.stats.iterate()
+	switch {
+	case sc.grpc != nil:
+		sc.grpc.do(r.traced(ctx, sc.stats), sc, r.values, dataMap(data))
+		return
+	case sc.ws != nil:
+		sc.ws.do(ctx, sc, r.values, dataMap(data))
+		return
+	case sc.produce != nil:
+		sc.produce.do(ctx, sc, dataMap(data))
+		return
+	}
+	for i := range sc.steps {
+		step := &sc.steps[i]
+		if d := thinkTime(step); d > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		}
+		if !r.request(ctx, u, withVariables(&step.HTTPProbe, data), sc.requests[i], sc.stats) {
+			// Later steps usually depend on the earlier ones, so the
+			// iteration starts over.
+			return
+		}
+	}
+}
+
+// thinkTime returns how long to pause before step, drawn from its think
+// time distribution.
+func thinkTime(step *syntheticv1.HTTPStep) time.Duration {
+	if step.ThinkTime == nil || step.ThinkTime.Duration <= 0 {
+		return 0
+	}
+	mean := step.ThinkTime.Duration
+	switch step.ThinkTimeDistribution {
+	case syntheticv1.ThinkTimeUniform:
+		return time.Duration(rand.Int63n(2*int64(mean) + 1))
+	case syntheticv1.ThinkTimeExponential:
+		return time.Duration(rand.ExpFloat64() * float64(mean))
+	}
+	return mean
+}
+
+// traced returns ctx with a trace that counts the connections opened in sc:
+// those the HTTP/1 transport dials for requests, and those of the HTTP/2
+// transports when they carry their first request.
+func (r *runner) traced(ctx context.Context, sc *scenarioStats) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				sc.connect()
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if c, ok := info.Conn.(counted); ok && c.first() {
+				sc.connect()
+			}
+		},
+	})
+}
+
+// ended returns true once the test of ctx is over. The deadline of a context
+// passes slightly before the context reports it, and the calls failing in
+// between were cut short by the end of the test too.
+func ended(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// withVariables returns spec with vars defined ahead of its own variables,
+// which therefore take precedence.
+func withVariables(spec *syntheticv1.HTTPProbe, vars []syntheticv1.Variable) *syntheticv1.HTTPProbe {
+	if len(vars) == 0 {
+		return spec
+	}
+	out := *spec
+	out.Variables = make([]syntheticv1.Variable, 0, len(vars)+len(spec.Variables))
+	out.Variables = append(out.Variables, vars...)
+	out.Variables = append(out.Variables, spec.Variables...)
+	return &out
+}
+
+// request issues a single request as u and records it in s, and any
+// connection it opens in sc. It reports whether the request succeeded.
+func (r *runner) request(ctx context.Context, u *user, spec *syntheticv1.HTTPProbe, s *stats, sc *scenarioStats) bool {
+	if limit := int(r.conns.MaxRequestsPerConnection); limit > 0 {
+		if u.requests > 0 && u.requests%limit == 0 {
+			u.transport.CloseIdleConnections()
+		}
+		u.requests++
+	}
+
+	timeout := probe.DefaultTimeout
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		timeout = spec.Timeout.Duration
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := probe.NewRequest(reqCtx, spec, r.values)
+	if err != nil {
+		// The request cannot be built, for example because the token
+		// endpoint is down. Count it and back off instead of spinning.
+		if !ended(ctx) {
+			s.failed()
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+		return false
+	}
+
+	span := r.tracer.Start(req)
+	start := time.Now()
+	resp, err := u.client.Do(req.WithContext(r.traced(reqCtx, sc)))
+	if err != nil {
+		// Requests cut short by the end of the test are not failures.
+		if !ended(ctx) {
+			s.record(time.Since(start), false)
+			span.Finish(0, err)
+		}
+		return false
+	}
+	// The HTTP/2 transport shares the body of every empty response, which
+	// must therefore not be read concurrently.
+	if resp.ContentLength != 0 {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	resp.Body.Close()
+	latency := time.Since(start)
+	if err != nil && ended(ctx) {
+		return false
+	}
+
+	ok := err == nil && probe.CheckStatus(spec, &probe.Result{StatusCode: resp.StatusCode}).Passed
+	s.record(latency, ok)
+	span.Finish(resp.StatusCode, err)
+	return ok
+}
@@ -0,0 +1,208 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+func TestRun(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var served int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every tenth request fails.
+		if atomic.AddInt64(&served, 1)%10 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		HTTP:     &syntheticv1.HTTPProbe{URL: srv.URL},
+		VUs:      4,
+		Duration: metav1.Duration{Duration: 200 * time.Millisecond},
+	}, probe.Inline, Options{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(summary.Requests).To(BeNumerically(">", 10))
+	g.Expect(summary.Requests).To(BeNumerically("<=", atomic.LoadInt64(&served)))
+	g.Expect(summary.Failures).To(BeNumerically("~", summary.Requests/10, 4))
+	g.Expect(summary.Duration.Duration).To(BeNumerically(">=", 200*time.Millisecond))
+	g.Expect(summary.Latency.P50.Duration).To(BeNumerically("<=", summary.Latency.P99.Duration))
+	g.Expect(summary.Latency.P99.Duration).To(BeNumerically("<=", summary.Latency.Max.Duration))
+}
+
+func TestRunProgress(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var progress []*syntheticv1.LoadSummary
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		HTTP:     &syntheticv1.HTTPProbe{URL: srv.URL},
+		VUs:      2,
+		Duration: metav1.Duration{Duration: 250 * time.Millisecond},
+	}, probe.Inline, Options{
+		Progress:         func(s *syntheticv1.LoadSummary) { progress = append(progress, s) },
+		ProgressInterval: 50 * time.Millisecond,
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(len(progress)).To(BeNumerically(">=", 3))
+	for i := 1; i < len(progress); i++ {
+		g.Expect(progress[i].Requests).To(BeNumerically(">=", progress[i-1].Requests))
+		g.Expect(progress[i].Duration.Duration).To(BeNumerically(">", progress[i-1].Duration.Duration))
+	}
+	g.Expect(progress[len(progress)-1].Requests).To(BeNumerically("<=", summary.Requests))
+}
+
+func TestRunRequiresRequest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		Duration: metav1.Duration{Duration: time.Second},
+	}, probe.Inline, Options{})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestRunScenarios(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var mu sync.Mutex
+	paths := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/pay" {
+			w.WriteHeader(http.StatusPaymentRequired)
+		}
+	}))
+	defer srv.Close()
+
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		HTTP:     &syntheticv1.HTTPProbe{URL: srv.URL + "/browse"},
+		VUs:      4,
+		Duration: metav1.Duration{Duration: 300 * time.Millisecond},
+		Scenarios: []syntheticv1.Scenario{{
+			Name:   "search",
+			Weight: 3,
+			HTTP:   &syntheticv1.HTTPProbe{URL: srv.URL + "/search"},
+		}, {
+			Name:     "checkout",
+			Executor: syntheticv1.ExecutorConstantVUs,
+			Steps: []syntheticv1.HTTPStep{
+				{Name: "cart", HTTPProbe: syntheticv1.HTTPProbe{URL: srv.URL + "/cart"}},
+				{Name: "pay", HTTPProbe: syntheticv1.HTTPProbe{URL: srv.URL + "/pay"}},
+			},
+		}, {
+			Name:     "poll",
+			Executor: syntheticv1.ExecutorConstantArrivalRate,
+			Rate:     50,
+			HTTP:     &syntheticv1.HTTPProbe{URL: srv.URL + "/poll"},
+		}},
+	}, probe.Inline, Options{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(summary.Scenarios).To(HaveLen(4))
+	var requests, failures int64
+	byName := map[string]syntheticv1.ScenarioSummary{}
+	for _, s := range summary.Scenarios {
+		byName[s.Name] = s
+		requests += s.Requests
+		failures += s.Failures
+	}
+	g.Expect(summary.Requests).To(Equal(requests))
+	g.Expect(summary.Failures).To(Equal(failures))
+
+	// The shared virtual users spend three times as many iterations on
+	// search as on the default scenario.
+	def, search := byName[syntheticv1.DefaultScenario], byName["search"]
+	g.Expect(def.ByRequest).To(HaveLen(1))
+	g.Expect(def.ByRequest[0].Name).To(Equal("GET " + srv.URL + "/browse"))
+	g.Expect(float64(search.Iterations) / float64(def.Iterations)).To(BeNumerically("~", 3, 1))
+
+	// Failed payments end the iteration, so every cart is followed by a
+	// failed payment.
+	checkout := byName["checkout"]
+	g.Expect(checkout.ByRequest).To(HaveLen(2))
+	g.Expect(checkout.ByRequest[0].Name).To(Equal("cart"))
+	g.Expect(checkout.ByRequest[1].Failures).To(Equal(checkout.ByRequest[1].Requests))
+	g.Expect(checkout.Failures).To(Equal(checkout.ByRequest[1].Requests))
+
+	g.Expect(byName["poll"].Iterations + byName["poll"].DroppedIterations).To(BeNumerically("~", 15, 3))
+}
+
+func TestRunRejectsBadScenario(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		Duration: metav1.Duration{Duration: time.Second},
+		Scenarios: []syntheticv1.Scenario{{
+			Name:     "poll",
+			Executor: syntheticv1.ExecutorConstantArrivalRate,
+			HTTP:     &syntheticv1.HTTPProbe{URL: "http://example.com"},
+		}},
+	}, probe.Inline, Options{})
+	g.Expect(err).To(MatchError(`scenario "poll": rate must be positive`))
+}
+
+func TestTick(t *testing.T) {
+	g := NewGomegaWithT(t)
+	g.Expect(tick(4)).To(Equal(250 * time.Millisecond))
+	g.Expect(tick(0.5)).To(Equal(2 * time.Second))
+	g.Expect(tick(2e9)).To(Equal(time.Nanosecond))
+	// Objects stored before the schema bounded rates may hold any int32.
+	g.Expect(tick(float64(math.MaxInt32))).To(Equal(time.Nanosecond))
+}
+
+func TestThinkTime(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	step := &syntheticv1.HTTPStep{ThinkTime: &metav1.Duration{Duration: time.Second}}
+	g.Expect(thinkTime(step)).To(Equal(time.Second))
+
+	for _, d := range []syntheticv1.ThinkTimeDistribution{syntheticv1.ThinkTimeUniform, syntheticv1.ThinkTimeExponential} {
+		step.ThinkTimeDistribution = d
+		var sum time.Duration
+		for i := 0; i < 10000; i++ {
+			pause := thinkTime(step)
+			g.Expect(pause).To(BeNumerically(">=", 0))
+			if d == syntheticv1.ThinkTimeUniform {
+				g.Expect(pause).To(BeNumerically("<=", 2*time.Second))
+			}
+			sum += pause
+		}
+		g.Expect(sum/10000).To(BeNumerically("~", time.Second, 50*time.Millisecond), string(d))
+	}
+
+	g.Expect(thinkTime(&syntheticv1.HTTPStep{})).To(BeZero())
+}
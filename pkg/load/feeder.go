@@ -0,0 +1,223 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// record is a row of feeder data, as the variables it defines.
+type record []syntheticv1.Variable
+
+// feeder hands out the records of a Feeder to the virtual users of a worker.
+type feeder struct {
+	name     string
+	strategy syntheticv1.FeederStrategy
+	records  []record
+
+	mu   sync.Mutex
+	next int
+}
+
+// newFeeders reads the data of every feeder in spec. Feeder paths are
+// resolved against dir.
+func newFeeders(ctx context.Context, spec []syntheticv1.Feeder, values probe.Values, dir string) ([]*feeder, error) {
+	out := make([]*feeder, 0, len(spec))
+	for i := range spec {
+		f, err := newFeeder(ctx, &spec[i], values, dir)
+		if err != nil {
+			return nil, fmt.Errorf("feeder %q: %v", spec[i].Name, err)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func newFeeder(ctx context.Context, spec *syntheticv1.Feeder, values probe.Values, dir string) (*feeder, error) {
+	var data []byte
+	switch {
+	case spec.ConfigMapKeyRef != nil && spec.Path != "":
+		return nil, errors.New("only one of configMapKeyRef and path may be set")
+	case spec.ConfigMapKeyRef != nil:
+		value, err := values.Get(ctx, "", &syntheticv1.ValueSource{ConfigMapKeyRef: spec.ConfigMapKeyRef})
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(value)
+	case spec.Path != "":
+		path, err := feederPath(dir, spec.Path)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("one of configMapKeyRef and path must be set")
+	}
+
+	var records []record
+	var err error
+	switch spec.Format {
+	case "", syntheticv1.FeederCSV:
+		records, err = parseCSV(data)
+	case syntheticv1.FeederJSONL:
+		records, err = parseJSONL(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q", spec.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no records")
+	}
+
+	f := &feeder{name: spec.Name, strategy: spec.Strategy, records: records}
+	switch f.strategy {
+	case "":
+		f.strategy = syntheticv1.FeedSequential
+	case syntheticv1.FeedSequential, syntheticv1.FeedRandom, syntheticv1.FeedUniquePerVU:
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", spec.Strategy)
+	}
+	return f, nil
+}
+
+// feederPath resolves path against dir, refusing paths that leave dir so
+// that a load test cannot read arbitrary files of the manager.
+func feederPath(dir, path string) (string, error) {
+	if dir == "" {
+		return "", errors.New("reading feeder data from files is not enabled")
+	}
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf("path %q must be relative to the feeder directory", path)
+	}
+	clean := filepath.Clean(path)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q leaves the feeder directory", path)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// parseCSV reads comma separated values whose first row names the columns.
+func parseCSV(data []byte) ([]record, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []record
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec := make(record, len(header))
+		for i, name := range header {
+			rec[i] = syntheticv1.Variable{Name: name, Value: row[i]}
+		}
+		out = append(out, rec)
+	}
+}
+
+// parseJSONL reads one JSON object per line. String fields become their
+// value and other fields their JSON encoding.
+func parseJSONL(data []byte) ([]record, error) {
+	var out []record
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		rec := make(record, 0, len(fields))
+		for name, raw := range fields {
+			value := string(raw)
+			var s string
+			if json.Unmarshal(raw, &s) == nil {
+				value = s
+			}
+			rec = append(rec, syntheticv1.Variable{Name: name, Value: value})
+		}
+		sort.Slice(rec, func(i, j int) bool { return rec[i].Name < rec[j].Name })
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// draw returns the record for the next iteration of a virtual user. vu is
+// the index of the virtual user across all workers.
+func (f *feeder) draw(vu int) record {
+	switch f.strategy {
+	case syntheticv1.FeedRandom:
+		return f.records[rand.Intn(len(f.records))]
+	case syntheticv1.FeedUniquePerVU:
+		return f.records[vu]
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rec := f.records[f.next]
+	f.next = (f.next + 1) % len(f.records)
+	return rec
+}
+
+// partition returns the index of the first virtual user of worker part, and
+// how many virtual users it runs, when vus are split across the workers.
+func partition(vus int, part *syntheticv1.Partition) (first, n int) {
+	if part == nil || part.Count <= 1 {
+		return 0, vus
+	}
+	count, index := int(part.Count), int(part.Index)
+	n = vus / count
+	rem := vus % count
+	first = index*n + min(index, rem)
+	if index < rem {
+		n++
+	}
+	return first, n
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
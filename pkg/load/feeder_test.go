@@ -0,0 +1,163 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+func TestParseFeederData(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	records, err := parseCSV([]byte("user,password\nalice,\"a,1\"\nbob,b2\n"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(records).To(Equal([]record{
+		{{Name: "user", Value: "alice"}, {Name: "password", Value: "a,1"}},
+		{{Name: "user", Value: "bob"}, {Name: "password", Value: "b2"}},
+	}))
+
+	_, err = parseCSV([]byte("user,password\nalice\n"))
+	g.Expect(err).To(HaveOccurred())
+
+	records, err = parseJSONL([]byte(`{"user":"alice","id":7,"tags":["a"]}` + "\n\n" + `{"user":"bob"}` + "\n"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(records).To(Equal([]record{
+		{{Name: "id", Value: "7"}, {Name: "tags", Value: `["a"]`}, {Name: "user", Value: "alice"}},
+		{{Name: "user", Value: "bob"}},
+	}))
+
+	_, err = parseJSONL([]byte("{\"user\":\"alice\"}\nnot json\n"))
+	g.Expect(err).To(MatchError(ContainSubstring("line 2")))
+}
+
+func TestFeederPath(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	path, err := feederPath("/data", "users/users.csv")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(path).To(Equal("/data/users/users.csv"))
+
+	_, err = feederPath("", "users.csv")
+	g.Expect(err).To(HaveOccurred())
+	_, err = feederPath("/data", "/etc/passwd")
+	g.Expect(err).To(HaveOccurred())
+	_, err = feederPath("/data", "users/../../etc/passwd")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestFeederStrategies(t *testing.T) {
+	g := NewGomegaWithT(t)
+	records := []record{
+		{{Name: "n", Value: "0"}},
+		{{Name: "n", Value: "1"}},
+		{{Name: "n", Value: "2"}},
+	}
+
+	f := &feeder{strategy: syntheticv1.FeedSequential, records: records}
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, f.draw(0)[0].Value)
+	}
+	g.Expect(got).To(Equal([]string{"0", "1", "2", "0"}))
+
+	f = &feeder{strategy: syntheticv1.FeedUniquePerVU, records: records}
+	g.Expect(f.draw(2)[0].Value).To(Equal("2"))
+	g.Expect(f.draw(2)[0].Value).To(Equal("2"))
+
+	f = &feeder{strategy: syntheticv1.FeedRandom, records: records}
+	for i := 0; i < 10; i++ {
+		g.Expect(records).To(ContainElement(f.draw(0)))
+	}
+}
+
+func TestPartition(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	first, n := partition(10, nil)
+	g.Expect([]int{first, n}).To(Equal([]int{0, 10}))
+
+	// Every virtual user belongs to exactly one worker.
+	seen := map[int]bool{}
+	for i := int32(0); i < 3; i++ {
+		first, n := partition(10, &syntheticv1.Partition{Index: i, Count: 3})
+		for vu := first; vu < first+n; vu++ {
+			g.Expect(seen).NotTo(HaveKey(vu))
+			seen[vu] = true
+		}
+	}
+	g.Expect(seen).To(HaveLen(10))
+}
+
+func TestRunUniquePerVUAcrossWorkers(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "feeder")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	g.Expect(ioutil.WriteFile(filepath.Join(dir, "users.csv"), []byte("user\nu0\nu1\nu2\nu3\nu4\n"), 0644)).To(Succeed())
+
+	spec := &syntheticv1.LoadTestSpec{
+		VUs:      4,
+		Duration: metav1.Duration{Duration: 50 * time.Millisecond},
+		Feeders: []syntheticv1.Feeder{{
+			Name:     "users",
+			Path:     "users.csv",
+			Strategy: syntheticv1.FeedUniquePerVU,
+		}},
+	}
+
+	// Each worker sends its requests to a server of its own.
+	seen := map[string]int{}
+	for i := int32(0); i < 2; i++ {
+		var mu sync.Mutex
+		users := map[string]bool{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			users[r.URL.Query().Get("user")] = true
+			mu.Unlock()
+		}))
+		spec.HTTP = &syntheticv1.HTTPProbe{URL: srv.URL + "/?user=$(user)"}
+		_, err := Run(context.Background(), spec, probe.Inline, Options{
+			Partition: &syntheticv1.Partition{Index: i, Count: 2},
+			FeederDir: dir,
+		})
+		srv.Close()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(users).To(HaveLen(2))
+		for user := range users {
+			seen[user]++
+		}
+	}
+	g.Expect(seen).To(Equal(map[string]int{"u0": 1, "u1": 1, "u2": 1, "u3": 1}))
+
+	spec.VUs = 6
+	_, err = Run(context.Background(), spec, probe.Inline, Options{FeederDir: dir})
+	g.Expect(err).To(MatchError(ContainSubstring("has 5 records for 6 virtual users")))
+}
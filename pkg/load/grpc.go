@@ -0,0 +1,258 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// grpcClient issues the calls of a gRPC scenario. The virtual users of the
+// scenario share a connection, as the users of a gRPC channel do.
+type grpcClient struct {
+	call      *syntheticv1.GRPCCall
+	messages  [][]byte
+	transport transport
+}
+
+func newGRPCClient(ctx context.Context, call *syntheticv1.GRPCCall, values probe.Values) (*grpcClient, error) {
+	if call.URL == "" || !strings.HasPrefix(call.Method, "/") {
+		return nil, errors.New("grpc url and method, starting with a slash, must be set")
+	}
+	switch call.Type {
+	case "", syntheticv1.GRPCUnary, syntheticv1.GRPCServerStream, syntheticv1.GRPCBidiStream:
+	default:
+		return nil, fmt.Errorf("unknown grpc call type %q", call.Type)
+	}
+	c := &grpcClient{call: call}
+	for i, m := range call.Messages {
+		data, err := base64.StdEncoding.DecodeString(m)
+		if err != nil {
+			return nil, fmt.Errorf("grpc message %d: %v", i, err)
+		}
+		c.messages = append(c.messages, data)
+	}
+	if len(c.messages) == 0 {
+		// The empty message is the encoding of a message with no fields set.
+		c.messages = [][]byte{nil}
+	}
+	tlsConfig, err := probe.TLSClientConfig(ctx, call.TLS, values)
+	if err != nil {
+		return nil, err
+	}
+	c.transport = newHTTP2Transport(tlsConfig)
+	return c, nil
+}
+
+func (c *grpcClient) streaming() bool {
+	return c.call.Type == syntheticv1.GRPCServerStream || c.call.Type == syntheticv1.GRPCBidiStream
+}
+
+// do issues one call and records it in sc. The latency of a streaming call
+// is the time to its first reply. It reports whether the call succeeded.
+func (c *grpcClient) do(ctx context.Context, sc *scenario, values probe.Values, data map[string]string) bool {
+	st := sc.requests[0]
+	var callCtx context.Context
+	var cancel context.CancelFunc
+	if c.streaming() {
+		callCtx, cancel = context.WithCancel(ctx)
+	} else {
+		timeout := probe.DefaultTimeout
+		if c.call.Timeout != nil && c.call.Timeout.Duration > 0 {
+			timeout = c.call.Timeout.Duration
+		}
+		callCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	// The client ends a stream once its lifetime is over: a bidirectional
+	// stream by closing its side, and a server stream by cancelling it.
+	var deadline <-chan time.Time
+	var expired int32
+	if c.streaming() && c.call.StreamLifetime != nil && c.call.StreamLifetime.Duration > 0 {
+		timer := time.NewTimer(c.call.StreamLifetime.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+		if c.call.Type == syntheticv1.GRPCServerStream {
+			go func() {
+				select {
+				case <-deadline:
+					atomic.StoreInt32(&expired, 1)
+					cancel()
+				case <-callCtx.Done():
+				}
+			}()
+		}
+	}
+
+	req, err := c.newRequest(callCtx, sc, values, data, deadline)
+	if err != nil {
+		if !ended(ctx) {
+			st.failed()
+		}
+		return false
+	}
+
+	start := time.Now()
+	resp, err := c.transport.RoundTrip(req.WithContext(callCtx))
+	if err != nil {
+		if !ended(ctx) {
+			st.record(time.Since(start), false)
+			c.streamError(sc)
+		}
+		return false
+	}
+	defer resp.Body.Close()
+	if c.call.Type != syntheticv1.GRPCBidiStream {
+		sc.stats.send()
+	}
+
+	var latency time.Duration
+	for {
+		err = readFrame(resp.Body)
+		if err != nil {
+			break
+		}
+		if latency == 0 {
+			latency = time.Since(start)
+		}
+		sc.stats.receive()
+	}
+	if latency == 0 {
+		latency = time.Since(start)
+	}
+
+	ok := resp.StatusCode == http.StatusOK && err == io.EOF && grpcStatus(resp) == "0"
+	if atomic.LoadInt32(&expired) == 1 {
+		ok = true
+	}
+	if !ok && ended(ctx) {
+		// Calls cut short by the end of the test are not failures.
+		return false
+	}
+	st.record(latency, ok)
+	if !ok {
+		c.streamError(sc)
+	}
+	return ok
+}
+
+// newRequest builds the request of a call. The body of a bidirectional
+// stream sends the messages in turn until deadline or ctx is done.
+func (c *grpcClient) newRequest(ctx context.Context, sc *scenario, values probe.Values, data map[string]string, deadline <-chan time.Time) (*http.Request, error) {
+	var body io.Reader
+	if c.call.Type == syntheticv1.GRPCBidiStream {
+		pr, pw := io.Pipe()
+		go c.sendStream(ctx, pw, sc, deadline)
+		body = pr
+	} else {
+		body = bytes.NewReader(frame(c.messages[0]))
+	}
+
+	url := strings.TrimSuffix(probe.Expand(c.call.URL, data), "/") + c.call.Method
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range c.call.Metadata {
+		value, err := values.Get(ctx, m.Value, m.ValueFrom)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q: %v", m.Name, err)
+		}
+		req.Header.Add(m.Name, probe.Expand(value, data))
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	return req, nil
+}
+
+// sendStream writes the messages of a bidirectional stream to w at the
+// message rate of the call.
+func (c *grpcClient) sendStream(ctx context.Context, w *io.PipeWriter, sc *scenario, deadline <-chan time.Time) {
+	rate := c.call.MessageRate
+	if rate <= 0 {
+		rate = 1
+	}
+	ticker := time.NewTicker(tick(float64(rate)))
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		if _, err := w.Write(frame(c.messages[i%len(c.messages)])); err != nil {
+			return
+		}
+		sc.stats.send()
+		select {
+		case <-ctx.Done():
+			w.CloseWithError(ctx.Err())
+			return
+		case <-deadline:
+			w.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *grpcClient) streamError(sc *scenario) {
+	if c.streaming() {
+		sc.stats.streamError()
+	}
+}
+
+// frame returns msg with the gRPC length prefix.
+func frame(msg []byte) []byte {
+	out := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(out[1:5], uint32(len(msg)))
+	copy(out[5:], msg)
+	return out
+}
+
+// readFrame reads and discards the next length prefixed message of r.
+func readFrame(r io.Reader) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errors.New("truncated grpc message")
+		}
+		return err
+	}
+	n := int64(binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		return errors.New("truncated grpc message")
+	}
+	return nil
+}
+
+// grpcStatus returns the status code of a call, which servers send in the
+// headers when the call fails before any reply.
+func grpcStatus(resp *http.Response) string {
+	if s := resp.Trailer.Get("Grpc-Status"); s != "" {
+		return s
+	}
+	return resp.Header.Get("Grpc-Status")
+}
@@ -0,0 +1,69 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+	"github.com/perph/perph/pkg/queue"
+)
+
+// producer publishes the messages of a queue scenario over a connection
+// shared by every virtual user.
+type producer struct {
+	spec   *syntheticv1.QueueProducer
+	client queue.Client
+}
+
+func newProducer(ctx context.Context, spec *syntheticv1.QueueProducer, values probe.Values) (*producer, error) {
+	if spec.Topic == "" {
+		return nil, errors.New("produce topic must be set")
+	}
+	c, err := queue.Dial(ctx, spec.Kafka, spec.NATS, values)
+	if err != nil {
+		return nil, err
+	}
+	return &producer{spec: spec, client: c}, nil
+}
+
+// do publishes a batch of messages, with data substituted into the
+// payload, and records it in sc. It reports whether the broker accepted the
+// batch.
+func (p *producer) do(ctx context.Context, sc *scenario, data map[string]string) bool {
+	msg := []byte(probe.Expand(p.spec.Payload, data))
+	batch := make([][]byte, orOne(p.spec.BatchSize))
+	for i := range batch {
+		batch[i] = msg
+	}
+
+	start := time.Now()
+	err := p.client.Publish(ctx, p.spec.Topic, batch)
+	if err != nil && ended(ctx) {
+		return false
+	}
+	sc.requests[0].record(time.Since(start), err == nil)
+	if err != nil {
+		return false
+	}
+	for range batch {
+		sc.stats.send()
+	}
+	return true
+}
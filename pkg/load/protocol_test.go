@@ -0,0 +1,362 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/websocket"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// newH2CServer serves h over cleartext HTTP/2 with prior knowledge.
+func newH2CServer(h http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+}
+
+func scenarioNamed(summary *syntheticv1.LoadSummary, name string) syntheticv1.ScenarioSummary {
+	for _, s := range summary.Scenarios {
+		if s.Name == name {
+			return s
+		}
+	}
+	return syntheticv1.ScenarioSummary{}
+}
+
+func TestRunForcedHTTP2(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var http1 int64
+	srv := newH2CServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			atomic.AddInt64(&http1, 1)
+		}
+	})
+	defer srv.Close()
+
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		HTTP:     &syntheticv1.HTTPProbe{URL: srv.URL},
+		VUs:      4,
+		Duration: metav1.Duration{Duration: 100 * time.Millisecond},
+		Connections: &syntheticv1.ConnectionSettings{
+			Protocol: syntheticv1.ProtocolHTTP2,
+			PerVU:    true,
+		},
+	}, probe.Inline, Options{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(summary.Failures).To(BeZero())
+	g.Expect(atomic.LoadInt64(&http1)).To(BeZero())
+
+	// Every virtual user multiplexes its requests over a single connection.
+	def := scenarioNamed(summary, syntheticv1.DefaultScenario)
+	g.Expect(def.Requests).To(BeNumerically(">", 8))
+	g.Expect(def.Connections).To(BeEquivalentTo(4))
+}
+
+func TestRunHTTP2OverTLS(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+		}
+	}))
+	g.Expect(http2.ConfigureServer(srv.Config, &http2.Server{})).To(Succeed())
+	srv.TLS = srv.Config.TLSConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		HTTP: &syntheticv1.HTTPProbe{
+			URL: srv.URL,
+			TLS: &syntheticv1.TLSConfig{InsecureSkipVerify: true},
+		},
+		VUs:         2,
+		Duration:    metav1.Duration{Duration: 100 * time.Millisecond},
+		Connections: &syntheticv1.ConnectionSettings{Protocol: syntheticv1.ProtocolHTTP2},
+	}, probe.Inline, Options{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(summary.Failures).To(BeZero())
+
+	// The virtual users share a single connection.
+	def := scenarioNamed(summary, syntheticv1.DefaultScenario)
+	g.Expect(def.Requests).To(BeNumerically(">", 4))
+	g.Expect(def.Connections).To(BeEquivalentTo(1))
+}
+
+func TestRunConnectionChurn(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		HTTP:     &syntheticv1.HTTPProbe{URL: srv.URL},
+		Duration: metav1.Duration{Duration: 100 * time.Millisecond},
+		Connections: &syntheticv1.ConnectionSettings{
+			Protocol:                 syntheticv1.ProtocolHTTP1,
+			MaxRequestsPerConnection: 5,
+		},
+	}, probe.Inline, Options{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	def := scenarioNamed(summary, syntheticv1.DefaultScenario)
+	g.Expect(def.Requests).To(BeNumerically(">", 10))
+	g.Expect(def.Connections).To(BeNumerically("~", (def.Requests+4)/5, 1))
+}
+
+// grpcEcho is a gRPC server that replies to every request message with the
+// message itself, and to /test.Echo/Fail with an error.
+func grpcEcho(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	if strings.HasSuffix(r.URL.Path, "/Fail") {
+		w.Header().Set("Grpc-Status", "13")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		var prefix [5]byte
+		if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+			break
+		}
+		msg := make([]byte, int(prefix[4]))
+		io.ReadFull(r.Body, msg)
+		// A server stream replies three times.
+		replies := 1
+		if strings.HasSuffix(r.URL.Path, "/Stream") {
+			replies = 3
+		}
+		for i := 0; i < replies; i++ {
+			w.Write(frame(msg))
+			w.(http.Flusher).Flush()
+		}
+	}
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func TestRunGRPC(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	srv := newH2CServer(grpcEcho)
+	defer srv.Close()
+
+	msg := base64.StdEncoding.EncodeToString([]byte{0x0a, 0x02, 'h', 'i'})
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		VUs:      2,
+		Duration: metav1.Duration{Duration: 300 * time.Millisecond},
+		Scenarios: []syntheticv1.Scenario{{
+			Name: "unary",
+			GRPC: &syntheticv1.GRPCCall{URL: srv.URL, Method: "/test.Echo/Say", Messages: []string{msg}},
+		}, {
+			Name:     "stream",
+			Executor: syntheticv1.ExecutorConstantVUs,
+			GRPC: &syntheticv1.GRPCCall{
+				URL:      srv.URL,
+				Method:   "/test.Echo/Stream",
+				Type:     syntheticv1.GRPCServerStream,
+				Messages: []string{msg},
+			},
+		}, {
+			Name:     "chat",
+			Executor: syntheticv1.ExecutorConstantVUs,
+			GRPC: &syntheticv1.GRPCCall{
+				URL:            srv.URL,
+				Method:         "/test.Echo/Chat",
+				Type:           syntheticv1.GRPCBidiStream,
+				Messages:       []string{msg},
+				MessageRate:    100,
+				StreamLifetime: &metav1.Duration{Duration: 100 * time.Millisecond},
+			},
+		}, {
+			Name:     "fail",
+			Executor: syntheticv1.ExecutorConstantVUs,
+			GRPC:     &syntheticv1.GRPCCall{URL: srv.URL, Method: "/test.Echo/Fail", Type: syntheticv1.GRPCServerStream},
+		}},
+	}, probe.Inline, Options{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	unary := scenarioNamed(summary, "unary")
+	g.Expect(unary.Requests).To(BeNumerically(">", 5))
+	g.Expect(unary.Failures).To(BeZero())
+	g.Expect(unary.MessagesReceived).To(Equal(unary.Requests))
+	g.Expect(unary.ByRequest[0].Name).To(Equal("/test.Echo/Say"))
+	g.Expect(unary.Connections).To(BeEquivalentTo(1))
+
+	stream := scenarioNamed(summary, "stream")
+	g.Expect(stream.Failures).To(BeZero())
+	g.Expect(stream.MessagesReceived).To(Equal(3 * stream.MessagesSent))
+
+	// Streams end cleanly once their lifetime is over, after exchanging
+	// about ten messages each way.
+	chat := scenarioNamed(summary, "chat")
+	g.Expect(chat.Iterations).To(BeNumerically(">=", 2))
+	g.Expect(chat.StreamErrors).To(BeZero())
+	g.Expect(chat.MessagesSent).To(BeNumerically(">=", 15))
+	g.Expect(chat.MessagesReceived).To(BeNumerically("~", chat.MessagesSent, 2))
+
+	fail := scenarioNamed(summary, "fail")
+	g.Expect(fail.Failures).To(Equal(fail.Requests))
+	g.Expect(fail.StreamErrors).To(BeNumerically(">=", fail.Requests-1))
+}
+
+func TestRunWebSocket(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		for {
+			var msg string
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+			if msg == "subscribe" {
+				websocket.Message.Send(ws, "ack")
+				websocket.Message.Send(ws, `{"price":42}`)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		VUs:      2,
+		Duration: metav1.Duration{Duration: 200 * time.Millisecond},
+		Scenarios: []syntheticv1.Scenario{{
+			Name: "ticker",
+			WebSocket: &syntheticv1.WebSocketSession{
+				URL: url,
+				Steps: []syntheticv1.WebSocketStep{
+					{Name: "ping", Send: "ping", Count: 2},
+					{Name: "subscribe", Send: "subscribe"},
+					{Name: "price", Expect: `"price":\d+`},
+				},
+			},
+		}, {
+			Name: "silent",
+			WebSocket: &syntheticv1.WebSocketSession{
+				URL: url,
+				Steps: []syntheticv1.WebSocketStep{
+					{Name: "never", Expect: "never", Timeout: &metav1.Duration{Duration: 20 * time.Millisecond}},
+				},
+			},
+		}},
+	}, probe.Inline, Options{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	ticker := scenarioNamed(summary, "ticker")
+	g.Expect(ticker.Iterations).To(BeNumerically(">", 2))
+	g.Expect(ticker.ByRequest).To(HaveLen(2))
+	g.Expect(ticker.ByRequest[0].Name).To(Equal("connect"))
+	g.Expect(ticker.ByRequest[1].Name).To(Equal("price"))
+	g.Expect(ticker.ByRequest[1].Failures).To(BeZero())
+	g.Expect(ticker.Connections).To(Equal(ticker.ByRequest[0].Requests))
+	g.Expect(ticker.MessagesSent).To(BeNumerically(">=", 3*ticker.ByRequest[1].Requests))
+
+	silent := scenarioNamed(summary, "silent")
+	g.Expect(silent.ByRequest[1].Failures).To(Equal(silent.ByRequest[1].Requests))
+	g.Expect(silent.StreamErrors).To(BeNumerically(">", 0))
+}
+
+func TestRunProduce(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// The REST proxy accepts every record whose value names a known user.
+	var accepted, rejected int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Records []struct {
+				Value []byte `json:"value"`
+			} `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		for _, rec := range in.Records {
+			if string(rec.Value) != `{"user":"alice"}` {
+				atomic.AddInt64(&rejected, 1)
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		atomic.AddInt64(&accepted, int64(len(in.Records)))
+		io.WriteString(w, `{"offsets":[]}`)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "feeder")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	g.Expect(ioutil.WriteFile(filepath.Join(dir, "users.csv"), []byte("user\nalice\nbob\n"), 0644)).To(Succeed())
+
+	summary, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		Duration: metav1.Duration{Duration: 200 * time.Millisecond},
+		Scenarios: []syntheticv1.Scenario{{
+			Name:     "orders",
+			Executor: syntheticv1.ExecutorConstantVUs,
+			VUs:      2,
+			Produce: &syntheticv1.QueueProducer{
+				Kafka:     &syntheticv1.KafkaEndpoint{URL: srv.URL},
+				Topic:     "orders",
+				Payload:   `{"user":"$(user)"}`,
+				BatchSize: 5,
+			},
+		}},
+		// Every other message names an unknown user.
+		Feeders: []syntheticv1.Feeder{{Name: "users", Path: "users.csv"}},
+	}, probe.Inline, Options{FeederDir: dir})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	orders := scenarioNamed(summary, "orders")
+	g.Expect(orders.ByRequest).To(HaveLen(1))
+	g.Expect(orders.ByRequest[0].Name).To(Equal("publish orders"))
+	g.Expect(orders.Requests).To(BeNumerically(">", 10))
+	g.Expect(orders.Failures).To(BeNumerically("~", orders.Requests/2, 2))
+	// Batches the proxy handled as the test ended are not counted.
+	g.Expect(orders.Failures).To(BeNumerically("<=", atomic.LoadInt64(&rejected)))
+	g.Expect(orders.MessagesSent).To(BeNumerically("<=", atomic.LoadInt64(&accepted)))
+	g.Expect(orders.MessagesSent).To(Equal(5 * (orders.Requests - orders.Failures)))
+}
+
+func TestRunRejectsAmbiguousScenario(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := Run(context.Background(), &syntheticv1.LoadTestSpec{
+		Duration: metav1.Duration{Duration: time.Second},
+		Scenarios: []syntheticv1.Scenario{{
+			Name:      "both",
+			HTTP:      &syntheticv1.HTTPProbe{URL: "http://example.com"},
+			WebSocket: &syntheticv1.WebSocketSession{URL: "ws://example.com"},
+		}},
+	}, probe.Inline, Options{})
+	g.Expect(err).To(MatchError(ContainSubstring("only one of")))
+}
@@ -0,0 +1,196 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// scenario is a scenario of the load test being run. Exactly one of steps,
+// grpc, ws and produce is set.
+type scenario struct {
+	steps   []syntheticv1.HTTPStep
+	grpc    *grpcClient
+	ws      *wsClient
+	produce *producer
+	stats   *scenarioStats
+
+	// requests holds the statistics each request is recorded in: every HTTP
+	// step, with steps of the same name sharing them, the gRPC call, or the
+	// WebSocket handshake followed by every step that expects a message, or
+	// the batches published to the queue.
+	requests []*stats
+}
+
+// group is a set of virtual users with a common executor.
+type group struct {
+	executor  syntheticv1.ScenarioExecutor
+	scenarios []*scenario
+	// weights holds the cumulative weights of the scenarios.
+	weights []int
+	vus     int
+	rate    float64
+
+	// first is the index, across all workers, of the first virtual user of
+	// the group that this worker runs, and n is how many it runs.
+	first, n int
+}
+
+// pick returns the scenario of the next iteration of a virtual user of g.
+func (g *group) pick() *scenario {
+	if len(g.scenarios) == 1 {
+		return g.scenarios[0]
+	}
+	n := rand.Intn(g.weights[len(g.weights)-1])
+	for i, w := range g.weights {
+		if n < w {
+			return g.scenarios[i]
+		}
+	}
+	return g.scenarios[len(g.scenarios)-1]
+}
+
+// plan groups the virtual users of the scenarios by executor. The Shared
+// scenarios, if any, form the first group.
+func plan(ctx context.Context, spec *syntheticv1.LoadTestSpec, scenarios []syntheticv1.Scenario, values probe.Values) ([]*group, *recorder, error) {
+	rec := &recorder{}
+	shared := &group{executor: syntheticv1.ExecutorShared, vus: orOne(spec.VUs)}
+	var groups []*group
+	for i := range scenarios {
+		s := &scenarios[i]
+		sc, err := newScenario(ctx, s, values)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scenario %q: %v", s.Name, err)
+		}
+		rec.scenarios = append(rec.scenarios, sc.stats)
+
+		switch s.Executor {
+		case "", syntheticv1.ExecutorShared:
+			weight := orOne(s.Weight)
+			if len(shared.weights) > 0 {
+				weight += shared.weights[len(shared.weights)-1]
+			}
+			shared.scenarios = append(shared.scenarios, sc)
+			shared.weights = append(shared.weights, weight)
+		case syntheticv1.ExecutorConstantVUs:
+			groups = append(groups, &group{
+				executor:  s.Executor,
+				scenarios: []*scenario{sc},
+				vus:       orOne(s.VUs),
+			})
+		case syntheticv1.ExecutorConstantArrivalRate:
+			if s.Rate <= 0 {
+				return nil, nil, fmt.Errorf("scenario %q: rate must be positive", s.Name)
+			}
+			groups = append(groups, &group{
+				executor:  s.Executor,
+				scenarios: []*scenario{sc},
+				vus:       orOne(s.VUs),
+				rate:      float64(s.Rate),
+			})
+		default:
+			return nil, nil, fmt.Errorf("scenario %q: unknown executor %q", s.Name, s.Executor)
+		}
+	}
+	if len(shared.scenarios) > 0 {
+		groups = append([]*group{shared}, groups...)
+	}
+	return groups, rec, nil
+}
+
+func newScenario(ctx context.Context, s *syntheticv1.Scenario, values probe.Values) (*scenario, error) {
+	steps := s.RequestSteps()
+	sc := &scenario{stats: &scenarioStats{name: s.Name}}
+	kinds := 0
+	for _, set := range []bool{len(steps) > 0, s.GRPC != nil, s.WebSocket != nil, s.Produce != nil} {
+		if set {
+			kinds++
+		}
+	}
+	switch {
+	case kinds > 1:
+		return nil, errors.New("only one of http, steps, grpc, webSocket and produce may be set")
+	case s.GRPC != nil:
+		c, err := newGRPCClient(ctx, s.GRPC, values)
+		if err != nil {
+			return nil, err
+		}
+		sc.grpc = c
+		sc.requests = []*stats{sc.stats.add(s.GRPC.Method)}
+	case s.WebSocket != nil:
+		c, err := newWSClient(ctx, s.WebSocket, values)
+		if err != nil {
+			return nil, err
+		}
+		sc.ws = c
+		sc.requests = []*stats{sc.stats.add(wsConnectRequest)}
+		for i, step := range s.WebSocket.Steps {
+			var st *stats
+			if c.expect[i] != nil {
+				st = sc.stats.add(step.Name)
+			}
+			sc.requests = append(sc.requests, st)
+		}
+	case s.Produce != nil:
+		p, err := newProducer(ctx, s.Produce, values)
+		if err != nil {
+			return nil, err
+		}
+		sc.produce = p
+		sc.requests = []*stats{sc.stats.add("publish " + s.Produce.Topic)}
+	case len(steps) > 0:
+		sc.steps = steps
+		byName := map[string]*stats{}
+		for i := range steps {
+			name := requestName(&steps[i])
+			st, ok := byName[name]
+			if !ok {
+				st = sc.stats.add(name)
+				byName[name] = st
+			}
+			sc.requests = append(sc.requests, st)
+		}
+	default:
+		return nil, errors.New("no request")
+	}
+	return sc, nil
+}
+
+// requestName names the requests of step in the summary.
+func requestName(step *syntheticv1.HTTPStep) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	method := step.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	return method + " " + step.URL
+}
+
+func orOne(n int32) int {
+	if n <= 0 {
+		return 1
+	}
+	return int(n)
+}
@@ -0,0 +1,233 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// stats collects the latency of the requests with the same name.
+type stats struct {
+	name string
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	requests  int64
+	failures  int64
+}
+
+// record counts a request that was sent.
+func (s *stats) record(latency time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies = append(s.latencies, latency)
+	s.requests++
+	if !ok {
+		s.failures++
+	}
+}
+
+// failed counts a request that could not be sent and has no latency.
+func (s *stats) failed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.failures++
+}
+
+// scenarioStats collects the iterations of a scenario and its requests.
+type scenarioStats struct {
+	// Accessed atomically.
+	iterations   int64
+	dropped      int64
+	connections  int64
+	sent         int64
+	received     int64
+	streamErrors int64
+
+	name     string
+	requests []*stats
+}
+
+// add returns new statistics for the requests named name.
+func (s *scenarioStats) add(name string) *stats {
+	st := &stats{name: name}
+	s.requests = append(s.requests, st)
+	return st
+}
+
+func (s *scenarioStats) iterate()     { atomic.AddInt64(&s.iterations, 1) }
+func (s *scenarioStats) drop()        { atomic.AddInt64(&s.dropped, 1) }
+func (s *scenarioStats) connect()     { atomic.AddInt64(&s.connections, 1) }
+func (s *scenarioStats) send()        { atomic.AddInt64(&s.sent, 1) }
+func (s *scenarioStats) receive()     { atomic.AddInt64(&s.received, 1) }
+func (s *scenarioStats) streamError() { atomic.AddInt64(&s.streamErrors, 1) }
+
+// recorder collects the statistics of every scenario.
+type recorder struct {
+	scenarios []*scenarioStats
+}
+
+func (r *recorder) summary(elapsed time.Duration) *syntheticv1.LoadSummary {
+	out := &syntheticv1.LoadSummary{Duration: metav1.Duration{Duration: elapsed}}
+	var all []time.Duration
+	for _, sc := range r.scenarios {
+		s := syntheticv1.ScenarioSummary{
+			Name:              sc.name,
+			Iterations:        atomic.LoadInt64(&sc.iterations),
+			DroppedIterations: atomic.LoadInt64(&sc.dropped),
+			Connections:       atomic.LoadInt64(&sc.connections),
+			MessagesSent:      atomic.LoadInt64(&sc.sent),
+			MessagesReceived:  atomic.LoadInt64(&sc.received),
+			StreamErrors:      atomic.LoadInt64(&sc.streamErrors),
+		}
+		var latencies []time.Duration
+		for _, req := range sc.requests {
+			req.mu.Lock()
+			sortDurations(req.latencies)
+			s.ByRequest = append(s.ByRequest, syntheticv1.RequestSummary{
+				Name:     req.name,
+				Requests: req.requests,
+				Failures: req.failures,
+				Latency:  Percentiles(req.latencies),
+			})
+			s.Requests += req.requests
+			s.Failures += req.failures
+			latencies = append(latencies, req.latencies...)
+			req.mu.Unlock()
+		}
+		sortDurations(latencies)
+		s.Latency = Percentiles(latencies)
+		all = append(all, latencies...)
+
+		out.Requests += s.Requests
+		out.Failures += s.Failures
+		out.Scenarios = append(out.Scenarios, s)
+	}
+	sortDurations(all)
+	out.Latency = Percentiles(all)
+	out.Histogram = Histogram(all)
+	return out
+}
+
+func sortDurations(d []time.Duration) {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+}
+
+// Merge combines the summaries of the workers of a load test, or returns nil
+// when there are none. Percentiles cannot be combined exactly, so each
+// latency percentile is the highest reported by any worker. Histograms are
+// combined exactly.
+func Merge(summaries []*syntheticv1.LoadSummary) *syntheticv1.LoadSummary {
+	if len(summaries) == 0 {
+		return nil
+	}
+	out := &syntheticv1.LoadSummary{}
+	for _, s := range summaries {
+		out.Requests += s.Requests
+		out.Failures += s.Failures
+		maxDuration(&out.Duration, s.Duration)
+		mergePercentiles(&out.Latency, s.Latency)
+		mergeHistogram(&out.Histogram, s.Histogram)
+		for _, sc := range s.Scenarios {
+			mergeScenario(out, sc)
+		}
+	}
+	return out
+}
+
+func mergeScenario(out *syntheticv1.LoadSummary, in syntheticv1.ScenarioSummary) {
+	var sc *syntheticv1.ScenarioSummary
+	for i := range out.Scenarios {
+		if out.Scenarios[i].Name == in.Name {
+			sc = &out.Scenarios[i]
+		}
+	}
+	if sc == nil {
+		out.Scenarios = append(out.Scenarios, syntheticv1.ScenarioSummary{Name: in.Name})
+		sc = &out.Scenarios[len(out.Scenarios)-1]
+	}
+	sc.Iterations += in.Iterations
+	sc.DroppedIterations += in.DroppedIterations
+	sc.Connections += in.Connections
+	sc.MessagesSent += in.MessagesSent
+	sc.MessagesReceived += in.MessagesReceived
+	sc.StreamErrors += in.StreamErrors
+	sc.Requests += in.Requests
+	sc.Failures += in.Failures
+	mergePercentiles(&sc.Latency, in.Latency)
+
+	for _, req := range in.ByRequest {
+		var r *syntheticv1.RequestSummary
+		for i := range sc.ByRequest {
+			if sc.ByRequest[i].Name == req.Name {
+				r = &sc.ByRequest[i]
+			}
+		}
+		if r == nil {
+			sc.ByRequest = append(sc.ByRequest, syntheticv1.RequestSummary{Name: req.Name})
+			r = &sc.ByRequest[len(sc.ByRequest)-1]
+		}
+		r.Requests += req.Requests
+		r.Failures += req.Failures
+		mergePercentiles(&r.Latency, req.Latency)
+	}
+}
+
+func mergePercentiles(p *syntheticv1.LatencyPercentiles, other syntheticv1.LatencyPercentiles) {
+	maxDuration(&p.P50, other.P50)
+	maxDuration(&p.P90, other.P90)
+	maxDuration(&p.P95, other.P95)
+	maxDuration(&p.P99, other.P99)
+	maxDuration(&p.Max, other.Max)
+}
+
+func maxDuration(d *metav1.Duration, other metav1.Duration) {
+	if other.Duration > d.Duration {
+		d.Duration = other.Duration
+	}
+}
+
+// Percentiles summarises latencies, which must be sorted in ascending order.
+func Percentiles(sorted []time.Duration) syntheticv1.LatencyPercentiles {
+	if len(sorted) == 0 {
+		return syntheticv1.LatencyPercentiles{}
+	}
+	at := func(q float64) metav1.Duration {
+		i := int(q*float64(len(sorted))+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(sorted) {
+			i = len(sorted) - 1
+		}
+		return metav1.Duration{Duration: sorted[i]}
+	}
+	return syntheticv1.LatencyPercentiles{
+		P50: at(0.50),
+		P90: at(0.90),
+		P95: at(0.95),
+		P99: at(0.99),
+		Max: metav1.Duration{Duration: sorted[len(sorted)-1]},
+	}
+}
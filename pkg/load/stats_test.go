@@ -0,0 +1,77 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestPercentiles(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	p := Percentiles(sorted)
+	g.Expect(p.P50.Duration).To(Equal(50 * time.Millisecond))
+	g.Expect(p.P90.Duration).To(Equal(90 * time.Millisecond))
+	g.Expect(p.P99.Duration).To(Equal(99 * time.Millisecond))
+	g.Expect(p.Max.Duration).To(Equal(100 * time.Millisecond))
+	g.Expect(Percentiles(nil)).To(Equal(syntheticv1.LatencyPercentiles{}))
+}
+
+func TestMerge(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	ms := func(n int) metav1.Duration { return metav1.Duration{Duration: time.Duration(n) * time.Millisecond} }
+	merged := Merge([]*syntheticv1.LoadSummary{
+		{Requests: 10, Failures: 1, Duration: ms(1000), Latency: syntheticv1.LatencyPercentiles{P50: ms(5), P99: ms(40), Max: ms(50)}},
+		{Requests: 20, Failures: 0, Duration: ms(1010), Latency: syntheticv1.LatencyPercentiles{P50: ms(7), P99: ms(30), Max: ms(60)}},
+	})
+	g.Expect(merged.Requests).To(BeEquivalentTo(30))
+	g.Expect(merged.Failures).To(BeEquivalentTo(1))
+	g.Expect(merged.Duration).To(Equal(ms(1010)))
+	g.Expect(merged.Latency.P50).To(Equal(ms(7)))
+	g.Expect(merged.Latency.P99).To(Equal(ms(40)))
+	g.Expect(merged.Latency.Max).To(Equal(ms(60)))
+	g.Expect(Merge(nil)).To(BeNil())
+
+	merged = Merge([]*syntheticv1.LoadSummary{
+		{Scenarios: []syntheticv1.ScenarioSummary{{
+			Name: "browse", Iterations: 5, Requests: 5,
+			ByRequest: []syntheticv1.RequestSummary{{Name: "home", Requests: 5, Latency: syntheticv1.LatencyPercentiles{P50: ms(3)}}},
+		}}},
+		{Scenarios: []syntheticv1.ScenarioSummary{{
+			Name: "checkout", Iterations: 1, DroppedIterations: 2,
+		}, {
+			Name: "browse", Iterations: 7, Requests: 7,
+			ByRequest: []syntheticv1.RequestSummary{{Name: "home", Requests: 7, Latency: syntheticv1.LatencyPercentiles{P50: ms(4)}}},
+		}}},
+	})
+	g.Expect(merged.Scenarios).To(Equal([]syntheticv1.ScenarioSummary{{
+		Name: "browse", Iterations: 12, Requests: 12,
+		ByRequest: []syntheticv1.RequestSummary{{Name: "home", Requests: 12, Latency: syntheticv1.LatencyPercentiles{P50: ms(4)}}},
+	}, {
+		Name: "checkout", Iterations: 1, DroppedIterations: 2,
+	}}))
+}
@@ -0,0 +1,253 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package load
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// wsConnectRequest names the opening handshake of a WebSocket session in
+// the summary.
+const wsConnectRequest = "connect"
+
+// wsClient opens the sessions of a WebSocket scenario.
+type wsClient struct {
+	session   *syntheticv1.WebSocketSession
+	tlsConfig *tls.Config
+	expect    []*regexp.Regexp
+}
+
+func newWSClient(ctx context.Context, session *syntheticv1.WebSocketSession, values probe.Values) (*wsClient, error) {
+	if session.URL == "" {
+		return nil, errors.New("websocket url must be set")
+	}
+	c := &wsClient{session: session, expect: make([]*regexp.Regexp, len(session.Steps))}
+	for i, step := range session.Steps {
+		switch {
+		case step.Send != "" && step.Expect != "":
+			return nil, fmt.Errorf("websocket step %q sets both send and expect", step.Name)
+		case step.Expect != "":
+			re, err := regexp.Compile(step.Expect)
+			if err != nil {
+				return nil, fmt.Errorf("websocket step %q: %v", step.Name, err)
+			}
+			c.expect[i] = re
+		case step.Send == "":
+			return nil, fmt.Errorf("websocket step %q sets neither send nor expect", step.Name)
+		}
+	}
+	tlsConfig, err := probe.TLSClientConfig(ctx, session.TLS, values)
+	if err != nil {
+		return nil, err
+	}
+	c.tlsConfig = tlsConfig
+	return c, nil
+}
+
+// do opens a session, performs its steps and closes it, recording the
+// handshake and every expected message in sc. It reports whether every step
+// succeeded.
+func (c *wsClient) do(ctx context.Context, sc *scenario, values probe.Values, data map[string]string) bool {
+	connect := sc.requests[0]
+	start := time.Now()
+	ws, err := c.dial(ctx, values, data)
+	if err != nil {
+		if !ended(ctx) {
+			connect.record(time.Since(start), false)
+		}
+		return false
+	}
+	connect.record(time.Since(start), true)
+	sc.stats.connect()
+
+	// Closing the connection ends the reader, and unblocks the steps when
+	// the test ends.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		ws.Close()
+	}()
+	received := make(chan string)
+	go func() {
+		defer close(received)
+		for {
+			var msg string
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+			sc.stats.receive()
+			select {
+			case received <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for i := range c.session.Steps {
+		step := &c.session.Steps[i]
+		var ok bool
+		if c.expect[i] == nil {
+			ok = c.send(ctx, ws, step, sc, data)
+		} else {
+			ok = c.await(ctx, received, c.expect[i], step, sc.requests[i+1])
+		}
+		if !ok {
+			if !ended(ctx) {
+				sc.stats.streamError()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// dial opens the connection and performs the opening handshake.
+func (c *wsClient) dial(ctx context.Context, values probe.Values, data map[string]string) (*websocket.Conn, error) {
+	location := probe.Expand(c.session.URL, data)
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	origin := *u
+	origin.Scheme = "http"
+	if u.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	origin.Path, origin.RawQuery = "", ""
+	config, err := websocket.NewConfig(location, origin.String())
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range c.session.Headers {
+		value, err := values.Get(ctx, h.Value, h.ValueFrom)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %v", h.Name, err)
+		}
+		config.Header.Add(h.Name, probe.Expand(value, data))
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	ctx, cancel := context.WithTimeout(ctx, probe.DefaultTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		tlsConfig := c.tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		conn = tls.Client(conn, tlsConfig)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ws, nil
+}
+
+// send sends the message of step as often as it asks.
+func (c *wsClient) send(ctx context.Context, ws *websocket.Conn, step *syntheticv1.WebSocketStep, sc *scenario, data map[string]string) bool {
+	count := int(step.Count)
+	if count <= 0 {
+		count = 1
+	}
+	msg := probe.Expand(step.Send, data)
+	for i := 0; i < count; i++ {
+		if i > 0 && step.Interval != nil && step.Interval.Duration > 0 {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(step.Interval.Duration):
+			}
+		}
+		if err := websocket.Message.Send(ws, msg); err != nil {
+			return false
+		}
+		sc.stats.send()
+	}
+	return true
+}
+
+// await waits for a received message matching re and records how long it
+// took in st.
+func (c *wsClient) await(ctx context.Context, received <-chan string, re *regexp.Regexp, step *syntheticv1.WebSocketStep, st *stats) bool {
+	timeout := probe.DefaultTimeout
+	if step.Timeout != nil && step.Timeout.Duration > 0 {
+		timeout = step.Timeout.Duration
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	start := time.Now()
+	for {
+		select {
+		case msg, ok := <-received:
+			if !ok {
+				if !ended(ctx) {
+					st.record(time.Since(start), false)
+				}
+				return false
+			}
+			if re.MatchString(msg) {
+				st.record(time.Since(start), true)
+				return true
+			}
+		case <-timer.C:
+			st.record(timeout, false)
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// dataMap returns the values of vars, which are all inline, keyed by name.
+func dataMap(vars []syntheticv1.Variable) map[string]string {
+	out := make(map[string]string, len(vars))
+	for _, v := range vars {
+		out[v.Name] = v.Value
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseSchedule(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, spec := range []string{
+		"* * * * *",
+		"*/15 9-17 * * MON-FRI",
+		"0 2 1,15 * *",
+		"30 4 * jan,jul 7",
+		"@daily",
+		"@weekly",
+	} {
+		_, err := ParseSchedule(spec)
+		g.Expect(err).NotTo(HaveOccurred(), spec)
+	}
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * * FUNDAY",
+		"@sometimes",
+	} {
+		_, err := ParseSchedule(spec)
+		g.Expect(err).To(HaveOccurred(), spec)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	g := NewGomegaWithT(t)
+	at := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		g.Expect(err).NotTo(HaveOccurred())
+		return tm
+	}
+	next := func(spec, from string) time.Time {
+		s, err := ParseSchedule(spec)
+		g.Expect(err).NotTo(HaveOccurred())
+		return s.Next(at(from))
+	}
+
+	// 2019-06-01 is a Saturday.
+	g.Expect(next("*/15 * * * *", "2019-06-01T10:07:30Z")).To(Equal(at("2019-06-01T10:15:00Z")))
+	g.Expect(next("*/15 * * * *", "2019-06-01T10:15:00Z")).To(Equal(at("2019-06-01T10:30:00Z")))
+	g.Expect(next("0 2 * * SUN", "2019-06-01T10:00:00Z")).To(Equal(at("2019-06-02T02:00:00Z")))
+	g.Expect(next("0 2 * * 7", "2019-06-01T10:00:00Z")).To(Equal(at("2019-06-02T02:00:00Z")))
+	g.Expect(next("0 0 1 * *", "2019-06-01T10:00:00Z")).To(Equal(at("2019-07-01T00:00:00Z")))
+	g.Expect(next("@yearly", "2019-06-01T10:00:00Z")).To(Equal(at("2020-01-01T00:00:00Z")))
+
+	// Both day fields restricted: either matches.
+	g.Expect(next("0 0 15 * MON", "2019-06-01T10:00:00Z")).To(Equal(at("2019-06-03T00:00:00Z")))
+	g.Expect(next("0 0 15 * MON", "2019-06-10T10:00:00Z")).To(Equal(at("2019-06-15T00:00:00Z")))
+
+	g.Expect(next("0 0 30 2 *", "2019-06-01T10:00:00Z").IsZero()).To(BeTrue())
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	g.Expect(err).NotTo(HaveOccurred())
+	s, _ := ParseSchedule("0 2 * * *")
+	g.Expect(s.Next(at("2019-06-01T10:00:00Z").In(berlin))).To(BeTemporally("==", at("2019-06-02T00:00:00Z")))
+}
@@ -0,0 +1,140 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package maintenance decides which maintenance windows silence a Check.
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// Silence is a maintenance window in progress.
+type Silence struct {
+	Window *syntheticv1.MaintenanceWindow
+	End    time.Time
+}
+
+// Active returns the window among windows that silences a Check with the
+// given labels at t, or nil if none does. When several do, the one that ends
+// last is returned. Invalid windows are skipped and reported in the error.
+func Active(windows []syntheticv1.MaintenanceWindow, set map[string]string, t time.Time) (*Silence, error) {
+	var out *Silence
+	var errs []error
+	for i := range windows {
+		w := &windows[i]
+		start, end, err := occurrence(w, set, t)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("maintenance window %q: %v", w.Name, err))
+			continue
+		}
+		if start.IsZero() || start.After(t) || !end.After(t) {
+			continue
+		}
+		if out == nil || end.After(out.End) {
+			out = &Silence{Window: w, End: end}
+		}
+	}
+	return out, utilerrors.NewAggregate(errs)
+}
+
+// NextChange returns the first time after t at which a window among windows
+// that applies to a Check with the given labels starts or ends, or the zero
+// time if none will. Invalid windows are skipped.
+func NextChange(windows []syntheticv1.MaintenanceWindow, set map[string]string, t time.Time) time.Time {
+	var next time.Time
+	for i := range windows {
+		start, end, err := occurrence(&windows[i], set, t)
+		if err != nil || start.IsZero() {
+			continue
+		}
+		change := start
+		if !start.After(t) {
+			change = end
+		}
+		if change.After(t) && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+	return next
+}
+
+// occurrence returns the occurrence of w in progress at t, or else the next
+// one, or zero times if w does not select a Check with the given labels or
+// has no occurrence left.
+func occurrence(w *syntheticv1.MaintenanceWindow, set map[string]string, t time.Time) (time.Time, time.Time, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&w.Spec.Selector)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !selector.Matches(labels.Set(set)) {
+		return time.Time{}, time.Time{}, nil
+	}
+
+	spec := &w.Spec
+	switch {
+	case spec.Schedule != "" && (spec.Start != nil || spec.End != nil):
+		return time.Time{}, time.Time{}, errors.New("only one of schedule and start and end may be set")
+	case spec.Start != nil && spec.End != nil:
+		if t.After(spec.End.Time) {
+			return time.Time{}, time.Time{}, nil
+		}
+		return spec.Start.Time, spec.End.Time, nil
+	case spec.Schedule == "":
+		return time.Time{}, time.Time{}, errors.New("either schedule and duration, or start and end, must be set")
+	case spec.Duration == nil || spec.Duration.Duration <= 0:
+		return time.Time{}, time.Time{}, errors.New("duration must be positive")
+	}
+
+	sched, err := ParseSchedule(spec.Schedule)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	loc := time.UTC
+	if spec.TimeZone != "" {
+		if loc, err = time.LoadLocation(spec.TimeZone); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	start, end := Occurrence(sched, loc, spec.Duration.Duration, t)
+	return start, end, nil
+}
+
+// Occurrence returns the occurrence in progress at t of a window that opens
+// whenever sched fires in loc and lasts d, or else the next one, or zero
+// times if the schedule does not fire again.
+func Occurrence(sched *Schedule, loc *time.Location, d time.Duration, t time.Time) (time.Time, time.Time) {
+	// The occurrence in progress is the last one to start in (t-d, t].
+	var start time.Time
+	s := sched.Next(t.In(loc).Add(-d))
+	for !s.IsZero() && !s.After(t) {
+		start = s
+		s = sched.Next(s)
+	}
+	if start.IsZero() {
+		start = s
+	}
+	if start.IsZero() {
+		return time.Time{}, time.Time{}
+	}
+	return start, start.Add(d)
+}
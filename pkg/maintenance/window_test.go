@@ -0,0 +1,79 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func window(name string, selector map[string]string, spec syntheticv1.MaintenanceWindowSpec) syntheticv1.MaintenanceWindow {
+	spec.Selector = metav1.LabelSelector{MatchLabels: selector}
+	return syntheticv1.MaintenanceWindow{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+}
+
+func TestActive(t *testing.T) {
+	g := NewGomegaWithT(t)
+	at := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		g.Expect(err).NotTo(HaveOccurred())
+		return tm
+	}
+	start, end := metav1.NewTime(at("2019-06-01T12:00:00Z")), metav1.NewTime(at("2019-06-01T18:00:00Z"))
+	windows := []syntheticv1.MaintenanceWindow{
+		// Sundays 02:00 to 06:00 Berlin time.
+		window("weekly", map[string]string{"team": "web"}, syntheticv1.MaintenanceWindowSpec{
+			Schedule: "0 2 * * SUN",
+			Duration: &metav1.Duration{Duration: 4 * time.Hour},
+			TimeZone: "Europe/Berlin",
+		}),
+		window("upgrade", nil, syntheticv1.MaintenanceWindowSpec{Start: &start, End: &end, Reason: "cluster upgrade"}),
+	}
+	web := map[string]string{"team": "web"}
+
+	s, err := Active(windows, web, at("2019-06-02T01:00:00Z"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(s).NotTo(BeNil())
+	g.Expect(s.Window.Name).To(Equal("weekly"))
+	g.Expect(s.End).To(BeTemporally("==", at("2019-06-02T04:00:00Z")))
+
+	s, _ = Active(windows, map[string]string{"team": "api"}, at("2019-06-02T01:00:00Z"))
+	g.Expect(s).To(BeNil())
+	s, _ = Active(windows, web, at("2019-06-02T04:00:00Z"))
+	g.Expect(s).To(BeNil())
+
+	s, _ = Active(windows, map[string]string{"team": "api"}, at("2019-06-01T12:00:00Z"))
+	g.Expect(s).NotTo(BeNil())
+	g.Expect(s.Window.Name).To(Equal("upgrade"))
+	s, _ = Active(windows, nil, at("2019-06-01T18:00:00Z"))
+	g.Expect(s).To(BeNil())
+
+	g.Expect(NextChange(windows, web, at("2019-06-01T10:00:00Z"))).To(BeTemporally("==", start.Time))
+	g.Expect(NextChange(windows, web, at("2019-06-01T13:00:00Z"))).To(BeTemporally("==", end.Time))
+	g.Expect(NextChange(windows, web, at("2019-06-01T19:00:00Z"))).To(BeTemporally("==", at("2019-06-02T00:00:00Z")))
+	g.Expect(NextChange(windows, web, at("2019-06-02T01:00:00Z"))).To(BeTemporally("==", at("2019-06-02T04:00:00Z")))
+
+	// Invalid windows are reported without hiding the valid ones.
+	windows = append(windows, window("broken", nil, syntheticv1.MaintenanceWindowSpec{Schedule: "@daily"}))
+	s, err = Active(windows, nil, at("2019-06-01T12:00:00Z"))
+	g.Expect(err).To(MatchError(ContainSubstring(`maintenance window "broken": duration must be positive`)))
+	g.Expect(s).NotTo(BeNil())
+}
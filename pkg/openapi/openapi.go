@@ -0,0 +1,430 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package openapi generates Checks and Validations from OpenAPI 3 documents.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// componentSchemaPrefix starts the references to the schemas of a document.
+const componentSchemaPrefix = "#/components/schemas/"
+
+// Document is the part of an OpenAPI 3 document the importer reads.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Servers    []Server            `json:"servers,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components,omitempty"`
+}
+
+// Server is a base URL of the API.
+type Server struct {
+	URL string `json:"url"`
+}
+
+// Components holds the reusable objects of a document.
+type Components struct {
+	Schemas    map[string]interface{} `json:"schemas,omitempty"`
+	Parameters map[string]Parameter   `json:"parameters,omitempty"`
+	Responses  map[string]Response    `json:"responses,omitempty"`
+}
+
+// PathItem describes the operations on a path.
+type PathItem struct {
+	Get        *Operation  `json:"get,omitempty"`
+	Parameters []Parameter `json:"parameters,omitempty"`
+}
+
+// Operation describes a single API operation.
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter describes a single operation parameter.
+type Parameter struct {
+	Ref      string                 `json:"$ref,omitempty"`
+	Name     string                 `json:"name"`
+	In       string                 `json:"in"`
+	Required bool                   `json:"required,omitempty"`
+	Schema   map[string]interface{} `json:"schema,omitempty"`
+	Example  interface{}            `json:"example,omitempty"`
+}
+
+// Response describes a single response of an operation.
+type Response struct {
+	Ref     string               `json:"$ref,omitempty"`
+	Content map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType describes a response body of one content type.
+type MediaType struct {
+	Schema interface{} `json:"schema,omitempty"`
+}
+
+// Parse reads an OpenAPI 3 document in JSON or YAML.
+func Parse(data []byte) (*Document, error) {
+	doc := &Document{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("parsing openapi document: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported openapi version %q, expected 3.x", doc.OpenAPI)
+	}
+	return doc, nil
+}
+
+// Options controls the generated objects.
+type Options struct {
+	// Namespace of the generated objects.
+	Namespace string
+	// BaseURL overrides the first server of the document.
+	BaseURL string
+	// Prefix is prepended to the name of every generated object.
+	Prefix string
+	// Interval of the generated Checks. The Check default is used when nil.
+	Interval *metav1.Duration
+}
+
+// Result holds the objects generated from a document.
+type Result struct {
+	Checks      []syntheticv1.Check
+	Validations []syntheticv1.Validation
+	// Skipped explains why operations were not imported.
+	Skipped []string
+}
+
+// Generate returns a Check for every GET operation of doc that can be called
+// without inventing parameter values, and a Validation asserting the
+// documented status codes and response schemas of each.
+func Generate(doc *Document, opts Options) (*Result, error) {
+	base := opts.BaseURL
+	if base == "" && len(doc.Servers) > 0 {
+		base = doc.Servers[0].URL
+	}
+	if base == "" {
+		return nil, fmt.Errorf("the document has no servers, a base URL is required")
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	res := &Result{}
+	names := map[string]bool{}
+	for _, path := range paths {
+		item := doc.Paths[path]
+		op := item.Get
+		if op == nil {
+			continue
+		}
+		target, err := doc.requestURL(base, path, append(item.Parameters, op.Parameters...))
+		if err != nil {
+			res.Skipped = append(res.Skipped, fmt.Sprintf("GET %s: %v", path, err))
+			continue
+		}
+
+		name := opts.Prefix + objectName(op.OperationID, path)
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("%s%s-%d", opts.Prefix, objectName(op.OperationID, path), i)
+		}
+		names[name] = true
+
+		validation, err := doc.validation(op)
+		if err != nil {
+			res.Skipped = append(res.Skipped, fmt.Sprintf("GET %s: %v", path, err))
+			continue
+		}
+
+		meta := metav1.ObjectMeta{Name: name, Namespace: opts.Namespace}
+		res.Checks = append(res.Checks, syntheticv1.Check{
+			TypeMeta:   metav1.TypeMeta{APIVersion: syntheticv1.GroupVersion.String(), Kind: "Check"},
+			ObjectMeta: meta,
+			Spec: syntheticv1.CheckSpec{
+				Interval: opts.Interval,
+				HTTP:     &syntheticv1.HTTPProbe{URL: target},
+			},
+		})
+		validation.TypeMeta = metav1.TypeMeta{APIVersion: syntheticv1.GroupVersion.String(), Kind: "Validation"}
+		validation.ObjectMeta = meta
+		validation.Spec.CheckRef = name
+		res.Validations = append(res.Validations, *validation)
+	}
+	return res, nil
+}
+
+// requestURL builds the URL of an operation, filling path and query
+// parameters from their examples or defaults. It fails if a required
+// parameter has neither, or must be sent in a header or cookie.
+func (d *Document) requestURL(base, path string, params []Parameter) (string, error) {
+	query := url.Values{}
+	for _, p := range params {
+		p, err := d.parameter(p)
+		if err != nil {
+			return "", err
+		}
+		value, ok := exampleValue(p)
+		switch p.In {
+		case "path":
+			if !ok {
+				return "", fmt.Errorf("path parameter %q has no example or default", p.Name)
+			}
+			path = strings.Replace(path, "{"+p.Name+"}", url.PathEscape(value), -1)
+		case "query":
+			if ok {
+				query.Set(p.Name, value)
+			} else if p.Required {
+				return "", fmt.Errorf("query parameter %q has no example or default", p.Name)
+			}
+		default:
+			if p.Required {
+				return "", fmt.Errorf("required %s parameter %q cannot be generated", p.In, p.Name)
+			}
+		}
+	}
+	if strings.Contains(path, "{") {
+		return "", fmt.Errorf("path template %q has undeclared parameters", path)
+	}
+	target := base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target, nil
+}
+
+func (d *Document) parameter(p Parameter) (Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+	name := strings.TrimPrefix(p.Ref, "#/components/parameters/")
+	ref, ok := d.Components.Parameters[name]
+	if !ok || name == p.Ref {
+		return p, fmt.Errorf("unresolved parameter reference %q", p.Ref)
+	}
+	return ref, nil
+}
+
+// exampleValue returns the value used for a parameter.
+func exampleValue(p Parameter) (string, bool) {
+	candidates := []interface{}{p.Example}
+	if p.Schema != nil {
+		candidates = append(candidates, p.Schema["example"], p.Schema["default"])
+		if enum, ok := p.Schema["enum"].([]interface{}); ok && len(enum) > 0 {
+			candidates = append(candidates, enum[0])
+		}
+	}
+	for _, c := range candidates {
+		switch v := c.(type) {
+		case nil:
+			continue
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	}
+	return "", false
+}
+
+// validation returns the contract of op: its documented status codes and
+// the schemas of its JSON responses.
+func (d *Document) validation(op *Operation) (*syntheticv1.Validation, error) {
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	v := &syntheticv1.Validation{}
+	anyStatus := false
+	for _, code := range codes {
+		resp, err := d.response(op.Responses[code])
+		if err != nil {
+			return nil, err
+		}
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			// "default" and ranges such as "2XX" allow any status.
+			anyStatus = true
+			status = 0
+		} else {
+			v.Spec.ExpectedStatus = append(v.Spec.ExpectedStatus, int32(status))
+		}
+		if status == 0 && code != "default" {
+			// A schema without a status applies to every status, so only
+			// the default response gets one.
+			continue
+		}
+
+		schema := jsonSchema(resp)
+		if schema == nil {
+			continue
+		}
+		bundled, err := d.bundle(schema)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(bundled)
+		if err != nil {
+			return nil, err
+		}
+		v.Spec.Schemas = append(v.Spec.Schemas, syntheticv1.ResponseSchema{
+			Status: int32(status),
+			Schema: string(data),
+		})
+	}
+	if anyStatus {
+		v.Spec.ExpectedStatus = nil
+	}
+	return v, nil
+}
+
+func (d *Document) response(r Response) (Response, error) {
+	if r.Ref == "" {
+		return r, nil
+	}
+	name := strings.TrimPrefix(r.Ref, "#/components/responses/")
+	ref, ok := d.Components.Responses[name]
+	if !ok || name == r.Ref {
+		return r, fmt.Errorf("unresolved response reference %q", r.Ref)
+	}
+	return ref, nil
+}
+
+// jsonSchema returns the schema of the JSON content of a response.
+func jsonSchema(r Response) interface{} {
+	types := make([]string, 0, len(r.Content))
+	for t := range r.Content {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		mediaType := strings.TrimSpace(strings.SplitN(t, ";", 2)[0])
+		if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+			return r.Content[t].Schema
+		}
+	}
+	return nil
+}
+
+// bundle returns schema as a standalone JSON Schema: references to the
+// component schemas are rewritten to point to copies of them under the
+// definitions of the returned schema.
+func (d *Document) bundle(schema interface{}) (interface{}, error) {
+	defs := map[string]interface{}{}
+	out, err := d.rewrite(schema, defs)
+	if err != nil {
+		return nil, err
+	}
+	if len(defs) == 0 {
+		return out, nil
+	}
+	obj, ok := out.(map[string]interface{})
+	if !ok {
+		return out, nil
+	}
+	obj["definitions"] = defs
+	return obj, nil
+}
+
+// rewrite copies schema with its component references rewritten, adding
+// every component it references, directly or not, to defs.
+func (d *Document) rewrite(schema interface{}, defs map[string]interface{}) (interface{}, error) {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(s))
+		for k, v := range s {
+			if ref, ok := v.(string); ok && k == "$ref" {
+				name := strings.TrimPrefix(ref, componentSchemaPrefix)
+				target, ok := d.Components.Schemas[name]
+				if !ok || name == ref {
+					return nil, fmt.Errorf("unresolved schema reference %q", ref)
+				}
+				out[k] = "#/definitions/" + name
+				if _, seen := defs[name]; !seen {
+					// Mark the component before descending into it, so that
+					// recursive schemas terminate.
+					defs[name] = nil
+					def, err := d.rewrite(target, defs)
+					if err != nil {
+						return nil, err
+					}
+					defs[name] = def
+				}
+				continue
+			}
+			rewritten, err := d.rewrite(v, defs)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = rewritten
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(s))
+		for i, v := range s {
+			rewritten, err := d.rewrite(v, defs)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rewritten
+		}
+		return out, nil
+	}
+	return schema, nil
+}
+
+var invalidName = regexp.MustCompile(`[^a-z0-9]+`)
+
+// objectName derives a DNS-1123 name from an operation.
+func objectName(operationID, path string) string {
+	name := operationID
+	if name == "" {
+		name = "get" + path
+	}
+	// Split camelCase operation IDs into words.
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	name = invalidName.ReplaceAllString(strings.ToLower(b.String()), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
@@ -0,0 +1,91 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/perph/perph/pkg/jsonschema"
+)
+
+func TestGenerate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	data, err := ioutil.ReadFile("testdata/petstore.yaml")
+	g.Expect(err).NotTo(HaveOccurred())
+	doc, err := Parse(data)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	res, err := Generate(doc, Options{Namespace: "shop", Prefix: "petstore-"})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(res.Skipped).To(ConsistOf(`GET /owners/{ownerId}: path parameter "ownerId" has no example or default`))
+	g.Expect(res.Checks).To(HaveLen(2))
+	g.Expect(res.Validations).To(HaveLen(2))
+
+	list := res.Checks[0]
+	g.Expect(list.Name).To(Equal("petstore-list-pets"))
+	g.Expect(list.Namespace).To(Equal("shop"))
+	g.Expect(list.Kind).To(Equal("Check"))
+	g.Expect(list.Spec.HTTP.URL).To(Equal("https://petstore.example.com/v1/pets?limit=20"))
+
+	// The default response allows any status, and its schema applies to
+	// every status other than 200.
+	v := res.Validations[0]
+	g.Expect(v.Spec.CheckRef).To(Equal("petstore-list-pets"))
+	g.Expect(v.Spec.ExpectedStatus).To(BeEmpty())
+	g.Expect(v.Spec.Schemas).To(HaveLen(2))
+	g.Expect(v.Spec.Schemas[0].Status).To(BeEquivalentTo(200))
+	g.Expect(v.Spec.Schemas[1].Status).To(BeZero())
+	g.Expect(v.Spec.Schemas[1].Schema).To(MatchJSON(`{
+		"$ref": "#/definitions/Error",
+		"definitions": {
+			"Error": {"type": "object", "required": ["message"], "properties": {"message": {"type": "string"}}}
+		}
+	}`))
+
+	show := res.Checks[1]
+	g.Expect(show.Name).To(Equal("petstore-show-pet-by-id"))
+	g.Expect(show.Spec.HTTP.URL).To(Equal("https://petstore.example.com/v1/pets/42"))
+	g.Expect(res.Validations[1].Spec.ExpectedStatus).To(Equal([]int32{200, 404}))
+
+	// Recursive component schemas are bundled with the response schema.
+	schema, err := jsonschema.Parse([]byte(res.Validations[1].Spec.Schemas[0].Schema))
+	g.Expect(err).NotTo(HaveOccurred())
+	var pet interface{}
+	g.Expect(json.Unmarshal([]byte(`{"id": 1, "name": "rex", "parent": {"id": 2}}`), &pet)).To(Succeed())
+	errs := schema.Validate(pet)
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errs[0].Error()).To(Equal(`/parent: missing required property "name"`))
+}
+
+func TestGenerateRequiresBaseURL(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := Generate(&Document{OpenAPI: "3.0.0"}, Options{})
+	g.Expect(err).To(HaveOccurred())
+
+	res, err := Generate(&Document{OpenAPI: "3.0.0", Paths: map[string]PathItem{
+		"/healthz": {Get: &Operation{}},
+	}}, Options{BaseURL: "http://localhost:8080/"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Checks[0].Name).To(Equal("get-healthz"))
+	g.Expect(res.Checks[0].Spec.HTTP.URL).To(Equal("http://localhost:8080/healthz"))
+}
@@ -0,0 +1,105 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/auth"
+)
+
+var grantTypes = map[syntheticv1.OAuth2GrantType]string{
+	"":                                 auth.GrantClientCredentials,
+	syntheticv1.GrantClientCredentials: auth.GrantClientCredentials,
+	syntheticv1.GrantPassword:          auth.GrantPassword,
+	syntheticv1.GrantJWTBearer:         auth.GrantJWTBearer,
+	syntheticv1.GrantTokenExchange:     auth.GrantTokenExchange,
+}
+
+// authenticate adds the credentials described by spec to req. Tokens come
+// from auth.Shared, so they are reused across runs and virtual users.
+func authenticate(ctx context.Context, req *http.Request, spec *syntheticv1.HTTPAuth, values Values) error {
+	if spec == nil || spec.OAuth2 == nil {
+		return nil
+	}
+	cfg, err := oauth2Config(ctx, spec.OAuth2, values)
+	if err != nil {
+		return fmt.Errorf("oauth2: %v", err)
+	}
+	token, err := auth.Shared.TokenSource(cfg).Token(ctx)
+	if err != nil {
+		return fmt.Errorf("oauth2: %v", err)
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
+
+func oauth2Config(ctx context.Context, spec *syntheticv1.OAuth2Auth, values Values) (auth.Config, error) {
+	grant, ok := grantTypes[spec.GrantType]
+	if !ok {
+		return auth.Config{}, fmt.Errorf("unsupported grant type %q", spec.GrantType)
+	}
+	cfg := auth.Config{
+		TokenURL:         spec.TokenURL,
+		GrantType:        grant,
+		ClientID:         spec.ClientID,
+		ClientAuthInBody: spec.ClientAuthInBody,
+		Scopes:           spec.Scopes,
+		Audience:         spec.Audience,
+		Params:           spec.Params,
+		Username:         spec.Username,
+		SubjectTokenType: spec.SubjectTokenType,
+	}
+
+	var err error
+	for _, f := range []struct {
+		name string
+		from *syntheticv1.ValueSource
+		into *string
+	}{
+		{"client secret", spec.ClientSecret, &cfg.ClientSecret},
+		{"password", spec.Password, &cfg.Password},
+		{"subject token", spec.SubjectToken, &cfg.SubjectToken},
+	} {
+		if f.from == nil {
+			continue
+		}
+		if *f.into, err = values.Get(ctx, "", f.from); err != nil {
+			return auth.Config{}, fmt.Errorf("%s: %v", f.name, err)
+		}
+	}
+
+	if j := spec.JWT; j != nil {
+		key, err := values.Get(ctx, "", &j.PrivateKey)
+		if err != nil {
+			return auth.Config{}, fmt.Errorf("jwt private key: %v", err)
+		}
+		cfg.JWT = &auth.JWTConfig{
+			Issuer:     j.Issuer,
+			Subject:    j.Subject,
+			Audience:   j.Audience,
+			PrivateKey: key,
+			KeyID:      j.KeyID,
+		}
+		if j.Lifetime != nil {
+			cfg.JWT.Lifetime = j.Lifetime.Duration
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,452 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// crawlConcurrency is the number of links and assets requested at once.
+const crawlConcurrency = 4
+
+// maxFindingsQuoted bounds the findings quoted in the crawl assertion.
+const maxFindingsQuoted = 3
+
+// HTMLInspection is the outcome of inspecting an HTML page.
+type HTMLInspection struct {
+	Result     syntheticv1.HTMLResult
+	Assertions []syntheticv1.AssertionResult
+	Findings   []syntheticv1.Finding
+}
+
+// InspectHTML parses the page of res, evaluates the assertions of spec
+// against it, and checks its links and assets when spec crawls. Findings of
+// the crawl are reported by an assertion named "crawl", which fails if any
+// finding fails the run.
+func InspectHTML(ctx context.Context, spec *syntheticv1.HTMLProbe, res *Result, values Values) (*HTMLInspection, error) {
+	doc, err := html.Parse(bytes.NewReader(res.Body))
+	if err != nil {
+		return nil, fmt.Errorf("parsing page: %v", err)
+	}
+	out := &HTMLInspection{Result: syntheticv1.HTMLResult{Title: title(doc), Pages: 1}}
+	for i := range spec.Assertions {
+		out.Assertions = append(out.Assertions, assertHTML(&spec.Assertions[i], doc))
+	}
+	if spec.Crawl == nil {
+		return out, nil
+	}
+
+	c, err := newCrawler(ctx, spec, res.URL, values)
+	if err != nil {
+		return nil, err
+	}
+	c.result = &out.Result
+	c.crawl(ctx, &page{url: res.URL, doc: doc})
+	sort.SliceStable(c.findings, func(i, j int) bool {
+		a, b := c.findings[i], c.findings[j]
+		if a.Page != b.Page {
+			return a.Page < b.Page
+		}
+		return a.URL < b.URL
+	})
+	out.Findings = c.findings
+
+	a := syntheticv1.AssertionResult{Name: "crawl", Passed: true}
+	var failing []string
+	for _, f := range out.Findings {
+		if spec.Crawl.Fails(f.Type) {
+			failing = append(failing, fmt.Sprintf("%s %s: %s", f.Type, f.URL, f.Message))
+		}
+	}
+	if len(failing) > 0 {
+		a.Passed = false
+		a.Message = fmt.Sprintf("%d findings: ", len(failing))
+		if len(failing) > maxFindingsQuoted {
+			failing = append(failing[:maxFindingsQuoted], fmt.Sprintf("and %d more", len(failing)-maxFindingsQuoted))
+		}
+		a.Message += strings.Join(failing, "; ")
+	}
+	out.Assertions = append(out.Assertions, a)
+	return out, nil
+}
+
+// assertHTML evaluates a against doc.
+func assertHTML(a *syntheticv1.HTMLAssertion, doc *html.Node) syntheticv1.AssertionResult {
+	r := syntheticv1.AssertionResult{Name: a.Name}
+	if r.Name == "" {
+		r.Name = a.Selector
+	}
+	sel, err := ParseSelector(a.Selector)
+	if err != nil {
+		r.Message = err.Error()
+		return r
+	}
+	var re *regexp.Regexp
+	if a.Matches != "" {
+		if re, err = regexp.Compile(a.Matches); err != nil {
+			r.Message = fmt.Sprintf("invalid pattern: %v", err)
+			return r
+		}
+	}
+
+	matched := sel.Select(doc)
+	n := len(matched)
+	switch {
+	case a.MinCount != nil && n < int(*a.MinCount):
+		r.Message = fmt.Sprintf("%d elements match, expected at least %d", n, *a.MinCount)
+		return r
+	case a.MaxCount != nil && n > int(*a.MaxCount):
+		r.Message = fmt.Sprintf("%d elements match, expected at most %d", n, *a.MaxCount)
+		return r
+	case n == 0 && a.MinCount == nil && a.MaxCount == nil:
+		r.Message = "no element matches"
+		return r
+	}
+	if a.Contains == "" && re == nil {
+		r.Passed = true
+		return r
+	}
+
+	what := "text"
+	if a.Attribute != "" {
+		what = "attribute " + a.Attribute
+	}
+	for _, el := range matched {
+		v := Text(el)
+		if a.Attribute != "" {
+			v = attr(el, a.Attribute)
+		}
+		if strings.Contains(v, a.Contains) && (re == nil || re.MatchString(v)) {
+			r.Passed = true
+			return r
+		}
+	}
+	switch {
+	case n == 0:
+		r.Message = "no element matches"
+	case a.Contains != "":
+		r.Message = fmt.Sprintf("no matching element has %s containing %q", what, a.Contains)
+	default:
+		r.Message = fmt.Sprintf("no matching element has %s matching %q", what, a.Matches)
+	}
+	return r
+}
+
+// title returns the text of the title element of doc.
+func title(doc *html.Node) string {
+	sel, _ := ParseSelector("head title")
+	if t := sel.Select(doc); len(t) > 0 {
+		return Text(t[0])
+	}
+	return ""
+}
+
+// page is a parsed page of a crawl.
+type page struct {
+	url *url.URL
+	doc *html.Node
+}
+
+// target is a link or asset of a page.
+type target struct {
+	url   *url.URL
+	page  *url.URL
+	asset bool
+}
+
+// assetAttrs lists the elements that load assets and the attribute that
+// holds their URL.
+var assetAttrs = map[string]string{
+	"img":    "src",
+	"script": "src",
+	"iframe": "src",
+	"source": "src",
+	"video":  "src",
+	"audio":  "src",
+	"embed":  "src",
+	"track":  "src",
+	"object": "data",
+}
+
+// assetRels are the relations of link elements that load assets.
+var assetRels = map[string]bool{
+	"stylesheet":       true,
+	"icon":             true,
+	"apple-touch-icon": true,
+	"preload":          true,
+	"modulepreload":    true,
+	"manifest":         true,
+}
+
+// references returns the links and assets of p, resolved against its base
+// URL. Only http and https URLs are returned, without fragments.
+func (p *page) references() []target {
+	base := p.url
+	var out []target
+	add := func(ref string, asset bool) {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		u.Fragment = ""
+		out = append(out, target{url: u, page: p.url, asset: asset})
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "base":
+				if u, err := p.url.Parse(attr(n, "href")); err == nil && attr(n, "href") != "" {
+					base = u
+				}
+			case "a", "area":
+				if href := attr(n, "href"); href != "" {
+					add(href, false)
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+					if assetRels[rel] && attr(n, "href") != "" {
+						add(attr(n, "href"), true)
+						break
+					}
+				}
+			default:
+				if key, ok := assetAttrs[n.Data]; ok && attr(n, key) != "" {
+					add(attr(n, key), true)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(p.doc)
+	return out
+}
+
+// crawler checks the links and assets of pages.
+type crawler struct {
+	spec    *syntheticv1.HTMLCrawl
+	client  *http.Client
+	timeout time.Duration
+	origin  string
+	// header is sent to the origin of the page only, since it may carry
+	// credentials.
+	header  http.Header
+	maxSize int64
+
+	mu       sync.Mutex
+	seen     map[string]bool
+	budget   int
+	result   *syntheticv1.HTMLResult
+	findings []syntheticv1.Finding
+}
+
+func newCrawler(ctx context.Context, spec *syntheticv1.HTMLProbe, u *url.URL, values Values) (*crawler, error) {
+	req, err := NewRequest(ctx, &spec.HTTPProbe, values)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := TLSClientConfig(ctx, spec.TLS, values)
+	if err != nil {
+		return nil, err
+	}
+	c := &crawler{
+		spec: spec.Crawl,
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: crawlConcurrency,
+		}},
+		timeout: DefaultTimeout,
+		origin:  origin(u),
+		header:  req.Header,
+		seen:    map[string]bool{u.String(): true},
+		budget:  spec.Crawl.MaxRequestsOrDefault(),
+	}
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		c.timeout = spec.Timeout.Duration
+	}
+	if q := spec.Crawl.MaxAssetSize; q != nil {
+		c.maxSize = q.Value()
+	}
+	return c, nil
+}
+
+func origin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// crawl checks the references of start, and follows its links on the same
+// origin up to the depth of the crawl.
+func (c *crawler) crawl(ctx context.Context, start *page) {
+	defer c.client.Transport.(*http.Transport).CloseIdleConnections()
+	depth := c.spec.DepthOrDefault()
+	level := []*page{start}
+	for d := 0; d < depth && len(level) > 0; d++ {
+		var targets []target
+		for _, p := range level {
+			for _, t := range p.references() {
+				if t.asset && p.url.Scheme == "https" && t.url.Scheme == "http" {
+					c.report(syntheticv1.FindingMixedContent, t, "asset of an https page is loaded over http")
+				}
+				if !t.asset && !c.spec.ExternalLinks && origin(t.url) != c.origin {
+					continue
+				}
+				if c.seen[t.url.String()] {
+					continue
+				}
+				c.seen[t.url.String()] = true
+				if c.budget == 0 {
+					c.result.Truncated = true
+					continue
+				}
+				c.budget--
+				targets = append(targets, t)
+			}
+		}
+
+		// Pages linked from the last level are checked but not parsed.
+		parse := d+1 < depth
+		var next []*page
+		work := make(chan target)
+		var wg sync.WaitGroup
+		for i := 0; i < crawlConcurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for t := range work {
+					if p := c.check(ctx, t, parse); p != nil {
+						c.mu.Lock()
+						next = append(next, p)
+						c.mu.Unlock()
+					}
+				}
+			}()
+		}
+		for _, t := range targets {
+			work <- t
+		}
+		close(work)
+		wg.Wait()
+		level = next
+	}
+}
+
+// check requests t and reports what is wrong with it. It returns the page t
+// links to if it is an HTML page on the origin of the crawl that should be
+// parsed.
+func (c *crawler) check(ctx context.Context, t target, parse bool) *page {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	sameOrigin := origin(t.url) == c.origin
+	follow := parse && !t.asset && sameOrigin
+	method := http.MethodGet
+	if !t.asset && !follow {
+		method = http.MethodHead
+	}
+	resp, err := c.do(ctx, method, t.url, sameOrigin)
+	if err == nil && method == http.MethodHead &&
+		(resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		// Some servers do not implement HEAD.
+		resp.Body.Close()
+		resp, err = c.do(ctx, http.MethodGet, t.url, sameOrigin)
+	}
+
+	c.mu.Lock()
+	if t.asset {
+		c.result.Assets++
+	} else {
+		c.result.Links++
+	}
+	c.mu.Unlock()
+	if err != nil {
+		c.report(syntheticv1.FindingBrokenLink, t, err.Error())
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		c.report(syntheticv1.FindingBrokenLink, t, fmt.Sprintf("status %d", resp.StatusCode))
+		return nil
+	}
+
+	switch {
+	case t.asset && c.maxSize > 0:
+		n, err := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, c.maxSize+1))
+		if err != nil {
+			c.report(syntheticv1.FindingBrokenLink, t, fmt.Sprintf("reading body: %v", err))
+		} else if n > c.maxSize {
+			c.report(syntheticv1.FindingOversizedAsset, t, fmt.Sprintf("larger than %d bytes", c.maxSize))
+		}
+	case follow:
+		typ, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if typ != "text/html" || origin(resp.Request.URL) != c.origin {
+			return nil
+		}
+		doc, err := html.Parse(io.LimitReader(resp.Body, MaxBodyBytes))
+		if err != nil {
+			return nil
+		}
+		c.mu.Lock()
+		c.result.Pages++
+		c.mu.Unlock()
+		return &page{url: resp.Request.URL, doc: doc}
+	}
+	return nil
+}
+
+func (c *crawler) do(ctx context.Context, method string, u *url.URL, sameOrigin bool) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if sameOrigin {
+		for name, values := range c.header {
+			req.Header[name] = values
+		}
+	}
+	return c.client.Do(req.WithContext(ctx))
+}
+
+func (c *crawler) report(typ syntheticv1.FindingType, t target, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.findings = append(c.findings, syntheticv1.Finding{
+		Type:    typ,
+		URL:     t.url.String(),
+		Page:    t.page.String(),
+		Message: message,
+	})
+}
@@ -0,0 +1,129 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestInspectHTML(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer external.Close()
+
+	var site *httptest.Server
+	site = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Header.Get("Authorization")).To(Equal("Bearer t0ken"))
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, `<html><head><title>Home</title>
+<link rel="stylesheet" href="/site.css"><script src="%[1]s/tracker.js"></script></head>
+<body><h1 class="welcome">Welcome back</h1>
+<a href="/about#team">About</a> <a href="/missing">Old</a> <a href="mailto:team@example.com">Mail</a>
+<a href="%[1]s/gone">Partner</a> <img src="/hero.jpg"></body></html>`, external.URL)
+		case "/about":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/">Home</a><a href="/jobs">Jobs</a></body></html>`)
+		case "/jobs", "/site.css":
+			w.Write([]byte("ok"))
+		case "/hero.jpg":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	one := int32(1)
+	maxSize := resource.MustParse("1Ki")
+	spec := &syntheticv1.HTMLProbe{
+		HTTPProbe: syntheticv1.HTTPProbe{
+			URL:     site.URL + "/",
+			Headers: []syntheticv1.HTTPHeader{{Name: "Authorization", Value: "Bearer t0ken"}},
+			TLS:     &syntheticv1.TLSConfig{InsecureSkipVerify: true},
+		},
+		Assertions: []syntheticv1.HTMLAssertion{
+			{Selector: "h1.welcome", Contains: "Welcome"},
+			{Name: "stylesheets", Selector: "link[rel=stylesheet]", Attribute: "href", Matches: `\.css$`, MaxCount: &one},
+			{Name: "banner", Selector: "#banner"},
+			{Name: "no-errors", Selector: ".error", MaxCount: new(int32)},
+		},
+		Crawl: &syntheticv1.HTMLCrawl{
+			MaxAssetSize: &maxSize,
+			FailOn:       []syntheticv1.FindingType{syntheticv1.FindingBrokenLink},
+		},
+	}
+
+	res, err := HTTP(context.Background(), &spec.HTTPProbe, Inline, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	inspection, err := InspectHTML(context.Background(), spec, res, Inline)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(inspection.Assertions).To(HaveLen(5))
+	g.Expect(inspection.Assertions[0]).To(Equal(syntheticv1.AssertionResult{Name: "h1.welcome", Passed: true}))
+	g.Expect(inspection.Assertions[1].Passed).To(BeTrue())
+	g.Expect(inspection.Assertions[2]).To(Equal(syntheticv1.AssertionResult{Name: "banner", Message: "no element matches"}))
+	g.Expect(inspection.Assertions[3].Passed).To(BeTrue())
+
+	// External links are not checked by default, but assets are checked
+	// wherever they are served from.
+	g.Expect(inspection.Result).To(Equal(syntheticv1.HTMLResult{Title: "Home", Pages: 1, Links: 2, Assets: 3}))
+	home := site.URL + "/"
+	g.Expect(inspection.Findings).To(ConsistOf(
+		syntheticv1.Finding{Type: syntheticv1.FindingBrokenLink, URL: site.URL + "/missing", Page: home, Message: "status 404"},
+		syntheticv1.Finding{Type: syntheticv1.FindingOversizedAsset, URL: site.URL + "/hero.jpg", Page: home, Message: "larger than 1024 bytes"},
+		syntheticv1.Finding{Type: syntheticv1.FindingMixedContent, URL: external.URL + "/tracker.js", Page: home, Message: "asset of an https page is loaded over http"},
+	))
+	crawl := inspection.Assertions[4]
+	g.Expect(crawl.Name).To(Equal("crawl"))
+	g.Expect(crawl.Passed).To(BeFalse())
+	g.Expect(crawl.Message).To(Equal("1 findings: BrokenLink " + site.URL + "/missing: status 404"))
+
+	// A deeper crawl parses the pages linked from the page and checks
+	// their links, and checks external links when asked to.
+	two := int32(2)
+	spec.Crawl = &syntheticv1.HTMLCrawl{Depth: &two, ExternalLinks: true}
+	inspection, err = InspectHTML(context.Background(), spec, res, Inline)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(inspection.Result).To(Equal(syntheticv1.HTMLResult{Title: "Home", Pages: 2, Links: 4, Assets: 3}))
+	g.Expect(inspection.Findings).To(ContainElement(syntheticv1.Finding{
+		Type: syntheticv1.FindingBrokenLink, URL: external.URL + "/gone", Page: home, Message: "status 404",
+	}))
+	g.Expect(inspection.Assertions[4].Message).To(HavePrefix("3 findings: "))
+
+	// The crawl stops at its request limit.
+	spec.Crawl.MaxRequests = &two
+	inspection, err = InspectHTML(context.Background(), spec, res, Inline)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(inspection.Result.Links + inspection.Result.Assets).To(BeEquivalentTo(2))
+	g.Expect(inspection.Result.Truncated).To(BeTrue())
+}
@@ -0,0 +1,276 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package probe executes the requests described by a Check.
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+const (
+	// DefaultTimeout bounds a probe request that does not set a timeout.
+	DefaultTimeout = 10 * time.Second
+
+	// MaxBodyBytes is the largest response body kept for assertions. Larger
+	// bodies are still read to the end so that transfer time is accurate.
+	MaxBodyBytes = 1 << 20
+)
+
+// Result is the outcome of a single probe request.
+type Result struct {
+	// URL is the address requested.
+	URL *url.URL
+
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Timings    syntheticv1.PhaseTimings
+	Connection syntheticv1.ConnectionInfo
+
+	// TraceID is the trace ID the request was sent with, if any.
+	TraceID string
+}
+
+// HTTP issues the request described by spec over a fresh connection and
+// records how long each phase of the request took. Values referenced by the
+// spec are looked up in values. The request carries the trace context of
+// tracing, and a request that fails once sent still returns a Result with
+// its TraceID.
+func HTTP(ctx context.Context, spec *syntheticv1.HTTPProbe, values Values, tracing *Tracer) (*Result, error) {
+	timeout := DefaultTimeout
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		timeout = spec.Timeout.Duration
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := NewRequest(ctx, spec, values)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := TLSClientConfig(ctx, spec.TLS, values)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransport(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer transport.CloseIdleConnections()
+
+	t := &tracer{}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, t.trace()))
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	span := tracing.Start(req)
+	t.start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		span.Finish(0, err)
+		return &Result{TraceID: span.TraceID()}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxBodyBytes))
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	if err != nil {
+		err = fmt.Errorf("reading response body: %v", err)
+		span.Finish(resp.StatusCode, err)
+		return &Result{StatusCode: resp.StatusCode, TraceID: span.TraceID()}, err
+	}
+	t.done = time.Now()
+	span.Finish(resp.StatusCode, nil)
+
+	result := &Result{
+		URL:        req.URL,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       data,
+		Timings:    t.timings(),
+		TraceID:    span.TraceID(),
+		Connection: syntheticv1.ConnectionInfo{
+			RemoteAddr: t.remoteAddr,
+			Protocol:   "http/1.1",
+		},
+	}
+	if resp.ProtoMajor == 2 {
+		result.Connection.Protocol = "h2"
+	}
+	if resp.TLS != nil {
+		result.Connection.TLSVersion = TLSVersionName(resp.TLS.Version)
+		result.Connection.CipherSuite = CipherSuiteName(resp.TLS.CipherSuite)
+	}
+	return result, nil
+}
+
+// NewRequest builds the request described by spec, resolving its variables
+// and header values and obtaining its credentials.
+func NewRequest(ctx context.Context, spec *syntheticv1.HTTPProbe, values Values) (*http.Request, error) {
+	vars, err := ResolveVariables(ctx, spec.Variables, values)
+	if err != nil {
+		return nil, err
+	}
+
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if spec.Body != "" {
+		body = strings.NewReader(Expand(spec.Body, vars))
+	}
+	req, err := http.NewRequest(method, Expand(spec.URL, vars), body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range spec.Headers {
+		value, err := values.Get(ctx, h.Value, h.ValueFrom)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %v", h.Name, err)
+		}
+		value = Expand(value, vars)
+		if strings.EqualFold(h.Name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Add(h.Name, value)
+	}
+	if err := authenticate(ctx, req, spec.Auth, values); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// newTransport returns a transport that never reuses connections, so that
+// every probe pays for and measures DNS, connect and the TLS handshake.
+func newTransport(tlsConfig *tls.Config) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig: tlsConfig,
+	}
+	// A custom TLS config turns off the transport's built in HTTP/2 support.
+	if err := http2.ConfigureTransport(transport); err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+// tracer collects httptrace events for a single request.
+type tracer struct {
+	start, done time.Time
+
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	wroteRequest, firstByte   time.Time
+
+	remoteAddr string
+}
+
+func (t *tracer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+		ConnectStart: func(string, string) {
+			// Only the first dial attempt is measured when several
+			// addresses are tried.
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.connectDone = time.Now()
+			}
+		},
+		TLSHandshakeStart: func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.tlsDone = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if addr := info.Conn.RemoteAddr(); addr != nil {
+				t.remoteAddr = addr.String()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.wroteRequest = time.Now() },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+}
+
+func (t *tracer) timings() syntheticv1.PhaseTimings {
+	return syntheticv1.PhaseTimings{
+		DNS:       between(t.dnsStart, t.dnsDone),
+		Connect:   between(t.connectStart, t.connectDone),
+		TLS:       between(t.tlsStart, t.tlsDone),
+		FirstByte: between(t.wroteRequest, t.firstByte),
+		Transfer:  between(t.firstByte, t.done),
+		Total:     between(t.start, t.done),
+	}
+}
+
+// between returns the time from start to end, or zero if either end of the
+// phase was never observed.
+func between(start, end time.Time) metav1.Duration {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return metav1.Duration{}
+	}
+	return metav1.Duration{Duration: end.Sub(start)}
+}
+
+// CheckStatus evaluates the expected status codes of spec against result.
+func CheckStatus(spec *syntheticv1.HTTPProbe, result *Result) syntheticv1.AssertionResult {
+	a := syntheticv1.AssertionResult{Name: "status", Passed: true}
+	if len(spec.ExpectedStatus) == 0 {
+		if result.StatusCode < 200 || result.StatusCode > 299 {
+			a.Passed = false
+			a.Message = fmt.Sprintf("got status %d, expected 2xx", result.StatusCode)
+		}
+		return a
+	}
+	for _, code := range spec.ExpectedStatus {
+		if int(code) == result.StatusCode {
+			return a
+		}
+	}
+	a.Passed = false
+	a.Message = fmt.Sprintf("got status %d, expected one of %v", result.StatusCode, spec.ExpectedStatus)
+	return a
+}
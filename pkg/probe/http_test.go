@@ -0,0 +1,84 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestHTTPTimings(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Header.Get("X-Probe")).To(Equal("perph"))
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	spec := &syntheticv1.HTTPProbe{
+		URL:     srv.URL,
+		Headers: []syntheticv1.HTTPHeader{{Name: "X-Probe", Value: "perph"}},
+	}
+	res, err := HTTP(context.Background(), spec, Inline, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.StatusCode).To(Equal(http.StatusOK))
+	g.Expect(string(res.Body)).To(Equal("ok"))
+	g.Expect(res.Connection.Protocol).To(Equal("http/1.1"))
+	g.Expect(res.Connection.RemoteAddr).To(Equal(srv.Listener.Addr().String()))
+	g.Expect(res.Connection.TLSVersion).To(BeEmpty())
+	g.Expect(res.Timings.Connect.Duration).To(BeNumerically(">", 0))
+	g.Expect(res.Timings.FirstByte.Duration).To(BeNumerically(">=", 10*time.Millisecond))
+	g.Expect(res.Timings.Total.Duration).To(BeNumerically(">=", res.Timings.FirstByte.Duration))
+	g.Expect(CheckStatus(spec, res).Passed).To(BeTrue())
+}
+
+func TestHTTPNegotiatesTLSAndHTTP2(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	g.Expect(http2.ConfigureServer(srv.Config, nil)).To(Succeed())
+	srv.TLS = srv.Config.TLSConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	spec := &syntheticv1.HTTPProbe{
+		URL:            srv.URL,
+		TLS:            &syntheticv1.TLSConfig{InsecureSkipVerify: true},
+		ExpectedStatus: []int32{http.StatusOK},
+	}
+	res, err := HTTP(context.Background(), spec, Inline, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.Connection.Protocol).To(Equal("h2"))
+	g.Expect(res.Connection.TLSVersion).To(HavePrefix("TLS 1."))
+	g.Expect(res.Connection.CipherSuite).To(HavePrefix("TLS_"))
+	g.Expect(res.Timings.TLS.Duration).To(BeNumerically(">", 0))
+
+	status := CheckStatus(spec, res)
+	g.Expect(status.Passed).To(BeFalse())
+	g.Expect(status.Message).To(ContainSubstring("418"))
+}
@@ -0,0 +1,321 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selector is a parsed CSS selector group.
+type Selector []complexSelector
+
+// complexSelector is a chain of compound selectors, the last of which
+// matches the element itself. child[i] is set when compounds[i] must match
+// the parent of the element matching compounds[i+1], rather than any
+// ancestor.
+type complexSelector struct {
+	compounds []compound
+	child     []bool
+}
+
+// compound matches an element by its type, id, classes and attributes.
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+type attrSelector struct {
+	name, op, value string
+}
+
+// ParseSelector parses a group of CSS selectors. Type, universal, id, class
+// and attribute selectors are supported, with the descendant and child
+// combinators. Pseudo classes and the sibling combinators are not.
+func ParseSelector(s string) (Selector, error) {
+	var out Selector
+	for _, part := range strings.Split(s, ",") {
+		c, err := parseComplex(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("selector %q: %v", s, err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func parseComplex(s string) (complexSelector, error) {
+	var c complexSelector
+	if s == "" {
+		return c, fmt.Errorf("empty selector")
+	}
+	child := false
+	for s != "" {
+		switch s[0] {
+		case ' ', '\t', '\n':
+			s = s[1:]
+			continue
+		case '>':
+			if len(c.compounds) == 0 || child {
+				return c, fmt.Errorf("misplaced >")
+			}
+			child = true
+			s = s[1:]
+			continue
+		case '+', '~':
+			return c, fmt.Errorf("combinator %c is not supported", s[0])
+		}
+		comp, rest, err := parseCompound(s)
+		if err != nil {
+			return c, err
+		}
+		if len(c.compounds) > 0 {
+			c.child = append(c.child, child)
+		}
+		c.compounds = append(c.compounds, comp)
+		child = false
+		s = rest
+	}
+	if child {
+		return c, fmt.Errorf("selector ends with >")
+	}
+	return c, nil
+}
+
+// parseCompound parses the compound selector at the start of s and returns
+// the rest of s.
+func parseCompound(s string) (compound, string, error) {
+	var c compound
+	if s[0] == '*' {
+		s = s[1:]
+	} else if n := identLen(s); n > 0 {
+		c.tag, s = strings.ToLower(s[:n]), s[n:]
+	}
+	for s != "" {
+		switch s[0] {
+		case '#', '.':
+			n := identLen(s[1:])
+			if n == 0 {
+				return c, "", fmt.Errorf("expected a name after %c", s[0])
+			}
+			if s[0] == '#' {
+				c.id = s[1 : n+1]
+			} else {
+				c.classes = append(c.classes, s[1:n+1])
+			}
+			s = s[n+1:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return c, "", fmt.Errorf("unterminated attribute selector")
+			}
+			a, err := parseAttr(s[1:end])
+			if err != nil {
+				return c, "", err
+			}
+			c.attrs = append(c.attrs, a)
+			s = s[end+1:]
+		case ':':
+			return c, "", fmt.Errorf("pseudo classes are not supported")
+		case ' ', '\t', '\n', '>', '+', '~':
+			return c, s, nil
+		default:
+			return c, "", fmt.Errorf("unexpected %q", s[0])
+		}
+	}
+	return c, s, nil
+}
+
+func parseAttr(s string) (attrSelector, error) {
+	i := strings.IndexAny(s, "=~^$*|")
+	if i < 0 {
+		name := strings.TrimSpace(s)
+		if identLen(name) != len(name) || name == "" {
+			return attrSelector{}, fmt.Errorf("invalid attribute selector [%s]", s)
+		}
+		return attrSelector{name: strings.ToLower(name)}, nil
+	}
+	a := attrSelector{name: strings.ToLower(strings.TrimSpace(s[:i]))}
+	op := s[i : i+1]
+	rest := s[i+1:]
+	if op != "=" {
+		if !strings.HasPrefix(rest, "=") {
+			return a, fmt.Errorf("invalid attribute selector [%s]", s)
+		}
+		op, rest = op+"=", rest[1:]
+	}
+	a.op = op
+	value := strings.TrimSpace(rest)
+	if n := len(value); n >= 2 && (value[0] == '"' || value[0] == '\'') && value[n-1] == value[0] {
+		value = value[1 : n-1]
+	}
+	a.value = value
+	if a.name == "" || identLen(a.name) != len(a.name) {
+		return a, fmt.Errorf("invalid attribute selector [%s]", s)
+	}
+	return a, nil
+}
+
+// identLen returns the length of the identifier at the start of s.
+func identLen(s string) int {
+	for i, r := range s {
+		if !(r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= 0x80) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// Select returns the elements under root that s matches, in document order.
+func (s Selector) Select(root *html.Node) []*html.Node {
+	var out []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && s.Matches(n) {
+			out = append(out, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return out
+}
+
+// Matches returns true if s matches the element n.
+func (s Selector) Matches(n *html.Node) bool {
+	for _, c := range s {
+		if c.matches(n, len(c.compounds)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns true if n matches compounds[i] and its ancestors match
+// the compounds before it.
+func (c *complexSelector) matches(n *html.Node, i int) bool {
+	if !c.compounds[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if c.matches(p, i-1) {
+			return true
+		}
+		if c.child[i-1] {
+			return false
+		}
+	}
+	return false
+}
+
+func (c *compound) matches(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	for _, class := range c.classes {
+		if !containsWord(attr(n, "class"), class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.matches(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *attrSelector) matches(n *html.Node) bool {
+	var v string
+	found := false
+	for _, at := range n.Attr {
+		if at.Namespace == "" && at.Key == a.name {
+			v, found = at.Val, true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return v == a.value
+	case "~=":
+		return containsWord(v, a.value)
+	case "|=":
+		return v == a.value || strings.HasPrefix(v, a.value+"-")
+	case "^=":
+		return a.value != "" && strings.HasPrefix(v, a.value)
+	case "$=":
+		return a.value != "" && strings.HasSuffix(v, a.value)
+	case "*=":
+		return a.value != "" && strings.Contains(v, a.value)
+	}
+	return false
+}
+
+// attr returns the value of the attribute key of n, or "".
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func containsWord(list, word string) bool {
+	for _, w := range strings.Fields(list) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// Text returns the text of n and its descendants, with runs of white space
+// collapsed.
+func Text(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		case n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style"):
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
@@ -0,0 +1,73 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/html"
+)
+
+const selectorPage = `<!DOCTYPE html>
+<html><head><title> Shop </title></head>
+<body>
+  <nav id="top" class="menu main"><a href="/">Home</a> <a href="/cart" lang="en-GB">Cart</a></nav>
+  <main>
+    <ul class="products">
+      <li class="product sale" data-sku="A-1"><span>Shoes</span></li>
+      <li class="product" data-sku="B-2"><div><span>Socks</span></div></li>
+    </ul>
+    <script>var span = "<span>";</script>
+  </main>
+</body></html>`
+
+func TestSelector(t *testing.T) {
+	g := NewGomegaWithT(t)
+	doc, err := html.Parse(strings.NewReader(selectorPage))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	texts := func(selector string) []string {
+		sel, err := ParseSelector(selector)
+		g.Expect(err).NotTo(HaveOccurred(), selector)
+		out := []string{}
+		for _, n := range sel.Select(doc) {
+			out = append(out, Text(n))
+		}
+		return out
+	}
+
+	g.Expect(texts("title")).To(Equal([]string{"Shop"}))
+	g.Expect(texts("#top a")).To(Equal([]string{"Home", "Cart"}))
+	g.Expect(texts("nav.menu.main > a[href='/cart']")).To(Equal([]string{"Cart"}))
+	g.Expect(texts("li.product span")).To(Equal([]string{"Shoes", "Socks"}))
+	g.Expect(texts("li.product > span")).To(Equal([]string{"Shoes"}))
+	g.Expect(texts("ul > li > * > span")).To(Equal([]string{"Socks"}))
+	g.Expect(texts(".sale, [data-sku$=\"2\"]")).To(Equal([]string{"Shoes", "Socks"}))
+	g.Expect(texts("[data-sku^=B]")).To(Equal([]string{"Socks"}))
+	g.Expect(texts("[class~=sale]")).To(Equal([]string{"Shoes"}))
+	g.Expect(texts("a[lang|=en]")).To(Equal([]string{"Cart"}))
+	g.Expect(texts("[data-sku*=-]")).To(HaveLen(2))
+	g.Expect(texts("main *")).To(HaveLen(7))
+	g.Expect(texts("nav.sale")).To(BeEmpty())
+	g.Expect(texts("main")).To(Equal([]string{"Shoes Socks"}))
+
+	for _, bad := range []string{"", "a,", "> a", "a >", "a + b", "a:hover", "[x", "[=x]", "a#"} {
+		_, err := ParseSelector(bad)
+		g.Expect(err).To(HaveOccurred(), bad)
+	}
+}
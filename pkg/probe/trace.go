@@ -0,0 +1,162 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/export"
+)
+
+// SpanExportTimeout bounds every request exporting spans, which are flushed
+// while the run they belong to is reconciled.
+const SpanExportTimeout = 5 * time.Second
+
+// MaxBufferedSpans bounds the spans a Tracer holds until they are flushed.
+// Spans of further requests are dropped.
+const MaxBufferedSpans = 10000
+
+// Tracer sends W3C trace context with requests, starting a trace at every
+// request, and collects the client spans of sampled requests for export.
+// The nil Tracer sends no trace context.
+type Tracer struct {
+	// Sampling is the fraction of requests sent with the sampled flag.
+	Sampling float64
+
+	// Exporter, when set, receives the spans of sampled requests.
+	Exporter *export.OTLP
+	// Resource lists the attributes of the resource spans belong to.
+	Resource [][2]string
+	// Attributes are set on every span.
+	Attributes [][2]string
+
+	mu    sync.Mutex
+	spans []export.Span
+}
+
+// NewTracer returns the tracer spec configures, which is nil if spec is.
+// Header values of the collector are looked up in values.
+func NewTracer(ctx context.Context, spec *syntheticv1.Tracing, values Values) (*Tracer, error) {
+	if spec == nil {
+		return nil, nil
+	}
+	t := &Tracer{
+		Sampling: spec.SamplingOrDefault(),
+		Resource: [][2]string{{"service.name", spec.ServiceNameOrDefault()}},
+	}
+	if o := spec.OTLP; o != nil {
+		header := http.Header{}
+		for _, h := range o.Headers {
+			value, err := values.Get(ctx, h.Value, h.ValueFrom)
+			if err != nil {
+				return nil, fmt.Errorf("header %q: %v", h.Name, err)
+			}
+			header.Add(h.Name, value)
+		}
+		t.Exporter = &export.OTLP{
+			Delivery: export.Delivery{Retries: 2, Backoff: time.Second, Timeout: SpanExportTimeout},
+			Endpoint: o.Endpoint,
+			GRPC:     o.Protocol == syntheticv1.OTLPGRPC,
+			Header:   header,
+		}
+	}
+	return t, nil
+}
+
+// Span is the client span of a request in flight.
+type Span struct {
+	span    export.Span
+	sampled bool
+	tracer  *Tracer
+}
+
+// Start starts the client span of req and sends its trace context in the
+// traceparent header. It returns nil for the nil Tracer.
+func (t *Tracer) Start(req *http.Request) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, sampled: mrand.Float64() < t.Sampling}
+	rand.Read(s.span.TraceID[:])
+	rand.Read(s.span.SpanID[:])
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	req.Header.Set("Traceparent", "00-"+hex.EncodeToString(s.span.TraceID[:])+"-"+hex.EncodeToString(s.span.SpanID[:])+"-"+flags)
+
+	// The query and credentials of the URL may hold secrets.
+	u := *req.URL
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	s.span.Name = "HTTP " + req.Method
+	s.span.Attributes = append([][2]string{
+		{"http.method", req.Method},
+		{"http.url", u.String()},
+	}, t.Attributes...)
+	s.span.Start = time.Now()
+	return s
+}
+
+// TraceID returns the trace ID of s in hex, or "" if s is nil.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.span.TraceIDString()
+}
+
+// Finish ends s with the status code of the response, or the error the
+// request failed with, and keeps it for export if it was sampled.
+func (s *Span) Finish(statusCode int, err error) {
+	if s == nil || !s.sampled || s.tracer.Exporter == nil {
+		return
+	}
+	s.span.End = time.Now()
+	s.span.StatusCode = statusCode
+	if err != nil {
+		s.span.Error = err.Error()
+	}
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) < MaxBufferedSpans {
+		t.spans = append(t.spans, s.span)
+	}
+}
+
+// Flush exports the spans finished since the last flush. Spans that fail to
+// export are dropped.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil || t.Exporter == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.Exporter.WriteSpans(ctx, t.Resource, spans)
+}
@@ -0,0 +1,99 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestTracer(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var mu sync.Mutex
+	var traceparents []string
+	var exported [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/v1/traces" {
+			g.Expect(r.Header.Get("Authorization")).To(Equal("Bearer t0ken"))
+			body, _ := ioutil.ReadAll(r.Body)
+			exported = append(exported, body)
+			return
+		}
+		traceparents = append(traceparents, r.Header.Get("Traceparent"))
+	}))
+	defer srv.Close()
+
+	spec := &syntheticv1.HTTPProbe{URL: srv.URL + "/search?token=secret"}
+	format := regexp.MustCompile(`^00-([0-9a-f]{32})-[0-9a-f]{16}-(0[01])$`)
+
+	// Unsampled requests carry trace context but export no span.
+	never := int32(0)
+	tracer, err := NewTracer(context.Background(), &syntheticv1.Tracing{
+		SamplingPercent: &never,
+		OTLP:            &syntheticv1.OTLPEndpoint{Endpoint: srv.URL},
+	}, Inline)
+	g.Expect(err).NotTo(HaveOccurred())
+	res, err := HTTP(context.Background(), spec, Inline, tracer)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tracer.Flush(context.Background())).To(Succeed())
+	m := format.FindStringSubmatch(traceparents[0])
+	g.Expect(m).NotTo(BeNil())
+	g.Expect(m[1]).To(Equal(res.TraceID))
+	g.Expect(m[2]).To(Equal("00"))
+	g.Expect(exported).To(BeEmpty())
+
+	tracer, err = NewTracer(context.Background(), &syntheticv1.Tracing{
+		OTLP: &syntheticv1.OTLPEndpoint{
+			Endpoint: srv.URL,
+			Headers:  []syntheticv1.HTTPHeader{{Name: "Authorization", Value: "Bearer t0ken"}},
+		},
+	}, Inline)
+	g.Expect(err).NotTo(HaveOccurred())
+	tracer.Attributes = [][2]string{{"perph.check", "search"}}
+	for i := 0; i < 2; i++ {
+		_, err = HTTP(context.Background(), spec, Inline, tracer)
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	g.Expect(tracer.Flush(context.Background())).To(Succeed())
+	g.Expect(format.FindStringSubmatch(traceparents[1])[2]).To(Equal("01"))
+	g.Expect(traceparents[1]).NotTo(Equal(traceparents[2]))
+	g.Expect(exported).To(HaveLen(1))
+	g.Expect(string(exported[0])).To(ContainSubstring("perph.check"))
+	g.Expect(string(exported[0])).To(ContainSubstring(srv.URL + "/search"))
+	g.Expect(string(exported[0])).NotTo(ContainSubstring("secret"))
+
+	// Nothing is left to flush.
+	g.Expect(tracer.Flush(context.Background())).To(Succeed())
+	g.Expect(exported).To(HaveLen(1))
+
+	// The nil tracer sends no trace context.
+	res, err = HTTP(context.Background(), spec, Inline, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(res.TraceID).To(BeEmpty())
+	g.Expect(traceparents[3]).To(BeEmpty())
+}
@@ -0,0 +1,103 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/jsonschema"
+)
+
+// maxSchemaErrors bounds the schema violations quoted in an assertion message.
+const maxSchemaErrors = 3
+
+// Validate evaluates the contract described by v against result, returning
+// one assertion for the status code, one for the latency and one for the
+// body schema when the contract has a schema for the response status.
+func Validate(v *syntheticv1.Validation, result *Result) []syntheticv1.AssertionResult {
+	var out []syntheticv1.AssertionResult
+
+	if len(v.Spec.ExpectedStatus) > 0 {
+		a := syntheticv1.AssertionResult{Name: v.Name + "/status"}
+		for _, code := range v.Spec.ExpectedStatus {
+			if int(code) == result.StatusCode {
+				a.Passed = true
+			}
+		}
+		if !a.Passed {
+			a.Message = fmt.Sprintf("status %d is not documented, expected one of %v", result.StatusCode, v.Spec.ExpectedStatus)
+		}
+		out = append(out, a)
+	}
+
+	if v.Spec.MaxLatency != nil {
+		a := syntheticv1.AssertionResult{Name: v.Name + "/latency"}
+		total := result.Timings.Total.Duration
+		a.Passed = total <= v.Spec.MaxLatency.Duration
+		if !a.Passed {
+			a.Message = fmt.Sprintf("response took %v, longer than %v", total, v.Spec.MaxLatency.Duration)
+		}
+		out = append(out, a)
+	}
+
+	var schema *syntheticv1.ResponseSchema
+	for i := range v.Spec.Schemas {
+		s := &v.Spec.Schemas[i]
+		if int(s.Status) == result.StatusCode {
+			schema = s
+			break
+		}
+		if s.Status == 0 && schema == nil {
+			schema = s
+		}
+	}
+	if schema != nil {
+		out = append(out, validateBody(v.Name+"/schema", schema.Schema, result.Body))
+	}
+	return out
+}
+
+func validateBody(name, schema string, body []byte) syntheticv1.AssertionResult {
+	a := syntheticv1.AssertionResult{Name: name}
+	s, err := jsonschema.Parse([]byte(schema))
+	if err != nil {
+		a.Message = err.Error()
+		return a
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		a.Message = fmt.Sprintf("response body is not JSON: %v", err)
+		return a
+	}
+	errs := s.Validate(doc)
+	if len(errs) == 0 {
+		a.Passed = true
+		return a
+	}
+	msgs := make([]string, 0, maxSchemaErrors)
+	for i, e := range errs {
+		if i == maxSchemaErrors {
+			msgs = append(msgs, fmt.Sprintf("and %d more", len(errs)-i))
+			break
+		}
+		msgs = append(msgs, e.Error())
+	}
+	a.Message = strings.Join(msgs, "; ")
+	return a
+}
@@ -0,0 +1,61 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestValidate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	v := &syntheticv1.Validation{}
+	v.Name = "pets"
+	v.Spec.ExpectedStatus = []int32{200, 404}
+	v.Spec.Schemas = []syntheticv1.ResponseSchema{
+		{Schema: `{"type": "object", "required": ["message"]}`},
+		{Status: 200, Schema: `{"type": "array", "items": {"type": "object", "required": ["id"]}}`},
+	}
+
+	g.Expect(Validate(v, &Result{StatusCode: 200, Body: []byte(`[{"id": 1}]`)})).To(Equal([]syntheticv1.AssertionResult{
+		{Name: "pets/status", Passed: true},
+		{Name: "pets/schema", Passed: true},
+	}))
+
+	g.Expect(Validate(v, &Result{StatusCode: 200, Body: []byte(`[{"id": 1}, {}, {}, {}, {}]`)})).To(ContainElement(syntheticv1.AssertionResult{
+		Name:    "pets/schema",
+		Message: `/1: missing required property "id"; /2: missing required property "id"; /3: missing required property "id"; and 1 more`,
+	}))
+
+	g.Expect(Validate(v, &Result{StatusCode: 500, Body: []byte(`<html>`)})).To(Equal([]syntheticv1.AssertionResult{
+		{Name: "pets/status", Message: "status 500 is not documented, expected one of [200 404]"},
+		{Name: "pets/schema", Message: "response body is not JSON: invalid character '<' looking for beginning of value"},
+	}))
+
+	v.Spec.Schemas = nil
+	v.Spec.MaxLatency = &metav1.Duration{Duration: 500 * time.Millisecond}
+	slow := &Result{StatusCode: 200, Timings: syntheticv1.PhaseTimings{Total: metav1.Duration{Duration: 2 * time.Second}}}
+	g.Expect(Validate(v, slow)).To(Equal([]syntheticv1.AssertionResult{
+		{Name: "pets/status", Passed: true},
+		{Name: "pets/latency", Message: "response took 2s, longer than 500ms"},
+	}))
+}
@@ -0,0 +1,137 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// Values resolves the values a spec reads from Secrets and ConfigMaps.
+type Values interface {
+	Get(ctx context.Context, value string, from *syntheticv1.ValueSource) (string, error)
+}
+
+// Inline resolves only values written inline in the spec, and fails on any
+// reference to a Secret or ConfigMap.
+var Inline Values = inlineValues{}
+
+type inlineValues struct{}
+
+func (inlineValues) Get(_ context.Context, value string, from *syntheticv1.ValueSource) (string, error) {
+	if from != nil {
+		return "", errors.New("valueFrom is not supported here")
+	}
+	return value, nil
+}
+
+// ResolveVariables returns the values of vars keyed by name.
+func ResolveVariables(ctx context.Context, vars []syntheticv1.Variable, values Values) (map[string]string, error) {
+	out := make(map[string]string, len(vars))
+	for _, v := range vars {
+		value, err := values.Get(ctx, v.Value, v.ValueFrom)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %v", v.Name, err)
+		}
+		out[v.Name] = value
+	}
+	return out, nil
+}
+
+// Expand replaces every $(NAME) in s with the value of the variable NAME.
+// References to unknown variables are left untouched, and $$(NAME) escapes
+// the reference to produce a literal $(NAME).
+func Expand(s string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(s, "$(") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if s[i+1] == '$' && i+2 < len(s) && s[i+2] == '(' {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+		if s[i+1] != '(' {
+			b.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i+2:], ')')
+		if end < 0 {
+			b.WriteString(s[i:])
+			break
+		}
+		name := s[i+2 : i+2+end]
+		if value, ok := vars[name]; ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(s[i : i+3+end])
+		}
+		i += 2 + end
+	}
+	return b.String()
+}
+
+// TLSClientConfig builds the client TLS configuration described by cfg.
+func TLSClientConfig(ctx context.Context, cfg *syntheticv1.TLSConfig, values Values) (*tls.Config, error) {
+	out := &tls.Config{}
+	if cfg == nil {
+		return out, nil
+	}
+	out.ServerName = cfg.ServerName
+	out.InsecureSkipVerify = cfg.InsecureSkipVerify
+
+	if cfg.CA != nil {
+		ca, err := values.Get(ctx, "", cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca: %v", err)
+		}
+		out.RootCAs = x509.NewCertPool()
+		if !out.RootCAs.AppendCertsFromPEM([]byte(ca)) {
+			return nil, errors.New("tls ca: no certificates found")
+		}
+	}
+
+	if cfg.Cert != nil || cfg.Key != nil {
+		if cfg.Cert == nil || cfg.Key == nil {
+			return nil, errors.New("tls cert and key must be set together")
+		}
+		cert, err := values.Get(ctx, "", cfg.Cert)
+		if err != nil {
+			return nil, fmt.Errorf("tls cert: %v", err)
+		}
+		key, err := values.Get(ctx, "", cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("tls key: %v", err)
+		}
+		pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("tls client certificate: %v", err)
+		}
+		out.Certificates = []tls.Certificate{pair}
+	}
+	return out, nil
+}
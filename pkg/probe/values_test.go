@@ -0,0 +1,79 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestExpand(t *testing.T) {
+	g := NewGomegaWithT(t)
+	vars := map[string]string{"HOST": "example.com", "ID": "42"}
+
+	g.Expect(Expand("https://$(HOST)/items/$(ID)", vars)).To(Equal("https://example.com/items/42"))
+	g.Expect(Expand("$(UNKNOWN) stays", vars)).To(Equal("$(UNKNOWN) stays"))
+	g.Expect(Expand("$$(HOST) is escaped", vars)).To(Equal("$(HOST) is escaped"))
+	g.Expect(Expand("costs $5 $(ID", vars)).To(Equal("costs $5 $(ID"))
+}
+
+// mapValues resolves Secret references from a map keyed by secret name.
+type mapValues map[string]string
+
+func (m mapValues) Get(_ context.Context, value string, from *syntheticv1.ValueSource) (string, error) {
+	if from == nil {
+		return value, nil
+	}
+	return m[from.SecretKeyRef.Name], nil
+}
+
+func TestNewRequestResolvesValues(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spec := &syntheticv1.HTTPProbe{
+		URL:    "https://$(HOST)/search",
+		Method: "POST",
+		Body:   `{"user":"$(USER)"}`,
+		Variables: []syntheticv1.Variable{
+			{Name: "HOST", Value: "api.example.com"},
+			{Name: "USER", ValueFrom: secretRef("user")},
+		},
+		Headers: []syntheticv1.HTTPHeader{
+			{Name: "Authorization", ValueFrom: secretRef("auth")},
+		},
+	}
+	values := mapValues{"user": "alice", "auth": "Bearer abc"}
+
+	req, err := NewRequest(context.Background(), spec, values)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(req.URL.String()).To(Equal("https://api.example.com/search"))
+	g.Expect(req.Header.Get("Authorization")).To(Equal("Bearer abc"))
+	g.Expect(req.ContentLength).To(BeNumerically("==", len(`{"user":"alice"}`)))
+
+	_, err = NewRequest(context.Background(), spec, Inline)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func secretRef(name string) *syntheticv1.ValueSource {
+	src := &syntheticv1.ValueSource{SecretKeyRef: &corev1.SecretKeySelector{}}
+	src.SecretKeyRef.Name = name
+	return src
+}
@@ -0,0 +1,158 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrUnauthenticated is returned by an Authorizer for a token that does not
+// identify anyone.
+var ErrUnauthenticated = errors.New("invalid bearer token")
+
+// Authorizer decides whether the bearer of a token may access a resource.
+type Authorizer interface {
+	Authorize(ctx context.Context, token string, attrs authorizationv1.ResourceAttributes) (bool, error)
+}
+
+// AuthorizeRequest checks that the bearer of r may access every resource in
+// attrs, and answers the request with 401, 403 or 500 if not.
+func AuthorizeRequest(w http.ResponseWriter, r *http.Request, a Authorizer, log logr.Logger, attrs ...authorizationv1.ResourceAttributes) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "bearer token required", http.StatusUnauthorized)
+		return false
+	}
+	for _, attr := range attrs {
+		allowed, err := a.Authorize(r.Context(), token, attr)
+		switch {
+		case err == ErrUnauthenticated:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return false
+		case err != nil:
+			log.Error(err, "unable to authorize request", "path", r.URL.Path)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		case !allowed:
+			http.Error(w, fmt.Sprintf("forbidden to %s %s in namespace %q", attr.Verb, attr.Resource, attr.Namespace), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultAuthTTL is how long a KubeAuthorizer remembers a decision by
+// default.
+const DefaultAuthTTL = time.Minute
+
+// KubeAuthorizer authenticates tokens with a TokenReview and authorizes their
+// users with a SubjectAccessReview, so that access to results follows the
+// RBAC rules on the objects they belong to.
+type KubeAuthorizer struct {
+	Client client.Client
+
+	// TTL is how long decisions are remembered. Defaults to DefaultAuthTTL.
+	TTL time.Duration
+
+	mu        sync.Mutex
+	decisions map[string]decision
+	now       func() time.Time
+}
+
+type decision struct {
+	allowed bool
+	err     error
+	expires time.Time
+}
+
+func (a *KubeAuthorizer) Authorize(ctx context.Context, token string, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:]) + "/" + attrs.Verb + "/" + attrs.Group + "/" + attrs.Resource + "/" + attrs.Namespace + "/" + attrs.Name
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+
+	a.mu.Lock()
+	if a.decisions == nil {
+		a.decisions = map[string]decision{}
+	}
+	d, ok := a.decisions[key]
+	a.mu.Unlock()
+	if ok && now().Before(d.expires) {
+		return d.allowed, d.err
+	}
+
+	allowed, err := a.review(ctx, token, attrs)
+	if err != nil && err != ErrUnauthenticated {
+		return false, err
+	}
+	ttl := a.TTL
+	if ttl <= 0 {
+		ttl = DefaultAuthTTL
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, d := range a.decisions {
+		if !now().Before(d.expires) {
+			delete(a.decisions, k)
+		}
+	}
+	a.decisions[key] = decision{allowed: allowed, err: err, expires: now().Add(ttl)}
+	return allowed, err
+}
+
+func (a *KubeAuthorizer) review(ctx context.Context, token string, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	tr := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := a.Client.Create(ctx, tr); err != nil {
+		return false, err
+	}
+	if !tr.Status.Authenticated {
+		return false, ErrUnauthenticated
+	}
+
+	user := tr.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &attrs,
+		User:               user.Username,
+		Groups:             user.Groups,
+		UID:                user.UID,
+		Extra:              extra,
+	}}
+	if err := a.Client.Create(ctx, sar); err != nil {
+		return false, err
+	}
+	return sar.Status.Allowed, nil
+}
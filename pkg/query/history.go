@@ -0,0 +1,162 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/store"
+)
+
+// History reads the runs of checks from the result store when it is set, and
+// from the SyntheticRuns kept in the cluster otherwise. The store of a
+// manager only holds the runs of its own location, so the runs of locations
+// it has no series of always come from the cluster, which keeps the last
+// HistoryLimit runs of each.
+type History struct {
+	Client client.Client
+	Store  store.Store
+}
+
+// series returns the series the store holds of the check name, from
+// location or every location when it is empty.
+func (h *History) series(namespace, name, location string) ([]store.Series, error) {
+	all, err := h.Store.Series()
+	if err != nil {
+		return nil, err
+	}
+	var out []store.Series
+	for _, series := range all {
+		if series.Kind == "checks" && series.Namespace == namespace && series.Name == name &&
+			(location == "" || series.Location == location) {
+			out = append(out, series)
+		}
+	}
+	return out, nil
+}
+
+// runs returns the finished SyntheticRuns of the check name in [from, to),
+// from location or every location when it is empty, by location.
+func (h *History) runs(ctx context.Context, namespace, name, location string, from, to time.Time) (map[string][]store.Record, error) {
+	labels := map[string]string{syntheticv1.CheckLabel: name}
+	if location != "" {
+		labels[syntheticv1.LocationLabel] = location
+	}
+	var list syntheticv1.SyntheticRunList
+	if err := h.Client.List(ctx, &list, client.InNamespace(namespace), client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+	out := map[string][]store.Record{}
+	for i := range list.Items {
+		run := &list.Items[i]
+		if !run.Finished() || run.Status.CompletionTime == nil {
+			continue
+		}
+		if t := run.Status.CompletionTime.Time; t.Before(from) || !t.Before(to) {
+			continue
+		}
+		out[run.Spec.Location] = append(out[run.Spec.Location], *store.RecordOf(run))
+	}
+	for _, records := range out {
+		sort.Slice(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	}
+	return out, nil
+}
+
+// Records returns the runs of the check name in [from, to) that are kept
+// in full, from location or every location when it is empty, by location.
+func (h *History) Records(ctx context.Context, namespace, name, location string, from, to time.Time) (map[string][]store.Record, error) {
+	if h.Store == nil {
+		return h.runs(ctx, namespace, name, location, from, to)
+	}
+	list, err := h.series(namespace, name, location)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]store.Record{}
+	for _, series := range list {
+		records, err := h.Store.Records(series, from, to)
+		if err != nil {
+			return nil, err
+		}
+		out[series.Location] = records
+	}
+	others, err := h.others(ctx, list, namespace, name, location, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for location, records := range others {
+		out[location] = records
+	}
+	return out, nil
+}
+
+// others returns the finished SyntheticRuns of the check name in [from, to)
+// from the locations other than those of stored, by location.
+func (h *History) others(ctx context.Context, stored []store.Series, namespace, name, location string, from, to time.Time) (map[string][]store.Record, error) {
+	runs, err := h.runs(ctx, namespace, name, location, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, series := range stored {
+		delete(runs, series.Location)
+	}
+	return runs, nil
+}
+
+// Points returns the history of the check name in [from, to), from
+// location or every location when it is empty, by location.
+func (h *History) Points(ctx context.Context, namespace, name, location string, from, to time.Time) (map[string][]store.Point, error) {
+	out := map[string][]store.Point{}
+	if h.Store == nil {
+		runs, err := h.runs(ctx, namespace, name, location, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for location, records := range runs {
+			for i := range records {
+				out[location] = append(out[location], records[i].Point())
+			}
+		}
+		return out, nil
+	}
+	list, err := h.series(namespace, name, location)
+	if err != nil {
+		return nil, err
+	}
+	for _, series := range list {
+		points, err := h.Store.Points(series, from, to)
+		if err != nil {
+			return nil, err
+		}
+		out[series.Location] = points
+	}
+	others, err := h.others(ctx, list, namespace, name, location, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for location, records := range others {
+		for i := range records {
+			out[location] = append(out[location], records[i].Point())
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,603 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package query serves the results and history of checks, and the progress
+// of load tests, over a read-only REST API for dashboards.
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/load"
+	"github.com/perph/perph/pkg/store"
+)
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+const (
+	// DefaultRange is the time range of history requests that do not set
+	// one.
+	DefaultRange = 24 * time.Hour
+	// DefaultLimit is the number of runs returned by history requests that
+	// do not set a limit.
+	DefaultLimit = 1000
+	// DefaultPollInterval is how often load test progress is checked for
+	// changes by default.
+	DefaultPollInterval = time.Second
+
+	// keepAliveInterval is how often an idle event stream gets a comment, so
+	// that proxies do not time it out.
+	keepAliveInterval = 15 * time.Second
+	// latencyPoints is the number of points of latency series that do not
+	// set a step.
+	latencyPoints = 120
+)
+
+// Server serves, to bearers of a token allowed to get the objects involved:
+//
+//	GET /api/v1/namespaces/<ns>/checks
+//	GET /api/v1/namespaces/<ns>/checks/<name>
+//	GET /api/v1/namespaces/<ns>/checks/<name>/runs?from=&to=&location=&limit=
+//	GET /api/v1/namespaces/<ns>/checks/<name>/uptime?from=&to=&location=
+//	GET /api/v1/namespaces/<ns>/checks/<name>/latency?from=&to=&location=&step=
+//	GET /api/v1/namespaces/<ns>/loadtests/<name>/events
+//
+// from and to are RFC 3339 times and default to the last DefaultRange.
+// History comes from Store when it is set, and from the SyntheticRuns kept
+// in the cluster otherwise and for the locations Store has no runs of. Load
+// test progress is streamed as server-sent events. Requests carry bearer
+// tokens, so the server only serves TLS.
+type Server struct {
+	Client     client.Client
+	Store      store.Store
+	Authorizer Authorizer
+	Log        logr.Logger
+
+	// Addr is the address the server listens on.
+	Addr string
+
+	// CertDir holds the serving certificate and key, as tls.crt and
+	// tls.key. Required.
+	CertDir string
+
+	// PollInterval defaults to DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+// CheckSummary describes a check and its latest finished run from each
+// location.
+type CheckSummary struct {
+	Name        string                         `json:"name"`
+	DependsOn   []string                       `json:"dependsOn,omitempty"`
+	Maintenance *syntheticv1.ActiveMaintenance `json:"maintenance,omitempty"`
+	Locations   []LocationSummary              `json:"locations"`
+}
+
+// LocationSummary is the latest finished run of a check from a location.
+type LocationSummary struct {
+	Location string                        `json:"location"`
+	Run      string                        `json:"run"`
+	Phase    syntheticv1.SyntheticRunPhase `json:"phase"`
+	Time     time.Time                     `json:"time"`
+	Message  string                        `json:"message,omitempty"`
+}
+
+// Run is a run of a check from a location.
+type Run struct {
+	Location string `json:"location"`
+	store.Record
+}
+
+// Uptime is the share of the runs of a check that succeeded. Silenced runs
+// are left out.
+type Uptime struct {
+	Location string `json:"location,omitempty"`
+	Runs     int64  `json:"runs"`
+	Failures int64  `json:"failures"`
+	Silenced int64  `json:"silenced"`
+	// Percent is nil when there were no runs.
+	Percent *float64 `json:"percent"`
+}
+
+// UptimeReport is the uptime of a check over a time range, overall and from
+// each location.
+type UptimeReport struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Overall   Uptime    `json:"overall"`
+	Locations []Uptime  `json:"locations"`
+}
+
+// LatencySeries is the response time of the runs of a check from a
+// location, in seconds.
+type LatencySeries struct {
+	Location string         `json:"location"`
+	Points   []LatencyPoint `json:"points"`
+}
+
+// LatencyPoint aggregates the runs of a step of a LatencySeries.
+type LatencyPoint struct {
+	Time time.Time `json:"time"`
+	Runs int64     `json:"runs"`
+	Mean float64   `json:"mean"`
+	Max  float64   `json:"max"`
+}
+
+// Progress is the state of the most recent run of a load test.
+type Progress struct {
+	Phase      syntheticv1.SyntheticRunPhase `json:"phase,omitempty"`
+	Run        string                        `json:"run,omitempty"`
+	Workers    []WorkerProgress              `json:"workers,omitempty"`
+	Summary    *syntheticv1.LoadSummary      `json:"summary,omitempty"`
+	Comparison *syntheticv1.Comparison       `json:"comparison,omitempty"`
+}
+
+// WorkerProgress is the state of the run of one worker of a load test.
+type WorkerProgress struct {
+	Run      string                        `json:"run"`
+	Location string                        `json:"location"`
+	Phase    syntheticv1.SyntheticRunPhase `json:"phase,omitempty"`
+	Summary  *syntheticv1.LoadSummary      `json:"summary,omitempty"`
+}
+
+// badRequest is an error caused by the request.
+type badRequest string
+
+func (e badRequest) Error() string { return string(e) }
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 5 || len(parts) > 7 || parts[0] != "api" || parts[1] != "v1" || parts[2] != "namespaces" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	namespace, resource := parts[3], parts[4]
+	var name, sub string
+	if len(parts) > 5 {
+		name = parts[5]
+	}
+	if len(parts) > 6 {
+		sub = parts[6]
+	}
+	switch {
+	case resource == "checks" && (sub == "" || sub == "runs" || sub == "uptime" || sub == "latency"):
+	case resource == "loadtests" && sub == "events":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, namespace, resource, name) {
+		return
+	}
+
+	ctx := r.Context()
+	var out interface{}
+	var err error
+	switch {
+	case resource == "loadtests":
+		s.streamProgress(w, r, types.NamespacedName{Namespace: namespace, Name: name})
+		return
+	case name == "":
+		out, err = s.listChecks(ctx, namespace)
+	case sub == "":
+		out, err = s.getCheck(ctx, namespace, name)
+	default:
+		out, err = s.checkHistory(ctx, r, sub, namespace, name)
+	}
+	switch {
+	case apierrors.IsNotFound(err):
+		http.Error(w, fmt.Sprintf("%s %s/%s not found", resource, namespace, name), http.StatusNotFound)
+		return
+	case err != nil:
+		if _, ok := err.(badRequest); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Log.Error(err, "unable to serve query", "path", r.URL.Path)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// authorize checks that the bearer of the request may get, or list when name
+// is empty, the resource, and answers the request if not.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, namespace, resource, name string) bool {
+	verb := "get"
+	if name == "" {
+		verb = "list"
+	}
+	return AuthorizeRequest(w, r, s.Authorizer, s.Log, authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      verb,
+		Group:     syntheticv1.GroupVersion.Group,
+		Version:   syntheticv1.GroupVersion.Version,
+		Resource:  resource,
+		Name:      name,
+	})
+}
+
+func (s *Server) listChecks(ctx context.Context, namespace string) ([]CheckSummary, error) {
+	var checks syntheticv1.CheckList
+	if err := s.Client.List(ctx, &checks, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var runs syntheticv1.SyntheticRunList
+	if err := s.Client.List(ctx, &runs, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	out := make([]CheckSummary, 0, len(checks.Items))
+	for i := range checks.Items {
+		out = append(out, summarize(&checks.Items[i], runs.Items))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (s *Server) getCheck(ctx context.Context, namespace, name string) (*CheckSummary, error) {
+	var check syntheticv1.Check
+	if err := s.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &check); err != nil {
+		return nil, err
+	}
+	var runs syntheticv1.SyntheticRunList
+	if err := s.Client.List(ctx, &runs, client.InNamespace(namespace),
+		client.MatchingLabels(map[string]string{syntheticv1.CheckLabel: name})); err != nil {
+		return nil, err
+	}
+	summary := summarize(&check, runs.Items)
+	return &summary, nil
+}
+
+// summarize returns the summary of check from its runs among runs.
+func summarize(check *syntheticv1.Check, runs []syntheticv1.SyntheticRun) CheckSummary {
+	latest := map[string]*syntheticv1.SyntheticRun{}
+	for i := range runs {
+		run := &runs[i]
+		if run.Spec.CheckRef != check.Name || !run.Finished() || run.Status.CompletionTime == nil {
+			continue
+		}
+		if l := latest[run.Spec.Location]; l == nil || l.Status.CompletionTime.Before(run.Status.CompletionTime) {
+			latest[run.Spec.Location] = run
+		}
+	}
+	out := CheckSummary{
+		Name:        check.Name,
+		DependsOn:   check.Spec.DependsOn,
+		Maintenance: check.Status.Maintenance,
+		Locations:   []LocationSummary{},
+	}
+	for location, run := range latest {
+		out.Locations = append(out.Locations, LocationSummary{
+			Location: location,
+			Run:      run.Name,
+			Phase:    run.Status.Phase,
+			Time:     run.Status.CompletionTime.Time,
+			Message:  run.Status.Message,
+		})
+	}
+	sort.Slice(out.Locations, func(i, j int) bool { return out.Locations[i].Location < out.Locations[j].Location })
+	return out
+}
+
+// checkHistory answers the runs, uptime and latency requests of a check.
+func (s *Server) checkHistory(ctx context.Context, r *http.Request, sub, namespace, name string) (interface{}, error) {
+	var check syntheticv1.Check
+	if err := s.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &check); err != nil {
+		return nil, err
+	}
+	q := r.URL.Query()
+	from, to, err := timeRange(q.Get("from"), q.Get("to"))
+	if err != nil {
+		return nil, err
+	}
+	location := q.Get("location")
+
+	switch sub {
+	case "runs":
+		limit := DefaultLimit
+		if l := q.Get("limit"); l != "" {
+			if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+				return nil, badRequest("invalid limit " + l)
+			}
+		}
+		records, err := s.history().Records(ctx, namespace, name, location, from, to)
+		if err != nil {
+			return nil, err
+		}
+		return newestRuns(records, limit), nil
+	case "uptime":
+		points, err := s.history().Points(ctx, namespace, name, location, from, to)
+		if err != nil {
+			return nil, err
+		}
+		return uptimeReport(points, from, to), nil
+	default:
+		step := to.Sub(from) / latencyPoints
+		if st := q.Get("step"); st != "" {
+			if step, err = time.ParseDuration(st); err != nil || step <= 0 {
+				return nil, badRequest("invalid step " + st)
+			}
+		}
+		if step < time.Minute {
+			step = time.Minute
+		}
+		points, err := s.history().Points(ctx, namespace, name, location, from, to)
+		if err != nil {
+			return nil, err
+		}
+		return latencySeries(points, from, step), nil
+	}
+}
+
+func (s *Server) history() *History {
+	return &History{Client: s.Client, Store: s.Store}
+}
+
+// timeRange parses the from and to parameters of a request.
+func timeRange(fromParam, toParam string) (time.Time, time.Time, error) {
+	to := time.Now()
+	if toParam != "" {
+		t, err := time.Parse(time.RFC3339, toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, badRequest("invalid to " + toParam)
+		}
+		to = t
+	}
+	from := to.Add(-DefaultRange)
+	if fromParam != "" {
+		t, err := time.Parse(time.RFC3339, fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, badRequest("invalid from " + fromParam)
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, badRequest("from must be before to")
+	}
+	return from, to, nil
+}
+
+// newestRuns returns the newest limit records across locations, oldest
+// first.
+func newestRuns(records map[string][]store.Record, limit int) []Run {
+	out := []Run{}
+	for location, list := range records {
+		for _, r := range list {
+			out = append(out, Run{Location: location, Record: r})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+func uptimeReport(points map[string][]store.Point, from, to time.Time) *UptimeReport {
+	report := &UptimeReport{From: from, To: to, Locations: []Uptime{}}
+	for location, list := range points {
+		u := Uptime{Location: location}
+		for _, p := range list {
+			u.Runs += p.Runs
+			u.Failures += p.Failures
+			u.Silenced += p.Silenced
+		}
+		u.Percent = percent(u.Runs, u.Failures)
+		report.Locations = append(report.Locations, u)
+		report.Overall.Runs += u.Runs
+		report.Overall.Failures += u.Failures
+		report.Overall.Silenced += u.Silenced
+	}
+	report.Overall.Percent = percent(report.Overall.Runs, report.Overall.Failures)
+	sort.Slice(report.Locations, func(i, j int) bool { return report.Locations[i].Location < report.Locations[j].Location })
+	return report
+}
+
+func percent(runs, failures int64) *float64 {
+	if runs == 0 {
+		return nil
+	}
+	p := 100 * float64(runs-failures) / float64(runs)
+	return &p
+}
+
+// latencySeries aggregates points into steps starting at from.
+func latencySeries(points map[string][]store.Point, from time.Time, step time.Duration) []LatencySeries {
+	out := []LatencySeries{}
+	for location, list := range points {
+		series := LatencySeries{Location: location, Points: []LatencyPoint{}}
+		var agg store.Point
+		flush := func() {
+			if agg.Runs == 0 {
+				return
+			}
+			series.Points = append(series.Points, LatencyPoint{
+				Time: agg.Time,
+				Runs: agg.Runs,
+				Mean: (agg.LatencySum / time.Duration(agg.Runs)).Seconds(),
+				Max:  agg.LatencyMax.Seconds(),
+			})
+		}
+		for _, p := range list {
+			start := from.Add(p.Time.Sub(from) / step * step)
+			if p.Time.Before(from) {
+				start = from
+			}
+			if !start.Equal(agg.Time) {
+				flush()
+				agg = store.Point{Time: start}
+			}
+			agg.Runs += p.Runs
+			agg.LatencySum += p.LatencySum
+			if p.LatencyMax > agg.LatencyMax {
+				agg.LatencyMax = p.LatencyMax
+			}
+		}
+		flush()
+		out = append(out, series)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Location < out[j].Location })
+	return out
+}
+
+// streamProgress sends the progress of a load test as a server-sent event
+// whenever it changes, until the client goes away or the load test is
+// deleted.
+func (s *Server) streamProgress(w http.ResponseWriter, r *http.Request, key types.NamespacedName) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	ctx := r.Context()
+	progress, err := s.progress(ctx, key)
+	switch {
+	case apierrors.IsNotFound(err):
+		http.Error(w, fmt.Sprintf("loadtests %s not found", key), http.StatusNotFound)
+		return
+	case err != nil:
+		s.Log.Error(err, "unable to read load test progress", "loadtest", key)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last []byte
+	lastWrite := time.Now()
+	for {
+		data, err := json.Marshal(progress)
+		if err != nil {
+			s.Log.Error(err, "unable to encode load test progress", "loadtest", key)
+			return
+		}
+		switch {
+		case !bytes.Equal(data, last):
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+			last, lastWrite = data, time.Now()
+		case time.Since(lastWrite) >= keepAliveInterval:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			lastWrite = time.Now()
+		}
+		flusher.Flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		progress, err = s.progress(ctx, key)
+		switch {
+		case apierrors.IsNotFound(err):
+			fmt.Fprint(w, "event: deleted\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case err != nil:
+			s.Log.Error(err, "unable to read load test progress", "loadtest", key)
+		}
+	}
+}
+
+// progress returns the progress of the most recent run of a load test.
+func (s *Server) progress(ctx context.Context, key types.NamespacedName) (*Progress, error) {
+	var lt syntheticv1.LoadTest
+	if err := s.Client.Get(ctx, key, &lt); err != nil {
+		return nil, err
+	}
+	p := &Progress{Phase: lt.Status.Phase, Run: lt.Status.LastRun, Comparison: lt.Status.Comparison}
+	if lt.Status.LastRun == "" {
+		return p, nil
+	}
+	var runs syntheticv1.SyntheticRunList
+	if err := s.Client.List(ctx, &runs, client.InNamespace(key.Namespace),
+		client.MatchingLabels(map[string]string{syntheticv1.LoadTestLabel: key.Name})); err != nil {
+		return nil, err
+	}
+	var summaries []*syntheticv1.LoadSummary
+	for i := range runs.Items {
+		run := &runs.Items[i]
+		if !run.IsWorkerOf(lt.Status.LastRun) {
+			continue
+		}
+		w := WorkerProgress{Run: run.Name, Location: run.Spec.Location, Phase: run.Status.Phase}
+		if run.Status.LoadTest != nil {
+			w.Summary = run.Status.LoadTest.DeepCopy()
+			w.Summary.Histogram = nil
+			summaries = append(summaries, run.Status.LoadTest)
+		}
+		p.Workers = append(p.Workers, w)
+	}
+	sort.Slice(p.Workers, func(i, j int) bool { return p.Workers[i].Run < p.Workers[j].Run })
+	if p.Summary = load.Merge(summaries); p.Summary != nil {
+		p.Summary.Histogram = nil
+	}
+	return p, nil
+}
+
+// Start serves requests over TLS until stop is closed. It refuses to start
+// without a certificate, as the tokens of callers would travel in the clear.
+func (s *Server) Start(stop <-chan struct{}) error {
+	if s.CertDir == "" {
+		return errors.New("the query API requires a serving certificate")
+	}
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s}
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	s.Log.Info("serving result queries", "addr", s.Addr)
+	err = srv.ServeTLS(l, filepath.Join(s.CertDir, "tls.crt"), filepath.Join(s.CertDir, "tls.key"))
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
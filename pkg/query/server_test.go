@@ -0,0 +1,401 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/store"
+)
+
+// staticAuthorizer allows the token "reader" to get and list checks and
+// load tests in the default namespace.
+type staticAuthorizer struct{}
+
+func (staticAuthorizer) Authorize(_ context.Context, token string, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	if token != "reader" && token != "nobody" {
+		return false, ErrUnauthenticated
+	}
+	return token == "reader" && attrs.Namespace == "default", nil
+}
+
+var start = time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// finishedRun returns a run of check in location that finished at minute
+// and took latency.
+func finishedRun(check, location string, minute int, phase syntheticv1.SyntheticRunPhase, latency time.Duration) *syntheticv1.SyntheticRun {
+	done := metav1.NewTime(start.Add(time.Duration(minute) * time.Minute))
+	return &syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      check + "-" + location + "-" + string(rune('a'+minute)),
+			Namespace: "default",
+			Labels:    map[string]string{syntheticv1.CheckLabel: check, syntheticv1.LocationLabel: location},
+		},
+		Spec: syntheticv1.SyntheticRunSpec{CheckRef: check, Location: location},
+		Status: syntheticv1.SyntheticRunStatus{
+			Phase:          phase,
+			CompletionTime: &done,
+			Timings:        &syntheticv1.PhaseTimings{Total: metav1.Duration{Duration: latency}},
+		},
+	}
+}
+
+func newServer(objs ...runtime.Object) *Server {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	syntheticv1.AddToScheme(scheme)
+	return &Server{
+		Client:       fake.NewFakeClientWithScheme(scheme, objs...),
+		Authorizer:   staticAuthorizer{},
+		Log:          zap.Logger(true),
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+func get(s *Server, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func objects() []runtime.Object {
+	api := &syntheticv1.Check{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Spec:       syntheticv1.CheckSpec{DependsOn: []string{"db"}},
+	}
+	db := &syntheticv1.Check{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}}
+	return []runtime.Object{
+		api, db,
+		finishedRun("api", "eu", 1, syntheticv1.RunSucceeded, 100*time.Millisecond),
+		finishedRun("api", "eu", 2, syntheticv1.RunFailed, 300*time.Millisecond),
+		finishedRun("api", "eu", 3, syntheticv1.RunSucceeded, 200*time.Millisecond),
+		finishedRun("api", "us", 1, syntheticv1.RunSucceeded, 400*time.Millisecond),
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	g := NewGomegaWithT(t)
+	s := newServer(objects()...)
+
+	w := get(s, "/api/v1/namespaces/default/checks", "")
+	g.Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(w.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+	g.Expect(get(s, "/api/v1/namespaces/default/checks", "forged").Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(get(s, "/api/v1/namespaces/default/checks", "nobody").Code).To(Equal(http.StatusForbidden))
+	g.Expect(get(s, "/api/v1/namespaces/other/checks", "reader").Code).To(Equal(http.StatusForbidden))
+	g.Expect(get(s, "/api/v1/namespaces/default/checks", "reader").Code).To(Equal(http.StatusOK))
+
+	g.Expect(get(s, "/api/v1/namespaces/default/validations", "reader").Code).To(Equal(http.StatusNotFound))
+	g.Expect(get(s, "/api/v1/namespaces/default/checks/api/other", "reader").Code).To(Equal(http.StatusNotFound))
+	g.Expect(get(s, "/api/v1/namespaces/default/checks/missing", "reader").Code).To(Equal(http.StatusNotFound))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/namespaces/default/checks", nil)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	g.Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 to dir.
+func writeCert(g *GomegaWithT, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	g.Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ioutil.WriteFile(filepath.Join(dir, "tls.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+	g.Expect(ioutil.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)).To(Succeed())
+}
+
+func TestStart(t *testing.T) {
+	g := NewGomegaWithT(t)
+	s := newServer(objects()...)
+
+	// Tokens are never accepted in the clear.
+	g.Expect(s.Start(make(chan struct{}))).To(MatchError(ContainSubstring("serving certificate")))
+
+	dir, err := ioutil.TempDir("", "query")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	writeCert(g, dir)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	s.Addr = l.Addr().String()
+	l.Close()
+	s.CertDir = dir
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- s.Start(stop) }()
+	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	c := &http.Client{Transport: tr}
+	var res *http.Response
+	g.Eventually(func() error {
+		res, err = c.Get("https://" + s.Addr + "/api/v1/namespaces/default/checks")
+		return err
+	}).Should(Succeed())
+	res.Body.Close()
+	g.Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+
+	res, err = http.Get("http://" + s.Addr + "/api/v1/namespaces/default/checks")
+	g.Expect(err).NotTo(HaveOccurred())
+	res.Body.Close()
+	g.Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+
+	close(stop)
+	g.Eventually(done).Should(Receive(BeNil()))
+}
+
+func TestChecks(t *testing.T) {
+	g := NewGomegaWithT(t)
+	s := newServer(objects()...)
+
+	w := get(s, "/api/v1/namespaces/default/checks", "reader")
+	var checks []CheckSummary
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &checks)).To(Succeed())
+	g.Expect(checks).To(HaveLen(2))
+	g.Expect(checks[0].Name).To(Equal("api"))
+	g.Expect(checks[0].DependsOn).To(Equal([]string{"db"}))
+	g.Expect(checks[0].Locations).To(HaveLen(2))
+	g.Expect(checks[0].Locations[0].Location).To(Equal("eu"))
+	g.Expect(checks[0].Locations[0].Phase).To(Equal(syntheticv1.RunSucceeded))
+	g.Expect(checks[0].Locations[0].Time).To(BeTemporally("==", start.Add(3*time.Minute)))
+	g.Expect(checks[1].Locations).To(BeEmpty())
+
+	w = get(s, "/api/v1/namespaces/default/checks/api", "reader")
+	var check CheckSummary
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &check)).To(Succeed())
+	g.Expect(check).To(Equal(checks[0]))
+}
+
+func testHistory(g *GomegaWithT, s *Server) {
+	const check = "/api/v1/namespaces/default/checks/api/"
+	const span = "from=2019-07-01T12:00:00Z&to=2019-07-01T13:00:00Z"
+
+	w := get(s, check+"runs?"+span, "reader")
+	g.Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+	var runs []Run
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &runs)).To(Succeed())
+	g.Expect(runs).To(HaveLen(4))
+	g.Expect(runs[0].Time).To(BeTemporally("==", start.Add(time.Minute)))
+	g.Expect(runs[3].Location).To(Equal("eu"))
+	g.Expect(runs[3].Status.Phase).To(Equal(syntheticv1.RunSucceeded))
+
+	w = get(s, check+"runs?limit=1&location=eu&"+span, "reader")
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &runs)).To(Succeed())
+	g.Expect(runs).To(HaveLen(1))
+	g.Expect(runs[0].Run).To(Equal("api-eu-d"))
+
+	w = get(s, check+"runs?from=2019-07-01T12:02:00Z&to=2019-07-01T12:03:00Z", "reader")
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &runs)).To(Succeed())
+	g.Expect(runs).To(HaveLen(1))
+	g.Expect(runs[0].Status.Phase).To(Equal(syntheticv1.RunFailed))
+
+	w = get(s, check+"uptime?"+span, "reader")
+	var uptime UptimeReport
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &uptime)).To(Succeed())
+	g.Expect(uptime.Overall.Runs).To(BeEquivalentTo(4))
+	g.Expect(*uptime.Overall.Percent).To(BeNumerically("==", 75))
+	g.Expect(uptime.Locations).To(HaveLen(2))
+	g.Expect(*uptime.Locations[1].Percent).To(BeNumerically("==", 100))
+
+	w = get(s, check+"uptime?from=2019-07-02T00:00:00Z&to=2019-07-03T00:00:00Z", "reader")
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &uptime)).To(Succeed())
+	g.Expect(uptime.Overall.Percent).To(BeNil())
+
+	w = get(s, check+"latency?location=eu&step=2m&"+span, "reader")
+	var latency []LatencySeries
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &latency)).To(Succeed())
+	g.Expect(latency).To(HaveLen(1))
+	g.Expect(latency[0].Points).To(Equal([]LatencyPoint{
+		{Time: start, Runs: 1, Mean: 0.1, Max: 0.1},
+		{Time: start.Add(2 * time.Minute), Runs: 2, Mean: 0.25, Max: 0.3},
+	}))
+
+	g.Expect(get(s, check+"runs?from=yesterday", "reader").Code).To(Equal(http.StatusBadRequest))
+	g.Expect(get(s, check+"runs?limit=0", "reader").Code).To(Equal(http.StatusBadRequest))
+	g.Expect(get(s, check+"latency?step=soon", "reader").Code).To(Equal(http.StatusBadRequest))
+	g.Expect(get(s, check+"uptime?from=2019-07-02T00:00:00Z&to=2019-07-01T00:00:00Z", "reader").Code).To(Equal(http.StatusBadRequest))
+}
+
+func TestHistoryFromRuns(t *testing.T) {
+	testHistory(NewGomegaWithT(t), newServer(objects()...))
+}
+
+func TestHistoryFromStore(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "query")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	b, err := store.Open(dir, store.DefaultPolicy, zap.Logger(true))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer b.Close()
+
+	// Only the check is kept in the cluster.
+	var objs []runtime.Object
+	for _, obj := range objects() {
+		if run, ok := obj.(*syntheticv1.SyntheticRun); ok {
+			g.Expect(b.Append(store.SeriesOf(run), store.RecordOf(run))).To(Succeed())
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	s := newServer(objs...)
+	s.Store = b
+	testHistory(g, s)
+}
+
+func TestHistoryFromStoreAndRuns(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "query")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	b, err := store.Open(dir, store.DefaultPolicy, zap.Logger(true))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer b.Close()
+
+	// The store holds the runs of its own location, eu, and the runs of us
+	// only exist in the cluster.
+	for _, obj := range objects() {
+		if run, ok := obj.(*syntheticv1.SyntheticRun); ok && run.Spec.Location == "eu" {
+			g.Expect(b.Append(store.SeriesOf(run), store.RecordOf(run))).To(Succeed())
+		}
+	}
+	s := newServer(objects()...)
+	s.Store = b
+	testHistory(g, s)
+}
+
+func TestStreamProgress(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	lt := &syntheticv1.LoadTest{
+		ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "default"},
+		Status:     syntheticv1.LoadTestStatus{LastRun: "checkout-1", Phase: syntheticv1.RunRunning},
+	}
+	worker := func(index int32, requests int64) *syntheticv1.SyntheticRun {
+		return &syntheticv1.SyntheticRun{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      syntheticv1.WorkerRunName("checkout-1", index, 2),
+				Namespace: "default",
+				Labels:    map[string]string{syntheticv1.LoadTestLabel: "checkout"},
+			},
+			Spec: syntheticv1.SyntheticRunSpec{
+				LoadTestRef: "checkout",
+				Location:    "eu",
+				Partition:   &syntheticv1.Partition{Index: index, Count: 2},
+			},
+			Status: syntheticv1.SyntheticRunStatus{
+				Phase:    syntheticv1.RunRunning,
+				LoadTest: &syntheticv1.LoadSummary{Requests: requests, Histogram: []int64{requests}},
+			},
+		}
+	}
+	previous := worker(0, 1000)
+	previous.Name = "checkout-0-0"
+	s := newServer(lt, worker(0, 10), worker(1, 20), previous)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/namespaces/default/loadtests/checkout/events", nil)
+	req.Header.Set("Authorization", "Bearer reader")
+	resp, err := http.DefaultClient.Do(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	g.Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+	events := bufio.NewReader(resp.Body)
+	next := func() (string, *Progress) {
+		var event string
+		var p Progress
+		for {
+			line, err := events.ReadString('\n')
+			g.Expect(err).NotTo(HaveOccurred())
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				g.Expect(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &p)).To(Succeed())
+			case line == "" && event != "":
+				return event, &p
+			}
+		}
+	}
+
+	event, p := next()
+	g.Expect(event).To(Equal("progress"))
+	g.Expect(p.Phase).To(Equal(syntheticv1.RunRunning))
+	g.Expect(p.Workers).To(HaveLen(2))
+	g.Expect(p.Workers[0].Summary.Requests).To(BeEquivalentTo(10))
+	g.Expect(p.Workers[0].Summary.Histogram).To(BeNil())
+	g.Expect(p.Summary.Requests).To(BeEquivalentTo(30))
+
+	ctx := context.Background()
+	var run syntheticv1.SyntheticRun
+	g.Expect(s.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "checkout-1-1"}, &run)).To(Succeed())
+	run.Status.LoadTest.Requests = 50
+	g.Expect(s.Client.Update(ctx, &run)).To(Succeed())
+
+	event, p = next()
+	g.Expect(event).To(Equal("progress"))
+	g.Expect(p.Summary.Requests).To(BeEquivalentTo(60))
+
+	g.Expect(s.Client.Delete(ctx, lt)).To(Succeed())
+	event, _ = next()
+	g.Expect(event).To(Equal("deleted"))
+}
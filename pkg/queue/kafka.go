@@ -0,0 +1,257 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+const (
+	kafkaV2Type     = "application/vnd.kafka.v2+json"
+	kafkaBinaryType = "application/vnd.kafka.binary.v2+json"
+
+	// kafkaPollTimeout is how long the proxy holds a poll for records open.
+	kafkaPollTimeout = time.Second
+)
+
+// kafkaClient talks to Kafka through the v2 API of a REST proxy. Messages
+// are exchanged in the binary embedded format, so they are passed through
+// unchanged.
+type kafkaClient struct {
+	base    string
+	headers http.Header
+	client  *http.Client
+}
+
+func dialKafka(ctx context.Context, ep *syntheticv1.KafkaEndpoint, values probe.Values) (*kafkaClient, error) {
+	if ep.URL == "" {
+		return nil, fmt.Errorf("kafka url must be set")
+	}
+	c := &kafkaClient{base: strings.TrimSuffix(ep.URL, "/"), headers: http.Header{}}
+	for _, h := range ep.Headers {
+		value, err := values.Get(ctx, h.Value, h.ValueFrom)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %v", h.Name, err)
+		}
+		c.headers.Add(h.Name, value)
+	}
+	tlsConfig, err := probe.TLSClientConfig(ctx, ep.TLS, values)
+	if err != nil {
+		return nil, err
+	}
+	c.client = &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		DialContext:     (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSClientConfig: tlsConfig,
+	}}
+	return c, nil
+}
+
+// kafkaError is the body of an error response of the proxy.
+type kafkaError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// do sends a request with a JSON body of type contentType, unless in is nil,
+// to the proxy and decodes the response into out, unless out is nil.
+func (c *kafkaClient) do(ctx context.Context, method, url, contentType, accept string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, probe.MaxBodyBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e kafkaError
+		if json.Unmarshal(data, &e) == nil && e.Message != "" {
+			return fmt.Errorf("%s %s: %s (error code %d)", method, url, e.Message, e.ErrorCode)
+		}
+		return fmt.Errorf("%s %s: status %d", method, url, resp.StatusCode)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *kafkaClient) Publish(ctx context.Context, topic string, msgs [][]byte) error {
+	type record struct {
+		Value []byte `json:"value"`
+	}
+	in := struct {
+		Records []record `json:"records"`
+	}{}
+	for _, msg := range msgs {
+		in.Records = append(in.Records, record{Value: msg})
+	}
+	var out struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.base+"/topics/"+url.PathEscape(topic), kafkaBinaryType, kafkaV2Type, in, &out); err != nil {
+		return err
+	}
+	for _, o := range out.Offsets {
+		if o.ErrorCode != nil || o.Error != "" {
+			return fmt.Errorf("publishing record: %s", o.Error)
+		}
+	}
+	return nil
+}
+
+func (c *kafkaClient) Subscribe(ctx context.Context, topic, group string) (Subscription, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	in := map[string]string{
+		"name":               "perph-" + hex.EncodeToString(suffix),
+		"format":             "binary",
+		"auto.offset.reset":  "latest",
+		"auto.commit.enable": "false",
+	}
+	var consumer struct {
+		InstanceID string `json:"instance_id"`
+		BaseURI    string `json:"base_uri"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.base+"/consumers/"+url.PathEscape(group), kafkaV2Type, kafkaV2Type, in, &consumer); err != nil {
+		return nil, err
+	}
+	sub := &kafkaSubscription{client: c, base: strings.TrimSuffix(consumer.BaseURI, "/")}
+	topics := map[string][]string{"topics": {topic}}
+	if err := c.do(ctx, http.MethodPost, sub.base+"/subscription", kafkaV2Type, kafkaV2Type, topics, nil); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	if err := sub.assign(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (c *kafkaClient) Close() error {
+	c.client.Transport.(*http.Transport).CloseIdleConnections()
+	return nil
+}
+
+// kafkaSubscription is a consumer instance of the REST proxy.
+type kafkaSubscription struct {
+	client *kafkaClient
+	base   string
+}
+
+// kafkaPartition is a partition of a topic, as the proxy lists them.
+type kafkaPartition struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+}
+
+// assign polls until the consumer has joined its group and been assigned
+// partitions, which the broker delays for a new group, and moves it to their
+// end so that it sees every record published afterwards.
+func (s *kafkaSubscription) assign(ctx context.Context) error {
+	var assigned struct {
+		Partitions []kafkaPartition `json:"partitions"`
+	}
+	for len(assigned.Partitions) == 0 {
+		if _, err := s.poll(ctx); err != nil {
+			return err
+		}
+		if err := s.client.do(ctx, http.MethodGet, s.base+"/assignments", "", kafkaV2Type, nil, &assigned); err != nil {
+			return err
+		}
+	}
+	if err := s.client.do(ctx, http.MethodPost, s.base+"/positions/end", kafkaV2Type, kafkaV2Type, assigned, nil); err != nil {
+		return err
+	}
+	// Seeking takes effect on the next poll, before the canary is published.
+	_, err := s.poll(ctx)
+	return err
+}
+
+func (s *kafkaSubscription) poll(ctx context.Context) ([][]byte, error) {
+	var records []struct {
+		Value []byte `json:"value"`
+	}
+	u := fmt.Sprintf("%s/records?timeout=%d", s.base, kafkaPollTimeout/time.Millisecond)
+	if err := s.client.do(ctx, http.MethodGet, u, "", kafkaBinaryType, nil, &records); err != nil {
+		return nil, err
+	}
+	out := make([][]byte, 0, len(records))
+	for _, r := range records {
+		out = append(out, r.Value)
+	}
+	return out, nil
+}
+
+func (s *kafkaSubscription) Next(ctx context.Context) ([][]byte, error) {
+	for {
+		msgs, err := s.poll(ctx)
+		if err != nil || len(msgs) > 0 {
+			return msgs, err
+		}
+	}
+}
+
+// Close deletes the consumer instance, which the proxy would otherwise keep
+// until it times out.
+func (s *kafkaSubscription) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.client.do(ctx, http.MethodDelete, s.base, "", kafkaV2Type, nil, nil)
+}
@@ -0,0 +1,379 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// natsClient speaks the text protocol of NATS core over a single
+// connection. Replies to PING are read in order, which is how publishes and
+// subscriptions are confirmed.
+type natsClient struct {
+	conn net.Conn
+
+	// mu serialises writes to w, and guards the fields below.
+	mu     sync.Mutex
+	w      *bufio.Writer
+	pongs  []chan error
+	subs   map[string]*natsSubscription
+	nextID int
+	err    error
+
+	done chan struct{}
+}
+
+// natsInfo is the part of the INFO message of the server the client uses.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+func dialNATS(ctx context.Context, ep *syntheticv1.NATSEndpoint, values probe.Values) (*natsClient, error) {
+	u, err := url.Parse(ep.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("nats url %q must have the scheme nats or tls", ep.URL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	connect := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "perph",
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 1,
+	}
+	if ep.Token != nil {
+		token, err := values.Get(ctx, "", ep.Token)
+		if err != nil {
+			return nil, fmt.Errorf("token: %v", err)
+		}
+		connect["auth_token"] = token
+	}
+	if ep.Username != "" {
+		connect["user"] = ep.Username
+		if ep.Password != nil {
+			password, err := values.Get(ctx, "", ep.Password)
+			if err != nil {
+				return nil, fmt.Errorf("password: %v", err)
+			}
+			connect["pass"] = password
+		}
+	}
+	tlsConfig, err := probe.TLSClientConfig(ctx, ep.TLS, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+
+	line, err := readLine(r)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", line)
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("parsing INFO: %v", err)
+	}
+	if u.Scheme == "tls" || info.TLSRequired {
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+		r = bufio.NewReader(conn)
+	}
+
+	// The server answers the PING after the CONNECT, or reports an error
+	// such as an authorization violation.
+	data, err := json.Marshal(connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", data); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, natsError(line)
+		}
+		if line == "PONG" {
+			break
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &natsClient{
+		conn: conn,
+		w:    bufio.NewWriter(conn),
+		subs: map[string]*natsSubscription{},
+		done: make(chan struct{}),
+	}
+	go c.read(r)
+	return c, nil
+}
+
+// readLine reads a line of the protocol without its CRLF.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func natsError(line string) error {
+	return fmt.Errorf("nats: %s", strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+}
+
+// read dispatches the messages of the server until the connection fails.
+func (c *natsClient) read(r *bufio.Reader) {
+	defer close(c.done)
+	var err error
+	for err == nil {
+		var line string
+		if line, err = readLine(r); err != nil {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			err = c.deliver(r, strings.Fields(line[len("MSG "):]))
+		case line == "PING":
+			c.mu.Lock()
+			c.w.WriteString("PONG\r\n")
+			err = c.w.Flush()
+			c.mu.Unlock()
+		case line == "PONG":
+			c.mu.Lock()
+			if len(c.pongs) > 0 {
+				c.pongs[0] <- nil
+				c.pongs = c.pongs[1:]
+			}
+			c.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			err = natsError(line)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for _, p := range c.pongs {
+		p <- c.err
+	}
+	c.pongs = nil
+	for _, s := range c.subs {
+		s.fail(c.err)
+	}
+}
+
+// deliver reads the payload of the message described by args, which are the
+// subject, the subscription ID, an optional reply subject and the size, and
+// hands it to its subscription.
+func (c *natsClient) deliver(r *bufio.Reader, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("malformed MSG %v", args)
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil {
+		return fmt.Errorf("malformed MSG %v", args)
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	s := c.subs[args[1]]
+	c.mu.Unlock()
+	if s != nil {
+		s.add(payload[:size])
+	}
+	return nil
+}
+
+// flush sends the buffered commands followed by a PING, and waits for the
+// PONG, by which time the server has processed the commands.
+func (c *natsClient) flush(ctx context.Context) error {
+	pong := make(chan error, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.w.WriteString("PING\r\n")
+	if err := c.w.Flush(); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.pongs = append(c.pongs, pong)
+	c.mu.Unlock()
+
+	select {
+	case err := <-pong:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *natsClient) Publish(ctx context.Context, topic string, msgs [][]byte) error {
+	c.mu.Lock()
+	for _, msg := range msgs {
+		fmt.Fprintf(c.w, "PUB %s %d\r\n", topic, len(msg))
+		c.w.Write(msg)
+		c.w.WriteString("\r\n")
+	}
+	c.mu.Unlock()
+	return c.flush(ctx)
+}
+
+// Subscribe subscribes to subject. NATS has no consumer groups, so group is
+// ignored.
+func (c *natsClient) Subscribe(ctx context.Context, subject, group string) (Subscription, error) {
+	c.mu.Lock()
+	c.nextID++
+	s := &natsSubscription{client: c, id: strconv.Itoa(c.nextID), notify: make(chan struct{}, 1)}
+	c.subs[s.id] = s
+	fmt.Fprintf(c.w, "SUB %s %s\r\n", subject, s.id)
+	c.mu.Unlock()
+	if err := c.flush(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *natsClient) Close() error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = errors.New("nats: connection closed")
+	}
+	c.mu.Unlock()
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// natsSubscription queues the messages of a subscription until they are
+// asked for.
+type natsSubscription struct {
+	client *natsClient
+	id     string
+
+	mu     sync.Mutex
+	msgs   [][]byte
+	err    error
+	notify chan struct{}
+}
+
+func (s *natsSubscription) add(msg []byte) {
+	s.mu.Lock()
+	s.msgs = append(s.msgs, msg)
+	s.mu.Unlock()
+	s.wake()
+}
+
+func (s *natsSubscription) fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	s.wake()
+}
+
+func (s *natsSubscription) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *natsSubscription) Next(ctx context.Context) ([][]byte, error) {
+	for {
+		s.mu.Lock()
+		msgs, err := s.msgs, s.err
+		s.msgs = nil
+		s.mu.Unlock()
+		if len(msgs) > 0 || err != nil {
+			return msgs, err
+		}
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *natsSubscription) Close() error {
+	c := s.client
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, s.id)
+	if c.err != nil {
+		return nil
+	}
+	fmt.Fprintf(c.w, "UNSUB %s\r\n", s.id)
+	return c.w.Flush()
+}
@@ -0,0 +1,138 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package queue publishes to and consumes from the message queues probed by
+// checks and load tests.
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// DefaultPayload is the canary message of a QueueProbe that does not set
+// one.
+const DefaultPayload = `{"canary":"$(` + syntheticv1.CanaryVariable + `)"}`
+
+// Client is a connection to a message queue. It is safe for concurrent use.
+type Client interface {
+	// Publish publishes msgs to topic and returns once the broker has
+	// accepted them.
+	Publish(ctx context.Context, topic string, msgs [][]byte) error
+
+	// Subscribe starts receiving the messages published to topic from now
+	// on. Kafka consumers join group.
+	Subscribe(ctx context.Context, topic, group string) (Subscription, error)
+
+	Close() error
+}
+
+// Subscription receives the messages of a topic.
+type Subscription interface {
+	// Next returns the messages received since the previous call, waiting
+	// until there is at least one.
+	Next(ctx context.Context) ([][]byte, error)
+
+	Close() error
+}
+
+// Dial connects to the message queue that exactly one of kafka and nats
+// describes.
+func Dial(ctx context.Context, kafka *syntheticv1.KafkaEndpoint, nats *syntheticv1.NATSEndpoint, values probe.Values) (Client, error) {
+	switch {
+	case kafka != nil && nats != nil:
+		return nil, errors.New("only one of kafka and nats may be set")
+	case kafka != nil:
+		return dialKafka(ctx, kafka, values)
+	case nats != nil:
+		return dialNATS(ctx, nats, values)
+	}
+	return nil, errors.New("one of kafka and nats must be set")
+}
+
+// Canary publishes the canary message of spec, identified by id, and waits
+// until it is seen on the output topic, which it reads in group unless spec
+// names a consumer group.
+func Canary(ctx context.Context, spec *syntheticv1.QueueProbe, id, group string, values probe.Values) (*syntheticv1.QueueResult, error) {
+	timeout := probe.DefaultTimeout
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		timeout = spec.Timeout.Duration
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	payload := spec.Payload
+	if payload == "" {
+		payload = DefaultPayload
+	}
+	if !strings.Contains(payload, "$("+syntheticv1.CanaryVariable+")") {
+		return nil, fmt.Errorf("payload must contain $(%s)", syntheticv1.CanaryVariable)
+	}
+	payload = probe.Expand(payload, map[string]string{syntheticv1.CanaryVariable: id})
+
+	c, err := Dial(ctx, spec.Kafka, spec.NATS, values)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	output := spec.OutputTopic
+	if output == "" {
+		output = spec.Topic
+	}
+	if spec.Kafka != nil && spec.Kafka.ConsumerGroup != "" {
+		group = spec.Kafka.ConsumerGroup
+	}
+	// Subscribing first guarantees that the canary is not missed.
+	sub, err := c.Subscribe(ctx, output, group)
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to %q: %v", output, err)
+	}
+	defer sub.Close()
+
+	start := time.Now()
+	if err := c.Publish(ctx, spec.Topic, [][]byte{[]byte(payload)}); err != nil {
+		return nil, fmt.Errorf("publishing to %q: %v", spec.Topic, err)
+	}
+	result := &syntheticv1.QueueResult{
+		Canary:  id,
+		Publish: metav1.Duration{Duration: time.Since(start)},
+	}
+
+	for {
+		msgs, err := sub.Next(ctx)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("canary %s not seen on %q within %v", id, output, timeout)
+			}
+			return nil, fmt.Errorf("receiving from %q: %v", output, err)
+		}
+		for _, msg := range msgs {
+			if bytes.Contains(msg, []byte(id)) {
+				result.EndToEnd = metav1.Duration{Duration: time.Since(start)}
+				return result, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,316 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/probe"
+)
+
+// restProxy is a stand-in for a Kafka REST proxy with a single partition per
+// topic. Consumers are assigned the partition on their second poll, as the
+// broker delays the first rebalance of a group, and start at its end then.
+type restProxy struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	topics    map[string][][]byte
+	consumers map[string]*restConsumer
+	deleted   int
+}
+
+type restConsumer struct {
+	topic    string
+	offset   int
+	polls    int
+	assigned bool
+}
+
+func newRESTProxy(pipe func(topic string, value []byte) (string, []byte)) *restProxy {
+	p := &restProxy{topics: map[string][][]byte{}, consumers: map[string]*restConsumer{}}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case r.Method == http.MethodPost && parts[0] == "topics":
+			var in struct {
+				Records []struct {
+					Value []byte `json:"value"`
+				} `json:"records"`
+			}
+			json.NewDecoder(r.Body).Decode(&in)
+			for _, rec := range in.Records {
+				p.topics[parts[1]] = append(p.topics[parts[1]], rec.Value)
+				if pipe != nil {
+					topic, value := pipe(parts[1], rec.Value)
+					p.topics[topic] = append(p.topics[topic], value)
+				}
+			}
+			fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":0}]}`)
+		case r.Method == http.MethodPost && len(parts) == 2:
+			var in map[string]string
+			json.NewDecoder(r.Body).Decode(&in)
+			base := p.URL + "/consumers/" + parts[1] + "/instances/" + in["name"]
+			p.consumers[base] = &restConsumer{}
+			fmt.Fprintf(w, `{"instance_id":%q,"base_uri":%q}`, in["name"], base)
+		case r.Method == http.MethodPost && parts[len(parts)-1] == "subscription":
+			var in struct {
+				Topics []string `json:"topics"`
+			}
+			json.NewDecoder(r.Body).Decode(&in)
+			c := p.consumers[p.URL+"/"+strings.Join(parts[:len(parts)-1], "/")]
+			c.topic = in.Topics[0]
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && parts[len(parts)-1] == "assignments":
+			c := p.consumers[p.URL+"/"+strings.Join(parts[:len(parts)-1], "/")]
+			if !c.assigned {
+				fmt.Fprint(w, `{"partitions":[]}`)
+				return
+			}
+			fmt.Fprintf(w, `{"partitions":[{"topic":%q,"partition":0}]}`, c.topic)
+		case r.Method == http.MethodPost && parts[len(parts)-1] == "end":
+			c := p.consumers[p.URL+"/"+strings.Join(parts[:len(parts)-2], "/")]
+			c.offset = len(p.topics[c.topic])
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && parts[len(parts)-1] == "records":
+			c := p.consumers[p.URL+"/"+strings.Join(parts[:len(parts)-1], "/")]
+			type record struct {
+				Value []byte `json:"value"`
+			}
+			out := []record{}
+			if c.polls++; c.polls == 2 {
+				c.assigned, c.offset = true, len(p.topics[c.topic])
+			}
+			if !c.assigned {
+				json.NewEncoder(w).Encode(out)
+				return
+			}
+			for _, v := range p.topics[c.topic][c.offset:] {
+				out = append(out, record{Value: v})
+			}
+			c.offset = len(p.topics[c.topic])
+			json.NewEncoder(w).Encode(out)
+		case r.Method == http.MethodDelete:
+			delete(p.consumers, p.URL+r.URL.Path)
+			p.deleted++
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code":40401,"message":"not found"}`)
+		}
+	}))
+	return p
+}
+
+func TestKafkaCanary(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// The pipeline copies orders to the enriched topic.
+	p := newRESTProxy(func(topic string, value []byte) (string, []byte) {
+		return "enriched", append([]byte("enriched "), value...)
+	})
+	defer p.Close()
+
+	result, err := Canary(context.Background(), &syntheticv1.QueueProbe{
+		Kafka:       &syntheticv1.KafkaEndpoint{URL: p.URL},
+		Topic:       "orders",
+		OutputTopic: "enriched",
+	}, "run-1", "perph-canary-orders", probe.Inline)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Canary).To(Equal("run-1"))
+	g.Expect(result.EndToEnd.Duration).To(BeNumerically(">=", result.Publish.Duration))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g.Expect(p.topics["orders"]).To(Equal([][]byte{[]byte(`{"canary":"run-1"}`)}))
+	g.Expect(p.consumers).To(BeEmpty())
+	g.Expect(p.deleted).To(Equal(1))
+}
+
+func TestKafkaCanaryTimeout(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := newRESTProxy(nil)
+	defer p.Close()
+
+	_, err := Canary(context.Background(), &syntheticv1.QueueProbe{
+		Kafka:       &syntheticv1.KafkaEndpoint{URL: p.URL},
+		Topic:       "orders",
+		OutputTopic: "enriched",
+		Timeout:     &metav1.Duration{Duration: 100 * time.Millisecond},
+	}, "run-1", "perph-canary-orders", probe.Inline)
+	g.Expect(err).To(MatchError(`canary run-1 not seen on "enriched" within 100ms`))
+}
+
+func TestCanaryRequiresVariable(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := Canary(context.Background(), &syntheticv1.QueueProbe{
+		Kafka:   &syntheticv1.KafkaEndpoint{URL: "http://localhost"},
+		Topic:   "orders",
+		Payload: "hello",
+	}, "run-1", "perph-canary-orders", probe.Inline)
+	g.Expect(err).To(MatchError("payload must contain $(CANARY)"))
+}
+
+// natsServer is a stand-in for a NATS server that delivers every message
+// published to a subject to its subscribers on the same connection, and
+// requires token unless it is empty.
+type natsServer struct {
+	net.Listener
+	token string
+}
+
+func newNATSServer(t *testing.T, token string) *natsServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsServer{Listener: l, token: token}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsServer) URL() string {
+	return "nats://" + s.Addr().String()
+}
+
+func (s *natsServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"auth_required\":true}\r\n")
+	subs := map[string][]string{}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		switch args[0] {
+		case "CONNECT":
+			var opts map[string]interface{}
+			json.Unmarshal([]byte(line[len("CONNECT "):]), &opts)
+			if s.token != "" && opts["auth_token"] != s.token {
+				fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "SUB":
+			subs[args[1]] = append(subs[args[1]], args[2])
+		case "PUB":
+			size, _ := strconv.Atoi(args[2])
+			payload := make([]byte, size+2)
+			io.ReadFull(r, payload)
+			for _, id := range subs[args[1]] {
+				fmt.Fprintf(conn, "MSG %s %s %d\r\n%s", args[1], id, size, payload)
+			}
+		}
+	}
+}
+
+// secretValues resolves Secret references from a map keyed by secret name.
+type secretValues map[string]string
+
+func (m secretValues) Get(_ context.Context, value string, from *syntheticv1.ValueSource) (string, error) {
+	if from == nil {
+		return value, nil
+	}
+	return m[from.SecretKeyRef.Name], nil
+}
+
+func TestNATSCanary(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := newNATSServer(t, "s3cret")
+	defer s.Close()
+
+	result, err := Canary(context.Background(), &syntheticv1.QueueProbe{
+		NATS: &syntheticv1.NATSEndpoint{
+			URL: s.URL(),
+			Token: &syntheticv1.ValueSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "nats"},
+				Key:                  "token",
+			}},
+		},
+		Topic:   "orders.created",
+		Payload: "order $(CANARY)",
+	}, "run-1", "perph-canary-orders", secretValues{"nats": "s3cret"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.EndToEnd.Duration).To(BeNumerically(">", 0))
+}
+
+func TestNATSAuthorizationViolation(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := newNATSServer(t, "s3cret")
+	defer s.Close()
+
+	_, err := Dial(context.Background(), nil, &syntheticv1.NATSEndpoint{URL: s.URL()}, probe.Inline)
+	g.Expect(err).To(MatchError("nats: Authorization Violation"))
+}
+
+func TestNATSPublishBatch(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := newNATSServer(t, "")
+	defer s.Close()
+
+	ctx := context.Background()
+	c, err := Dial(ctx, nil, &syntheticv1.NATSEndpoint{URL: s.URL()}, probe.Inline)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer c.Close()
+
+	sub, err := c.Subscribe(ctx, "events", "")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer sub.Close()
+
+	g.Expect(c.Publish(ctx, "events", [][]byte{[]byte("a"), []byte("b\r\nc")})).To(Succeed())
+	var got [][]byte
+	for len(got) < 2 {
+		msgs, err := sub.Next(ctx)
+		g.Expect(err).NotTo(HaveOccurred())
+		got = append(got, msgs...)
+	}
+	g.Expect(got).To(Equal([][]byte{[]byte("a"), []byte("b\r\nc")}))
+}
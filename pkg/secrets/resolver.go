@@ -0,0 +1,158 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secrets resolves the Secret and ConfigMap references of a spec.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// Redacted replaces Secret values in anything a run reports.
+const Redacted = "[REDACTED]"
+
+// Resolver reads the values referenced by ValueSources in one namespace.
+//
+// Reads go through the given reader, which for the manager's client is the
+// shared informer cache, so runs do not call the API server for every
+// reference. Each object is read at most once per Resolver, so a run sees a
+// consistent value even if the object changes while it executes. Every
+// Secret value handed out is remembered so that it can be redacted.
+type Resolver struct {
+	reader    client.Reader
+	namespace string
+
+	mu         sync.Mutex
+	secrets    map[string]*corev1.Secret
+	configMaps map[string]*corev1.ConfigMap
+	sensitive  []string
+}
+
+// NewResolver returns a Resolver for references in namespace.
+func NewResolver(reader client.Reader, namespace string) *Resolver {
+	return &Resolver{
+		reader:     reader,
+		namespace:  namespace,
+		secrets:    map[string]*corev1.Secret{},
+		configMaps: map[string]*corev1.ConfigMap{},
+	}
+}
+
+// Get returns value when from is nil, and the referenced value otherwise.
+func (r *Resolver) Get(ctx context.Context, value string, from *syntheticv1.ValueSource) (string, error) {
+	if from == nil {
+		return value, nil
+	}
+	switch {
+	case from.SecretKeyRef != nil:
+		return r.secretKey(ctx, from.SecretKeyRef)
+	case from.ConfigMapKeyRef != nil:
+		return r.configMapKey(ctx, from.ConfigMapKeyRef)
+	}
+	return "", fmt.Errorf("valueFrom must set secretKeyRef or configMapKeyRef")
+}
+
+func (r *Resolver) secretKey(ctx context.Context, sel *corev1.SecretKeySelector) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	secret, ok := r.secrets[sel.Name]
+	if !ok {
+		secret = &corev1.Secret{}
+		err := r.reader.Get(ctx, types.NamespacedName{Namespace: r.namespace, Name: sel.Name}, secret)
+		if err != nil {
+			if apierrors.IsNotFound(err) && optional(sel.Optional) {
+				return "", nil
+			}
+			return "", fmt.Errorf("reading secret %q: %v", sel.Name, err)
+		}
+		r.secrets[sel.Name] = secret
+	}
+
+	data, ok := secret.Data[sel.Key]
+	if !ok {
+		if optional(sel.Optional) {
+			return "", nil
+		}
+		return "", fmt.Errorf("secret %q has no key %q", sel.Name, sel.Key)
+	}
+	value := string(data)
+	if value != "" {
+		r.sensitive = append(r.sensitive, value)
+	}
+	return value, nil
+}
+
+func (r *Resolver) configMapKey(ctx context.Context, sel *corev1.ConfigMapKeySelector) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cm, ok := r.configMaps[sel.Name]
+	if !ok {
+		cm = &corev1.ConfigMap{}
+		err := r.reader.Get(ctx, types.NamespacedName{Namespace: r.namespace, Name: sel.Name}, cm)
+		if err != nil {
+			if apierrors.IsNotFound(err) && optional(sel.Optional) {
+				return "", nil
+			}
+			return "", fmt.Errorf("reading configmap %q: %v", sel.Name, err)
+		}
+		r.configMaps[sel.Name] = cm
+	}
+
+	if value, ok := cm.Data[sel.Key]; ok {
+		return value, nil
+	}
+	if data, ok := cm.BinaryData[sel.Key]; ok {
+		return string(data), nil
+	}
+	if optional(sel.Optional) {
+		return "", nil
+	}
+	return "", fmt.Errorf("configmap %q has no key %q", sel.Name, sel.Key)
+}
+
+// Redact replaces every Secret value handed out by the Resolver in s.
+func (r *Resolver) Redact(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.sensitive) == 0 {
+		return s
+	}
+
+	// Replace longer values first so that a value containing another one
+	// is not left partially visible.
+	values := append([]string(nil), r.sensitive...)
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		s = strings.Replace(s, v, Redacted, -1)
+	}
+	return s
+}
+
+func optional(b *bool) bool {
+	return b != nil && *b
+}
@@ -0,0 +1,82 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestResolver(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	c := fake.NewFakeClient(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+			Data:       map[string][]byte{"token": []byte("s3cr3t-token")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default"},
+			Data:       map[string]string{"region": "eu-west-1"},
+		},
+	)
+	r := NewResolver(c, "default")
+	ctx := context.Background()
+
+	v, err := r.Get(ctx, "inline", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(v).To(Equal("inline"))
+
+	v, err = r.Get(ctx, "", &syntheticv1.ValueSource{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "api"},
+		Key:                  "token",
+	}})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(v).To(Equal("s3cr3t-token"))
+
+	v, err = r.Get(ctx, "", &syntheticv1.ValueSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "settings"},
+		Key:                  "region",
+	}})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(v).To(Equal("eu-west-1"))
+
+	_, err = r.Get(ctx, "", &syntheticv1.ValueSource{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "missing"},
+		Key:                  "token",
+	}})
+	g.Expect(err).To(HaveOccurred())
+
+	optional := true
+	v, err = r.Get(ctx, "", &syntheticv1.ValueSource{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "api"},
+		Key:                  "other",
+		Optional:             &optional,
+	}})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(v).To(BeEmpty())
+
+	// Only Secret values are redacted.
+	g.Expect(r.Redact(`Get https://api/?token=s3cr3t-token&region=eu-west-1`)).
+		To(Equal(`Get https://api/?token=[REDACTED]&region=eu-west-1`))
+}
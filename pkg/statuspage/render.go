@@ -0,0 +1,126 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statuspage
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+var stateLabels = map[syntheticv1.ComponentState]string{
+	syntheticv1.ComponentOperational: "Operational",
+	syntheticv1.ComponentMaintenance: "Under maintenance",
+	syntheticv1.ComponentDegraded:    "Degraded performance",
+	syntheticv1.ComponentOutage:      "Major outage",
+}
+
+var banners = map[syntheticv1.ComponentState]string{
+	syntheticv1.ComponentOperational: "All systems operational",
+	syntheticv1.ComponentMaintenance: "Maintenance in progress",
+	syntheticv1.ComponentDegraded:    "Some systems are degraded",
+	syntheticv1.ComponentOutage:      "Major outage",
+}
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"label":  func(s syntheticv1.ComponentState) string { return stateLabels[s] },
+	"banner": func(s syntheticv1.ComponentState) string { return banners[s] },
+	"uptime": uptime,
+	"bar":    bar,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 860px; margin: 2em auto; padding: 0 1em; color: #222; }
+.banner { padding: 1em; border-radius: 4px; color: #fff; font-weight: bold; }
+.component { border: 1px solid #ddd; border-radius: 4px; padding: 1em; margin: 1em 0; }
+.component h2 { font-size: 1.1em; margin: 0; display: flex; justify-content: space-between; }
+.description { color: #666; margin: .3em 0; }
+.bars { display: flex; height: 2em; margin: .5em 0 .2em; }
+.bars span { flex: 1; margin-right: 1px; border-radius: 1px; }
+.legend { display: flex; justify-content: space-between; color: #888; font-size: .8em; }
+.Operational { background: #2fcc66; }
+.UnderMaintenance { background: #3498db; }
+.Degraded { background: #f1c40f; }
+.Outage { background: #e74c3c; }
+.nodata { background: #ccc; }
+.state-Operational { color: #2fcc66; }
+.state-UnderMaintenance { color: #3498db; }
+.state-Degraded { color: #d4a90c; }
+.state-Outage { color: #e74c3c; }
+.incident { border-left: 3px solid #ddd; padding-left: 1em; margin: 1em 0; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="banner {{.State}}">{{banner .State}}</div>
+{{range .Components}}
+<div class="component">
+<h2><span>{{.Name}}</span><span class="state-{{.State}}">{{label .State}}</span></h2>
+{{if .Description}}<p class="description">{{.Description}}</p>{{end}}
+<div class="bars">{{range .Days}}<span class="{{bar .Uptime}}" title="{{.Date}}: {{uptime .Uptime}}"></span>{{end}}</div>
+<div class="legend"><span>{{len .Days}} days ago</span><span>{{uptime .Uptime}} uptime</span><span>Today</span></div>
+</div>
+{{end}}
+<h2>Incidents</h2>
+{{range .Incidents}}
+<div class="incident">
+<strong>{{.Component}}</strong>: <span class="state-{{.State}}">{{label .State}}</span><br>
+Started {{.Start.UTC.Format "2006-01-02 15:04 MST"}}{{if .End}}, resolved {{.End.UTC.Format "2006-01-02 15:04 MST"}}{{else}}, ongoing{{end}}
+</div>
+{{else}}
+<p>No incidents reported.</p>
+{{end}}
+<p class="legend">Updated {{.Updated.UTC.Format "2006-01-02 15:04:05 MST"}}</p>
+</body>
+</html>
+`))
+
+// uptime formats an uptime percentage.
+func uptime(p *float64) string {
+	if p == nil {
+		return "no data"
+	}
+	return fmt.Sprintf("%.2f%%", *p)
+}
+
+// bar returns the class of the bar of a day with uptime p.
+func bar(p *float64) string {
+	switch {
+	case p == nil:
+		return "nodata"
+	case *p >= 99.9:
+		return string(syntheticv1.ComponentOperational)
+	case *p >= 99:
+		return string(syntheticv1.ComponentDegraded)
+	default:
+		return string(syntheticv1.ComponentOutage)
+	}
+}
+
+// HTML renders p as a standalone HTML document.
+func HTML(p *Page) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,151 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statuspage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/query"
+)
+
+// Server serves status pages without authentication, as HTML at
+// /<namespace>/<name> and as JSON at /<namespace>/<name>/status.json. A
+// page is built at most once per its interval.
+type Server struct {
+	Client  client.Client
+	History *query.History
+	Log     logr.Logger
+
+	// Addr is the address the server listens on.
+	Addr string
+
+	mu    sync.Mutex
+	cache map[types.NamespacedName]*cached
+}
+
+type cached struct {
+	page    *Page
+	expires time.Time
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "status.json") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	p, err := s.page(r.Context(), key)
+	if apierrors.IsNotFound(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.Log.Error(err, "unable to build status page", "page", key)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(parts) == 3 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+		return
+	}
+	body, err := HTML(p)
+	if err != nil {
+		s.Log.Error(err, "unable to render status page", "page", key)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(body)
+}
+
+// page returns the content of the StatusPage key, building it when the
+// cached one has expired.
+func (s *Server) page(ctx context.Context, key types.NamespacedName) (*Page, error) {
+	now := time.Now()
+	s.mu.Lock()
+	c := s.cache[key]
+	s.mu.Unlock()
+	if c != nil && now.Before(c.expires) {
+		return c.page, nil
+	}
+
+	var sp syntheticv1.StatusPage
+	if err := s.Client.Get(ctx, key, &sp); err != nil {
+		return nil, err
+	}
+	var checks syntheticv1.CheckList
+	if err := s.Client.List(ctx, &checks, client.InNamespace(key.Namespace)); err != nil {
+		return nil, err
+	}
+	p, err := Build(ctx, s.History, &sp, checks.Items, now)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cache == nil {
+		s.cache = map[types.NamespacedName]*cached{}
+	}
+	for k, c := range s.cache {
+		if !now.Before(c.expires) {
+			delete(s.cache, k)
+		}
+	}
+	s.cache[key] = &cached{page: p, expires: now.Add(sp.IntervalOrDefault())}
+	return p, nil
+}
+
+// Start serves status pages on Addr until stop is closed.
+func (s *Server) Start(stop <-chan struct{}) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s}
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	s.Log.Info("serving status pages", "addr", s.Addr)
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statuspage works out the state of the components of a StatusPage
+// from their Checks, tracks incidents, and renders the public page.
+package statuspage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/query"
+)
+
+const day = 24 * time.Hour
+
+// Page is the public content of a status page. It leaves out the checks
+// behind each component.
+type Page struct {
+	Title      string                     `json:"title"`
+	Updated    time.Time                  `json:"updated"`
+	State      syntheticv1.ComponentState `json:"state"`
+	Components []Component                `json:"components"`
+	Incidents  []Incident                 `json:"incidents"`
+}
+
+// Component is a component of a Page.
+type Component struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description,omitempty"`
+	State       syntheticv1.ComponentState `json:"state"`
+	// Uptime is the percentage of runs that succeeded over all days, or nil
+	// without runs.
+	Uptime *float64 `json:"uptime"`
+	Days   []Day    `json:"days"`
+}
+
+// Day is the uptime of a component over a UTC day.
+type Day struct {
+	Date   string   `json:"date"`
+	Uptime *float64 `json:"uptime"`
+}
+
+// Incident is an incident of a Page.
+type Incident struct {
+	Component string                     `json:"component"`
+	State     syntheticv1.ComponentState `json:"state"`
+	Start     time.Time                  `json:"start"`
+	End       *time.Time                 `json:"end,omitempty"`
+}
+
+// severity orders component states from the best to the worst.
+var severity = map[syntheticv1.ComponentState]int{
+	syntheticv1.ComponentOperational: 0,
+	syntheticv1.ComponentMaintenance: 1,
+	syntheticv1.ComponentDegraded:    2,
+	syntheticv1.ComponentOutage:      3,
+}
+
+// ComponentChecks returns the checks among checks that component is made
+// of, sorted by name.
+func ComponentChecks(component *syntheticv1.StatusComponent, checks []syntheticv1.Check) ([]*syntheticv1.Check, error) {
+	selector := labels.Nothing()
+	if component.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(component.Selector); err != nil {
+			return nil, err
+		}
+	}
+	named := map[string]bool{}
+	for _, name := range component.Checks {
+		named[name] = true
+	}
+	var out []*syntheticv1.Check
+	for i := range checks {
+		check := &checks[i]
+		if named[check.Name] || selector.Matches(labels.Set(check.Labels)) {
+			out = append(out, check)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Evaluate returns the state of each component of page from its checks
+// among checks and their runs among runs. A check fails when its latest
+// finished run from any location failed outside of a maintenance window.
+func Evaluate(page *syntheticv1.StatusPage, checks []syntheticv1.Check, runs []syntheticv1.SyntheticRun) ([]syntheticv1.ComponentStatus, error) {
+	latest := map[string]map[string]*syntheticv1.SyntheticRun{}
+	for i := range runs {
+		run := &runs[i]
+		if run.Spec.CheckRef == "" || !run.Finished() || run.Status.CompletionTime == nil {
+			continue
+		}
+		byLocation := latest[run.Spec.CheckRef]
+		if byLocation == nil {
+			byLocation = map[string]*syntheticv1.SyntheticRun{}
+			latest[run.Spec.CheckRef] = byLocation
+		}
+		if l := byLocation[run.Spec.Location]; l == nil || l.Status.CompletionTime.Before(run.Status.CompletionTime) {
+			byLocation[run.Spec.Location] = run
+		}
+	}
+
+	var out []syntheticv1.ComponentStatus
+	for i := range page.Spec.Components {
+		component := &page.Spec.Components[i]
+		members, err := ComponentChecks(component, checks)
+		if err != nil {
+			return nil, err
+		}
+		status := syntheticv1.ComponentStatus{Name: component.Name, State: syntheticv1.ComponentOperational}
+		maintenance := false
+		for _, check := range members {
+			failing := false
+			for _, run := range latest[check.Name] {
+				switch {
+				case run.Status.SilencedBy != "":
+					maintenance = true
+				case run.Failed():
+					failing = true
+				}
+			}
+			if check.Status.Maintenance != nil {
+				maintenance = true
+			}
+			if failing {
+				status.Failing = append(status.Failing, check.Name)
+			}
+		}
+		switch {
+		case len(status.Failing) > 0 && len(status.Failing) == len(members):
+			status.State = syntheticv1.ComponentOutage
+		case len(status.Failing) > 0:
+			status.State = syntheticv1.ComponentDegraded
+		case maintenance:
+			status.State = syntheticv1.ComponentMaintenance
+		}
+		out = append(out, status)
+	}
+	return out, nil
+}
+
+// UpdateIncidents opens an incident for each failing component of status
+// without an open one, and resolves the open incidents of the others at now.
+// Incidents resolved more than days ago are dropped.
+func UpdateIncidents(status *syntheticv1.StatusPageStatus, now time.Time, days int) {
+	failing := map[string]*syntheticv1.ComponentStatus{}
+	for i := range status.Components {
+		c := &status.Components[i]
+		if c.State == syntheticv1.ComponentDegraded || c.State == syntheticv1.ComponentOutage {
+			failing[c.Name] = c
+		}
+	}
+
+	at := metav1.NewTime(now)
+	var incidents []syntheticv1.Incident
+	for _, incident := range status.Incidents {
+		if incident.End == nil {
+			c, ok := failing[incident.Component]
+			if !ok {
+				incident.End = &at
+			} else {
+				if severity[c.State] > severity[incident.State] {
+					incident.State = c.State
+				}
+				incident.Checks = union(incident.Checks, c.Failing)
+				delete(failing, incident.Component)
+			}
+		}
+		if incident.End != nil && incident.End.Time.Before(now.Add(-time.Duration(days)*day)) {
+			continue
+		}
+		incidents = append(incidents, incident)
+	}
+	for _, c := range failing {
+		incidents = append(incidents, syntheticv1.Incident{
+			Component: c.Name,
+			State:     c.State,
+			Checks:    c.Failing,
+			Start:     at,
+		})
+	}
+	sort.SliceStable(incidents, func(i, j int) bool {
+		if !incidents[i].Start.Equal(&incidents[j].Start) {
+			return incidents[j].Start.Before(&incidents[i].Start)
+		}
+		return incidents[i].Component < incidents[j].Component
+	})
+	status.Incidents = incidents
+}
+
+func union(a, b []string) []string {
+	set := map[string]bool{}
+	for _, s := range a {
+		set[s] = true
+	}
+	out := a
+	for _, s := range b {
+		if !set[s] {
+			out = append(out, s)
+			set[s] = true
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Build returns the public content of page at now, with the uptime of each
+// component over its checks among checks read from history across every
+// location, and the state and incidents recorded in the status of page.
+func Build(ctx context.Context, history *query.History, page *syntheticv1.StatusPage, checks []syntheticv1.Check, now time.Time) (*Page, error) {
+	days := page.DaysOrDefault()
+	first := now.UTC().Truncate(day).Add(-time.Duration(days-1) * day)
+	out := &Page{
+		Title:      page.Spec.Title,
+		Updated:    now,
+		State:      syntheticv1.ComponentOperational,
+		Components: []Component{},
+		Incidents:  []Incident{},
+	}
+	states := map[string]syntheticv1.ComponentState{}
+	for _, c := range page.Status.Components {
+		states[c.Name] = c.State
+	}
+
+	for i := range page.Spec.Components {
+		spec := &page.Spec.Components[i]
+		members, err := ComponentChecks(spec, checks)
+		if err != nil {
+			return nil, err
+		}
+		runs := make([]int64, days)
+		failures := make([]int64, days)
+		for _, check := range members {
+			points, err := history.Points(ctx, check.Namespace, check.Name, "", first, now)
+			if err != nil {
+				return nil, err
+			}
+			for _, list := range points {
+				for _, p := range list {
+					d := int(p.Time.Sub(first) / day)
+					if d < 0 || d >= days {
+						continue
+					}
+					runs[d] += p.Runs
+					failures[d] += p.Failures
+				}
+			}
+		}
+
+		c := Component{Name: spec.Name, Description: spec.Description, State: states[spec.Name]}
+		if c.State == "" {
+			c.State = syntheticv1.ComponentOperational
+		}
+		var totalRuns, totalFailures int64
+		for d := 0; d < days; d++ {
+			c.Days = append(c.Days, Day{
+				Date:   first.Add(time.Duration(d) * day).Format("2006-01-02"),
+				Uptime: percent(runs[d], failures[d]),
+			})
+			totalRuns += runs[d]
+			totalFailures += failures[d]
+		}
+		c.Uptime = percent(totalRuns, totalFailures)
+		if severity[c.State] > severity[out.State] {
+			out.State = c.State
+		}
+		out.Components = append(out.Components, c)
+	}
+
+	for _, incident := range page.Status.Incidents {
+		i := Incident{Component: incident.Component, State: incident.State, Start: incident.Start.Time}
+		if incident.End != nil {
+			end := incident.End.Time
+			i.End = &end
+		}
+		out.Incidents = append(out.Incidents, i)
+	}
+	return out, nil
+}
+
+func percent(runs, failures int64) *float64 {
+	if runs == 0 {
+		return nil
+	}
+	p := 100 * float64(runs-failures) / float64(runs)
+	return &p
+}
@@ -0,0 +1,264 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statuspage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+	"github.com/perph/perph/pkg/query"
+	"github.com/perph/perph/pkg/store"
+)
+
+var now = time.Date(2019, 7, 10, 12, 0, 0, 0, time.UTC)
+
+func check(name string, labels map[string]string) syntheticv1.Check {
+	return syntheticv1.Check{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels}}
+}
+
+// run returns a run of check in location that finished hours before now.
+func run(check, location string, hours int, phase syntheticv1.SyntheticRunPhase) syntheticv1.SyntheticRun {
+	done := metav1.NewTime(now.Add(-time.Duration(hours) * time.Hour))
+	return syntheticv1.SyntheticRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      check + "-" + location + "-" + string(rune('a'+hours)),
+			Namespace: "default",
+			Labels:    map[string]string{syntheticv1.CheckLabel: check, syntheticv1.LocationLabel: location},
+		},
+		Spec:   syntheticv1.SyntheticRunSpec{CheckRef: check, Location: location},
+		Status: syntheticv1.SyntheticRunStatus{Phase: phase, CompletionTime: &done},
+	}
+}
+
+func page() *syntheticv1.StatusPage {
+	days := int32(3)
+	return &syntheticv1.StatusPage{
+		ObjectMeta: metav1.ObjectMeta{Name: "status", Namespace: "default"},
+		Spec: syntheticv1.StatusPageSpec{
+			Title: "Example <status>",
+			Days:  &days,
+			Components: []syntheticv1.StatusComponent{
+				{Name: "API", Checks: []string{"api"}},
+				{Name: "Web", Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"component": "web"}}},
+				{Name: "Billing", Checks: []string{"billing"}},
+			},
+		},
+	}
+}
+
+func checks() []syntheticv1.Check {
+	return []syntheticv1.Check{
+		check("api", nil),
+		check("home", map[string]string{"component": "web"}),
+		check("login", map[string]string{"component": "web"}),
+		check("billing", nil),
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	silenced := run("billing", "eu", 1, syntheticv1.RunFailed)
+	silenced.Status.SilencedBy = "upgrade"
+	runs := []syntheticv1.SyntheticRun{
+		// api recovered in eu and fails in us: down
+		run("api", "eu", 2, syntheticv1.RunFailed),
+		run("api", "eu", 1, syntheticv1.RunSucceeded),
+		run("api", "us", 1, syntheticv1.RunFailed),
+		// one of the checks of web fails
+		run("home", "eu", 1, syntheticv1.RunSucceeded),
+		run("login", "eu", 1, syntheticv1.RunDependencyFailed),
+		silenced,
+	}
+
+	components, err := Evaluate(page(), checks(), runs)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(components).To(Equal([]syntheticv1.ComponentStatus{
+		{Name: "API", State: syntheticv1.ComponentOutage, Failing: []string{"api"}},
+		{Name: "Web", State: syntheticv1.ComponentDegraded, Failing: []string{"login"}},
+		{Name: "Billing", State: syntheticv1.ComponentMaintenance},
+	}))
+
+	components, err = Evaluate(page(), checks(), nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	for _, c := range components {
+		g.Expect(c.State).To(Equal(syntheticv1.ComponentOperational))
+	}
+}
+
+func TestUpdateIncidents(t *testing.T) {
+	g := NewGomegaWithT(t)
+	status := &syntheticv1.StatusPageStatus{
+		Components: []syntheticv1.ComponentStatus{
+			{Name: "API", State: syntheticv1.ComponentDegraded, Failing: []string{"api"}},
+			{Name: "Web", State: syntheticv1.ComponentOperational},
+		},
+	}
+	UpdateIncidents(status, now, 3)
+	g.Expect(status.Incidents).To(HaveLen(1))
+	g.Expect(status.Incidents[0].Component).To(Equal("API"))
+	g.Expect(status.Incidents[0].State).To(Equal(syntheticv1.ComponentDegraded))
+	g.Expect(status.Incidents[0].End).To(BeNil())
+
+	// The incident escalates and collects failing checks while it is open.
+	status.Components[0] = syntheticv1.ComponentStatus{Name: "API", State: syntheticv1.ComponentOutage, Failing: []string{"api", "auth"}}
+	UpdateIncidents(status, now.Add(time.Minute), 3)
+	g.Expect(status.Incidents).To(HaveLen(1))
+	g.Expect(status.Incidents[0].State).To(Equal(syntheticv1.ComponentOutage))
+	g.Expect(status.Incidents[0].Checks).To(Equal([]string{"api", "auth"}))
+	g.Expect(status.Incidents[0].Start.Time).To(Equal(now))
+
+	// Recovering resolves it, and a new failure opens another one.
+	status.Components[0] = syntheticv1.ComponentStatus{Name: "API", State: syntheticv1.ComponentOperational}
+	UpdateIncidents(status, now.Add(2*time.Minute), 3)
+	g.Expect(status.Incidents[0].End.Time).To(Equal(now.Add(2 * time.Minute)))
+	status.Components[1] = syntheticv1.ComponentStatus{Name: "Web", State: syntheticv1.ComponentDegraded, Failing: []string{"home"}}
+	UpdateIncidents(status, now.Add(3*time.Minute), 3)
+	g.Expect(status.Incidents).To(HaveLen(2))
+	g.Expect(status.Incidents[0].Component).To(Equal("Web"))
+	g.Expect(status.Incidents[1].Component).To(Equal("API"))
+
+	// Resolved incidents are dropped once older than the days shown.
+	status.Components[1].State = syntheticv1.ComponentOperational
+	UpdateIncidents(status, now.Add(3*24*time.Hour+150*time.Second), 3)
+	g.Expect(status.Incidents).To(HaveLen(1))
+	g.Expect(status.Incidents[0].Component).To(Equal("Web"))
+}
+
+func objects() []runtime.Object {
+	sp := page()
+	sp.Status.Components = []syntheticv1.ComponentStatus{
+		{Name: "API", State: syntheticv1.ComponentDegraded, Failing: []string{"api"}},
+	}
+	start := metav1.NewTime(now.Add(-time.Hour))
+	sp.Status.Incidents = []syntheticv1.Incident{
+		{Component: "API", State: syntheticv1.ComponentDegraded, Checks: []string{"api"}, Start: start},
+	}
+	objs := []runtime.Object{sp}
+	for _, c := range checks() {
+		c := c
+		objs = append(objs, &c)
+	}
+	for _, r := range []syntheticv1.SyntheticRun{
+		run("api", "eu", 1, syntheticv1.RunFailed),
+		run("api", "eu", 2, syntheticv1.RunSucceeded),
+		run("api", "eu", 26, syntheticv1.RunSucceeded),
+		run("home", "eu", 1, syntheticv1.RunSucceeded),
+	} {
+		r := r
+		objs = append(objs, &r)
+	}
+	return objs
+}
+
+func newServer(objs ...runtime.Object) *Server {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	syntheticv1.AddToScheme(scheme)
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	return &Server{
+		Client:  c,
+		History: &query.History{Client: c},
+		Log:     zap.Logger(true),
+	}
+}
+
+func TestBuild(t *testing.T) {
+	g := NewGomegaWithT(t)
+	s := newServer(objects()...)
+	p, err := Build(context.Background(), s.History, page(), checks(), now)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(p.Components).To(HaveLen(3))
+
+	api := p.Components[0]
+	g.Expect(api.Days).To(HaveLen(3))
+	g.Expect(api.Days[0].Date).To(Equal("2019-07-08"))
+	g.Expect(api.Days[0].Uptime).To(BeNil())
+	g.Expect(*api.Days[1].Uptime).To(Equal(100.0))
+	g.Expect(api.Days[2].Date).To(Equal("2019-07-10"))
+	g.Expect(*api.Days[2].Uptime).To(Equal(50.0))
+	g.Expect(*api.Uptime).To(BeNumerically("~", 66.67, 0.01))
+	g.Expect(p.Components[2].Uptime).To(BeNil())
+}
+
+func TestBuildFromStore(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "statuspage")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	b, err := store.Open(dir, store.DefaultPolicy, zap.Logger(true))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer b.Close()
+
+	// The store of the manager in us holds its own runs, and those of eu
+	// are kept in the cluster.
+	failed := run("api", "us", 3, syntheticv1.RunFailed)
+	g.Expect(b.Append(store.SeriesOf(&failed), store.RecordOf(&failed))).To(Succeed())
+	s := newServer(objects()...)
+	s.History.Store = b
+
+	p, err := Build(context.Background(), s.History, page(), checks(), now)
+	g.Expect(err).NotTo(HaveOccurred())
+	api := p.Components[0]
+	g.Expect(*api.Days[1].Uptime).To(Equal(100.0))
+	g.Expect(*api.Days[2].Uptime).To(BeNumerically("~", 33.33, 0.01))
+}
+
+func TestServer(t *testing.T) {
+	g := NewGomegaWithT(t)
+	s := newServer(objects()...)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/default/status/status.json", nil))
+	g.Expect(w.Code).To(Equal(http.StatusOK))
+	var p Page
+	g.Expect(json.Unmarshal(w.Body.Bytes(), &p)).To(Succeed())
+	g.Expect(p.State).To(Equal(syntheticv1.ComponentDegraded))
+	g.Expect(p.Incidents).To(HaveLen(1))
+	g.Expect(w.Body.String()).NotTo(ContainSubstring(`"api"`))
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/default/status", nil))
+	g.Expect(w.Code).To(Equal(http.StatusOK))
+	g.Expect(w.Header().Get("Content-Type")).To(HavePrefix("text/html"))
+	body := w.Body.String()
+	g.Expect(body).To(ContainSubstring("Example &lt;status&gt;"))
+	g.Expect(body).To(ContainSubstring("Some systems are degraded"))
+	// The server builds pages at the current time, long after the runs.
+	g.Expect(strings.Count(body, `<span class="nodata"`)).To(Equal(3 * 3))
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/default/missing", nil))
+	g.Expect(w.Code).To(Equal(http.StatusNotFound))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/default/status/other", nil))
+	g.Expect(w.Code).To(Equal(http.StatusNotFound))
+}
@@ -0,0 +1,322 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// rawBucket holds the runs of a series kept in full. The aggregates of each
+// downsampling level are held in a bucket named after its resolution.
+var rawBucket = []byte("raw")
+
+// CompactInterval is how often a Bolt store applies its policy.
+const CompactInterval = time.Minute
+
+// Bolt is a Store backed by a BoltDB file. Each series is a bucket of
+// append-only logs keyed by time, one for the runs kept in full and one per
+// downsampling level.
+type Bolt struct {
+	db     *bolt.DB
+	policy Policy
+	log    logr.Logger
+}
+
+var _ Store = &Bolt{}
+
+// Open opens the store in dir, creating it if necessary.
+func Open(dir string, policy Policy, log logr.Logger) (*Bolt, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, "results.db"), 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &Bolt{db: db, policy: policy, log: log}, nil
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// timeKey returns the key of an entry at t, followed by suffix so that runs
+// that finished at the same time do not collide. Times before the epoch,
+// such as the zero time of an open range, map to the first key.
+func timeKey(t time.Time, suffix string) []byte {
+	key := make([]byte, 8, 8+len(suffix))
+	if t.Before(time.Unix(0, 0)) {
+		t = time.Unix(0, 0)
+	}
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return append(key, suffix...)
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8]))).UTC()
+}
+
+func (b *Bolt) Append(s Series, r *Record) error {
+	value, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		series, err := tx.CreateBucketIfNotExists([]byte(s.String()))
+		if err != nil {
+			return err
+		}
+		raw, err := series.CreateBucketIfNotExists(rawBucket)
+		if err != nil {
+			return err
+		}
+		return raw.Put(timeKey(r.Time, r.Run), value)
+	})
+}
+
+// scan calls fn with every entry of bucket whose time is in [from, to).
+func scan(bucket *bolt.Bucket, from, to time.Time, fn func(k, v []byte) error) error {
+	c := bucket.Cursor()
+	end := timeKey(to, "")
+	for k, v := c.Seek(timeKey(from, "")); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) Records(s Series, from, to time.Time) ([]Record, error) {
+	var records []Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(s.String()))
+		if series == nil {
+			return nil
+		}
+		raw := series.Bucket(rawBucket)
+		if raw == nil {
+			return nil
+		}
+		return scan(raw, from, to, func(_, v []byte) error {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (b *Bolt) Points(s Series, from, to time.Time) ([]Point, error) {
+	var points []Point
+	err := b.db.View(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(s.String()))
+		if series == nil {
+			return nil
+		}
+		return series.ForEach(func(name, _ []byte) error {
+			bucket := series.Bucket(name)
+			if bucket == nil {
+				return nil
+			}
+			return scan(bucket, from, to, func(_, v []byte) error {
+				if bytes.Equal(name, rawBucket) {
+					var r Record
+					if err := json.Unmarshal(v, &r); err != nil {
+						return err
+					}
+					points = append(points, r.Point())
+					return nil
+				}
+				var p Point
+				if err := json.Unmarshal(v, &p); err != nil {
+					return err
+				}
+				points = append(points, p)
+				return nil
+			})
+		})
+	})
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, err
+}
+
+func (b *Bolt) Series() ([]Series, error) {
+	var list []Series
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			s, err := parseSeries(string(name))
+			if err != nil {
+				return err
+			}
+			list = append(list, s)
+			return nil
+		})
+	})
+	return list, err
+}
+
+// Compact applies the policy of the store at now: runs and aggregates past
+// their retention are aggregated into the next downsampling level, or
+// dropped after the last.
+func (b *Bolt) Compact(now time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var empty [][]byte
+		err := tx.ForEach(func(name []byte, series *bolt.Bucket) error {
+			if err := b.compactSeries(series, now); err != nil {
+				return err
+			}
+			if k, _ := series.Cursor().First(); k == nil {
+				empty = append(empty, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range empty {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) compactSeries(series *bolt.Bucket, now time.Time) error {
+	// from is the bucket being compacted and cutoff the time its entries
+	// expire before. Aggregates expire at the end of their interval.
+	from, cutoff := series.Bucket(rawBucket), now.Add(-b.policy.Retention)
+	for i := 0; i <= len(b.policy.Downsampling); i++ {
+		var next *bolt.Bucket
+		var level Level
+		if i < len(b.policy.Downsampling) {
+			level = b.policy.Downsampling[i]
+			var err error
+			if next, err = series.CreateBucketIfNotExists([]byte(level.Resolution.String())); err != nil {
+				return err
+			}
+		}
+		if from != nil {
+			if err := downsample(from, cutoff, next, level.Resolution, i == 0); err != nil {
+				return err
+			}
+		}
+		from, cutoff = next, now.Add(-level.Retention-level.Resolution)
+	}
+
+	// Drop the logs that ended up empty.
+	var empty [][]byte
+	err := series.ForEach(func(name, _ []byte) error {
+		if bucket := series.Bucket(name); bucket != nil {
+			if k, _ := bucket.Cursor().First(); k == nil {
+				empty = append(empty, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range empty {
+		if err := series.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downsample moves the entries of from older than cutoff into the
+// aggregates of next at the given resolution, or drops them if next is nil.
+func downsample(from *bolt.Bucket, cutoff time.Time, next *bolt.Bucket, resolution time.Duration, raw bool) error {
+	var expired [][]byte
+	aggregates := map[int64]*Point{}
+	err := scan(from, time.Unix(0, 0), cutoff, func(k, v []byte) error {
+		expired = append(expired, k)
+		if next == nil {
+			return nil
+		}
+		var p Point
+		if raw {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			p = r.Point()
+		} else if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		start := p.Time.Truncate(resolution)
+		agg, ok := aggregates[start.UnixNano()]
+		if !ok {
+			agg = &Point{Time: start.UTC(), Resolution: resolution}
+			if existing := next.Get(timeKey(start, "")); existing != nil {
+				if err := json.Unmarshal(existing, agg); err != nil {
+					return err
+				}
+			}
+			aggregates[start.UnixNano()] = agg
+		}
+		agg.add(p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := from.Delete(k); err != nil {
+			return err
+		}
+	}
+	for start, agg := range aggregates {
+		value, err := json.Marshal(agg)
+		if err != nil {
+			return err
+		}
+		if err := next.Put(timeKey(time.Unix(0, start), ""), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start applies the policy of the store every CompactInterval until stop is
+// closed, so that the store can be added to a manager.
+func (b *Bolt) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(CompactInterval)
+	defer ticker.Stop()
+	for {
+		if err := b.Compact(time.Now()); err != nil {
+			b.log.Error(err, "unable to compact result store")
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func record(run string, t time.Time, phase syntheticv1.SyntheticRunPhase, latency time.Duration) *Record {
+	return &Record{
+		Run:  run,
+		Time: t,
+		Status: syntheticv1.SyntheticRunStatus{
+			Phase:   phase,
+			Timings: &syntheticv1.PhaseTimings{Total: metav1.Duration{Duration: latency}},
+		},
+	}
+}
+
+func TestBolt(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "store")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	policy := Policy{
+		Retention: time.Hour,
+		Downsampling: []Level{
+			{Resolution: 10 * time.Minute, Retention: 2 * time.Hour},
+			{Resolution: time.Hour, Retention: 24 * time.Hour},
+		},
+	}
+	b, err := Open(dir, policy, zap.Logger(true))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer b.Close()
+
+	api := Series{Kind: "checks", Namespace: "default", Name: "api", Location: "eu"}
+	start := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 60; i++ {
+		phase := syntheticv1.RunSucceeded
+		if i%10 == 0 {
+			phase = syntheticv1.RunFailed
+		}
+		at := start.Add(time.Duration(i) * time.Minute)
+		g.Expect(b.Append(api, record(at.Format("api-150405"), at, phase, time.Duration(i)*time.Millisecond))).To(Succeed())
+	}
+	silenced := record("api-silenced", start.Add(90*time.Second), syntheticv1.RunFailed, time.Second)
+	silenced.Status.SilencedBy = "weekly"
+	g.Expect(b.Append(api, silenced)).To(Succeed())
+
+	series, err := b.Series()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(series).To(Equal([]Series{api}))
+
+	records, err := b.Records(api, start.Add(10*time.Minute), start.Add(20*time.Minute))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(records).To(HaveLen(10))
+	g.Expect(records[0].Run).To(Equal("api-001000"))
+	g.Expect(records[0].Status.Phase).To(Equal(syntheticv1.RunFailed))
+	// A range open at the start covers every run.
+	records, err = b.Records(api, time.Time{}, start.Add(10*time.Minute))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(records).To(HaveLen(11))
+	records, err = b.Records(api, start.Add(10*time.Minute), start.Add(20*time.Minute))
+	g.Expect(err).NotTo(HaveOccurred())
+	run := records[0].SyntheticRun(api)
+	g.Expect(SeriesOf(run)).To(Equal(api))
+	g.Expect(RecordOf(run).Time.Equal(records[0].Time)).To(BeTrue())
+
+	// The details of a summarized run come from the store, although the API
+	// server keeps its completion time to the second.
+	web := Series{Kind: "checks", Namespace: "default", Name: "web", Location: "eu"}
+	detailed := record("web-1", start.Add(300*time.Millisecond), syntheticv1.RunFailed, 10*time.Millisecond)
+	detailed.Status.Assertions = []syntheticv1.AssertionResult{{Name: "status"}}
+	g.Expect(b.Append(web, detailed)).To(Succeed())
+	summarized := detailed.SyntheticRun(web)
+	Summarize(&summarized.Status)
+	g.Expect(summarized.Status).To(Equal(syntheticv1.SyntheticRunStatus{
+		Phase:          syntheticv1.RunFailed,
+		CompletionTime: summarized.Status.CompletionTime,
+		Timings:        &syntheticv1.PhaseTimings{Total: metav1.Duration{Duration: 10 * time.Millisecond}},
+	}))
+	truncated := metav1.NewTime(start)
+	summarized.Status.CompletionTime = &truncated
+	status, err := Details(b, summarized)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(status.Assertions).To(Equal(detailed.Status.Assertions))
+
+	// Nothing has expired yet.
+	g.Expect(b.Compact(start.Add(time.Hour))).To(Succeed())
+	points, err := b.Points(api, start, start.Add(time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(points).To(HaveLen(61))
+
+	// The first half hour of runs is downsampled into ten minute intervals.
+	g.Expect(b.Compact(start.Add(90 * time.Minute))).To(Succeed())
+	records, err = b.Records(api, start, start.Add(time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(records).To(HaveLen(30))
+	points, err = b.Points(api, start, start.Add(time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(points).To(HaveLen(33))
+	g.Expect(points[0]).To(Equal(Point{
+		Time:       start,
+		Resolution: 10 * time.Minute,
+		Runs:       10,
+		Failures:   1,
+		Silenced:   1,
+		LatencySum: 45 * time.Millisecond,
+		LatencyMax: 9 * time.Millisecond,
+	}))
+	g.Expect(points[3].Resolution).To(BeZero())
+	g.Expect(points[3].Time).To(Equal(start.Add(30 * time.Minute)))
+
+	// Runs in full and ten minute aggregates expire into an hourly one.
+	g.Expect(b.Compact(start.Add(5 * time.Hour))).To(Succeed())
+	points, err = b.Points(api, start, start.Add(time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(points).To(Equal([]Point{{
+		Time:       start,
+		Resolution: time.Hour,
+		Runs:       60,
+		Failures:   6,
+		Silenced:   1,
+		LatencySum: 1770 * time.Millisecond,
+		LatencyMax: 59 * time.Millisecond,
+	}}))
+
+	// Everything is dropped after the last level's retention.
+	g.Expect(b.Compact(start.Add(26 * time.Hour))).To(Succeed())
+	series, err = b.Series()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(series).To(BeEmpty())
+}
+
+func TestParseLevels(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	levels, err := ParseLevels("5m:720h, 1h:2160h")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(levels).To(Equal(DefaultPolicy.Downsampling))
+
+	levels, err = ParseLevels("")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(levels).To(BeEmpty())
+
+	for _, s := range []string{"5m", "5x:1h", "5m:1x", "1h:5m", "0s:1h", "1h:24h,5m:720h"} {
+		_, err := ParseLevels(s)
+		g.Expect(err).To(HaveOccurred(), s)
+	}
+}
@@ -0,0 +1,272 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package store keeps the detailed results of SyntheticRuns outside of etcd,
+// so that the API server only needs to hold a short history of summaries.
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// Store records finished runs and serves their history.
+type Store interface {
+	// Append records a finished run of a series.
+	Append(s Series, r *Record) error
+
+	// Records returns the runs of a series that finished in [from, to),
+	// oldest first. Runs that have been downsampled are not returned.
+	Records(s Series, from, to time.Time) ([]Record, error)
+
+	// Points returns the history of a series in [from, to), oldest first,
+	// with one point per run that is still kept in full and one per
+	// downsampled interval beyond that.
+	Points(s Series, from, to time.Time) ([]Point, error)
+
+	// Series lists the series the store holds results of.
+	Series() ([]Series, error)
+
+	Close() error
+}
+
+// Series identifies the runs of a Check or LoadTest from one location.
+type Series struct {
+	// Kind is "checks" or "loadtests".
+	Kind      string
+	Namespace string
+	Name      string
+	Location  string
+}
+
+// SeriesOf returns the series run belongs to.
+func SeriesOf(run *syntheticv1.SyntheticRun) Series {
+	s := Series{Namespace: run.Namespace, Location: run.Spec.Location}
+	switch {
+	case run.Spec.CheckRef != "":
+		s.Kind, s.Name = "checks", run.Spec.CheckRef
+	case run.Spec.LoadTestRef != "":
+		s.Kind, s.Name = "loadtests", run.Spec.LoadTestRef
+	}
+	return s
+}
+
+func (s Series) String() string {
+	return strings.Join([]string{s.Kind, s.Namespace, s.Name, s.Location}, "/")
+}
+
+func parseSeries(key string) (Series, error) {
+	parts := strings.SplitN(key, "/", 4)
+	if len(parts) != 4 {
+		return Series{}, fmt.Errorf("invalid series %q", key)
+	}
+	return Series{Kind: parts[0], Namespace: parts[1], Name: parts[2], Location: parts[3]}, nil
+}
+
+// Record is a run kept in full.
+type Record struct {
+	// Run is the name of the SyntheticRun.
+	Run string `json:"run"`
+
+	// Time is when the run finished.
+	Time time.Time `json:"time"`
+
+	Status syntheticv1.SyntheticRunStatus `json:"status"`
+}
+
+// RecordOf returns the record of a finished run.
+func RecordOf(run *syntheticv1.SyntheticRun) *Record {
+	r := &Record{Run: run.Name, Status: *run.Status.DeepCopy()}
+	if run.Status.CompletionTime != nil {
+		r.Time = run.Status.CompletionTime.Time
+	}
+	return r
+}
+
+// SyntheticRun returns the run r records of series s, as far as the store
+// keeps it: the spec only names what was run and where.
+func (r *Record) SyntheticRun(s Series) *syntheticv1.SyntheticRun {
+	run := &syntheticv1.SyntheticRun{Status: *r.Status.DeepCopy()}
+	run.Name, run.Namespace = r.Run, s.Namespace
+	run.Spec.Location = s.Location
+	switch s.Kind {
+	case "checks":
+		run.Spec.CheckRef = s.Name
+	case "loadtests":
+		run.Spec.LoadTestRef = s.Name
+	}
+	if run.Status.CompletionTime == nil {
+		t := metav1.NewTime(r.Time)
+		run.Status.CompletionTime = &t
+	}
+	return run
+}
+
+// Point aggregates the runs of a series over an interval. Silenced runs are
+// only counted in Silenced.
+type Point struct {
+	// Time is the start of the interval, or when the run finished for a
+	// point of a single run kept in full.
+	Time time.Time `json:"time"`
+
+	// Resolution is the length of the interval, or zero for a point of a
+	// single run kept in full.
+	Resolution time.Duration `json:"resolution,omitempty"`
+
+	Runs     int64 `json:"runs"`
+	Failures int64 `json:"failures"`
+	Silenced int64 `json:"silenced,omitempty"`
+
+	// LatencySum and LatencyMax aggregate the total response time of check
+	// runs, and the 95th percentile latency of load test runs.
+	LatencySum time.Duration `json:"latencySum"`
+	LatencyMax time.Duration `json:"latencyMax"`
+}
+
+// Point returns the point of the single run r.
+func (r *Record) Point() Point {
+	p := Point{Time: r.Time}
+	if r.Status.SilencedBy != "" {
+		p.Silenced = 1
+		return p
+	}
+	p.Runs = 1
+	if r.Status.Phase != syntheticv1.RunSucceeded {
+		p.Failures = 1
+	}
+	switch {
+	case r.Status.Timings != nil:
+		p.LatencySum = r.Status.Timings.Total.Duration
+	case r.Status.LoadTest != nil:
+		p.LatencySum = r.Status.LoadTest.Latency.P95.Duration
+	}
+	p.LatencyMax = p.LatencySum
+	return p
+}
+
+// add merges q into p.
+func (p *Point) add(q Point) {
+	p.Runs += q.Runs
+	p.Failures += q.Failures
+	p.Silenced += q.Silenced
+	p.LatencySum += q.LatencySum
+	if q.LatencyMax > p.LatencyMax {
+		p.LatencyMax = q.LatencyMax
+	}
+}
+
+// Summarize reduces the status of a check run kept in the store to its
+// phase, message, status code and total duration, besides when it ran and
+// why it was silenced or failed. Details returns the rest.
+func Summarize(status *syntheticv1.SyntheticRunStatus) {
+	summary := syntheticv1.SyntheticRunStatus{
+		Phase:          status.Phase,
+		StartTime:      status.StartTime,
+		CompletionTime: status.CompletionTime,
+		Message:        status.Message,
+		SilencedBy:     status.SilencedBy,
+		RootCause:      status.RootCause,
+		StatusCode:     status.StatusCode,
+	}
+	if status.Timings != nil {
+		summary.Timings = &syntheticv1.PhaseTimings{Total: status.Timings.Total}
+	}
+	*status = summary
+}
+
+// Details returns the status of run as st keeps it in full, or the status of
+// run itself if st does not hold the run in full.
+func Details(st Store, run *syntheticv1.SyntheticRun) (*syntheticv1.SyntheticRunStatus, error) {
+	if run.Status.CompletionTime == nil {
+		return &run.Status, nil
+	}
+	// The API server keeps the completion time to the second.
+	t := run.Status.CompletionTime.Time
+	records, err := st.Records(SeriesOf(run), t, t.Add(time.Second))
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if records[i].Run == run.Name {
+			return &records[i].Status, nil
+		}
+	}
+	return &run.Status, nil
+}
+
+// Policy decides how long results are kept.
+type Policy struct {
+	// Retention is how long runs are kept in full.
+	Retention time.Duration
+
+	// Downsampling lists the intervals runs are aggregated into once they
+	// are older than Retention, from the finest to the coarsest. Each level
+	// is kept for its own retention, measured from the end of the interval,
+	// before being aggregated into the next. Results older than the last
+	// level's retention are dropped.
+	Downsampling []Level
+}
+
+// Level is a downsampling level of a Policy.
+type Level struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// DefaultPolicy keeps runs in full for a week, five minute aggregates for a
+// month and hourly aggregates for 90 days.
+var DefaultPolicy = Policy{
+	Retention: 7 * 24 * time.Hour,
+	Downsampling: []Level{
+		{Resolution: 5 * time.Minute, Retention: 30 * 24 * time.Hour},
+		{Resolution: time.Hour, Retention: 90 * 24 * time.Hour},
+	},
+}
+
+// ParseLevels parses downsampling levels written as a comma separated list
+// of resolution:retention pairs, such as "5m:720h,1h:2160h".
+func ParseLevels(s string) ([]Level, error) {
+	var levels []Level
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("downsampling level %q is not resolution:retention", part)
+		}
+		resolution, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("downsampling level %q: %v", part, err)
+		}
+		retention, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("downsampling level %q: %v", part, err)
+		}
+		if resolution <= 0 || retention < resolution {
+			return nil, fmt.Errorf("downsampling level %q must have a positive resolution no longer than its retention", part)
+		}
+		if n := len(levels); n > 0 && resolution <= levels[n-1].Resolution {
+			return nil, fmt.Errorf("downsampling level %q must be coarser than the one before", part)
+		}
+		levels = append(levels, Level{Resolution: resolution, Retention: retention})
+	}
+	return levels, nil
+}
@@ -0,0 +1,185 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package template renders Checks from CheckTemplates.
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+// Effective returns the spec check runs with when rendered from tmpl. Fields
+// set on the check override those of the rendered template.
+func Effective(check *syntheticv1.Check, tmpl *syntheticv1.CheckTemplate) (*syntheticv1.CheckSpec, error) {
+	declared := map[string]bool{}
+	for _, p := range tmpl.Spec.Parameters {
+		declared[p.Name] = true
+	}
+	var unknown []string
+	for name := range check.Spec.Parameters {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("template %q does not declare parameters %s", tmpl.Name, strings.Join(unknown, ", "))
+	}
+
+	params, err := Resolve(tmpl.Spec.Parameters, check.Spec.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %v", tmpl.Name, err)
+	}
+	spec, err := Render(&tmpl.Spec.Template, params)
+	if err != nil {
+		return nil, err
+	}
+
+	own := check.Spec.DeepCopy()
+	if own.Interval != nil {
+		spec.Interval = own.Interval
+	}
+	if own.Locations != nil {
+		spec.Locations = own.Locations
+	}
+	if own.HistoryLimit != nil {
+		spec.HistoryLimit = own.HistoryLimit
+	}
+	if own.HTTP != nil {
+		spec.HTTP = own.HTTP
+	}
+	if own.Steps != nil {
+		spec.Steps = own.Steps
+	}
+	if own.Queue != nil {
+		spec.Queue = own.Queue
+	}
+	if own.HTML != nil {
+		spec.HTML = own.HTML
+	}
+	if own.Tracing != nil {
+		spec.Tracing = own.Tracing
+	}
+	// A template cannot refer to another template.
+	spec.TemplateRef = ""
+	spec.Parameters = nil
+	return spec, nil
+}
+
+// Resolve returns values completed with the defaults of the declared
+// parameters. It fails if a parameter without a default has no value.
+// Values of undeclared parameters are passed through.
+func Resolve(decls []syntheticv1.TemplateParameter, values map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(decls)+len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	var missing []string
+	for _, p := range decls {
+		if _, ok := out[p.Name]; ok {
+			continue
+		}
+		if p.Default == nil {
+			missing = append(missing, p.Name)
+			continue
+		}
+		out[p.Name] = *p.Default
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required parameters %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// Render returns a copy of spec with every $(NAME) in its strings replaced by
+// params[NAME]. References to names that are not parameters and escaped
+// $$(NAME) references are kept as they are, so the probe can still resolve
+// its own variables when the Check runs.
+func Render(spec *syntheticv1.CheckSpec, params map[string]string) (*syntheticv1.CheckSpec, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	data, err = json.Marshal(walk(tree, params))
+	if err != nil {
+		return nil, err
+	}
+	out := &syntheticv1.CheckSpec{}
+	if err := json.Unmarshal(data, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// walk substitutes params into every string of a decoded JSON value.
+func walk(v interface{}, params map[string]string) interface{} {
+	switch v := v.(type) {
+	case string:
+		return substitute(v, params)
+	case []interface{}:
+		for i := range v {
+			v[i] = walk(v[i], params)
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = walk(v[k], params)
+		}
+	}
+	return v
+}
+
+func substitute(s string, params map[string]string) string {
+	if !strings.Contains(s, "$(") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if s[i+1] == '$' {
+			b.WriteString("$$")
+			i++
+			continue
+		}
+		if s[i+1] != '(' {
+			b.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i+2:], ')')
+		if end < 0 {
+			b.WriteString(s[i:])
+			break
+		}
+		name := s[i+2 : i+2+end]
+		if value, ok := params[name]; ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(s[i : i+3+end])
+		}
+		i += 2 + end
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	syntheticv1 "github.com/perph/perph/api/v1"
+)
+
+func TestRender(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spec := &syntheticv1.CheckSpec{
+		Interval: &metav1.Duration{Duration: 30 * time.Second},
+		HTTP: &syntheticv1.HTTPProbe{
+			URL: "https://$(HOST)$(PATH)?token=$(TOKEN)",
+			Headers: []syntheticv1.HTTPHeader{
+				{Name: "X-Service", Value: "$(NAME).$(NAMESPACE)"},
+				{Name: "X-Literal", Value: "$$(HOST)"},
+			},
+			Variables: []syntheticv1.Variable{{Name: "TOKEN", Value: "abc"}},
+		},
+	}
+	out, err := Render(spec, map[string]string{
+		"HOST":      "api.example.com",
+		"PATH":      "/healthz",
+		"NAME":      "api",
+		"NAMESPACE": "shop",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(out.HTTP.URL).To(Equal("https://api.example.com/healthz?token=$(TOKEN)"))
+	g.Expect(out.HTTP.Headers[0].Value).To(Equal("api.shop"))
+	g.Expect(out.HTTP.Headers[1].Value).To(Equal("$$(HOST)"))
+	g.Expect(out.Interval).To(Equal(spec.Interval))
+
+	// The template itself is left untouched.
+	g.Expect(spec.HTTP.URL).To(Equal("https://$(HOST)$(PATH)?token=$(TOKEN)"))
+}
+
+func TestEffective(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tmpl := &syntheticv1.CheckTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "api"},
+		Spec: syntheticv1.CheckTemplateSpec{
+			Parameters: []syntheticv1.TemplateParameter{
+				{Name: "host"},
+				{Name: "path", Default: strPtr("/healthz")},
+				{Name: "region", Default: strPtr("eu")},
+			},
+			Template: syntheticv1.CheckSpec{
+				Interval:  &metav1.Duration{Duration: time.Minute},
+				Locations: []string{"$(region)"},
+				HTTP:      &syntheticv1.HTTPProbe{URL: "https://$(host)$(path)"},
+			},
+		},
+	}
+	check := &syntheticv1.Check{
+		Spec: syntheticv1.CheckSpec{
+			TemplateRef: "api",
+			Parameters:  map[string]string{"host": "shop.example.com"},
+			Interval:    &metav1.Duration{Duration: 10 * time.Second},
+		},
+	}
+
+	spec, err := Effective(check, tmpl)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.HTTP.URL).To(Equal("https://shop.example.com/healthz"))
+	g.Expect(spec.Locations).To(Equal([]string{"eu"}))
+	g.Expect(spec.Interval.Duration).To(Equal(10 * time.Second))
+	g.Expect(spec.TemplateRef).To(BeEmpty())
+
+	check.Spec.Parameters = map[string]string{"path": "/"}
+	_, err = Effective(check, tmpl)
+	g.Expect(err).To(MatchError(ContainSubstring("missing required parameters host")))
+
+	check.Spec.Parameters = map[string]string{"host": "a", "hots": "b"}
+	_, err = Effective(check, tmpl)
+	g.Expect(err).To(MatchError(ContainSubstring("does not declare parameters hots")))
+}
+
+func strPtr(s string) *string { return &s }